@@ -72,6 +72,7 @@ type (
 		responses   map[uint64]Callback
 
 		connectedCallback func() // connected callback
+		closeOnce         sync.Once
 	}
 )
 
@@ -160,8 +161,10 @@ func (c *Connector) On(event string, callback Callback) {
 
 // Close close the connection, and shutdown the benchmark
 func (c *Connector) Close() {
-	c.conn.Close()
-	close(c.die)
+	c.closeOnce.Do(func() {
+		c.conn.Close()
+		close(c.die)
+	})
 }
 
 func (c *Connector) eventHandler(event string) (Callback, bool) {
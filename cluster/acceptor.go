@@ -3,6 +3,7 @@ package cluster
 import (
 	"context"
 	"net"
+	"sync/atomic"
 
 	"github.com/lonng/nano/cluster/clusterpb"
 	"github.com/lonng/nano/internal/message"
@@ -17,6 +18,7 @@ type acceptor struct {
 	lastMid    uint64
 	rpcHandler rpcHandler
 	gateAddr   string
+	node       *Node
 }
 
 // Push implements the session.NetworkEntity interface
@@ -51,29 +53,67 @@ func (a *acceptor) RPC(route string, v interface{}) error {
 	return nil
 }
 
+// Request is not supported by the remote sessions, see session.Session.Request
+func (a *acceptor) Request(route string, v interface{}) ([]byte, error) {
+	return nil, ErrRequestNotSupported
+}
+
 // LastMid implements the session.NetworkEntity interface
 func (a *acceptor) LastMid() uint64 {
-	return a.lastMid
+	return atomic.LoadUint64(&a.lastMid)
 }
 
 // Response implements the session.NetworkEntity interface
 func (a *acceptor) Response(v interface{}) error {
-	return a.ResponseMid(a.lastMid, v)
+	return a.ResponseMid(atomic.LoadUint64(&a.lastMid), v)
 }
 
 // ResponseMid implements the session.NetworkEntity interface
 func (a *acceptor) ResponseMid(mid uint64, v interface{}) error {
+	return a.respond(mid, v, false)
+}
+
+// StreamResponseMid sends a response of the request with more responses to follow
+func (a *acceptor) StreamResponseMid(mid uint64, v interface{}) error {
+	return a.respond(mid, v, true)
+}
+
+func (a *acceptor) respond(mid uint64, v interface{}, more bool) error {
 	// TODO: buffer
 	data, err := message.Serialize(v)
 	if err != nil {
 		return err
 	}
+	if a.node != nil {
+		a.node.handler.caches.fill(a.session, mid, data, more)
+	}
 	request := &clusterpb.ResponseMessage{
 		SessionId: a.sid,
 		Id:        mid,
 		Data:      data,
+		More:      more,
 	}
 	_, err = a.gateClient.HandleResponse(context.Background(), request)
+	if err != nil && a.session.UID() > 0 && a.node != nil {
+		// The client may have migrated to another gate during the request
+		return a.redirectResponse(request, err)
+	}
+	return err
+}
+
+// redirectResponse routes the response to the gate which currently holds the uid, the
+// original error will be returned if the client has not bound to another gate yet
+func (a *acceptor) redirectResponse(request *clusterpb.ResponseMessage, cause error) error {
+	loc, err := a.node.locateSession(a.session.UID())
+	if err != nil || (loc.GateAddr == a.gateAddr && loc.SessionId == a.sid) {
+		return cause
+	}
+	pool, err := a.node.rpcClient.getConnPool(loc.GateAddr)
+	if err != nil {
+		return err
+	}
+	request.SessionId = loc.SessionId
+	_, err = clusterpb.NewMemberClient(pool.Get()).HandleResponse(context.Background(), request)
 	return err
 }
 
@@ -87,6 +127,14 @@ func (a *acceptor) Close() error {
 	return err
 }
 
+// LingerStore implements the session.LingerProvider interface
+func (a *acceptor) LingerStore() *session.LingerStore {
+	if a.node == nil {
+		return nil
+	}
+	return a.node.linger
+}
+
 // RemoteAddr implements the session.NetworkEntity interface
 func (*acceptor) RemoteAddr() net.Addr {
 	return mock.NetAddr{}
@@ -0,0 +1,253 @@
+package cluster
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/lonng/nano/cluster/clusterpb"
+	"github.com/lonng/nano/internal/log"
+	"github.com/lonng/nano/session"
+)
+
+// adminKickReason is the reason of the kick packet sent to the sessions kicked by admin
+const adminKickReason = "kicked by admin"
+
+// startAdmin starts the admin server which serves the management operations of
+// current node, every request must carry the admin token as a bearer token:
+//
+//	GET  /members           list the known members of the cluster
+//	GET  /routes            list the route table for client code generation
+//	POST /kick?uid=1|sid=1  kick the client sessions of current node
+//	POST /drain             stop accepting new client connections
+//	POST /reload            call the reload hook of the application
+func (n *Node) startAdmin() error {
+	if n.AdminToken == "" {
+		return ErrAdminTokenRequired
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/members", n.adminMembers)
+	mux.HandleFunc("/routes", n.adminRoutes)
+	mux.HandleFunc("/kick", n.adminKick)
+	mux.HandleFunc("/drain", n.adminDrain)
+	mux.HandleFunc("/reload", n.adminReload)
+
+	listener, err := net.Listen("tcp", n.AdminAddr)
+	if err != nil {
+		return err
+	}
+	n.admin = &http.Server{Handler: n.authorize(mux)}
+	go func() {
+		if err := n.admin.Serve(listener); err != nil && err != http.ErrServerClosed {
+			log.Println("Admin server stopped", err)
+		}
+	}()
+	return nil
+}
+
+func (n *Node) authorize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(n.AdminToken)) != 1 {
+			writeAdminError(w, http.StatusUnauthorized, "invalid admin token")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func writeAdminJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Println("Write admin response failed", err)
+	}
+}
+
+func writeAdminError(w http.ResponseWriter, status int, msg string) {
+	writeAdminJSON(w, status, map[string]string{"error": msg})
+}
+
+func (n *Node) adminMembers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeAdminError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	members := []*clusterpb.MemberInfo{n.memberInfo()}
+	n.cluster.mu.RLock()
+	for _, m := range n.cluster.members {
+		if m.memberInfo.ServiceAddr == n.ServiceAddr {
+			continue
+		}
+		members = append(members, m.memberInfo)
+	}
+	n.cluster.mu.RUnlock()
+	writeAdminJSON(w, http.StatusOK, members)
+}
+
+type (
+	// RouteInfo describes a local route and the type of its argument, the response
+	// type is unknown by reflection because handlers only return an error
+	RouteInfo struct {
+		Route  string     `json:"route"`
+		Raw    bool       `json:"raw"` // the argument is the raw bytes without serialization
+		Arg    string     `json:"arg"`
+		Fields []ArgField `json:"fields,omitempty"`
+	}
+
+	// ArgField describes an exported field of the argument struct
+	ArgField struct {
+		Name string `json:"name"`
+		Key  string `json:"key"` // key in the json encoding
+		Type string `json:"type"`
+	}
+
+	// RouteTable contains the local routes of current node and the services which
+	// are provided by remote members
+	RouteTable struct {
+		Routes         []RouteInfo `json:"routes"`
+		RemoteServices []string    `json:"remoteServices"`
+	}
+)
+
+// argFields returns the exported fields of the struct type t, the internal fields
+// of generated protobuf messages are skipped
+func argFields(t reflect.Type) []ArgField {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return nil
+	}
+	var fields []ArgField
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" || strings.HasPrefix(f.Name, "XXX_") {
+			continue
+		}
+		key := f.Name
+		if tag := strings.Split(f.Tag.Get("json"), ",")[0]; tag == "-" {
+			continue
+		} else if tag != "" {
+			key = tag
+		}
+		fields = append(fields, ArgField{Name: f.Name, Key: key, Type: f.Type.String()})
+	}
+	return fields
+}
+
+// RouteTable returns the route table of current node
+func (h *LocalHandler) RouteTable() RouteTable {
+	table := RouteTable{RemoteServices: h.RemoteService()}
+	for route, handler := range h.localHandlers {
+		info := RouteInfo{
+			Route: route,
+			Raw:   handler.IsRawArg,
+			Arg:   handler.Type.String(),
+		}
+		if !handler.IsRawArg {
+			info.Fields = argFields(handler.Type)
+		}
+		table.Routes = append(table.Routes, info)
+	}
+	sort.Slice(table.Routes, func(i, j int) bool {
+		return table.Routes[i].Route < table.Routes[j].Route
+	})
+	return table
+}
+
+func (n *Node) adminRoutes(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeAdminError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	writeAdminJSON(w, http.StatusOK, n.handler.RouteTable())
+}
+
+func (n *Node) adminKick(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeAdminError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	var match func(s *session.Session) bool
+	query := r.URL.Query()
+	switch {
+	case query.Get("uid") != "":
+		uid, err := strconv.ParseInt(query.Get("uid"), 10, 64)
+		if err != nil {
+			writeAdminError(w, http.StatusBadRequest, fmt.Sprintf("invalid uid: %s", query.Get("uid")))
+			return
+		}
+		match = func(s *session.Session) bool { return s.UID() == uid }
+	case query.Get("sid") != "":
+		sid, err := strconv.ParseInt(query.Get("sid"), 10, 64)
+		if err != nil {
+			writeAdminError(w, http.StatusBadRequest, fmt.Sprintf("invalid sid: %s", query.Get("sid")))
+			return
+		}
+		match = func(s *session.Session) bool { return s.ID() == sid }
+	default:
+		writeAdminError(w, http.StatusBadRequest, "uid or sid is required")
+		return
+	}
+
+	// only the open sessions of the local clients are kicked, the remote ones are kicked
+	// by their gates
+	var kicked []*session.Session
+	n.ForEachSession(func(s *session.Session) bool {
+		if match(s) {
+			kicked = append(kicked, s)
+		}
+		return true
+	})
+	if len(kicked) == 0 {
+		writeAdminError(w, http.StatusNotFound, "session not found")
+		return
+	}
+	for _, s := range kicked {
+		if err := s.Kick(adminKickReason); err != nil {
+			log.Println(fmt.Sprintf("Kick session failed, ID=%d, UID=%d, Error=%v", s.ID(), s.UID(), err))
+		}
+	}
+	writeAdminJSON(w, http.StatusOK, map[string]int{"kicked": len(kicked)})
+}
+
+func (n *Node) adminDrain(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeAdminError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	atomic.StoreInt32(&n.draining, 1)
+	log.Println("Node is draining, new client connections will be refused")
+	writeAdminJSON(w, http.StatusOK, map[string]bool{"draining": true})
+}
+
+func (n *Node) adminReload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeAdminError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if n.AdminReload == nil {
+		writeAdminError(w, http.StatusNotImplemented, "reload is not supported")
+		return
+	}
+	if err := n.AdminReload(); err != nil {
+		writeAdminError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeAdminJSON(w, http.StatusOK, map[string]bool{"reloaded": true})
+}
+
+// isDraining reports whether current node refuses the new client connections
+func (n *Node) isDraining() bool {
+	return atomic.LoadInt32(&n.draining) == 1
+}
@@ -21,25 +21,52 @@
 package cluster
 
 import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"net"
 	"reflect"
+	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/gorilla/websocket"
+	pcodec "github.com/lonng/nano/codec"
 	"github.com/lonng/nano/internal/codec"
 	"github.com/lonng/nano/internal/env"
 	"github.com/lonng/nano/internal/log"
 	"github.com/lonng/nano/internal/message"
 	"github.com/lonng/nano/internal/packet"
+	"github.com/lonng/nano/metrics"
 	"github.com/lonng/nano/pipeline"
 	"github.com/lonng/nano/scheduler"
+	"github.com/lonng/nano/serialize"
 	"github.com/lonng/nano/session"
 )
 
 const (
 	agentWriteBacklog = 16
+
+	// agentDeadlineSweep is the number of request deadlines which triggers the sweep
+	agentDeadlineSweep = 64
+)
+
+// Metrics of the payload compression
+const (
+	metricCompressRawBytes        = "nano_compress_raw_bytes_total"
+	metricCompressCompressedBytes = "nano_compress_compressed_bytes_total"
+)
+
+// Error codes of the error message, which is sent to client with the error flag
+const (
+	codeBadRequest    = 400
+	codeUnauthorized  = 401
+	codeForbidden     = 403
+	codeInternalError = 500
+	codeUnavailable   = 503
 )
 
 var (
@@ -48,24 +75,148 @@ var (
 	// ErrBufferExceed indicates that the current session buffer is full and
 	// can not receive more data.
 	ErrBufferExceed = errors.New("session send buffer exceed")
+	// ErrRequestTimeout indicates that the client did not respond to a server
+	// initiated request in time.
+	ErrRequestTimeout = errors.New("request to client timeout")
 )
 
 type (
 	// Agent corresponding a user, used for store raw conn information
 	agent struct {
 		// regular agent member
-		session  *session.Session    // session
-		conn     net.Conn            // low-level conn fd
-		lastMid  uint64              // last message id
-		state    int32               // current agent state
-		chDie    chan struct{}       // wait for close
-		chSend   chan pendingMessage // push message queue
-		lastAt   int64               // last heartbeat unix time stamp
-		decoder  *codec.Decoder      // binary decoder
-		pipeline pipeline.Pipeline
+		session    *session.Session    // session
+		conn       net.Conn            // low-level conn fd
+		muLast     sync.RWMutex        // guards lastMid and lastRoute
+		lastMid    uint64              // last message id
+		lastRoute  string              // last message route
+		state      int32               // current agent state
+		chDie      chan struct{}       // wait for close
+		chSend     chan pendingMessage // push message queue
+		chSendHigh chan pendingMessage // high priority message queue
+		chFlushed  chan struct{}       // closed once the write goroutine exited
+		lastAt     int64               // last heartbeat unix time stamp
+		decoder    *codec.Decoder      // binary decoder
+		framing    codec.Framing       // length encoding of the packets
+		heartbeat  []byte              // heartbeat packet of the framing
+		pipeline   pipeline.Pipeline
 
 		rpcHandler rpcHandler
 		srv        reflect.Value // cached session reflect.Value
+
+		// server initiated requests which are waiting for client response
+		muRequests sync.Mutex
+		requestID  uint64
+		requests   map[uint64]chan []byte
+
+		// deadlines of the client requests attached a timeout, the expired responses
+		// are dropped
+		muDeadlines sync.Mutex
+		deadlines   map[uint64]time.Time
+
+		dedup    *dedupCache                            // nil if request deduplication disabled
+		onBind   func(s *session.Session)               // called after the session bound an uid
+		onRebind func(s *session.Session, oldUID int64) // called after the session rebound an uid
+		linger   *session.LingerStore                   // nil if the session lingers in session.Linger
+		raw      bool                                   // handshake and heartbeat are skipped in raw mode
+
+		// called with the serialized response of the client requests, nil if no route
+		// is cached
+		onRespond func(mid uint64, data []byte, more bool)
+
+		// transforms the outbound payloads after serialization and compression
+		outbound func([]byte) []byte
+
+		// the messages queued before the agent closed are flushed to the connection
+		// within the timeout, e.g: the kick reason, zero drops them
+		closeFlush time.Duration
+
+		// limits the handshake and heartbeat packets, nil if unlimited
+		sysLimiter *packetLimiter
+
+		// limits the bytes read from the connection, nil if unlimited, the connection
+		// is kicked instead of throttled if readRateKick, readKicked is only accessed
+		// by the read goroutine
+		readLimiter   *byteLimiter
+		readRateKick  bool
+		readKicked    bool
+		readThrottled *metrics.Counter
+		readRateKicks *metrics.Counter
+
+		// custom codec of the packets, which replaces the framing if not nil
+		packetCodec pcodec.PacketCodec
+
+		// messages counters of current node, nil if the agent is not created by handler
+		messagesIn  *metrics.Counter
+		messagesOut *metrics.Counter
+
+		// serializer negotiated in handshake, nil if the application serializer is used
+		serializer serialize.Serializer
+
+		// protocol version negotiated in handshake, the latest version in raw mode
+		protocol int32
+
+		// reliable pushes retained until client acknowledged them
+		reliable *reliableWindow
+
+		// the connection whose send queue stays at or above slowThreshold for slowWindow
+		// is kicked as a slow consumer, slowSince is the unix nano time it reached the
+		// threshold, zero if below it. Zero slowWindow disables the detection
+		slowThreshold int
+		slowWindow    time.Duration
+		slowSince     int64
+		slowKicks     *metrics.Counter
+
+		// bytes received from and sent to the connection
+		bytesIn  int64
+		bytesOut int64
+
+		// messages dispatched to the handlers but not handled yet, queueSlots caps them
+		// if not nil, queueGauge sums them up for current node
+		queued     int64
+		queueSlots chan struct{}
+		queueGauge *metrics.Gauge
+
+		// the messages received while paused are buffered up to pauseLimit, and they are
+		// dispatched by resumed in order before the newer ones
+		muPause    sync.Mutex
+		paused     bool
+		draining   bool
+		pending    []*message.Message
+		pauseLimit int
+		resumed    func(msg *message.Message)
+
+		tracer    *messageTracer // nil if the message tracing disabled
+		localizer Localizer      // nil if the messages are not localized
+
+		// reason of the close, set by the first close
+		closeReason atomic.Value
+
+		// reports the codec errors of the connection, true will be returned if the
+		// connection should be closed, nil if the agent is not created by handler
+		onCodecError   func(err error, phase string) bool
+		decodeFailures int32
+
+		// encrypts the payloads by the key of the connection if not nil, the replaced
+		// key is accepted in keyGrace after a rotation
+		cipher      PayloadCipher
+		keys        connKeys
+		keyGrace    time.Duration
+		keyRotation time.Duration // interval of the periodic rotation, zero if disabled
+
+		// payload compression negotiated in handshake
+		compress        bool
+		compressDict    []byte // preset dictionary negotiated in handshake, nil if not negotiated
+		rawBytes        int64  // bytes of the compressed payloads before compression
+		compressedBytes int64  // bytes of the compressed payloads after compression
+
+		// names of the serializer and compression negotiated in handshake, which are
+		// read by the other goroutines through the session
+		negotiated atomic.Value // negotiation
+	}
+
+	negotiation struct {
+		serializer  string
+		compression string
 	}
 
 	pendingMessage struct {
@@ -73,20 +224,63 @@ type (
 		route   string       // message route(push)
 		mid     uint64       // response message id(response)
 		payload interface{}  // payload
+		err     bool         // is an error message
+
+		// payload compressed in advance, which is shared by the bulk push
+		deflated []byte
+
+		urgent bool   // sent ahead of the queued messages
+		kick   bool   // kick packet carrying the reason as payload, the agent closes after sent
+		rekey  bool   // rekey packet rotating the key of the connection
+		more   bool   // more responses of the request follow
+		seq    uint64 // sequence number of the reliable push, zero if not reliable
+	}
+
+	// agentReader is the reader of the connection passed to the custom codec, err is the
+	// last error of reading the connection
+	agentReader struct {
+		*agent
+		err error
+	}
+
+	// errorMessage represents the payload of an error message, it is always
+	// encoded in JSON regardless of the application serializer
+	errorMessage struct {
+		Code    int    `json:"code"`
+		Message string `json:"msg"`
 	}
 )
 
+func errorPayload(code int, msg string) []byte {
+	data, err := json.Marshal(errorMessage{Code: code, Message: msg})
+	if err != nil {
+		// never happen
+		panic(err)
+	}
+	return data
+}
+
 // Create new agent instance
 func newAgent(conn net.Conn, pipeline pipeline.Pipeline, rpcHandler rpcHandler) *agent {
 	a := &agent{
 		conn:       conn,
 		state:      statusStart,
 		chDie:      make(chan struct{}),
-		lastAt:     time.Now().Unix(),
+		lastAt:     env.Clock.Now().Unix(),
 		chSend:     make(chan pendingMessage, agentWriteBacklog),
+		chSendHigh: make(chan pendingMessage, agentWriteBacklog),
+		chFlushed:  make(chan struct{}),
 		decoder:    codec.NewDecoder(),
+		heartbeat:  hbd,
 		pipeline:   pipeline,
 		rpcHandler: rpcHandler,
+		requests:   map[uint64]chan []byte{},
+		protocol:   ProtocolVersion,
+		reliable:   newReliableWindow(0),
+	}
+
+	if env.DedupWindow > 0 {
+		a.dedup = newDedupCache(env.DedupWindow)
 	}
 
 	// binding session
@@ -103,15 +297,76 @@ func (a *agent) send(m pendingMessage) (err error) {
 			err = ErrBrokenPipe
 		}
 	}()
-	a.chSend <- m
+	if m.urgent {
+		a.chSendHigh <- m
+	} else {
+		a.chSend <- m
+		a.observeBacklog()
+	}
 	return
 }
 
+// observeBacklog tracks how long the send queue has stayed at or above the slow consumer
+// threshold, and kicks the connection once it exceeded the window, since the messages
+// pushed to a full queue are dropped
+func (a *agent) observeBacklog() {
+	if a.slowWindow <= 0 {
+		return
+	}
+	if len(a.chSend) < a.slowThreshold {
+		atomic.StoreInt64(&a.slowSince, 0)
+		return
+	}
+
+	now := env.Clock.Now().UnixNano()
+	since := atomic.LoadInt64(&a.slowSince)
+	if since == 0 {
+		atomic.CompareAndSwapInt64(&a.slowSince, 0, now)
+		return
+	}
+	if time.Duration(now-since) < a.slowWindow || !atomic.CompareAndSwapInt64(&a.slowSince, since, 0) {
+		return
+	}
+	if a.slowKicks != nil {
+		a.slowKicks.Inc()
+	}
+	log.Println(fmt.Sprintf("Slow consumer detected, SessionID=%d, UID=%d, Queued=%d, Since=%s",
+		a.session.ID(), a.session.UID(), len(a.chSend), time.Duration(now-since)))
+	a.kickNow(slowConsumerKickReason)
+}
+
+// kickNow kicks the connection like Kick without blocking, since it may be called by the
+// write goroutine, which is the only reader of the high priority queue. The agent is
+// closed without the kick packet if the queue is full
+func (a *agent) kickNow(reason string) {
+	if a.status() == statusClosed {
+		return
+	}
+	defer func() {
+		// the queue is closed by the write goroutine exited
+		recover()
+	}()
+	select {
+	case a.chSendHigh <- pendingMessage{payload: []byte(a.localize(reason)), urgent: true, kick: true}:
+	default:
+		a.close(session.Kicked)
+	}
+}
+
 // LastMid implements the session.NetworkEntity interface
 func (a *agent) LastMid() uint64 {
+	a.muLast.RLock()
+	defer a.muLast.RUnlock()
 	return a.lastMid
 }
 
+// setLast records the request being handled, which is called by the dispatcher
+func (a *agent) setLast(mid uint64, route string) {
+	a.muLast.Lock()
+	a.lastMid, a.lastRoute = mid, route
+	a.muLast.Unlock()
+}
+
 // Push, implementation for session.NetworkEntity interface
 func (a *agent) Push(route string, v interface{}) error {
 	if a.status() == statusClosed {
@@ -119,6 +374,7 @@ func (a *agent) Push(route string, v interface{}) error {
 	}
 
 	if len(a.chSend) >= agentWriteBacklog {
+		a.observeBacklog()
 		return ErrBufferExceed
 	}
 
@@ -136,6 +392,166 @@ func (a *agent) Push(route string, v interface{}) error {
 	return a.send(pendingMessage{typ: message.Push, route: route, payload: v})
 }
 
+// PushReliable pushes the message with a sequence number, which is retained and
+// retransmitted until client acknowledged it by an ack packet
+func (a *agent) PushReliable(route string, v interface{}) error {
+	if a.raw || a.protocolVersion() < ProtocolReliable {
+		return session.ErrReliableUnsupported
+	}
+	if a.status() == statusClosed {
+		return ErrBrokenPipe
+	}
+
+	m, ok := a.reliable.retain(pendingMessage{typ: message.Push, route: route, payload: v})
+	if !ok {
+		return ErrBufferExceed
+	}
+	if err := a.send(m); err != nil {
+		a.reliable.drop(m.seq)
+		return err
+	}
+	return nil
+}
+
+// Unacked returns the number of the reliable pushes which are not acknowledged
+func (a *agent) Unacked() int {
+	return a.reliable.unacked()
+}
+
+// setFraming sets the length encoding of the packets, the packets larger than maxSize
+// are rejected by the decoder, codec.MaxPacketSize is used if maxSize is not positive
+func (a *agent) setFraming(framing codec.Framing, maxSize int) {
+	a.framing = framing
+	a.decoder = codec.NewFramingDecoder(framing, maxSize)
+	if p, err := framing.Encode(packet.Heartbeat, nil); err == nil {
+		a.heartbeat = p
+	}
+}
+
+// setPacketCodec sets the custom codec of the packets, which replaces the framing
+func (a *agent) setPacketCodec(c pcodec.PacketCodec) {
+	a.packetCodec = c
+	if p, err := a.encodePacket(packet.Heartbeat, nil); err == nil {
+		a.heartbeat = p
+	}
+}
+
+// encodePacket encodes the packet with the custom codec if set, otherwise the framing
+func (a *agent) encodePacket(typ packet.Type, data []byte) ([]byte, error) {
+	if a.packetCodec != nil {
+		return a.packetCodec.Encode(packet.Packet{Type: typ, Length: len(data), Data: data})
+	}
+	return a.framing.Encode(typ, data)
+}
+
+// Read reads the connection for the custom codec, which counts the bytes received
+func (r *agentReader) Read(p []byte) (int, error) {
+	for {
+		n, err := r.conn.Read(p)
+		atomic.AddInt64(&r.bytesIn, int64(n))
+		if err != nil {
+			r.err = err
+		}
+		if err != nil || r.throttleRead(n) {
+			return n, err
+		}
+		// the data of the kicked connection is discarded until it closed
+	}
+}
+
+// throttleRead limits the bytes read from the connection, it waits until the rate
+// recovered if exceeded, false will be returned if the connection is kicked instead,
+// whose data should be discarded
+func (a *agent) throttleRead(n int) bool {
+	if a.readLimiter == nil {
+		return true
+	}
+	if a.readKicked {
+		return false
+	}
+	wait := a.readLimiter.reserve(n, env.Clock.Now())
+	if wait <= 0 {
+		return true
+	}
+	if a.readRateKick {
+		a.readKicked = true
+		if a.readRateKicks != nil {
+			a.readRateKicks.Inc()
+		}
+		log.Println(fmt.Sprintf("Read rate exceeded, ID=%d, UID=%d", a.session.ID(), a.session.UID()))
+		a.Kick(readRateKickReason)
+		return false
+	}
+	if a.readThrottled != nil {
+		a.readThrottled.Inc()
+	}
+	<-env.Clock.After(wait)
+	return true
+}
+
+// Kick sends a kick packet with the reason to client ahead of the queued messages,
+// and closes the agent after the packet is sent
+func (a *agent) Kick(reason string) error {
+	if a.status() == statusClosed {
+		return ErrBrokenPipe
+	}
+	return a.send(pendingMessage{payload: []byte(a.localize(reason)), urgent: true, kick: true})
+}
+
+// PushWait pushes message to client, it waits for the send queue if it is full instead
+// of failing with ErrBufferExceed, until the cancel channel is closed
+func (a *agent) PushWait(cancel <-chan struct{}, route string, v interface{}) (err error) {
+	if a.status() == statusClosed {
+		return ErrBrokenPipe
+	}
+
+	defer func() {
+		if e := recover(); e != nil {
+			err = ErrBrokenPipe
+		}
+	}()
+	select {
+	case a.chSend <- pendingMessage{typ: message.Push, route: route, payload: v}:
+		return nil
+	case <-cancel:
+		return ErrBufferExceed
+	case <-a.chDie:
+		return ErrBrokenPipe
+	}
+}
+
+// PushPriority pushes message to client, the high priority messages are sent ahead of
+// the messages queued in the send queue
+func (a *agent) PushPriority(route string, v interface{}, prio session.Priority) error {
+	if prio < session.PriorityHigh {
+		return a.Push(route, v)
+	}
+
+	if a.status() == statusClosed {
+		return ErrBrokenPipe
+	}
+
+	if len(a.chSendHigh) >= agentWriteBacklog {
+		return ErrBufferExceed
+	}
+
+	return a.send(pendingMessage{typ: message.Push, route: route, payload: v, urgent: true})
+}
+
+// pushShared pushes the serialized payload which is shared with other agents, the
+// payload must not be modified after pushed
+func (a *agent) pushShared(route string, data, deflated []byte) error {
+	if a.status() == statusClosed {
+		return ErrBrokenPipe
+	}
+
+	if len(a.chSend) >= agentWriteBacklog {
+		return ErrBufferExceed
+	}
+
+	return a.send(pendingMessage{typ: message.Push, route: route, payload: data, deflated: deflated})
+}
+
 // RPC, implementation for session.NetworkEntity interface
 func (a *agent) RPC(route string, v interface{}) error {
 	if a.status() == statusClosed {
@@ -156,15 +572,118 @@ func (a *agent) RPC(route string, v interface{}) error {
 	return nil
 }
 
+// Request sends a request to client and waits for the client response
+func (a *agent) Request(route string, v interface{}) ([]byte, error) {
+	if a.status() == statusClosed {
+		return nil, ErrBrokenPipe
+	}
+
+	if len(a.chSend) >= agentWriteBacklog {
+		return nil, ErrBufferExceed
+	}
+
+	a.muRequests.Lock()
+	a.requestID++
+	id := a.requestID
+	ch := make(chan []byte, 1)
+	a.requests[id] = ch
+	a.muRequests.Unlock()
+
+	defer func() {
+		a.muRequests.Lock()
+		delete(a.requests, id)
+		a.muRequests.Unlock()
+	}()
+
+	if env.Debug {
+		log.Println(fmt.Sprintf("Type=Request, ID=%d, UID=%d, MID=%d, Route=%s",
+			a.session.ID(), a.session.UID(), id, route))
+	}
+
+	if err := a.send(pendingMessage{typ: message.Request, route: route, mid: id, payload: v}); err != nil {
+		return nil, err
+	}
+
+	timer := env.Clock.NewTimer(env.RequestTimeout)
+	defer timer.Stop()
+
+	select {
+	case data := <-ch:
+		return data, nil
+	case <-timer.C():
+		return nil, ErrRequestTimeout
+	case <-a.chDie:
+		return nil, ErrBrokenPipe
+	}
+}
+
+// onResponse delivers the client response to the pending server initiated request
+func (a *agent) onResponse(mid uint64, data []byte) bool {
+	a.muRequests.Lock()
+	ch, found := a.requests[mid]
+	delete(a.requests, mid)
+	a.muRequests.Unlock()
+	if !found {
+		return false
+	}
+
+	// copy data, the decoder reuses the underlying buffer
+	payload := make([]byte, len(data))
+	copy(payload, data)
+	ch <- payload
+	return true
+}
+
+// OnBind, implementation for session.BindObserver interface
+func (a *agent) OnBind(_ int64) {
+	// report asynchronously, avoid blocking the handler
+	if a.onBind != nil {
+		go a.onBind(a.session)
+	}
+}
+
+// OnRebind, implementation for session.RebindObserver interface
+func (a *agent) OnRebind(oldUID, _ int64) {
+	// report asynchronously, avoid blocking the handler
+	if a.onRebind != nil {
+		go a.onRebind(a.session, oldUID)
+	}
+}
+
+// LingerStore, implementation for session.LingerProvider interface
+func (a *agent) LingerStore() *session.LingerStore {
+	return a.linger
+}
+
 // Response, implementation for session.NetworkEntity interface
 // Response message to session
 func (a *agent) Response(v interface{}) error {
-	return a.ResponseMid(a.lastMid, v)
+	a.muLast.RLock()
+	mid, route := a.lastMid, a.lastRoute
+	a.muLast.RUnlock()
+	return a.response(mid, route, v)
 }
 
 // ResponseMid, implementation for session.NetworkEntity interface
 // Response message to session
 func (a *agent) ResponseMid(mid uint64, v interface{}) error {
+	return a.response(mid, "", v)
+}
+
+func (a *agent) response(mid uint64, route string, v interface{}) error {
+	return a.respond(mid, route, v, false)
+}
+
+// StreamResponseMid sends a response of the request with more responses to follow, the
+// stream of responses is ended by ResponseMid
+func (a *agent) StreamResponseMid(mid uint64, v interface{}) error {
+	if a.protocolVersion() < ProtocolStream {
+		return session.ErrStreamUnsupported
+	}
+	return a.respond(mid, "", v, true)
+}
+
+func (a *agent) respond(mid uint64, route string, v interface{}, more bool) error {
 	if a.status() == statusClosed {
 		return ErrBrokenPipe
 	}
@@ -173,6 +692,15 @@ func (a *agent) ResponseMid(mid uint64, v interface{}) error {
 		return ErrSessionOnNotify
 	}
 
+	if a.expired(mid, !more) {
+		metrics.Default.Counter(metricRequestExpired, "stage", "response").Inc()
+		if env.Debug {
+			log.Println(fmt.Sprintf("Drop the response of expired request, ID=%d, UID=%d, MID=%d",
+				a.session.ID(), a.session.UID(), mid))
+		}
+		return nil
+	}
+
 	if len(a.chSend) >= agentWriteBacklog {
 		return ErrBufferExceed
 	}
@@ -188,13 +716,333 @@ func (a *agent) ResponseMid(mid uint64, v interface{}) error {
 		}
 	}
 
-	return a.send(pendingMessage{typ: message.Response, mid: mid, payload: v})
+	if a.onRespond != nil {
+		data, err := message.SerializeWith(a.payloadSerializer(), v)
+		if err != nil {
+			return err
+		}
+		a.onRespond(mid, data, more)
+		v = data
+	}
+
+	m := pendingMessage{typ: message.Response, route: route, mid: mid, payload: v, more: more}
+	// only the last response of a stream is replayed to the duplicate request
+	if a.dedup != nil && !more {
+		a.dedup.finish(m)
+	}
+	return a.send(m)
+}
+
+// responseError responds an error message to the request, which is always encoded
+// in JSON with the error flag
+func (a *agent) responseError(mid uint64, route string, code int, msg string) error {
+	if a.status() == statusClosed {
+		return ErrBrokenPipe
+	}
+
+	m := pendingMessage{typ: message.Response, route: route, mid: mid, payload: errorPayload(code, a.localize(msg)), err: true, urgent: true}
+	if a.dedup != nil {
+		a.dedup.finish(m)
+	}
+	return a.send(m)
+}
+
+// localize translates the message generated by server into the locale of the client,
+// the message is formatted as is if no localizer configured
+func (a *agent) localize(key string, args ...interface{}) string {
+	return localize(a.localizer, a.session.Locale(), key, args...)
+}
+
+// deflate compresses the payload of message or uses the payload compressed in advance,
+// the payload will be sent without compression if it becomes larger after compression
+func (a *agent) deflate(m *message.Message, deflated []byte) {
+	data := deflated
+	if data == nil {
+		var err error
+		data, err = message.DeflateDict(m.Data, a.compressDict)
+		if err != nil {
+			log.Println("Compress payload failed", err)
+			return
+		}
+	}
+	if len(data) >= len(m.Data) {
+		return
+	}
+	atomic.AddInt64(&a.rawBytes, int64(len(m.Data)))
+	atomic.AddInt64(&a.compressedBytes, int64(len(data)))
+	metrics.Default.Counter(metricCompressRawBytes).Add(int64(len(m.Data)))
+	metrics.Default.Counter(metricCompressCompressedBytes).Add(int64(len(data)))
+	m.Data, m.Deflated = data, true
+}
+
+// CompressionStats, implementation for the compression statistics of session
+func (a *agent) CompressionStats() session.CompressionStats {
+	return session.CompressionStats{
+		RawBytes:        atomic.LoadInt64(&a.rawBytes),
+		CompressedBytes: atomic.LoadInt64(&a.compressedBytes),
+	}
+}
+
+// Serializer returns the name of the serializer negotiated in the handshake
+func (a *agent) Serializer() string {
+	n, _ := a.negotiated.Load().(negotiation)
+	return n.serializer
+}
+
+// Compression returns the payload compression negotiated in the handshake
+func (a *agent) Compression() string {
+	n, _ := a.negotiated.Load().(negotiation)
+	return n.compression
+}
+
+// BytesIn returns the bytes received from the connection
+func (a *agent) BytesIn() int64 {
+	return atomic.LoadInt64(&a.bytesIn)
+}
+
+// BytesOut returns the bytes sent to the connection
+func (a *agent) BytesOut() int64 {
+	return atomic.LoadInt64(&a.bytesOut)
+}
+
+// setDeadline records the deadline of the client request, the expired deadlines are
+// swept once the records piled up, e.g: the requests never responded
+func (a *agent) setDeadline(mid uint64, deadline time.Time) {
+	a.muDeadlines.Lock()
+	defer a.muDeadlines.Unlock()
+
+	if a.deadlines == nil {
+		a.deadlines = map[uint64]time.Time{}
+	}
+	if len(a.deadlines) >= agentDeadlineSweep {
+		now := env.Clock.Now()
+		for id, d := range a.deadlines {
+			if now.After(d) {
+				delete(a.deadlines, id)
+			}
+		}
+	}
+	a.deadlines[mid] = deadline
+}
+
+// expired reports whether the deadline of the request being responded has passed, the
+// deadline is removed by the last response of the request
+func (a *agent) expired(mid uint64, last bool) bool {
+	a.muDeadlines.Lock()
+	defer a.muDeadlines.Unlock()
+
+	deadline, found := a.deadlines[mid]
+	if !found {
+		return false
+	}
+	if last {
+		delete(a.deadlines, mid)
+	}
+	return env.Clock.Now().After(deadline)
+}
+
+// QueueDepth returns the messages dispatched to the handlers but not handled yet
+func (a *agent) QueueDepth() int {
+	return int(atomic.LoadInt64(&a.queued))
+}
+
+// enqueue reserves a slot in the queue before dispatching a message, it waits for a
+// slot if the queue is full and wait is true, false is returned if no slot reserved
+func (a *agent) enqueue(wait bool) bool {
+	if a.queueSlots != nil {
+		if wait {
+			select {
+			case a.queueSlots <- struct{}{}:
+			case <-a.chDie:
+				return false
+			}
+		} else {
+			select {
+			case a.queueSlots <- struct{}{}:
+			default:
+				return false
+			}
+		}
+	}
+	atomic.AddInt64(&a.queued, 1)
+	if a.queueGauge != nil {
+		a.queueGauge.Add(1)
+	}
+	return true
+}
+
+// dequeue releases the slot after the message has been handled
+func (a *agent) dequeue() {
+	atomic.AddInt64(&a.queued, -1)
+	if a.queueGauge != nil {
+		a.queueGauge.Add(-1)
+	}
+	if a.queueSlots != nil {
+		<-a.queueSlots
+	}
+}
+
+// Pause stops dispatching the messages of the session until Resume, the messages
+// received in the meantime are buffered
+func (a *agent) Pause() {
+	a.muPause.Lock()
+	a.paused = true
+	a.muPause.Unlock()
+}
+
+// Resume dispatches the messages buffered while paused in order, then the new ones
+func (a *agent) Resume() {
+	a.muPause.Lock()
+	defer a.muPause.Unlock()
+
+	a.paused = false
+	if a.draining || len(a.pending) == 0 || a.resumed == nil {
+		return
+	}
+	// dispatched by another goroutine, the caller is usually a handler, which must not
+	// wait for the queue of the session
+	a.draining = true
+	go a.drain()
+}
+
+// Paused reports whether the session is paused
+func (a *agent) Paused() bool {
+	a.muPause.Lock()
+	defer a.muPause.Unlock()
+	return a.paused
+}
+
+// hold buffers the message if the session is paused or the buffered messages are not
+// dispatched yet, overflow reports the message is dropped since the buffer is full
+func (a *agent) hold(msg *message.Message) (held, overflow bool) {
+	a.muPause.Lock()
+	defer a.muPause.Unlock()
+
+	if !a.paused && !a.draining {
+		return false, false
+	}
+	if len(a.pending) >= a.pauseLimit {
+		return true, true
+	}
+	a.pending = append(a.pending, msg)
+	return true, false
+}
+
+// drain dispatches the buffered messages until the buffer is empty or paused again
+func (a *agent) drain() {
+	for {
+		a.muPause.Lock()
+		closed := a.status() == statusClosed
+		if a.paused || len(a.pending) == 0 || closed {
+			if closed {
+				a.pending = nil
+			}
+			a.draining = false
+			a.muPause.Unlock()
+			return
+		}
+		msg := a.pending[0]
+		a.pending[0] = nil
+		a.pending = a.pending[1:]
+		a.muPause.Unlock()
+
+		a.resumed(msg)
+	}
+}
+
+// setProtocol sets the protocol version negotiated in handshake
+func (a *agent) setProtocol(protocol int) {
+	atomic.StoreInt32(&a.protocol, int32(protocol))
+}
+
+// protocolVersion returns the protocol version of the connection
+func (a *agent) protocolVersion() int {
+	return int(atomic.LoadInt32(&a.protocol))
+}
+
+// RotateKey rotates the key of the payload encryption, the messages queued after it
+// are encrypted by the new key
+func (a *agent) RotateKey() error {
+	if a.cipher == nil || a.protocolVersion() < ProtocolStream {
+		return session.ErrKeyRotationUnsupported
+	}
+	if a.status() == statusClosed {
+		return ErrBrokenPipe
+	}
+	return a.send(pendingMessage{rekey: true})
+}
+
+// rekey generates a new key and encodes the rekey packet carrying the key material,
+// which is encrypted by the replaced key. It is called by the write goroutine, so the
+// messages encoded after it are encrypted by the new key
+func (a *agent) rekey() []byte {
+	key, material, err := a.cipher.NewKey(a.session)
+	if err != nil {
+		log.Println(fmt.Sprintf("Generate key failed: %v, SessionID=%d, UID=%d", err, a.session.ID(), a.session.UID()))
+		return nil
+	}
+	current, _ := a.keys.keys()
+	if material, err = a.cipher.Encrypt(current, material); err != nil {
+		log.Println(fmt.Sprintf("Encrypt key material failed: %v, SessionID=%d, UID=%d", err, a.session.ID(), a.session.UID()))
+		return nil
+	}
+	p, err := a.encodePacket(packet.Rekey, material)
+	if err != nil {
+		log.Println(err)
+		return nil
+	}
+	a.keys.rotate(key, a.keyGrace)
+	return p
+}
+
+// decrypt decrypts the payload received from client by the current key, and by the
+// replaced key during the grace window of a rotation
+func (a *agent) decrypt(payload []byte) ([]byte, error) {
+	current, previous := a.keys.keys()
+	data, err := a.cipher.Decrypt(current, payload)
+	if err != nil && previous != nil {
+		if data, e := a.cipher.Decrypt(previous, payload); e == nil {
+			return data, nil
+		}
+	}
+	return data, err
+}
+
+// payloadSerializer returns the serializer of the payloads exchanged with client
+func (a *agent) payloadSerializer() serialize.Serializer {
+	if a.serializer != nil {
+		return a.serializer
+	}
+	return env.Serializer
+}
+
+// ClientCertificate returns the verified certificate of client on the TLS connection
+func (a *agent) ClientCertificate() *x509.Certificate {
+	conn := a.conn
+	if ws, ok := conn.(*wsConn); ok {
+		conn = ws.conn.UnderlyingConn()
+	}
+	tc, ok := conn.(*tls.Conn)
+	if !ok {
+		return nil
+	}
+	state := tc.ConnectionState()
+	if len(state.VerifiedChains) == 0 || len(state.PeerCertificates) == 0 {
+		return nil
+	}
+	return state.PeerCertificates[0]
 }
 
 // Close, implementation for session.NetworkEntity interface
 // Close closes the agent, clean inner state and close low-level connection.
 // Any blocked Read or Write operations will be unblocked and return errors.
 func (a *agent) Close() error {
+	return a.close(session.Kicked)
+}
+
+// close closes the agent for the reason, the reason of the first close is passed to
+// the lifetime callbacks
+func (a *agent) close(reason session.CloseReason) error {
 	if a.status() == statusClosed {
 		return ErrCloseClosedSession
 	}
@@ -210,13 +1058,54 @@ func (a *agent) Close() error {
 	case <-a.chDie:
 		// expect
 	default:
+		a.closeReason.Store(reason)
 		close(a.chDie)
-		scheduler.PushTask(func() { session.Lifetime.Close(a.session) })
+		scheduler.PushTask(func() { session.Lifetime.CloseWithReason(a.session, reason) })
+	}
+
+	if a.closeFlush > 0 {
+		// the connection is closed once the write goroutine flushed the queued
+		// messages, or the flush timeout elapsed
+		a.conn.SetWriteDeadline(time.Now().Add(a.closeFlush))
+		go func() {
+			select {
+			case <-a.chFlushed:
+			case <-env.Clock.After(a.closeFlush):
+			}
+			a.closeConn(reason)
+		}()
+		return nil
 	}
+	return a.closeConn(reason)
+}
 
+// closeConn closes the connection, the WebSocket clients receive the close code of the
+// reason, see wsCloseCode
+func (a *agent) closeConn(reason session.CloseReason) error {
+	if ws, ok := a.conn.(*wsConn); ok {
+		return ws.closeWithReason(reason)
+	}
 	return a.conn.Close()
 }
 
+// closedFor returns the reason the agent was closed for, empty if not closed
+func (a *agent) closedFor() session.CloseReason {
+	reason, _ := a.closeReason.Load().(session.CloseReason)
+	return reason
+}
+
+// readCloseReason returns the close reason of the connection whose read failed, which
+// distinguishes the clean close of client from the unexpected errors
+func readCloseReason(err error) session.CloseReason {
+	if err == io.EOF || websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+		return session.ClientClosed
+	}
+	if e, ok := err.(net.Error); ok && e.Timeout() {
+		return session.Timeout
+	}
+	return session.ReadError
+}
+
 // RemoteAddr, implementation for session.NetworkEntity interface
 // returns the remote network address.
 func (a *agent) RemoteAddr() net.Addr {
@@ -237,84 +1126,326 @@ func (a *agent) setStatus(state int32) {
 }
 
 func (a *agent) write() {
-	ticker := time.NewTicker(env.Heartbeat)
-	chWrite := make(chan []byte, agentWriteBacklog)
+	ticker := env.Clock.NewTicker(env.Heartbeat)
+
+	// rotates the key of the payload encryption periodically if enabled
+	var chRotate <-chan time.Time
+	if interval := a.keyRotation; a.cipher != nil && interval > 0 {
+		rotate := env.Clock.NewTicker(interval)
+		defer rotate.Stop()
+		chRotate = rotate.C()
+	}
+
+	// the connection is closed for the failed write unless the reason is set on return
+	reason := session.ReadError
+	// the queued messages are flushed if the agent is closed or the application quits
+	var drain bool
+
 	// clean func
 	defer func() {
 		ticker.Stop()
+		if drain && a.closeFlush > 0 {
+			a.flushQueued()
+		}
+		close(a.chFlushed)
 		close(a.chSend)
-		close(chWrite)
-		a.Close()
+		close(a.chSendHigh)
+		a.close(reason)
 		if env.Debug {
 			log.Println(fmt.Sprintf("Session write goroutine exit, SessionID=%d, UID=%d", a.session.ID(), a.session.UID()))
 		}
 	}()
 
+	// the packets are written in place, so the write loop never blocks on itself and
+	// observes the close of agent as soon as the in-progress write aborted
+	flush := func(p []byte) bool {
+		// close agent while low-level conn broken
+		if err := a.writeFull(p); err != nil {
+			log.Println(err.Error())
+			return false
+		}
+		return true
+	}
+
 	for {
+		// the close is observed ahead of the queued messages, which are flushed by the
+		// clean func instead of being aborted by the close
 		select {
-		case <-ticker.C:
-			deadline := time.Now().Add(-2 * env.Heartbeat).Unix()
+		case <-a.chDie:
+			drain = true
+			return
+		case <-env.Die:
+			reason = session.ServerShutdown
+			drain = true
+			return
+		default:
+		}
+
+		// high priority messages skip the messages queued in the send queue
+		select {
+		case data := <-a.chSendHigh:
+			if !a.writeUrgent(data) {
+				if data.kick {
+					// the messages queued before the kick are flushed after it
+					reason, drain = session.Kicked, true
+				}
+				return
+			}
+			continue
+		default:
+		}
+
+		select {
+		case <-ticker.C():
+			if a.raw {
+				break
+			}
+			deadline := env.Clock.Now().Add(-2 * env.Heartbeat).Unix()
 			if atomic.LoadInt64(&a.lastAt) < deadline {
 				log.Println(fmt.Sprintf("Session heartbeat timeout, LastTime=%d, Deadline=%d", atomic.LoadInt64(&a.lastAt), deadline))
+				reason = session.Timeout
 				return
 			}
-			chWrite <- hbd
-
-		case data := <-chWrite:
-			// close agent while low-level conn broken
-			if _, err := a.conn.Write(data); err != nil {
-				log.Println(err.Error())
+			if !flush(a.heartbeat) {
 				return
 			}
 
-		case data := <-a.chSend:
-			payload, err := message.Serialize(data.payload)
-			if err != nil {
-				switch data.typ {
-				case message.Push:
-					log.Println(fmt.Sprintf("Push: %s error: %s", data.route, err.Error()))
-				case message.Response:
-					log.Println(fmt.Sprintf("Response message(id: %d) error: %s", data.mid, err.Error()))
-				default:
-					// expect
+			// retransmit the reliable pushes not acknowledged within a heartbeat interval
+			now := env.Clock.Now()
+			for _, m := range a.reliable.overdue(now.Add(-env.Heartbeat), now) {
+				p := a.encode(m)
+				if p == nil {
+					a.reliable.drop(m.seq)
+					continue
+				}
+				if !flush(p) {
+					return
 				}
-				break
 			}
 
-			// construct message and encode
-			m := &message.Message{
-				Type:  data.typ,
-				Data:  payload,
-				Route: data.route,
-				ID:    data.mid,
+		case <-chRotate:
+			if a.protocolVersion() < ProtocolStream {
+				// the client cannot decode the rekey packet
+				break
 			}
-			if pipe := a.pipeline; pipe != nil {
-				err := pipe.Outbound().Process(a.session, m)
-				if err != nil {
-					log.Println("broken pipeline", err.Error())
-					break
-				}
+			if p := a.rekey(); p != nil && !flush(p) {
+				return
 			}
 
-			em, err := m.Encode()
-			if err != nil {
-				log.Println(err.Error())
-				break
+		case data := <-a.chSendHigh:
+			if !a.writeUrgent(data) {
+				if data.kick {
+					reason, drain = session.Kicked, true
+				}
+				return
 			}
 
-			// packet encode
-			p, err := codec.Encode(packet.Data, em)
-			if err != nil {
-				log.Println(err)
+		case data := <-a.chSend:
+			a.observeBacklog()
+			if data.rekey {
+				if p := a.rekey(); p != nil && !flush(p) {
+					return
+				}
 				break
 			}
-			chWrite <- p
+			if p := a.encode(data); p != nil {
+				if !flush(p) {
+					return
+				}
+				if a.messagesOut != nil {
+					a.messagesOut.Inc()
+				}
+			} else if data.seq > 0 {
+				a.reliable.drop(data.seq)
+			}
 
 		case <-a.chDie: // agent closed signal
+			drain = true
 			return
 
 		case <-env.Die: // application quit
+			reason = session.ServerShutdown
+			drain = true
+			return
+		}
+	}
+}
+
+// encode serializes, compresses and transforms the pending message into a data packet,
+// nil will be returned if the message cannot be sent
+func (a *agent) encode(data pendingMessage) []byte {
+	a.tracer.trace("out", a.session, data.typ, data.route, data.mid, data.payload)
+	payload, err := message.SerializeWith(a.payloadSerializer(), data.payload)
+	if err != nil {
+		switch data.typ {
+		case message.Push:
+			log.Println(fmt.Sprintf("Push: %s error: %s", data.route, err.Error()))
+		case message.Response:
+			log.Println(fmt.Sprintf("Response message(id: %d, route: %s) error: %s, UID=%d",
+				data.mid, data.route, err.Error(), a.session.UID()))
+		case message.Request:
+			log.Println(fmt.Sprintf("Request: %s(id: %d) error: %s", data.route, data.mid, err.Error()))
+		default:
+			// expect
+		}
+		if a.onCodecError != nil {
+			a.onCodecError(err, CodecPhaseEncode)
+		}
+
+		// client is waiting for the response, reply an error instead
+		if data.typ != message.Response {
+			return nil
+		}
+		payload = errorPayload(codeInternalError, "serialize response failed")
+		data.err = true
+	}
+
+	// construct message and encode
+	m := &message.Message{
+		Type:  data.typ,
+		Data:  payload,
+		Route: data.route,
+		ID:    data.mid,
+		Err:   data.err,
+		More:  data.more,
+		Seq:   data.seq,
+	}
+	if pipe := a.pipeline; pipe != nil {
+		err := pipe.Outbound().Process(a.session, m)
+		if err != nil {
+			log.Println("broken pipeline", err.Error())
+			return nil
+		}
+	}
+
+	if a.compress && len(m.Data) >= env.CompressThreshold {
+		// the payload compressed in advance is invalid if pipeline exists
+		var deflated []byte
+		if a.pipeline == nil {
+			deflated = data.deflated
+		}
+		a.deflate(m, deflated)
+	}
+	if a.outbound != nil {
+		m.Data = a.outbound(m.Data)
+	}
+	if a.cipher != nil {
+		current, _ := a.keys.keys()
+		if m.Data, err = a.cipher.Encrypt(current, m.Data); err != nil {
+			log.Println(fmt.Sprintf("Encrypt payload failed: %v, SessionID=%d, UID=%d", err, a.session.ID(), a.session.UID()))
+			return nil
+		}
+	}
+
+	em, err := m.Encode()
+	if err != nil {
+		log.Println(err.Error())
+		return nil
+	}
+
+	// packet encode
+	p, err := a.encodePacket(packet.Data, em)
+	if err != nil {
+		log.Println(err)
+		return nil
+	}
+	return p
+}
+
+// writeFull writes the whole packet to the connection, a short write on a slow
+// connection is continued with the remainder, otherwise the framing of following
+// packets will be corrupted. The write deadline of the connection is not extended,
+// so a deadline exceeded error stops writing the remainder. The write is not aborted
+// by the close if the queued messages are flushed on close, which is bounded by the
+// write deadline set by close instead
+func (a *agent) writeFull(data []byte) error {
+	if a.closeFlush > 0 {
+		return a.writeUntil(data, nil)
+	}
+	return a.writeUntil(data, a.chDie)
+}
+
+// writeUntil writes the data to the connection, it is aborted if the abort channel is
+// closed before the data written, nil never aborts
+func (a *agent) writeUntil(data []byte, abort <-chan struct{}) error {
+	for len(data) > 0 {
+		select {
+		case <-abort:
+			// the agent closed during the write, e.g: client disconnected
+			return ErrBrokenPipe
+		default:
+		}
+		n, err := a.conn.Write(data)
+		atomic.AddInt64(&a.bytesOut, int64(n))
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return io.ErrShortWrite
+		}
+		data = data[n:]
+	}
+	return nil
+}
+
+// flushQueued writes the messages queued before the agent closed within the close flush
+// timeout, the high priority ones first, it stops at a failed write
+func (a *agent) flushQueued() {
+	a.conn.SetWriteDeadline(time.Now().Add(a.closeFlush))
+	for {
+		var data pendingMessage
+		select {
+		case data = <-a.chSendHigh:
+		default:
+			select {
+			case data = <-a.chSend:
+			default:
+				return
+			}
+		}
+
+		var p []byte
+		switch {
+		case data.kick:
+			p, _ = a.encodePacket(packet.Kick, data.payload.([]byte))
+		case !data.rekey:
+			p = a.encode(data)
+		}
+		if p == nil {
+			continue
+		}
+		if err := a.writeUntil(p, nil); err != nil {
 			return
 		}
+		if a.messagesOut != nil && !data.kick {
+			a.messagesOut.Inc()
+		}
+	}
+}
+
+// writeUrgent writes the high priority message to the connection immediately, it
+// returns false if the connection is broken
+func (a *agent) writeUrgent(data pendingMessage) bool {
+	if data.kick {
+		p, err := a.encodePacket(packet.Kick, data.payload.([]byte))
+		if err == nil {
+			// the kick reason is written even if the agent is being closed, the write
+			// is unblocked by the close of connection
+			a.writeUntil(p, nil)
+		}
+		return false
+	}
+
+	p := a.encode(data)
+	if p == nil {
+		return true
+	}
+	if err := a.writeFull(p); err != nil {
+		log.Println(err.Error())
+		return false
+	}
+	if a.messagesOut != nil {
+		a.messagesOut.Inc()
 	}
+	return true
 }
@@ -0,0 +1,847 @@
+package cluster
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/lonng/nano/benchmark/testdata"
+	"github.com/lonng/nano/internal/clock"
+	"github.com/lonng/nano/internal/codec"
+	"github.com/lonng/nano/internal/env"
+	"github.com/lonng/nano/internal/message"
+	"github.com/lonng/nano/internal/packet"
+	"github.com/lonng/nano/session"
+)
+
+// readMessage reads the next data packet which is written by agent
+func readMessage(t *testing.T, conn net.Conn, decoder *codec.Decoder) *message.Message {
+	buf := make([]byte, 2048)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		packets, err := decoder.Decode(buf[:n])
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, p := range packets {
+			if p.Type != packet.Data {
+				continue
+			}
+			msg, err := message.Decode(p.Data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return msg
+		}
+	}
+}
+
+func TestAgentRequest(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+
+	h := NewHandler(nil, nil)
+	a := newAgent(server, nil, nil)
+	go a.write()
+	defer a.Close()
+
+	type result struct {
+		data []byte
+		err  error
+	}
+	chResult := make(chan result, 1)
+	go func() {
+		data, err := a.session.Request("client.ping", []byte("ping"))
+		chResult <- result{data, err}
+	}()
+
+	msg := readMessage(t, client, codec.NewDecoder())
+	if msg.Type != message.Request || msg.Route != "client.ping" || string(msg.Data) != "ping" {
+		t.Fatalf("unexpected request: %s", msg.String())
+	}
+
+	h.processMessage(a, &message.Message{Type: message.Response, ID: msg.ID, Data: []byte("pong")})
+	r := <-chResult
+	if r.err != nil {
+		t.Fatal(r.err)
+	}
+	if string(r.data) != "pong" {
+		t.Fatalf("expect pong, got %s", r.data)
+	}
+}
+
+func TestAgentRequestTimeout(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+
+	timeout := env.RequestTimeout
+	env.RequestTimeout = 20 * time.Millisecond
+	defer func() { env.RequestTimeout = timeout }()
+
+	a := newAgent(server, nil, nil)
+	go a.write()
+	defer a.Close()
+
+	go io.Copy(ioutil.Discard, client)
+	if _, err := a.session.Request("client.ping", []byte("ping")); err != ErrRequestTimeout {
+		t.Fatalf("expect timeout error, got %v", err)
+	}
+}
+
+func TestAgentResponseSerializeError(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+
+	a := newAgent(server, nil, nil)
+	go a.write()
+	defer a.Close()
+
+	decoder := codec.NewDecoder()
+	go func() {
+		// unmarshalable value for both protobuf and json serializer
+		a.session.ResponseMID(1, struct{ C chan int }{})
+	}()
+	msg := readMessage(t, client, decoder)
+	if msg.Type != message.Response || msg.ID != 1 || !msg.Err {
+		t.Fatalf("expect error response, got %s", msg.String())
+	}
+	var e errorMessage
+	if err := json.Unmarshal(msg.Data, &e); err != nil {
+		t.Fatal(err)
+	}
+	if e.Code != codeInternalError {
+		t.Fatalf("unexpected error code %d", e.Code)
+	}
+
+	// write goroutine should still be working
+	go a.session.Push("test", []byte("ok"))
+	msg = readMessage(t, client, decoder)
+	if msg.Type != message.Push || string(msg.Data) != "ok" {
+		t.Fatalf("unexpected message: %s", msg.String())
+	}
+}
+
+func TestAgentDedupRequest(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+
+	window := env.DedupWindow
+	env.DedupWindow = time.Minute
+	defer func() { env.DedupWindow = window }()
+
+	h := NewHandler(nil, nil)
+	a := newAgent(server, nil, nil)
+	go a.write()
+	defer a.Close()
+
+	decoder := codec.NewDecoder()
+	h.processMessage(a, &message.Message{Type: message.Request, ID: 1, Route: "Room.Buy"})
+	go a.session.ResponseMID(1, []byte("bought"))
+	msg := readMessage(t, client, decoder)
+	if msg.Type != message.Response || msg.ID != 1 || string(msg.Data) != "bought" {
+		t.Fatalf("unexpected response: %s", msg.String())
+	}
+
+	// the duplicate request receives the cached response
+	go h.processMessage(a, &message.Message{Type: message.Request, ID: 1, Route: "Room.Buy"})
+	msg = readMessage(t, client, decoder)
+	if msg.Type != message.Response || msg.ID != 1 || string(msg.Data) != "bought" {
+		t.Fatalf("unexpected response: %s", msg.String())
+	}
+}
+
+func TestAgentCompression(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+
+	threshold := env.CompressThreshold
+	env.CompressThreshold = 64
+	defer func() { env.CompressThreshold = threshold }()
+
+	offer := []byte(`{"sys":{"compress":["deflate"]}}`)
+	if !acceptCompression(offer) || acceptCompression([]byte(`{"sys":{}}`)) {
+		t.Fatal("unexpected compression negotiation")
+	}
+	env.CompressionFilter = func([]byte) bool { return false }
+	if acceptCompression(offer) {
+		t.Fatal("expect compression declined")
+	}
+	env.CompressionFilter = nil
+
+	a := newAgent(server, nil, nil)
+	a.compress = true
+	go a.write()
+	defer a.Close()
+
+	decoder := codec.NewDecoder()
+	raw := []byte(strings.Repeat("nano", 64))
+	go a.session.Push("test", raw)
+	msg := readMessage(t, client, decoder)
+	if !msg.Deflated {
+		t.Fatalf("expect deflated message: %s", msg.String())
+	}
+	data, err := message.Inflate(msg.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != string(raw) {
+		t.Fatal("inflated payload not equal")
+	}
+
+	// small payload will not be compressed
+	go a.session.Push("test", []byte("small"))
+	msg = readMessage(t, client, decoder)
+	if msg.Deflated || string(msg.Data) != "small" {
+		t.Fatalf("unexpected message: %s", msg.String())
+	}
+
+	stats := a.session.CompressionStats()
+	if stats.RawBytes != int64(len(raw)) || stats.CompressedBytes == 0 || stats.Ratio() >= 1 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestNodePushMany(t *testing.T) {
+	threshold := env.CompressThreshold
+	env.CompressThreshold = 64
+	defer func() { env.CompressThreshold = threshold }()
+
+	var sessions []*session.Session
+	var clients []net.Conn
+	for i := 0; i < 2; i++ {
+		server, client := net.Pipe()
+		defer client.Close()
+		a := newAgent(server, nil, nil)
+		a.compress = i == 0
+		go a.write()
+		defer a.Close()
+		sessions = append(sessions, a.session)
+		clients = append(clients, client)
+	}
+
+	raw := []byte(strings.Repeat("nano", 64))
+	go (&Node{}).PushMany(sessions, "test", raw)
+	for i, client := range clients {
+		msg := readMessage(t, client, codec.NewDecoder())
+		if msg.Deflated != (i == 0) {
+			t.Fatalf("unexpected message: %s", msg.String())
+		}
+		data := msg.Data
+		if msg.Deflated {
+			var err error
+			if data, err = message.Inflate(msg.Data); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if string(data) != string(raw) {
+			t.Fatal("payload not equal")
+		}
+	}
+}
+
+func benchmarkAgents(n int) []*session.Session {
+	var sessions []*session.Session
+	for i := 0; i < n; i++ {
+		server, client := net.Pipe()
+		a := newAgent(server, nil, nil)
+		a.compress = true
+		go a.write()
+		go io.Copy(ioutil.Discard, client)
+		sessions = append(sessions, a.session)
+	}
+	return sessions
+}
+
+func waitDrained(sessions []*session.Session) {
+	for _, s := range sessions {
+		for len(s.NetworkEntity().(*agent).chSend) > 0 {
+			runtime.Gosched()
+		}
+	}
+}
+
+func BenchmarkPushLoop(b *testing.B) {
+	threshold := env.CompressThreshold
+	env.CompressThreshold = 64
+	defer func() { env.CompressThreshold = threshold }()
+
+	sessions := benchmarkAgents(100)
+	pong := &testdata.Pong{Content: strings.Repeat("nano", 256)}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, s := range sessions {
+			s.Push("test", pong)
+		}
+		waitDrained(sessions)
+	}
+}
+
+func BenchmarkPushMany(b *testing.B) {
+	threshold := env.CompressThreshold
+	env.CompressThreshold = 64
+	defer func() { env.CompressThreshold = threshold }()
+
+	sessions := benchmarkAgents(100)
+	pong := &testdata.Pong{Content: strings.Repeat("nano", 256)}
+	node := &Node{}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		node.PushMany(sessions, "test", pong)
+		waitDrained(sessions)
+	}
+}
+
+func TestAgentPushPriority(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+
+	a := newAgent(server, nil, nil)
+	defer a.Close()
+
+	// routine messages are queued before the write goroutine starts
+	for i := 0; i < 3; i++ {
+		if err := a.session.Push("state", []byte("state")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := a.session.PushPriority("kick", []byte("kick"), session.PriorityHigh); err != nil {
+		t.Fatal(err)
+	}
+	go a.write()
+
+	decoder := codec.NewDecoder()
+	routes := []string{"kick", "state", "state", "state"}
+	for _, route := range routes {
+		msg := readMessage(t, client, decoder)
+		if msg.Route != route {
+			t.Fatalf("expect route %s, got %s", route, msg.Route)
+		}
+	}
+}
+
+// TestAgentConcurrentSend should be run with the race detector, e.g: make race
+func TestAgentConcurrentSend(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+	go io.Copy(ioutil.Discard, client)
+
+	a := newAgent(server, nil, nil)
+	go a.write()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 1; j <= 100; j++ {
+				// the dispatcher records the handling request meanwhile
+				a.setLast(uint64(j), "room.join")
+				a.session.Push("room.state", []byte("state"))
+				a.session.PushPriority("room.kick", []byte("kick"), session.PriorityHigh)
+				a.session.Response([]byte("joined"))
+				a.session.ResponseMID(a.session.LastMid(), []byte("joined"))
+				a.session.Set("seq", j)
+				if i == 0 && j == 50 {
+					a.Close()
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestAgentBytesOut(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+
+	a := newAgent(server, nil, nil)
+	defer a.Close()
+	go a.write()
+
+	if err := a.session.Push("state", []byte("state")); err != nil {
+		t.Fatal(err)
+	}
+	readMessage(t, client, codec.NewDecoder())
+
+	m, err := message.Encode(&message.Message{Type: message.Push, Route: "state", Data: []byte("state")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := codec.Encode(packet.Data, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the counter is updated after the write returned
+	for i := 0; i < 100 && a.session.BytesOut() == 0; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	if out := a.session.BytesOut(); out != int64(len(p)) {
+		t.Fatalf("bytes out %d, expect %d", out, len(p))
+	}
+}
+
+// shortWriteConn writes at most 3 bytes for each call like a congested connection
+type shortWriteConn struct {
+	net.Conn
+}
+
+func (c shortWriteConn) Write(b []byte) (int, error) {
+	if len(b) > 3 {
+		b = b[:3]
+	}
+	return c.Conn.Write(b)
+}
+
+func TestAgentShortWrite(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+
+	a := newAgent(shortWriteConn{server}, nil, nil)
+	defer a.Close()
+	go a.write()
+
+	routes := []string{"state", "chat", "kick"}
+	for _, route := range routes {
+		if err := a.session.Push(route, []byte("payload of "+route)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the frames are not truncated by the short writes
+	decoder := codec.NewDecoder()
+	for _, route := range routes {
+		msg := readMessage(t, client, decoder)
+		if msg.Route != route || string(msg.Data) != "payload of "+route {
+			t.Fatalf("expect route %s, got %s: %s", route, msg.Route, msg.Data)
+		}
+	}
+}
+
+func TestAgentSlowConsumer(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+
+	a := newAgent(server, nil, nil)
+	a.slowThreshold = 4
+	a.slowWindow = 50 * time.Millisecond
+	go a.write()
+	defer a.Close()
+
+	// the client does not read, the send queue stays full
+	for start := time.Now(); time.Since(start) < 100*time.Millisecond; {
+		a.Push("tick", []byte("price"))
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	client.SetReadDeadline(time.Now().Add(time.Second))
+	decoder := codec.NewDecoder()
+	buf := make([]byte, 2048)
+	for {
+		n, err := client.Read(buf)
+		if err != nil {
+			t.Fatalf("expect kick packet, got %v", err)
+		}
+		packets, err := decoder.Decode(buf[:n])
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, p := range packets {
+			if p.Type == packet.Kick {
+				if string(p.Data) != slowConsumerKickReason {
+					t.Fatalf("unexpected kick reason %q", p.Data)
+				}
+				return
+			}
+		}
+	}
+}
+
+func TestAgentSlowConsumerQueueFull(t *testing.T) {
+	defer func(c clock.Clock) { env.Clock = c }(env.Clock)
+	manual := clock.NewManual(time.Unix(1000, 0))
+	env.Clock = manual
+
+	server, client := net.Pipe()
+	defer client.Close()
+	go io.Copy(ioutil.Discard, client)
+
+	a := newAgent(server, nil, nil)
+	a.slowThreshold = 1
+	a.slowWindow = time.Second
+	for i := 0; i < cap(a.chSendHigh); i++ {
+		a.chSendHigh <- pendingMessage{typ: message.Push, route: "urgent", payload: []byte("payload"), urgent: true}
+	}
+	a.chSend <- pendingMessage{typ: message.Push, route: "tick", payload: []byte("price")}
+	a.observeBacklog()
+	manual.Advance(2 * time.Second)
+
+	// the detection on the write goroutine never blocks on the full high priority queue
+	observed := make(chan struct{})
+	go func() {
+		a.observeBacklog()
+		close(observed)
+	}()
+	select {
+	case <-observed:
+	case <-time.After(time.Second):
+		t.Fatal("slow consumer detection blocked on the full queue")
+	}
+	if reason := a.closedFor(); reason != session.Kicked {
+		t.Fatalf("expect kicked, got %s", reason)
+	}
+}
+
+func TestAgentDisconnectMidWrite(t *testing.T) {
+	server, client := net.Pipe()
+
+	a := newAgent(shortWriteConn{server}, nil, nil)
+	exited := make(chan struct{})
+	go func() {
+		a.write()
+		close(exited)
+	}()
+
+	if err := a.session.Push("snapshot", make([]byte, 1<<20)); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < agentWriteBacklog; i++ {
+		a.session.Push("state", []byte("payload"))
+	}
+
+	// the client disconnects after a part of the large push received
+	buf := make([]byte, 1024)
+	if _, err := client.Read(buf); err != nil {
+		t.Fatal(err)
+	}
+	client.Close()
+
+	select {
+	case <-exited:
+	case <-time.After(time.Second):
+		t.Fatal("write goroutine should exit after client disconnected")
+	}
+	if a.status() != statusClosed {
+		t.Fatal("agent should be closed after client disconnected")
+	}
+	if err := a.session.Push("state", []byte("payload")); err != ErrBrokenPipe {
+		t.Fatalf("expect ErrBrokenPipe, got %v", err)
+	}
+}
+
+func TestAgentCompressionDictionary(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+
+	threshold := env.CompressThreshold
+	env.CompressThreshold = 16
+	defer func() { env.CompressThreshold = threshold }()
+
+	dict := []byte(`{"x":0,"y":0,"hp":100,"state":"moving"}`)
+	offer := []byte(`{"sys":{"compress":["deflate"],"compressDict":"` + dictionaryID(dict) + `"}}`)
+	if !acceptDictionary(offer, dict) || acceptDictionary(offer, []byte("other")) || acceptDictionary(offer, nil) {
+		t.Fatal("unexpected dictionary negotiation")
+	}
+
+	a := newAgent(server, nil, nil)
+	a.compress = true
+	a.compressDict = dict
+	go a.write()
+	defer a.Close()
+
+	raw := []byte(`{"x":12,"y":34,"hp":95,"state":"moving"}`)
+	go a.session.Push("test", raw)
+	msg := readMessage(t, client, codec.NewDecoder())
+	if !msg.Deflated {
+		t.Fatalf("expect deflated message: %s", msg.String())
+	}
+	data, err := message.InflateDict(msg.Data, dict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != string(raw) {
+		t.Fatal("inflated payload not equal")
+	}
+
+	plain, err := message.Deflate(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msg.Data) >= len(plain) {
+		t.Fatalf("dictionary should improve the ratio, %d bytes with dictionary, %d bytes without", len(msg.Data), len(plain))
+	}
+}
+
+func TestAgentCloseReason(t *testing.T) {
+	cases := []struct {
+		err    error
+		reason session.CloseReason
+	}{
+		{io.EOF, session.ClientClosed},
+		{&websocket.CloseError{Code: websocket.CloseGoingAway}, session.ClientClosed},
+		{&websocket.CloseError{Code: websocket.CloseAbnormalClosure}, session.ReadError},
+		{&net.OpError{Op: "read", Err: timeoutError{}}, session.Timeout},
+		{io.ErrUnexpectedEOF, session.ReadError},
+	}
+	for _, c := range cases {
+		if reason := readCloseReason(c.err); reason != c.reason {
+			t.Fatalf("%v: expect %s, got %s", c.err, c.reason, reason)
+		}
+	}
+
+	server, client := net.Pipe()
+	defer client.Close()
+	go io.Copy(ioutil.Discard, client)
+
+	a := newAgent(server, nil, nil)
+	exited := make(chan struct{})
+	go func() {
+		a.write()
+		close(exited)
+	}()
+	a.Kick("maintenance")
+	<-exited
+	if reason := a.closedFor(); reason != session.Kicked {
+		t.Fatalf("expect kicked, got %s", reason)
+	}
+
+	// the reason of the first close is retained
+	a.close(session.ReadError)
+	if reason := a.closedFor(); reason != session.Kicked {
+		t.Fatalf("expect kicked, got %s", reason)
+	}
+}
+
+func TestAgentCloseFlush(t *testing.T) {
+	// readAll returns the packets received by client until the connection closed
+	readAll := func(client net.Conn) []*packet.Packet {
+		client.SetReadDeadline(time.Now().Add(2 * time.Second))
+		decoder := codec.NewDecoder()
+		buf := make([]byte, 1024)
+		var packets []*packet.Packet
+		for {
+			n, err := client.Read(buf)
+			if err != nil {
+				if err != io.EOF {
+					t.Fatalf("expect the connection closed after flushed, got %v", err)
+				}
+				return packets
+			}
+			decoded, err := decoder.Decode(buf[:n])
+			if err != nil {
+				t.Fatal(err)
+			}
+			packets = append(packets, decoded...)
+		}
+	}
+
+	for _, kick := range []bool{false, true} {
+		server, client := net.Pipe()
+		a := newAgent(server, nil, nil)
+		a.closeFlush = time.Second
+
+		// the messages are queued before the write goroutine started, so the close is
+		// observed with the send queue non-empty
+		for i := 0; i < 3; i++ {
+			if err := a.Push("test", []byte("final")); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if kick {
+			a.Kick("maintenance")
+		}
+		a.Close()
+		go a.write()
+
+		var kicked bool
+		var pushed int
+		for _, p := range readAll(client) {
+			switch {
+			case p.Type == packet.Kick && string(p.Data) == "maintenance":
+				kicked = true
+			case p.Type == packet.Data:
+				pushed++
+			}
+		}
+		client.Close()
+		if kicked != kick {
+			t.Fatalf("expect the kick reason delivered %v, got %v", kick, kicked)
+		}
+		if pushed != 3 {
+			t.Fatalf("expect the queued messages flushed before close, got %d", pushed)
+		}
+	}
+
+	// the messages queued before the kick are flushed after it by the write goroutine
+	server, client := net.Pipe()
+	defer client.Close()
+	a := newAgent(server, nil, nil)
+	a.closeFlush = time.Second
+	for i := 0; i < 3; i++ {
+		a.Push("test", []byte("final"))
+	}
+	a.Kick("maintenance")
+	go a.write()
+	packets := readAll(client)
+	if len(packets) != 4 || packets[0].Type != packet.Kick {
+		t.Fatalf("expect the kick followed by the queued messages, got %d packets", len(packets))
+	}
+	if reason := a.closedFor(); reason != session.Kicked {
+		t.Fatalf("expect kicked, got %s", reason)
+	}
+}
+
+func TestAgentHeartbeatTimeout(t *testing.T) {
+	defer func(c clock.Clock) { env.Clock = c }(env.Clock)
+	manual := clock.NewManual(time.Unix(1000, 0))
+	env.Clock = manual
+
+	server, client := net.Pipe()
+	defer client.Close()
+	go io.Copy(ioutil.Discard, client)
+
+	a := newAgent(server, nil, nil)
+	exited := make(chan struct{})
+	go func() {
+		a.write()
+		close(exited)
+	}()
+	for manual.Waiters() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// a heartbeat is sent on each tick, the client silent for 2 intervals times out
+	manual.Advance(env.Heartbeat)
+	manual.Advance(env.Heartbeat)
+	select {
+	case <-exited:
+		t.Fatal("expect the agent alive within 2 heartbeat intervals")
+	case <-time.After(10 * time.Millisecond):
+	}
+	manual.Advance(env.Heartbeat + time.Second)
+	select {
+	case <-exited:
+	case <-time.After(time.Second):
+		t.Fatal("expect the agent closed for the heartbeat timeout")
+	}
+	if reason := a.closedFor(); reason != session.Timeout {
+		t.Fatalf("expect timeout, got %s", reason)
+	}
+}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestAgentReadRate(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+
+	a := newAgent(server, nil, nil)
+	defer a.Close()
+	go a.write()
+
+	// the connection exceeding the rate is throttled
+	a.readLimiter = newByteLimiter(1000, 0)
+	start := time.Now()
+	if !a.throttleRead(1100) {
+		t.Fatal("expect the connection throttled instead of kicked")
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Fatalf("expect the read throttled for 100ms, got %v", elapsed)
+	}
+
+	// or kicked, whose data is discarded afterwards
+	a.readRateKick = true
+	if a.throttleRead(1000) || a.throttleRead(1) {
+		t.Fatal("expect the data of kicked connection discarded")
+	}
+	client.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, 1024)
+	n, err := client.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	packets, err := codec.NewDecoder().Decode(buf[:n])
+	if err != nil || len(packets) != 1 || packets[0].Type != packet.Kick {
+		t.Fatalf("expect kick packet, got %v (%v)", packets, err)
+	}
+	if string(packets[0].Data) != readRateKickReason {
+		t.Fatalf("expect kick reason %q, got %q", readRateKickReason, packets[0].Data)
+	}
+}
+
+func TestAgentLocalize(t *testing.T) {
+	if locale := handshakeLocale([]byte(`{"sys":{"locale":"zh-CN"}}`)); locale != "zh-CN" {
+		t.Fatalf("expect zh-CN, got %s", locale)
+	}
+	if locale := handshakeLocale([]byte(`{"sys":{}}`)); locale != "" {
+		t.Fatalf("expect empty locale, got %s", locale)
+	}
+
+	server, client := net.Pipe()
+	defer client.Close()
+
+	a := newAgent(server, nil, nil)
+	a.localizer = func(locale, key string, args ...interface{}) string {
+		return locale + ":" + fmt.Sprintf(key, args...)
+	}
+	a.session.SetLocale("zh-CN")
+	go a.write()
+
+	if err := a.responseError(1, "test.route", codeUnavailable, "member busy"); err != nil {
+		t.Fatal(err)
+	}
+	a.Kick("maintenance")
+
+	client.SetReadDeadline(time.Now().Add(2 * time.Second))
+	decoder := codec.NewDecoder()
+	buf := make([]byte, 1024)
+	var msg, reason string
+	for reason == "" {
+		n, err := client.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		packets, err := decoder.Decode(buf[:n])
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, p := range packets {
+			switch p.Type {
+			case packet.Data:
+				m, err := message.Decode(p.Data)
+				if err != nil {
+					t.Fatal(err)
+				}
+				var e errorMessage
+				if err := json.Unmarshal(m.Data, &e); err != nil {
+					t.Fatal(err)
+				}
+				msg = e.Message
+			case packet.Kick:
+				reason = string(p.Data)
+			}
+		}
+	}
+	if msg != "zh-CN:member busy" || reason != "zh-CN:maintenance" {
+		t.Fatalf("unexpected localized messages %q, %q", msg, reason)
+	}
+
+	// the messages are formatted as is without localizer
+	if s := localize(nil, "zh-CN", "protocol version %d is not supported", 1); s != "protocol version 1 is not supported" {
+		t.Fatalf("unexpected message %q", s)
+	}
+}
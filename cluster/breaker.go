@@ -0,0 +1,202 @@
+package cluster
+
+import (
+	"sync"
+	"time"
+
+	"github.com/lonng/nano/internal/env"
+	"github.com/lonng/nano/internal/log"
+	"github.com/lonng/nano/metrics"
+)
+
+// Metrics of the circuit breakers of the members, labeled by the target address,
+// nano_breaker_state is 0 if closed, 1 if open and 2 if half-open
+const (
+	metricBreakerState    = "nano_breaker_state"
+	metricBreakerTrips    = "nano_breaker_trips_total"
+	metricBreakerRejected = "nano_breaker_rejected_total"
+)
+
+// Defaults of the circuit breaker options
+const (
+	defaultBreakerMinRequests = 10
+	defaultBreakerWindow      = 10 * time.Second
+	defaultBreakerOpenTimeout = 5 * time.Second
+	defaultBreakerProbes      = 1
+)
+
+type breakerState int64
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+type (
+	// circuitBreakers guards the forwards to each member by a circuit breaker, it is nil
+	// unless the failure rate configured
+	circuitBreakers struct {
+		failureRate float64
+		minRequests int
+		window      time.Duration
+		openTimeout time.Duration
+		probes      int
+
+		mu       sync.Mutex
+		breakers map[string]*memberBreaker
+	}
+
+	// memberBreaker is closed while the member is healthy, it trips open once the
+	// failure rate of a window reached the threshold and the forwards fail fast, then
+	// it turns half-open after the open timeout and lets the limited probes through,
+	// which close it if all succeeded or open it again if any failed
+	memberBreaker struct {
+		addr        string
+		mu          sync.Mutex
+		state       breakerState
+		windowStart time.Time
+		requests    int
+		failures    int
+		openedAt    time.Time
+		probing     int // probes in flight
+		probed      int // succeeded probes
+
+		stateGauge *metrics.Gauge
+		trips      *metrics.Counter
+		rejected   *metrics.Counter
+	}
+)
+
+func newCircuitBreakers(n *Node) *circuitBreakers {
+	c := &circuitBreakers{
+		failureRate: n.BreakerFailureRate,
+		minRequests: n.BreakerMinRequests,
+		window:      n.BreakerWindow,
+		openTimeout: n.BreakerOpenTimeout,
+		probes:      n.BreakerProbes,
+		breakers:    map[string]*memberBreaker{},
+	}
+	if c.minRequests <= 0 {
+		c.minRequests = defaultBreakerMinRequests
+	}
+	if c.window <= 0 {
+		c.window = defaultBreakerWindow
+	}
+	if c.openTimeout <= 0 {
+		c.openTimeout = defaultBreakerOpenTimeout
+	}
+	if c.probes <= 0 {
+		c.probes = defaultBreakerProbes
+	}
+	return c
+}
+
+func (c *circuitBreakers) breaker(addr string) *memberBreaker {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	b, found := c.breakers[addr]
+	if !found {
+		b = &memberBreaker{
+			addr:        addr,
+			windowStart: env.Clock.Now(),
+			stateGauge:  metrics.Default.Gauge(metricBreakerState, "target", addr),
+			trips:       metrics.Default.Counter(metricBreakerTrips, "target", addr),
+			rejected:    metrics.Default.Counter(metricBreakerRejected, "target", addr),
+		}
+		b.stateGauge.Set(int64(breakerClosed))
+		c.breakers[addr] = b
+	}
+	return b
+}
+
+// allow reports whether a forward to the member is allowed, probe is true if the
+// forward probes the half-open breaker. The result of the allowed forward must be
+// reported by done
+func (c *circuitBreakers) allow(addr string) (ok, probe bool) {
+	if c == nil {
+		return true, false
+	}
+
+	b := c.breaker(addr)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := env.Clock.Now()
+	if b.state == breakerOpen && now.Sub(b.openedAt) >= c.openTimeout {
+		b.setState(breakerHalfOpen)
+		b.probing, b.probed = 0, 0
+	}
+	switch b.state {
+	case breakerOpen:
+		b.rejected.Inc()
+		return false, false
+	case breakerHalfOpen:
+		if b.probing+b.probed >= c.probes {
+			b.rejected.Inc()
+			return false, false
+		}
+		b.probing++
+		return true, true
+	}
+	return true, false
+}
+
+// done reports the result of an allowed forward to the member
+func (c *circuitBreakers) done(addr string, probe bool, err error) {
+	if c == nil {
+		return
+	}
+
+	b := c.breaker(addr)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := env.Clock.Now()
+	if probe {
+		if b.state != breakerHalfOpen {
+			return
+		}
+		b.probing--
+		if err != nil {
+			b.trip(now)
+			return
+		}
+		b.probed++
+		if b.probed >= c.probes {
+			b.setState(breakerClosed)
+			b.windowStart, b.requests, b.failures = now, 0, 0
+			log.Println("Circuit breaker of member is closed", b.addr)
+		}
+		return
+	}
+	if b.state != breakerClosed {
+		// the forward allowed before the breaker tripped
+		return
+	}
+
+	if now.Sub(b.windowStart) >= c.window {
+		b.windowStart, b.requests, b.failures = now, 0, 0
+	}
+	b.requests++
+	if err != nil {
+		b.failures++
+	}
+	if b.requests >= c.minRequests && float64(b.failures) >= c.failureRate*float64(b.requests) {
+		b.trip(now)
+	}
+}
+
+// trip opens the breaker, the caller must hold the lock
+func (b *memberBreaker) trip(now time.Time) {
+	b.setState(breakerOpen)
+	b.openedAt = now
+	b.trips.Inc()
+	log.Println("Circuit breaker of member is open", b.addr)
+}
+
+func (b *memberBreaker) setState(state breakerState) {
+	b.state = state
+	b.stateGauge.Set(int64(state))
+}
@@ -0,0 +1,98 @@
+package cluster
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/lonng/nano/internal/clock"
+	"github.com/lonng/nano/internal/env"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	defer func(c clock.Clock) { env.Clock = c }(env.Clock)
+	manual := clock.NewManual(time.Unix(1000, 0))
+	env.Clock = manual
+
+	c := newCircuitBreakers(&Node{Options: Options{
+		BreakerFailureRate: 0.5,
+		BreakerMinRequests: 4,
+		BreakerWindow:      time.Second,
+		BreakerOpenTimeout: 2 * time.Second,
+	}})
+	addr := "127.0.0.1:4902"
+	b := c.breaker(addr)
+	trips, rejected := b.trips.Value(), b.rejected.Value()
+	failed := errors.New("forward failed")
+
+	forward := func(err error) {
+		ok, probe := c.allow(addr)
+		if !ok {
+			t.Fatal("forward should be allowed")
+		}
+		c.done(addr, probe, err)
+	}
+
+	// the failures of the expired window are discarded
+	forward(failed)
+	forward(failed)
+	manual.Advance(time.Second)
+	forward(nil)
+	forward(failed)
+	forward(nil)
+	forward(failed)
+	if b.state != breakerOpen || b.trips.Value()-trips != 1 {
+		t.Fatalf("breaker should trip open, state=%d", b.state)
+	}
+	if b.stateGauge.Value() != int64(breakerOpen) {
+		t.Fatalf("unexpected state gauge %d", b.stateGauge.Value())
+	}
+	if ok, _ := c.allow(addr); ok {
+		t.Fatal("forward should fail fast while open")
+	}
+	if b.rejected.Value()-rejected != 1 {
+		t.Fatalf("unexpected rejected %d", b.rejected.Value()-rejected)
+	}
+
+	// the other members are not affected
+	if ok, _ := c.allow("127.0.0.1:4903"); !ok {
+		t.Fatal("forward to other member should be allowed")
+	}
+
+	// half-open lets one probe through, the failed probe opens it again
+	manual.Advance(2 * time.Second)
+	ok, probe := c.allow(addr)
+	if !ok || !probe {
+		t.Fatal("probe should be allowed after the open timeout")
+	}
+	if ok, _ := c.allow(addr); ok {
+		t.Fatal("forward should be rejected while probing")
+	}
+	c.done(addr, probe, failed)
+	if b.state != breakerOpen || b.trips.Value()-trips != 2 {
+		t.Fatalf("failed probe should open the breaker, state=%d", b.state)
+	}
+
+	// the succeeded probe closes it
+	manual.Advance(2 * time.Second)
+	ok, probe = c.allow(addr)
+	if !ok || !probe {
+		t.Fatal("probe should be allowed after the open timeout")
+	}
+	c.done(addr, probe, nil)
+	if b.state != breakerClosed || b.stateGauge.Value() != int64(breakerClosed) {
+		t.Fatalf("succeeded probe should close the breaker, state=%d", b.state)
+	}
+	forward(failed)
+	if b.state != breakerClosed {
+		t.Fatal("breaker should count the failures from a new window")
+	}
+}
+
+func TestCircuitBreakerNil(t *testing.T) {
+	var c *circuitBreakers
+	if ok, probe := c.allow("127.0.0.1:4902"); !ok || probe {
+		t.Fatal("nil breakers should allow all forwards")
+	}
+	c.done("127.0.0.1:4902", false, errors.New("forward failed"))
+}
@@ -0,0 +1,190 @@
+package cluster
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/lonng/nano/internal/env"
+	"github.com/lonng/nano/metrics"
+	"github.com/lonng/nano/session"
+)
+
+// metricRouteCache counts the lookups of the cached routes, labeled by route, result
+// (hit/miss) and the service address of current node
+const metricRouteCache = "nano_route_cache_total"
+
+type (
+	cacheEntry struct {
+		data   []byte
+		expire time.Time
+	}
+
+	// routeCache holds the serialized responses of a route, the entries are keyed by
+	// the owner (uid if bound, otherwise session id) and the request payload
+	routeCache struct {
+		ttl       time.Duration
+		entries   map[string]map[string]cacheEntry
+		lastSweep time.Time
+
+		hits   *metrics.Counter
+		misses *metrics.Counter
+	}
+
+	// cacheFill identifies a request missed the cache, whose response fills the cache
+	cacheFill struct {
+		session *session.Session
+		mid     uint64
+	}
+
+	pendingFill struct {
+		route *routeCache
+		owner string
+		key   string
+		at    time.Time
+	}
+
+	// responseCache caches the responses of the routes registered with the cache option,
+	// the cached response is served to the same request of the same session or uid
+	// before the handler is dispatched, until the ttl elapsed or invalidated
+	responseCache struct {
+		mu     sync.Mutex
+		routes map[string]*routeCache
+		fills  map[cacheFill]pendingFill
+	}
+)
+
+func newResponseCache() *responseCache {
+	return &responseCache{
+		routes: map[string]*routeCache{},
+		fills:  map[cacheFill]pendingFill{},
+	}
+}
+
+// cacheOwner returns the owner of the entries cached for the session, the entries of the
+// bound uid are shared by its sessions and can be invalidated by uid
+func cacheOwner(s *session.Session) string {
+	if uid := s.UID(); uid > 0 {
+		return "u" + strconv.FormatInt(uid, 10)
+	}
+	return "s" + strconv.FormatInt(s.ID(), 10)
+}
+
+// register enables the cache of route with the ttl
+func (c *responseCache) register(route string, ttl time.Duration, member string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.routes[route] = &routeCache{
+		ttl:     ttl,
+		entries: map[string]map[string]cacheEntry{},
+		hits:    metrics.Default.Counter(metricRouteCache, "route", route, "result", "hit", "member", member),
+		misses:  metrics.Default.Counter(metricRouteCache, "route", route, "result", "miss", "member", member),
+	}
+}
+
+// enabled reports whether any route is cached
+func (c *responseCache) enabled() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return len(c.routes) > 0
+}
+
+// lookup returns the cached response of the request, the request missed the cache is
+// recorded and its response fills the cache. The second return value is false if the
+// route is not cached
+func (c *responseCache) lookup(s *session.Session, mid uint64, route string, payload []byte) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	rc, found := c.routes[route]
+	if !found {
+		return nil, false
+	}
+
+	now := env.Clock.Now()
+	owner, key := cacheOwner(s), string(payload)
+	if e, found := rc.entries[owner][key]; found {
+		if now.Before(e.expire) {
+			rc.hits.Inc()
+			return e.data, true
+		}
+		delete(rc.entries[owner], key)
+	}
+	rc.misses.Inc()
+
+	if now.Sub(rc.lastSweep) > rc.ttl {
+		rc.lastSweep = now
+		c.sweep(rc, now)
+	}
+	c.fills[cacheFill{session: s, mid: mid}] = pendingFill{route: rc, owner: owner, key: key, at: now}
+	return nil, true
+}
+
+// sweep removes the expired entries of rc and the requests never responded
+func (c *responseCache) sweep(rc *routeCache, now time.Time) {
+	for owner, entries := range rc.entries {
+		for key, e := range entries {
+			if !now.Before(e.expire) {
+				delete(entries, key)
+			}
+		}
+		if len(entries) == 0 {
+			delete(rc.entries, owner)
+		}
+	}
+	for f, p := range c.fills {
+		if p.route == rc && now.Sub(p.at) > rc.ttl {
+			delete(c.fills, f)
+		}
+	}
+}
+
+// fill caches the serialized response of the request missed the cache, the streamed
+// responses are not cached
+func (c *responseCache) fill(s *session.Session, mid uint64, data []byte, more bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	f := cacheFill{session: s, mid: mid}
+	p, found := c.fills[f]
+	if !found {
+		return
+	}
+	delete(c.fills, f)
+	if more {
+		return
+	}
+
+	entries := p.route.entries[p.owner]
+	if entries == nil {
+		entries = map[string]cacheEntry{}
+		p.route.entries[p.owner] = entries
+	}
+	entries[p.key] = cacheEntry{data: data, expire: env.Clock.Now().Add(p.route.ttl)}
+}
+
+// invalidate removes the cached responses of route for uid, or all the cached responses
+// of route if uid is zero
+func (c *responseCache) invalidate(route string, uid int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	rc, found := c.routes[route]
+	if !found {
+		return
+	}
+	owner := "u" + strconv.FormatInt(uid, 10)
+	if uid == 0 {
+		rc.entries = map[string]map[string]cacheEntry{}
+	} else {
+		delete(rc.entries, owner)
+	}
+	// the responses of the requests in flight are stale as well
+	for f, p := range c.fills {
+		if p.route == rc && (uid == 0 || p.owner == owner) {
+			delete(c.fills, f)
+		}
+	}
+}
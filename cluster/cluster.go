@@ -24,9 +24,13 @@ import (
 	"context"
 	"fmt"
 	"sync"
+	"time"
 
 	"github.com/lonng/nano/cluster/clusterpb"
+	"github.com/lonng/nano/internal/env"
 	"github.com/lonng/nano/internal/log"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 // cluster represents a nano cluster, which contains a bunch of nano nodes
@@ -39,10 +43,27 @@ type cluster struct {
 
 	mu      sync.RWMutex
 	members []*Member
+
+	// new members which are waiting to be announced to the existing members
+	muAnnounce sync.Mutex
+	announces  []*clusterpb.MemberInfo
+	announcing bool
+
+	// the gate and session which currently holds the uid
+	muSessions sync.RWMutex
+	sessions   map[int64]*clusterpb.BindSessionRequest
+}
+
+// serializerName returns the identity of the application serializer
+func serializerName() string {
+	return fmt.Sprintf("%T", env.Serializer)
 }
 
 func newCluster(currentNode *Node) *cluster {
-	return &cluster{currentNode: currentNode}
+	return &cluster{
+		currentNode: currentNode,
+		sessions:    map[int64]*clusterpb.BindSessionRequest{},
+	}
 }
 
 // Register implements the MasterServer gRPC service
@@ -51,38 +72,67 @@ func (c *cluster) Register(_ context.Context, req *clusterpb.RegisterRequest) (*
 		return nil, ErrInvalidRegisterReq
 	}
 
+	// The payloads forwarded between members are encoded by the application serializer,
+	// members with different serializers cannot decode the payloads of each other
+	if name := req.MemberInfo.Serializer; name != "" && name != serializerName() {
+		return nil, status.Errorf(codes.FailedPrecondition, "%s: member %s uses %s, master uses %s",
+			ErrSerializerMismatch.Error(), req.MemberInfo.ServiceAddr, name, serializerName())
+	}
+
+	// A member registers again with the same address if it restarted before the previous
+	// instance has been unregistered, the entry of the previous one is replaced
 	resp := &clusterpb.RegisterResponse{}
+	registered := false
+	c.mu.RLock()
 	for _, m := range c.members {
 		if m.memberInfo.ServiceAddr == req.MemberInfo.ServiceAddr {
-			return nil, fmt.Errorf("address %s has registered", req.MemberInfo.ServiceAddr)
+			registered = true
+			continue
 		}
+		resp.Members = append(resp.Members, m.memberInfo)
 	}
+	c.mu.RUnlock()
 
-	// Notify registered node to update remote services
-	newMember := &clusterpb.NewMemberRequest{MemberInfo: req.MemberInfo}
-	for _, m := range c.members {
-		resp.Members = append(resp.Members, m.memberInfo)
-		if m.isMaster {
-			continue
-		}
-		pool, err := c.rpcClient.getConnPool(m.memberInfo.ServiceAddr)
-		if err != nil {
-			return nil, err
-		}
-		client := clusterpb.NewMemberClient(pool.Get())
-		_, err = client.NewMember(context.Background(), newMember)
-		if err != nil {
-			return nil, err
+	// Notify registered node to update remote services, the notifications will be
+	// coalesced into a batch if debounce enabled
+	if debounce := c.currentNode.NewMemberDebounce; debounce > 0 {
+		c.announce(req.MemberInfo, debounce)
+	} else {
+		newMember := &clusterpb.NewMemberRequest{MemberInfo: req.MemberInfo}
+		for _, m := range c.members {
+			if m.isMaster || m.memberInfo.ServiceAddr == req.MemberInfo.ServiceAddr {
+				continue
+			}
+			pool, err := c.rpcClient.getConnPool(m.memberInfo.ServiceAddr)
+			if err != nil {
+				return nil, err
+			}
+			client := clusterpb.NewMemberClient(pool.Get())
+			_, err = client.NewMember(context.Background(), newMember)
+			if err != nil {
+				return nil, err
+			}
 		}
 	}
 
-	log.Println("New peer register to cluster", req.MemberInfo.ServiceAddr)
+	if registered {
+		log.Println("Peer register to cluster again", req.MemberInfo.ServiceAddr)
+
+		// The sessions held by the previous instance have gone
+		c.muSessions.Lock()
+		for uid, b := range c.sessions {
+			if b.GateAddr == req.MemberInfo.ServiceAddr {
+				delete(c.sessions, uid)
+			}
+		}
+		c.muSessions.Unlock()
+	} else {
+		log.Println("New peer register to cluster", req.MemberInfo.ServiceAddr)
+	}
 
 	// Register services to current node
 	c.currentNode.handler.addRemoteService(req.MemberInfo)
-	c.mu.Lock()
-	c.members = append(c.members, &Member{isMaster: false, memberInfo: req.MemberInfo})
-	c.mu.Unlock()
+	c.addMember(req.MemberInfo)
 	return resp, nil
 }
 
@@ -92,49 +142,250 @@ func (c *cluster) Unregister(_ context.Context, req *clusterpb.UnregisterRequest
 		return nil, ErrInvalidRegisterReq
 	}
 
-	var index = -1
+	found := false
 	resp := &clusterpb.UnregisterResponse{}
-	for i, m := range c.members {
-		if m.memberInfo.ServiceAddr == req.ServiceAddr {
-			index = i
+	for _, addr := range c.remoteAddrs() {
+		if addr == req.ServiceAddr {
+			found = true
 			break
 		}
 	}
-	if index < 0 {
+	if !found {
 		return nil, fmt.Errorf("address %s has  notregistered", req.ServiceAddr)
 	}
 
-	// Notify registered node to update remote services
+	// Notify registered node to update remote services, the members leaving at the
+	// same time, e.g: in a cluster shutdown, may be unreachable
 	delMember := &clusterpb.DelMemberRequest{ServiceAddr: req.ServiceAddr}
-	for _, m := range c.members {
-		if m.MemberInfo().ServiceAddr == c.currentNode.ServiceAddr {
+	for _, addr := range c.remoteAddrs() {
+		if addr == c.currentNode.ServiceAddr {
 			continue
 		}
-		pool, err := c.rpcClient.getConnPool(m.memberInfo.ServiceAddr)
+		pool, err := c.rpcClient.getConnPool(addr)
 		if err != nil {
-			return nil, err
+			log.Println("Retrieve member address error", addr, err)
+			continue
 		}
 		client := clusterpb.NewMemberClient(pool.Get())
 		_, err = client.DelMember(context.Background(), delMember)
 		if err != nil {
-			return nil, err
+			log.Println("Notify member to delete member failed", addr, req.ServiceAddr, err)
 		}
 	}
 
 	log.Println("Exists peer unregister to cluster", req.ServiceAddr)
 
+	// The member has gone, needn't to announce it anymore
+	c.muAnnounce.Lock()
+	for i, info := range c.announces {
+		if info.ServiceAddr == req.ServiceAddr {
+			c.announces = append(c.announces[:i], c.announces[i+1:]...)
+			break
+		}
+	}
+	c.muAnnounce.Unlock()
+
+	// The sessions held by the member have gone
+	c.muSessions.Lock()
+	for uid, b := range c.sessions {
+		if b.GateAddr == req.ServiceAddr {
+			delete(c.sessions, uid)
+		}
+	}
+	c.muSessions.Unlock()
+
 	// Register services to current node
 	c.currentNode.handler.delMember(req.ServiceAddr)
+	// the index is looked up again as the members may change during the notifications
 	c.mu.Lock()
-	if index == len(c.members)-1 {
-		c.members = c.members[:index]
-	} else {
-		c.members = append(c.members[:index], c.members[index+1:]...)
+	for i, m := range c.members {
+		if m.memberInfo.ServiceAddr == req.ServiceAddr {
+			c.members = append(c.members[:i], c.members[i+1:]...)
+			break
+		}
 	}
 	c.mu.Unlock()
 	return resp, nil
 }
 
+// SyncMembers implements the MasterServer gRPC service
+func (c *cluster) SyncMembers(_ context.Context, _ *clusterpb.SyncMembersRequest) (*clusterpb.SyncMembersResponse, error) {
+	resp := &clusterpb.SyncMembersResponse{}
+	c.mu.RLock()
+	for _, m := range c.members {
+		resp.Members = append(resp.Members, m.memberInfo)
+	}
+	c.mu.RUnlock()
+	return resp, nil
+}
+
+// BindSession implements the MasterServer gRPC service
+func (c *cluster) BindSession(_ context.Context, req *clusterpb.BindSessionRequest) (*clusterpb.BindSessionResponse, error) {
+	if req.Uid < 1 || req.GateAddr == "" {
+		return nil, ErrInvalidBindSessionReq
+	}
+	c.muSessions.Lock()
+	c.sessions[req.Uid] = req
+	c.muSessions.Unlock()
+	return &clusterpb.BindSessionResponse{}, nil
+}
+
+// LocateSession implements the MasterServer gRPC service
+func (c *cluster) LocateSession(_ context.Context, req *clusterpb.LocateSessionRequest) (*clusterpb.LocateSessionResponse, error) {
+	c.muSessions.RLock()
+	b, found := c.sessions[req.Uid]
+	c.muSessions.RUnlock()
+	if !found {
+		return nil, fmt.Errorf("session of uid %d not found", req.Uid)
+	}
+	return &clusterpb.LocateSessionResponse{GateAddr: b.GateAddr, SessionId: b.SessionId}, nil
+}
+
+// UnbindSession implements the MasterServer gRPC service, the uid is kept if it has
+// been bound to another session since, e.g: the client reconnected to another gate
+func (c *cluster) UnbindSession(_ context.Context, req *clusterpb.UnbindSessionRequest) (*clusterpb.UnbindSessionResponse, error) {
+	c.muSessions.Lock()
+	if b, found := c.sessions[req.Uid]; found && b.GateAddr == req.GateAddr && b.SessionId == req.SessionId {
+		delete(c.sessions, req.Uid)
+	}
+	c.muSessions.Unlock()
+	return &clusterpb.UnbindSessionResponse{}, nil
+}
+
+// announce queues the new member and announces all queued members to the
+// existing members in a single batch after the debounce window
+func (c *cluster) announce(info *clusterpb.MemberInfo, debounce time.Duration) {
+	c.muAnnounce.Lock()
+	defer c.muAnnounce.Unlock()
+
+	// the member registered again before announced is announced once with the latest info
+	for i, announce := range c.announces {
+		if announce.ServiceAddr == info.ServiceAddr {
+			c.announces = append(c.announces[:i], c.announces[i+1:]...)
+			break
+		}
+	}
+	c.announces = append(c.announces, info)
+	if c.announcing {
+		return
+	}
+	c.announcing = true
+	env.Clock.AfterFunc(debounce, c.flushAnnounces)
+}
+
+func (c *cluster) flushAnnounces() {
+	c.muAnnounce.Lock()
+	announces := c.announces
+	c.announces = nil
+	c.announcing = false
+	c.muAnnounce.Unlock()
+
+	if len(announces) == 0 {
+		return
+	}
+
+	c.mu.RLock()
+	members := make([]*Member, len(c.members))
+	copy(members, c.members)
+	c.mu.RUnlock()
+
+	for _, m := range members {
+		if m.isMaster {
+			continue
+		}
+		// members registered later have received the earlier ones in the
+		// register response, receivers should ignore the duplicated members
+		request := &clusterpb.NewMembersRequest{}
+		for _, info := range announces {
+			if info.ServiceAddr != m.memberInfo.ServiceAddr {
+				request.MemberInfos = append(request.MemberInfos, info)
+			}
+		}
+		if len(request.MemberInfos) == 0 {
+			continue
+		}
+		pool, err := c.rpcClient.getConnPool(m.memberInfo.ServiceAddr)
+		if err != nil {
+			log.Println("Announce new members failed", m.memberInfo.ServiceAddr, err)
+			continue
+		}
+		client := clusterpb.NewMemberClient(pool.Get())
+		_, err = client.NewMembers(context.Background(), request)
+		if err != nil {
+			log.Println("Announce new members failed", m.memberInfo.ServiceAddr, err)
+		}
+	}
+}
+
+// exportRegistry returns the registered members except the master itself
+func (c *cluster) exportRegistry() []*clusterpb.MemberInfo {
+	var members []*clusterpb.MemberInfo
+	c.mu.RLock()
+	for _, m := range c.members {
+		if m.isMaster {
+			continue
+		}
+		members = append(members, m.memberInfo)
+	}
+	c.mu.RUnlock()
+	return members
+}
+
+// importRegistry registers the members exported from the previous master, and
+// points each of them to the current master
+func (c *cluster) importRegistry(members []*clusterpb.MemberInfo) error {
+	var imported []*clusterpb.MemberInfo
+	for _, info := range members {
+		if info.ServiceAddr == c.currentNode.ServiceAddr {
+			continue
+		}
+		c.currentNode.handler.addRemoteService(info)
+		c.addMember(info)
+		imported = append(imported, info)
+	}
+
+	var err error
+	for _, info := range imported {
+		if e := c.notifyMasterChanged(info); e != nil {
+			log.Println("Notify master changed failed", info.ServiceAddr, e)
+			err = fmt.Errorf("notify member %s master changed failed: %v", info.ServiceAddr, e)
+		}
+	}
+	return err
+}
+
+// restoreRegistry registers the members in the registry snapshot of a restarted master,
+// each of them is verified by notifying it the master changed, and the unreachable ones
+// are dropped
+func (c *cluster) restoreRegistry(members []*clusterpb.MemberInfo) {
+	for _, info := range members {
+		if info.ServiceAddr == c.currentNode.ServiceAddr {
+			continue
+		}
+		if err := c.notifyMasterChanged(info); err != nil {
+			log.Println("Drop unreachable member of registry snapshot", info.ServiceAddr, err)
+			continue
+		}
+		c.currentNode.handler.addRemoteService(info)
+		c.addMember(info)
+		log.Println("Restore member from registry snapshot", info.ServiceAddr)
+	}
+}
+
+// notifyMasterChanged notifies the member to switch to current master
+func (c *cluster) notifyMasterChanged(info *clusterpb.MemberInfo) error {
+	pool, err := c.rpcClient.getConnPool(info.ServiceAddr)
+	if err != nil {
+		return err
+	}
+	request := &clusterpb.MasterChangedRequest{
+		Master: c.currentNode.memberInfo(),
+	}
+	client := clusterpb.NewMemberClient(pool.Get())
+	_, err = client.MasterChanged(context.Background(), request)
+	return err
+}
+
 func (c *cluster) setRpcClient(client *rpcClient) {
 	c.rpcClient = client
 }
@@ -159,6 +410,18 @@ func (c *cluster) initMembers(members []*clusterpb.MemberInfo) {
 	c.mu.Unlock()
 }
 
+// syncMembers replaces the local members with the authoritative member list
+func (c *cluster) syncMembers(members []*clusterpb.MemberInfo) {
+	c.mu.Lock()
+	c.members = c.members[:0]
+	for _, info := range members {
+		c.members = append(c.members, &Member{
+			memberInfo: info,
+		})
+	}
+	c.mu.Unlock()
+}
+
 func (c *cluster) addMember(info *clusterpb.MemberInfo) {
 	c.mu.Lock()
 	var found bool
@@ -0,0 +1,3377 @@
+package cluster_test
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	goio "io"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/gorilla/websocket"
+	"github.com/lonng/nano/benchmark/io"
+	"github.com/lonng/nano/benchmark/testdata"
+	"github.com/lonng/nano/cluster"
+	"github.com/lonng/nano/cluster/clusterpb"
+	pcodec "github.com/lonng/nano/codec"
+	"github.com/lonng/nano/codec/codectest"
+	"github.com/lonng/nano/component"
+	"github.com/lonng/nano/internal/clock"
+	"github.com/lonng/nano/internal/codec"
+	"github.com/lonng/nano/internal/env"
+	"github.com/lonng/nano/internal/message"
+	"github.com/lonng/nano/internal/packet"
+	"github.com/lonng/nano/metrics"
+	"github.com/lonng/nano/pipeline"
+	"github.com/lonng/nano/scheduler"
+	"github.com/lonng/nano/serialize"
+	jsonserializer "github.com/lonng/nano/serialize/json"
+	"github.com/lonng/nano/serialize/protobuf"
+	"github.com/lonng/nano/session"
+	. "github.com/pingcap/check"
+	"google.golang.org/grpc"
+)
+
+type clusterSuite struct{}
+
+var _ = Suite(&clusterSuite{})
+
+type (
+	LoginComponent struct{ component.Base }
+	OrderComponent struct {
+		component.Base
+		pending chan *session.Session
+	}
+)
+
+func (c *LoginComponent) Login(s *session.Session, _ *testdata.Ping) error {
+	if err := s.Bind(1); err != nil {
+		return err
+	}
+	return s.Response(&testdata.Pong{Content: "logged in"})
+}
+
+// Wait never responds, the response is waiting for the migrated request
+func (c *LoginComponent) Wait(s *session.Session, _ *testdata.Ping) error {
+	return nil
+}
+
+// Buy responds later by the test
+func (c *OrderComponent) Buy(s *session.Session, _ *testdata.Ping) error {
+	c.pending <- s
+	return nil
+}
+
+func connect(c *C, addr string) *io.Connector {
+	connector := io.NewConnector()
+	chWait := make(chan struct{})
+	connector.OnConnected(func() {
+		chWait <- struct{}{}
+	})
+	// the client listener is started asynchronously by the node
+	var err error
+	for i := 0; i < 10; i++ {
+		if err = connector.Start(addr); err == nil {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	c.Assert(err, IsNil)
+	<-chWait
+	return connector
+}
+
+func (s *clusterSuite) TestNewMemberDebounce(c *C) {
+	masterComps := &component.Components{}
+	masterComps.Register(&MasterComponent{})
+	masterNode := &cluster.Node{
+		Options: cluster.Options{
+			IsMaster:          true,
+			Components:        masterComps,
+			NewMemberDebounce: 50 * time.Millisecond,
+		},
+		ServiceAddr: "127.0.0.1:4460",
+	}
+	err := masterNode.Startup()
+	c.Assert(err, IsNil)
+	defer masterNode.Shutdown()
+
+	member1Comps := &component.Components{}
+	member1Comps.Register(&GateComponent{})
+	memberNode1 := &cluster.Node{
+		Options: cluster.Options{
+			AdvertiseAddr: "127.0.0.1:4460",
+			Components:    member1Comps,
+		},
+		ServiceAddr: "127.0.0.1:14461",
+	}
+	err = memberNode1.Startup()
+	c.Assert(err, IsNil)
+	defer memberNode1.Shutdown()
+
+	member2Comps := &component.Components{}
+	member2Comps.Register(&GameComponent{})
+	memberNode2 := &cluster.Node{
+		Options: cluster.Options{
+			AdvertiseAddr: "127.0.0.1:4460",
+			Components:    member2Comps,
+		},
+		ServiceAddr: "127.0.0.1:24461",
+	}
+	err = memberNode2.Startup()
+	c.Assert(err, IsNil)
+	defer memberNode2.Shutdown()
+
+	// member2 has received member1 in register response
+	c.Assert(memberNode2.Handler().RemoteService(), DeepEquals, []string{"GateComponent", "MasterComponent"})
+	// member1 will be notified after the debounce window
+	c.Assert(memberNode1.Handler().RemoteService(), DeepEquals, []string{"MasterComponent"})
+
+	time.Sleep(200 * time.Millisecond)
+	c.Assert(memberNode1.Handler().RemoteService(), DeepEquals, []string{"GameComponent", "MasterComponent"})
+	c.Assert(memberNode2.Handler().RemoteService(), DeepEquals, []string{"GateComponent", "MasterComponent"})
+}
+
+func (s *clusterSuite) TestSyncMembers(c *C) {
+	masterComps := &component.Components{}
+	masterComps.Register(&MasterComponent{})
+	masterNode := &cluster.Node{
+		Options: cluster.Options{
+			IsMaster:   true,
+			Components: masterComps,
+			// announcements will not be delivered during the test
+			NewMemberDebounce: time.Hour,
+		},
+		ServiceAddr: "127.0.0.1:4470",
+	}
+	err := masterNode.Startup()
+	c.Assert(err, IsNil)
+	defer masterNode.Shutdown()
+
+	member1Comps := &component.Components{}
+	member1Comps.Register(&GateComponent{})
+	memberNode1 := &cluster.Node{
+		Options: cluster.Options{
+			AdvertiseAddr:       "127.0.0.1:4470",
+			Components:          member1Comps,
+			SyncMembersInterval: 50 * time.Millisecond,
+		},
+		ServiceAddr: "127.0.0.1:14471",
+	}
+	err = memberNode1.Startup()
+	c.Assert(err, IsNil)
+	defer memberNode1.Shutdown()
+
+	member2Comps := &component.Components{}
+	member2Comps.Register(&GameComponent{})
+	memberNode2 := &cluster.Node{
+		Options: cluster.Options{
+			AdvertiseAddr: "127.0.0.1:4470",
+			Components:    member2Comps,
+		},
+		ServiceAddr: "127.0.0.1:24471",
+	}
+	err = memberNode2.Startup()
+	c.Assert(err, IsNil)
+	defer memberNode2.Shutdown()
+
+	c.Assert(memberNode1.Handler().RemoteService(), DeepEquals, []string{"MasterComponent"})
+
+	// member1 reconciles the missed member with the master
+	time.Sleep(200 * time.Millisecond)
+	c.Assert(memberNode1.Handler().RemoteService(), DeepEquals, []string{"GameComponent", "MasterComponent"})
+}
+
+func (s *clusterSuite) TestMasterHandoff(c *C) {
+	masterComps := &component.Components{}
+	masterComps.Register(&MasterComponent{})
+	masterNode := &cluster.Node{
+		Options: cluster.Options{
+			IsMaster:   true,
+			Components: masterComps,
+		},
+		ServiceAddr: "127.0.0.1:4480",
+	}
+	err := masterNode.Startup()
+	c.Assert(err, IsNil)
+	defer masterNode.Shutdown()
+
+	memberComps := &component.Components{}
+	memberComps.Register(&GateComponent{})
+	memberNode := &cluster.Node{
+		Options: cluster.Options{
+			AdvertiseAddr: "127.0.0.1:4480",
+			Components:    memberComps,
+		},
+		ServiceAddr: "127.0.0.1:14481",
+	}
+	err = memberNode.Startup()
+	c.Assert(err, IsNil)
+	c.Assert(memberNode.Handler().RemoteService(), DeepEquals, []string{"MasterComponent"})
+
+	replacementComps := &component.Components{}
+	replacementComps.Register(&GameComponent{})
+	replacementNode := &cluster.Node{
+		Options: cluster.Options{
+			IsMaster:   true,
+			Components: replacementComps,
+		},
+		ServiceAddr: "127.0.0.1:5480",
+	}
+	err = replacementNode.Startup()
+	c.Assert(err, IsNil)
+	defer replacementNode.Shutdown()
+
+	registry := masterNode.ExportRegistry()
+	c.Assert(registry, HasLen, 1)
+	c.Assert(registry[0].ServiceAddr, Equals, "127.0.0.1:14481")
+	c.Assert(memberNode.ImportRegistry(registry), Equals, cluster.ErrNotMaster)
+
+	err = replacementNode.ImportRegistry(registry)
+	c.Assert(err, IsNil)
+	c.Assert(replacementNode.Handler().RemoteService(), DeepEquals, []string{"GateComponent"})
+	c.Assert(memberNode.Handler().RemoteService(), DeepEquals, []string{"GameComponent"})
+
+	// member unregisters from the replacement master
+	memberNode.Shutdown()
+	c.Assert(replacementNode.ExportRegistry(), HasLen, 0)
+}
+
+func (s *clusterSuite) TestResponseToMigratedGate(c *C) {
+	// scheduler will be closed by the node suite
+	go scheduler.Sched()
+
+	masterNode := &cluster.Node{
+		Options: cluster.Options{
+			IsMaster:   true,
+			Components: &component.Components{},
+		},
+		ServiceAddr: "127.0.0.1:4490",
+	}
+	err := masterNode.Startup()
+	c.Assert(err, IsNil)
+	defer masterNode.Shutdown()
+
+	var gates []*cluster.Node
+	for _, addr := range []string{"127.0.0.1:14491", "127.0.0.1:24491"} {
+		comps := &component.Components{}
+		comps.Register(&LoginComponent{})
+		gate := &cluster.Node{
+			Options: cluster.Options{
+				AdvertiseAddr: "127.0.0.1:4490",
+				ClientAddr:    strings.Replace(addr, "91", "92", 1),
+				Components:    comps,
+				TCPNoDelay:    true,
+			},
+			ServiceAddr: addr,
+		}
+		err = gate.Startup()
+		c.Assert(err, IsNil)
+		defer gate.Shutdown()
+		gates = append(gates, gate)
+	}
+
+	order := &OrderComponent{pending: make(chan *session.Session, 1)}
+	comps := &component.Components{}
+	comps.Register(order)
+	orderNode := &cluster.Node{
+		Options: cluster.Options{
+			AdvertiseAddr: "127.0.0.1:4490",
+			Components:    comps,
+		},
+		ServiceAddr: "127.0.0.1:34491",
+	}
+	err = orderNode.Startup()
+	c.Assert(err, IsNil)
+	defer orderNode.Shutdown()
+
+	onResult := make(chan string, 1)
+	callback := func(data interface{}) {
+		onResult <- string(data.([]byte))
+	}
+
+	// login on the first gate, and send a request to the order node
+	client1 := connect(c, "127.0.0.1:14492")
+	c.Assert(client1.Request("LoginComponent.Login", &testdata.Ping{Content: "ping"}, callback), IsNil)
+	c.Assert(strings.Contains(<-onResult, "logged in"), IsTrue)
+	c.Assert(client1.Request("OrderComponent.Buy", &testdata.Ping{Content: "ping"}, callback), IsNil)
+	pending := <-order.pending
+	c.Assert(pending.UID(), Equals, int64(1))
+
+	// the client migrates to the second gate, and waits the response with the same id
+	client1.Close()
+	client2 := connect(c, "127.0.0.1:24492")
+	defer client2.Close()
+	c.Assert(client2.Request("LoginComponent.Login", &testdata.Ping{Content: "ping"}, callback), IsNil)
+	c.Assert(strings.Contains(<-onResult, "logged in"), IsTrue)
+	c.Assert(client2.Request("LoginComponent.Wait", &testdata.Ping{Content: "ping"}, callback), IsNil)
+	time.Sleep(100 * time.Millisecond)
+
+	err = pending.ResponseMID(pending.LastMid(), &testdata.Pong{Content: "bought"})
+	c.Assert(err, IsNil)
+	c.Assert(strings.Contains(<-onResult, "bought"), IsTrue)
+}
+
+func (s *clusterSuite) TestRegisterSerializerMismatch(c *C) {
+	masterNode := &cluster.Node{
+		Options: cluster.Options{
+			IsMaster:   true,
+			Components: &component.Components{},
+		},
+		ServiceAddr: "127.0.0.1:4500",
+	}
+	err := masterNode.Startup()
+	c.Assert(err, IsNil)
+	defer masterNode.Shutdown()
+
+	conn, err := grpc.Dial("127.0.0.1:4500", grpc.WithInsecure())
+	c.Assert(err, IsNil)
+	defer conn.Close()
+
+	client := clusterpb.NewMasterClient(conn)
+	_, err = client.Register(context.Background(), &clusterpb.RegisterRequest{
+		MemberInfo: &clusterpb.MemberInfo{
+			ServiceAddr: "127.0.0.1:14501",
+			Serializer:  "*json.Serializer",
+		},
+	})
+	c.Assert(err, NotNil)
+	c.Assert(strings.Contains(err.Error(), cluster.ErrSerializerMismatch.Error()), IsTrue)
+	c.Assert(masterNode.ExportRegistry(), HasLen, 0)
+}
+
+type SlowComponent struct {
+	component.Base
+	delay time.Duration
+}
+
+func (c *SlowComponent) Init() {
+	time.Sleep(c.delay)
+}
+
+func (c *SlowComponent) Ping(s *session.Session, _ *testdata.Ping) error {
+	return s.Response(&testdata.Pong{Content: "pong"})
+}
+
+func (s *clusterSuite) TestInitTimeout(c *C) {
+	comps := &component.Components{}
+	comps.Register(&SlowComponent{delay: time.Second})
+	node := &cluster.Node{
+		Options: cluster.Options{
+			IsMaster:    true,
+			Components:  comps,
+			InitTimeout: 50 * time.Millisecond,
+		},
+		ServiceAddr: "127.0.0.1:4520",
+	}
+	err := node.Startup()
+	c.Assert(err, NotNil)
+	c.Assert(err, ErrorMatches, "component SlowComponent Init timeout after 50ms")
+	node.Shutdown()
+
+	// the component overrides the timeout of node
+	comps = &component.Components{}
+	comps.Register(&SlowComponent{delay: 100 * time.Millisecond}, component.WithInitTimeout(0))
+	node = &cluster.Node{
+		Options: cluster.Options{
+			IsMaster:    true,
+			Components:  comps,
+			InitTimeout: 50 * time.Millisecond,
+		},
+		ServiceAddr: "127.0.0.1:14520",
+	}
+	err = node.Startup()
+	c.Assert(err, IsNil)
+	node.Shutdown()
+
+	// the member failed to start up leaves the cluster
+	masterNode := &cluster.Node{
+		Options: cluster.Options{
+			IsMaster:   true,
+			Components: &component.Components{},
+		},
+		ServiceAddr: "127.0.0.1:4521",
+	}
+	err = masterNode.Startup()
+	c.Assert(err, IsNil)
+	defer masterNode.Shutdown()
+
+	comps = &component.Components{}
+	comps.Register(&SlowComponent{delay: time.Second})
+	memberNode := &cluster.Node{
+		Options: cluster.Options{
+			AdvertiseAddr: "127.0.0.1:4521",
+			Components:    comps,
+			InitTimeout:   50 * time.Millisecond,
+		},
+		ServiceAddr: "127.0.0.1:14521",
+	}
+	err = memberNode.Startup()
+	c.Assert(err, ErrorMatches, "component SlowComponent Init timeout after 50ms")
+	c.Assert(masterNode.ExportRegistry(), HasLen, 0)
+	select {
+	case <-memberNode.Done():
+	default:
+		c.Fatal("member is not shut down after the startup failed")
+	}
+}
+
+func (s *clusterSuite) TestAdmin(c *C) {
+	// scheduler will be closed by the node suite
+	go scheduler.Sched()
+
+	comps := &component.Components{}
+	comps.Register(&LoginComponent{})
+	node := &cluster.Node{
+		Options: cluster.Options{
+			IsMaster:    true,
+			Components:  comps,
+			ClientAddr:  "127.0.0.1:14530",
+			AdminAddr:   "127.0.0.1:24530",
+			AdminToken:  "secret",
+			AdminReload: func() error { return errors.New("bad config") },
+		},
+		ServiceAddr: "127.0.0.1:4530",
+	}
+	err := node.Startup()
+	c.Assert(err, IsNil)
+	defer node.Shutdown()
+
+	call := func(method, path, token string) (int, string) {
+		req, err := http.NewRequest(method, "http://127.0.0.1:24530"+path, nil)
+		c.Assert(err, IsNil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := http.DefaultClient.Do(req)
+		c.Assert(err, IsNil)
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		c.Assert(err, IsNil)
+		return resp.StatusCode, string(body)
+	}
+
+	code, _ := call(http.MethodGet, "/members", "wrong")
+	c.Assert(code, Equals, http.StatusUnauthorized)
+
+	code, body := call(http.MethodGet, "/members", "secret")
+	c.Assert(code, Equals, http.StatusOK)
+	c.Assert(strings.Contains(body, `"serviceAddr":"127.0.0.1:4530"`), IsTrue)
+
+	code, body = call(http.MethodGet, "/routes", "secret")
+	c.Assert(code, Equals, http.StatusOK)
+	var table cluster.RouteTable
+	c.Assert(json.Unmarshal([]byte(body), &table), IsNil)
+	c.Assert(table.Routes, HasLen, 2)
+	c.Assert(table.Routes[0].Route, Equals, "LoginComponent.Login")
+	c.Assert(table.Routes[0].Arg, Equals, "*testdata.Ping")
+	c.Assert(table.Routes[0].Fields, DeepEquals, []cluster.ArgField{{Name: "Content", Key: "Content", Type: "string"}})
+
+	code, body = call(http.MethodPost, "/reload", "secret")
+	c.Assert(code, Equals, http.StatusInternalServerError)
+	c.Assert(strings.Contains(body, "bad config"), IsTrue)
+
+	onResult := make(chan string, 1)
+	client := connect(c, "127.0.0.1:14530")
+	defer client.Close()
+	c.Assert(client.Request("LoginComponent.Login", &testdata.Ping{Content: "ping"}, func(data interface{}) {
+		onResult <- string(data.([]byte))
+	}), IsNil)
+	c.Assert(strings.Contains(<-onResult, "logged in"), IsTrue)
+
+	code, _ = call(http.MethodPost, "/kick?uid=2", "secret")
+	c.Assert(code, Equals, http.StatusNotFound)
+	code, body = call(http.MethodPost, "/kick?uid=1", "secret")
+	c.Assert(code, Equals, http.StatusOK)
+	c.Assert(body, Equals, "{\"kicked\":1}\n")
+	// the kicked session is not counted again
+	for i := 0; i < 100 && code != http.StatusNotFound; i++ {
+		time.Sleep(10 * time.Millisecond)
+		code, _ = call(http.MethodPost, "/kick?uid=1", "secret")
+	}
+	c.Assert(code, Equals, http.StatusNotFound)
+
+	// the new connections are closed after draining
+	code, _ = call(http.MethodPost, "/drain", "secret")
+	c.Assert(code, Equals, http.StatusOK)
+	conn, err := net.Dial("tcp", "127.0.0.1:14530")
+	c.Assert(err, IsNil)
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = conn.Read(make([]byte, 1))
+	c.Assert(err, Equals, goio.EOF)
+}
+
+func (s *clusterSuite) TestRawMode(c *C) {
+	// scheduler will be closed by the node suite
+	go scheduler.Sched()
+
+	comps := &component.Components{}
+	comps.Register(&LoginComponent{})
+	node := &cluster.Node{
+		Options: cluster.Options{
+			IsMaster:   true,
+			Components: comps,
+			ClientAddr: "127.0.0.1:14540",
+			RawMode:    true,
+		},
+		ServiceAddr: "127.0.0.1:4540",
+	}
+	err := node.Startup()
+	c.Assert(err, IsNil)
+	defer node.Shutdown()
+	time.Sleep(100 * time.Millisecond)
+
+	conn, err := net.Dial("tcp", "127.0.0.1:14540")
+	c.Assert(err, IsNil)
+	defer conn.Close()
+
+	// send a request right after connecting
+	data, err := proto.Marshal(&testdata.Ping{Content: "ping"})
+	c.Assert(err, IsNil)
+	m, err := message.Encode(&message.Message{Type: message.Request, ID: 1, Route: "LoginComponent.Login", Data: data})
+	c.Assert(err, IsNil)
+	p, err := codec.Encode(packet.Data, m)
+	c.Assert(err, IsNil)
+	_, err = conn.Write(p)
+	c.Assert(err, IsNil)
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, 1024)
+	n, err := conn.Read(buf)
+	c.Assert(err, IsNil)
+	packets, err := codec.NewDecoder().Decode(buf[:n])
+	c.Assert(err, IsNil)
+	c.Assert(packets, HasLen, 1)
+	c.Assert(packets[0].Type, Equals, packet.Type(packet.Data))
+	resp, err := message.Decode(packets[0].Data)
+	c.Assert(err, IsNil)
+	c.Assert(resp.ID, Equals, uint64(1))
+	c.Assert(strings.Contains(string(resp.Data), "logged in"), IsTrue)
+
+	// heartbeat is rejected in raw mode
+	p, err = codec.Encode(packet.Heartbeat, nil)
+	c.Assert(err, IsNil)
+	_, err = conn.Write(p)
+	c.Assert(err, IsNil)
+	_, err = conn.Read(buf)
+	c.Assert(err, Equals, goio.EOF)
+}
+
+func (s *clusterSuite) TestRouteACL(c *C) {
+	// scheduler will be closed by the node suite
+	go scheduler.Sched()
+
+	comps := &component.Components{}
+	comps.Register(&LoginComponent{})
+	node := &cluster.Node{
+		Options: cluster.Options{
+			IsMaster:   true,
+			Components: comps,
+			ClientAddr: "127.0.0.1:14550",
+			RouteACL: map[string]func(*session.Session) bool{
+				"LoginComponent.*": func(s *session.Session) bool {
+					return s.String("role") != "banned"
+				},
+				"LoginComponent.Wait": func(s *session.Session) bool {
+					return s.String("role") == "admin"
+				},
+			},
+		},
+		ServiceAddr: "127.0.0.1:4550",
+	}
+	err := node.Startup()
+	c.Assert(err, IsNil)
+	defer node.Shutdown()
+
+	onResult := make(chan string, 1)
+	callback := func(data interface{}) {
+		onResult <- string(data.([]byte))
+	}
+
+	client := connect(c, "127.0.0.1:14550")
+	defer client.Close()
+	c.Assert(client.Request("LoginComponent.Login", &testdata.Ping{Content: "ping"}, callback), IsNil)
+	c.Assert(strings.Contains(<-onResult, "logged in"), IsTrue)
+	c.Assert(client.Request("LoginComponent.Wait", &testdata.Ping{Content: "ping"}, callback), IsNil)
+	c.Assert(<-onResult, Equals, `{"code":403,"msg":"permission denied"}`)
+}
+
+func xor(data []byte) []byte {
+	out := make([]byte, len(data))
+	for i := range data {
+		out[i] = data[i] ^ 0x5a
+	}
+	return out
+}
+
+func (s *clusterSuite) TestPayloadTransform(c *C) {
+	// scheduler will be closed by the node suite
+	go scheduler.Sched()
+
+	comps := &component.Components{}
+	comps.Register(&LoginComponent{})
+	node := &cluster.Node{
+		Options: cluster.Options{
+			IsMaster:          true,
+			Components:        comps,
+			ClientAddr:        "127.0.0.1:14560",
+			RawMode:           true,
+			OutboundTransform: xor,
+			InboundTransform: func(data []byte) ([]byte, error) {
+				return xor(data), nil
+			},
+		},
+		ServiceAddr: "127.0.0.1:4560",
+	}
+	err := node.Startup()
+	c.Assert(err, IsNil)
+	defer node.Shutdown()
+	time.Sleep(100 * time.Millisecond)
+
+	conn, err := net.Dial("tcp", "127.0.0.1:14560")
+	c.Assert(err, IsNil)
+	defer conn.Close()
+
+	data, err := proto.Marshal(&testdata.Ping{Content: "ping"})
+	c.Assert(err, IsNil)
+	m, err := message.Encode(&message.Message{Type: message.Request, ID: 1, Route: "LoginComponent.Login", Data: xor(data)})
+	c.Assert(err, IsNil)
+	p, err := codec.Encode(packet.Data, m)
+	c.Assert(err, IsNil)
+	_, err = conn.Write(p)
+	c.Assert(err, IsNil)
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, 1024)
+	n, err := conn.Read(buf)
+	c.Assert(err, IsNil)
+	packets, err := codec.NewDecoder().Decode(buf[:n])
+	c.Assert(err, IsNil)
+	c.Assert(packets, HasLen, 1)
+	resp, err := message.Decode(packets[0].Data)
+	c.Assert(err, IsNil)
+	c.Assert(strings.Contains(string(resp.Data), "logged in"), IsFalse)
+	pong := &testdata.Pong{}
+	c.Assert(proto.Unmarshal(xor(resp.Data), pong), IsNil)
+	c.Assert(pong.Content, Equals, "logged in")
+}
+
+func (s *clusterSuite) TestStats(c *C) {
+	// scheduler will be closed by the node suite
+	go scheduler.Sched()
+
+	comps := &component.Components{}
+	comps.Register(&LoginComponent{})
+	node := &cluster.Node{
+		Options: cluster.Options{
+			IsMaster:   true,
+			Components: comps,
+			ClientAddr: "127.0.0.1:14570",
+		},
+		ServiceAddr: "127.0.0.1:4570",
+	}
+	err := node.Startup()
+	c.Assert(err, IsNil)
+	defer node.Shutdown()
+
+	onResult := make(chan string, 1)
+	client := connect(c, "127.0.0.1:14570")
+	c.Assert(client.Request("LoginComponent.Login", &testdata.Ping{Content: "ping"}, func(data interface{}) {
+		onResult <- string(data.([]byte))
+	}), IsNil)
+	c.Assert(strings.Contains(<-onResult, "logged in"), IsTrue)
+
+	stats := node.Stats()
+	c.Assert(stats.Connections, Equals, int64(1))
+	c.Assert(stats.MessagesIn, Equals, int64(1))
+	c.Assert(stats.MessagesOut, Equals, int64(1))
+	c.Assert(stats.Goroutines > 0, IsTrue)
+	c.Assert(stats.Routes, DeepEquals, map[string]int64{"LoginComponent.Login": 1})
+
+	client.Close()
+	time.Sleep(100 * time.Millisecond)
+	c.Assert(node.Stats().Connections, Equals, int64(0))
+}
+
+func (s *clusterSuite) TestEphemeralPort(c *C) {
+	masterNode := &cluster.Node{
+		Options: cluster.Options{
+			IsMaster:   true,
+			Components: &component.Components{},
+		},
+		ServiceAddr: "127.0.0.1:0",
+	}
+	err := masterNode.Startup()
+	c.Assert(err, IsNil)
+	defer masterNode.Shutdown()
+	c.Assert(strings.HasSuffix(masterNode.BoundServiceAddr(), ":0"), IsFalse)
+
+	comps := &component.Components{}
+	comps.Register(&LoginComponent{})
+	memberNode := &cluster.Node{
+		Options: cluster.Options{
+			AdvertiseAddr: masterNode.BoundServiceAddr(),
+			ClientAddr:    "127.0.0.1:0",
+			Components:    comps,
+		},
+		ServiceAddr: "127.0.0.1:0",
+	}
+	err = memberNode.Startup()
+	c.Assert(err, IsNil)
+	defer memberNode.Shutdown()
+
+	// the member registers the resolved address
+	registry := masterNode.ExportRegistry()
+	c.Assert(registry, HasLen, 1)
+	c.Assert(registry[0].ServiceAddr, Equals, memberNode.BoundServiceAddr())
+	c.Assert(strings.HasSuffix(memberNode.BoundClientAddr(), ":0"), IsFalse)
+
+	client := connect(c, memberNode.BoundClientAddr())
+	client.Close()
+}
+
+func (s *clusterSuite) TestSystemPacketLimit(c *C) {
+	node := &cluster.Node{
+		Options: cluster.Options{
+			IsMaster:           true,
+			Components:         &component.Components{},
+			ClientAddr:         "127.0.0.1:14580",
+			SystemPacketLimit:  5,
+			SystemPacketWindow: time.Minute,
+		},
+		ServiceAddr: "127.0.0.1:4580",
+	}
+	err := node.Startup()
+	c.Assert(err, IsNil)
+	defer node.Shutdown()
+
+	conn, err := net.Dial("tcp", "127.0.0.1:14580")
+	c.Assert(err, IsNil)
+	defer conn.Close()
+
+	// flood heartbeats, the connection is closed after exceeding the limit
+	p, err := codec.Encode(packet.Heartbeat, nil)
+	c.Assert(err, IsNil)
+	for i := 0; i < 20; i++ {
+		if _, err := conn.Write(p); err != nil {
+			break
+		}
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = goio.Copy(ioutil.Discard, conn)
+	c.Assert(err, IsNil)
+}
+
+type CertComponent struct{ component.Base }
+
+func (c *CertComponent) Whoami(s *session.Session, _ []byte) error {
+	cert := s.ClientCertificate()
+	if cert == nil {
+		return s.Response([]byte("anonymous"))
+	}
+	return s.Response([]byte(cert.Subject.CommonName))
+}
+
+// issueCert issues a certificate signed by the parent, a self-signed certificate
+// will be issued if the parent is nil
+func issueCert(c *C, cn string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	c.Assert(err, IsNil)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	c.Assert(err, IsNil)
+	cert, err := x509.ParseCertificate(der)
+	c.Assert(err, IsNil)
+	return cert, key
+}
+
+func writePEM(c *C, path, typ string, der []byte) {
+	c.Assert(ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0600), IsNil)
+}
+
+func (s *clusterSuite) TestClientCertAuth(c *C) {
+	// scheduler will be closed by the node suite
+	go scheduler.Sched()
+
+	wsPath := env.WSPath
+	env.WSPath = "/mtls"
+	defer func() { env.WSPath = wsPath }()
+
+	dir := c.MkDir()
+	ca, caKey := issueCert(c, "nano-ca", nil, nil)
+	serverCert, serverKey := issueCert(c, "nano-server", ca, caKey)
+	clientCert, clientKey := issueCert(c, "player-1", ca, caKey)
+	writePEM(c, filepath.Join(dir, "ca.pem"), "CERTIFICATE", ca.Raw)
+	writePEM(c, filepath.Join(dir, "server.pem"), "CERTIFICATE", serverCert.Raw)
+	der, err := x509.MarshalECPrivateKey(serverKey)
+	c.Assert(err, IsNil)
+	writePEM(c, filepath.Join(dir, "server.key"), "EC PRIVATE KEY", der)
+
+	comps := &component.Components{}
+	comps.Register(&CertComponent{})
+	node := &cluster.Node{
+		Options: cluster.Options{
+			IsMaster:          true,
+			Components:        comps,
+			ClientAddr:        "127.0.0.1:14590",
+			IsWebsocket:       true,
+			TSLCertificate:    filepath.Join(dir, "server.pem"),
+			TSLKey:            filepath.Join(dir, "server.key"),
+			ClientCAFile:      filepath.Join(dir, "ca.pem"),
+			RequireClientCert: true,
+		},
+		ServiceAddr: "127.0.0.1:4590",
+	}
+	err = node.Startup()
+	c.Assert(err, IsNil)
+	defer node.Shutdown()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+
+	// the client without certificate is rejected
+	dialer := websocket.Dialer{TLSClientConfig: &tls.Config{RootCAs: roots}}
+	_, _, err = dialer.Dial("wss://127.0.0.1:14590/mtls", nil)
+	c.Assert(err, NotNil)
+
+	dialer.TLSClientConfig.Certificates = []tls.Certificate{{
+		Certificate: [][]byte{clientCert.Raw},
+		PrivateKey:  clientKey,
+	}}
+	conn, _, err := dialer.Dial("wss://127.0.0.1:14590/mtls", nil)
+	c.Assert(err, IsNil)
+	defer conn.Close()
+
+	send := func(typ packet.Type, data []byte) {
+		p, err := codec.Encode(typ, data)
+		c.Assert(err, IsNil)
+		c.Assert(conn.WriteMessage(websocket.BinaryMessage, p), IsNil)
+	}
+	recv := func() *packet.Packet {
+		_, data, err := conn.ReadMessage()
+		c.Assert(err, IsNil)
+		packets, err := codec.NewDecoder().Decode(data)
+		c.Assert(err, IsNil)
+		c.Assert(packets, HasLen, 1)
+		return packets[0]
+	}
+
+	send(packet.Handshake, []byte(`{"sys":{}}`))
+	c.Assert(recv().Type, Equals, packet.Type(packet.Handshake))
+	send(packet.HandshakeAck, nil)
+	m, err := message.Encode(&message.Message{Type: message.Request, ID: 1, Route: "CertComponent.Whoami", Data: []byte("?")})
+	c.Assert(err, IsNil)
+	send(packet.Data, m)
+	resp, err := message.Decode(recv().Data)
+	c.Assert(err, IsNil)
+	c.Assert(string(resp.Data), Equals, "player-1")
+}
+
+type AuthComponent struct{ component.Base }
+
+func (c *AuthComponent) Whoami(s *session.Session, _ []byte) error {
+	return s.Response([]byte(strconv.FormatInt(s.UID(), 10)))
+}
+
+func (s *clusterSuite) TestWSAuthenticate(c *C) {
+	// scheduler will be closed by the node suite
+	go scheduler.Sched()
+
+	wsPath := env.WSPath
+	env.WSPath = "/auth"
+	defer func() { env.WSPath = wsPath }()
+
+	comps := &component.Components{}
+	comps.Register(&AuthComponent{})
+	node := &cluster.Node{
+		Options: cluster.Options{
+			ClientAddr:  "127.0.0.1:14800",
+			IsWebsocket: true,
+			Components:  comps,
+			WSAuthenticate: func(r *http.Request) (int64, error) {
+				if r.URL.Query().Get("token") != "secret" {
+					return 0, errors.New("invalid token")
+				}
+				return 42, nil
+			},
+		},
+		ServiceAddr: "127.0.0.1:4800",
+	}
+	err := node.Startup()
+	c.Assert(err, IsNil)
+	defer node.Shutdown()
+
+	// the upgrade without a valid token is refused
+	var resp *http.Response
+	for i := 0; i < 10; i++ {
+		_, resp, err = websocket.DefaultDialer.Dial("ws://127.0.0.1:14800/auth?token=guess", nil)
+		if resp != nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	c.Assert(err, NotNil)
+	c.Assert(resp, NotNil)
+	c.Assert(resp.StatusCode, Equals, http.StatusUnauthorized)
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws://127.0.0.1:14800/auth?token=secret", nil)
+	c.Assert(err, IsNil)
+	defer conn.Close()
+
+	send := func(typ packet.Type, data []byte) {
+		p, err := codec.Encode(typ, data)
+		c.Assert(err, IsNil)
+		c.Assert(conn.WriteMessage(websocket.BinaryMessage, p), IsNil)
+	}
+	recv := func() *packet.Packet {
+		_, data, err := conn.ReadMessage()
+		c.Assert(err, IsNil)
+		packets, err := codec.NewDecoder().Decode(data)
+		c.Assert(err, IsNil)
+		c.Assert(packets, HasLen, 1)
+		return packets[0]
+	}
+
+	// the uid is bound without an in-band login message
+	send(packet.Handshake, []byte(`{"sys":{}}`))
+	c.Assert(recv().Type, Equals, packet.Type(packet.Handshake))
+	send(packet.HandshakeAck, nil)
+	m, err := message.Encode(&message.Message{Type: message.Request, ID: 1, Route: "AuthComponent.Whoami", Data: []byte("?")})
+	c.Assert(err, IsNil)
+	send(packet.Data, m)
+	res, err := message.Decode(recv().Data)
+	c.Assert(err, IsNil)
+	c.Assert(string(res.Data), Equals, "42")
+}
+
+func (s *clusterSuite) TestAuthenticator(c *C) {
+	// scheduler will be closed by the node suite
+	go scheduler.Sched()
+
+	comps := &component.Components{}
+	comps.Register(&AuthComponent{})
+	node := &cluster.Node{
+		Options: cluster.Options{
+			IsMaster:   true,
+			Components: comps,
+			ClientAddr: "127.0.0.1:14600",
+			Authenticator: func(ctx context.Context, handshake []byte) (int64, error) {
+				if string(handshake) != "token-42" {
+					return 0, errors.New("invalid token")
+				}
+				return 42, nil
+			},
+			AuthTimeout: time.Second,
+		},
+		ServiceAddr: "127.0.0.1:4600",
+	}
+	err := node.Startup()
+	c.Assert(err, IsNil)
+	defer node.Shutdown()
+
+	handshake := func(token string) (net.Conn, map[string]interface{}) {
+		conn, err := net.Dial("tcp", "127.0.0.1:14600")
+		c.Assert(err, IsNil)
+		p, err := codec.Encode(packet.Handshake, []byte(token))
+		c.Assert(err, IsNil)
+		_, err = conn.Write(p)
+		c.Assert(err, IsNil)
+
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		buf := make([]byte, 1024)
+		n, err := conn.Read(buf)
+		c.Assert(err, IsNil)
+		packets, err := codec.NewDecoder().Decode(buf[:n])
+		c.Assert(err, IsNil)
+		c.Assert(packets[0].Type, Equals, packet.Type(packet.Handshake))
+		resp := map[string]interface{}{}
+		c.Assert(json.Unmarshal(packets[0].Data, &resp), IsNil)
+		return conn, resp
+	}
+
+	// rejected before reaching any route
+	conn, resp := handshake("bad")
+	c.Assert(resp["code"], Equals, float64(401))
+	c.Assert(resp["msg"], Equals, "invalid token")
+	_, err = goio.Copy(ioutil.Discard, conn)
+	c.Assert(err, IsNil)
+	conn.Close()
+
+	// the session is bound to the uid returned by authenticator
+	conn, resp = handshake("token-42")
+	defer conn.Close()
+	c.Assert(resp["code"], Equals, float64(200))
+
+	ack, err := codec.Encode(packet.HandshakeAck, nil)
+	c.Assert(err, IsNil)
+	data, err := message.Encode(&message.Message{Type: message.Request, ID: 1, Route: "AuthComponent.Whoami", Data: []byte("{}")})
+	c.Assert(err, IsNil)
+	req, err := codec.Encode(packet.Data, data)
+	c.Assert(err, IsNil)
+	_, err = conn.Write(append(ack, req...))
+	c.Assert(err, IsNil)
+
+	buf := make([]byte, 1024)
+	n, err := conn.Read(buf)
+	c.Assert(err, IsNil)
+	packets, err := codec.NewDecoder().Decode(buf[:n])
+	c.Assert(err, IsNil)
+	msg, err := message.Decode(packets[0].Data)
+	c.Assert(err, IsNil)
+	c.Assert(string(msg.Data), Equals, "42")
+}
+
+type PlainComponent struct{ component.Base }
+
+func (c *PlainComponent) Echo(s *session.Session, msg *struct{ Content string }) error {
+	return s.Response(msg)
+}
+
+func (s *clusterSuite) TestNegotiateSerializer(c *C) {
+	// scheduler will be closed by the node suite
+	go scheduler.Sched()
+
+	// the handler arguments must be compatible with the negotiable serializers
+	comps := &component.Components{}
+	comps.Register(&PlainComponent{})
+	node := &cluster.Node{
+		Options: cluster.Options{
+			IsMaster:    true,
+			Components:  comps,
+			Serializers: map[string]serialize.Serializer{"protobuf": protobuf.NewSerializer()},
+		},
+		ServiceAddr: "127.0.0.1:4611",
+	}
+	err := node.Startup()
+	c.Assert(err, ErrorMatches, "handler PlainComponent.Echo argument .* is incompatible with serializer protobuf: .*")
+
+	comps = &component.Components{}
+	comps.Register(&LoginComponent{})
+	node = &cluster.Node{
+		Options: cluster.Options{
+			IsMaster:    true,
+			Components:  comps,
+			ClientAddr:  "127.0.0.1:14610",
+			Serializers: map[string]serialize.Serializer{"json": jsonserializer.NewSerializer()},
+		},
+		ServiceAddr: "127.0.0.1:4610",
+	}
+	err = node.Startup()
+	c.Assert(err, IsNil)
+	defer node.Shutdown()
+
+	conn, err := net.Dial("tcp", "127.0.0.1:14610")
+	c.Assert(err, IsNil)
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+
+	recv := func() *packet.Packet {
+		buf := make([]byte, 1024)
+		n, err := conn.Read(buf)
+		c.Assert(err, IsNil)
+		packets, err := codec.NewDecoder().Decode(buf[:n])
+		c.Assert(err, IsNil)
+		c.Assert(packets, HasLen, 1)
+		return packets[0]
+	}
+
+	p, err := codec.Encode(packet.Handshake, []byte(`{"sys":{"serializer":["xml","json"]}}`))
+	c.Assert(err, IsNil)
+	_, err = conn.Write(p)
+	c.Assert(err, IsNil)
+	c.Assert(string(recv().Data), Matches, `.*"serializer":"json".*`)
+
+	// the payloads of the connection are encoded in json
+	ack, err := codec.Encode(packet.HandshakeAck, nil)
+	c.Assert(err, IsNil)
+	data, err := message.Encode(&message.Message{Type: message.Request, ID: 1, Route: "LoginComponent.Login", Data: []byte(`{"Content":"ping"}`)})
+	c.Assert(err, IsNil)
+	req, err := codec.Encode(packet.Data, data)
+	c.Assert(err, IsNil)
+	_, err = conn.Write(append(ack, req...))
+	c.Assert(err, IsNil)
+
+	msg, err := message.Decode(recv().Data)
+	c.Assert(err, IsNil)
+	c.Assert(string(msg.Data), Equals, `{"Content":"logged in"}`)
+
+	// the negotiation is exposed by the session
+	var negotiated []string
+	node.ForEachSession(func(s *session.Session) bool {
+		negotiated = append(negotiated, s.Serializer(), s.Compression())
+		return true
+	})
+	c.Assert(negotiated, DeepEquals, []string{"json", ""})
+}
+
+func (s *clusterSuite) TestForEachSession(c *C) {
+	// scheduler will be closed by the node suite
+	go scheduler.Sched()
+
+	comps := &component.Components{}
+	comps.Register(&LoginComponent{})
+	node := &cluster.Node{
+		Options: cluster.Options{
+			IsMaster:   true,
+			Components: comps,
+			ClientAddr: "127.0.0.1:14620",
+		},
+		ServiceAddr: "127.0.0.1:4620",
+	}
+	err := node.Startup()
+	c.Assert(err, IsNil)
+	defer node.Shutdown()
+
+	onResult := make(chan string, 1)
+	client1 := connect(c, "127.0.0.1:14620")
+	defer client1.Close()
+	c.Assert(client1.Request("LoginComponent.Login", &testdata.Ping{Content: "ping"}, func(data interface{}) {
+		onResult <- string(data.([]byte))
+	}), IsNil)
+	<-onResult
+	client2 := connect(c, "127.0.0.1:14620")
+
+	var uids []int64
+	node.ForEachSession(func(s *session.Session) bool {
+		uids = append(uids, s.UID())
+		if s.UID() == 1 {
+			// handshake, ack and the request are received, the handshake response
+			// and the response are sent
+			c.Assert(s.BytesIn() > 0, IsTrue)
+			c.Assert(s.BytesOut() > 0, IsTrue)
+		}
+		return true
+	})
+	c.Assert(uids, HasLen, 2)
+
+	// stop early
+	visited := 0
+	node.ForEachSession(func(s *session.Session) bool {
+		visited++
+		return false
+	})
+	c.Assert(visited, Equals, 1)
+
+	// the closed sessions are not visited
+	client2.Close()
+	time.Sleep(100 * time.Millisecond)
+	uids = uids[:0]
+	node.ForEachSession(func(s *session.Session) bool {
+		uids = append(uids, s.UID())
+		return true
+	})
+	c.Assert(uids, DeepEquals, []int64{1})
+}
+
+func (s *clusterSuite) TestObserver(c *C) {
+	// scheduler will be closed by the node suite
+	go scheduler.Sched()
+
+	masterComps := &component.Components{}
+	masterComps.Register(&LoginComponent{})
+	masterNode := &cluster.Node{
+		Options: cluster.Options{
+			IsMaster:   true,
+			Components: masterComps,
+			ClientAddr: "127.0.0.1:14630",
+			TeeRoutes:  []string{"LoginComponent.*", "Room.Chat"},
+		},
+		ServiceAddr: "127.0.0.1:4630",
+	}
+	err := masterNode.Startup()
+	c.Assert(err, IsNil)
+	defer masterNode.Shutdown()
+
+	observed := make(chan *cluster.Observation, 4)
+	observerComps := &component.Components{}
+	observerComps.Register(&OrderComponent{})
+	observerNode := &cluster.Node{
+		Options: cluster.Options{
+			AdvertiseAddr: "127.0.0.1:4630",
+			Components:    observerComps,
+			Observe: func(o *cluster.Observation) {
+				observed <- o
+			},
+		},
+		ServiceAddr: "127.0.0.1:4631",
+	}
+	err = observerNode.Startup()
+	c.Assert(err, IsNil)
+	defer observerNode.Shutdown()
+	c.Assert(observerNode.IsObserver(), IsTrue)
+
+	// observers never serve the routes
+	c.Assert(masterNode.Handler().RemoteService(), HasLen, 0)
+
+	client := connect(c, "127.0.0.1:14630")
+	defer client.Close()
+	c.Assert(client.Notify("LoginComponent.Wait", &testdata.Ping{Content: "hi"}), IsNil)
+
+	o := <-observed
+	c.Assert(o.Kind, Equals, cluster.ObserveNotify)
+	c.Assert(o.Route, Equals, "LoginComponent.Wait")
+	c.Assert(o.Gate, Equals, "127.0.0.1:4630")
+	ping := &testdata.Ping{}
+	c.Assert(proto.Unmarshal(o.Data, ping), IsNil)
+	c.Assert(ping.Content, Equals, "hi")
+
+	// the broadcasts of routes not teed are not observed
+	c.Assert(masterNode.PushMany(nil, "Room.Leave", &testdata.Pong{Content: "bye"}), IsNil)
+	c.Assert(masterNode.PushMany(nil, "Room.Chat", &testdata.Pong{Content: "hello"}), IsNil)
+	o = <-observed
+	c.Assert(o.Kind, Equals, cluster.ObserveBroadcast)
+	c.Assert(o.Route, Equals, "Room.Chat")
+	select {
+	case o := <-observed:
+		c.Fatalf("unexpected observation %s", o.Route)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func (s *clusterSuite) TestSingleSessionPerUID(c *C) {
+	// scheduler will be closed by the node suite
+	go scheduler.Sched()
+
+	comps := &component.Components{}
+	comps.Register(&LoginComponent{})
+	node := &cluster.Node{
+		Options: cluster.Options{
+			IsMaster:            true,
+			Components:          comps,
+			ClientAddr:          "127.0.0.1:14640",
+			SingleSessionPerUID: true,
+			KickReason:          "replaced",
+		},
+		ServiceAddr: "127.0.0.1:4640",
+	}
+	err := node.Startup()
+	c.Assert(err, IsNil)
+	defer node.Shutdown()
+
+	// the first client logs in as uid 1 with raw packets to observe the kick packet
+	var conn net.Conn
+	for i := 0; i < 10; i++ {
+		if conn, err = net.Dial("tcp", "127.0.0.1:14640"); err == nil {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	c.Assert(err, IsNil)
+	defer conn.Close()
+
+	hs, err := codec.Encode(packet.Handshake, nil)
+	c.Assert(err, IsNil)
+	ack, err := codec.Encode(packet.HandshakeAck, nil)
+	c.Assert(err, IsNil)
+	ping, err := proto.Marshal(&testdata.Ping{Content: "ping"})
+	c.Assert(err, IsNil)
+	data, err := message.Encode(&message.Message{Type: message.Request, ID: 1, Route: "LoginComponent.Login", Data: ping})
+	c.Assert(err, IsNil)
+	req, err := codec.Encode(packet.Data, data)
+	c.Assert(err, IsNil)
+	_, err = conn.Write(append(append(hs, ack...), req...))
+	c.Assert(err, IsNil)
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	decoder := codec.NewDecoder()
+	var pending []*packet.Packet
+	next := func() *packet.Packet {
+		for len(pending) == 0 {
+			buf := make([]byte, 1024)
+			n, err := conn.Read(buf)
+			c.Assert(err, IsNil)
+			packets, err := decoder.Decode(buf[:n])
+			c.Assert(err, IsNil)
+			pending = append(pending, packets...)
+		}
+		p := pending[0]
+		pending = pending[1:]
+		return p
+	}
+	c.Assert(next().Type, Equals, packet.Type(packet.Handshake))
+	c.Assert(next().Type, Equals, packet.Type(packet.Data))
+
+	// the second login of uid 1 kicks the first one
+	onResult := make(chan string, 1)
+	client := connect(c, "127.0.0.1:14640")
+	defer client.Close()
+	c.Assert(client.Request("LoginComponent.Login", &testdata.Ping{Content: "ping"}, func(data interface{}) {
+		onResult <- string(data.([]byte))
+	}), IsNil)
+	<-onResult
+
+	kick := next()
+	c.Assert(kick.Type, Equals, packet.Type(packet.Kick))
+	c.Assert(string(kick.Data), Equals, "replaced")
+	_, err = goio.Copy(ioutil.Discard, conn)
+	c.Assert(err, IsNil)
+
+	time.Sleep(100 * time.Millisecond)
+	var uids []int64
+	node.ForEachSession(func(s *session.Session) bool {
+		uids = append(uids, s.UID())
+		return true
+	})
+	c.Assert(uids, DeepEquals, []int64{1})
+}
+
+func (s *clusterSuite) TestFraming(c *C) {
+	// scheduler will be closed by the node suite
+	go scheduler.Sched()
+
+	comps := &component.Components{}
+	comps.Register(&LoginComponent{})
+	node := &cluster.Node{
+		Options: cluster.Options{
+			IsMaster:      true,
+			Components:    comps,
+			ClientAddr:    "127.0.0.1:14650",
+			Framing:       codec.FramingVarint,
+			MaxPacketSize: 1 << 20,
+		},
+		ServiceAddr: "127.0.0.1:4650",
+	}
+	err := node.Startup()
+	c.Assert(err, IsNil)
+	defer node.Shutdown()
+
+	var conn net.Conn
+	for i := 0; i < 10; i++ {
+		if conn, err = net.Dial("tcp", "127.0.0.1:14650"); err == nil {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	c.Assert(err, IsNil)
+	defer conn.Close()
+
+	// the request exceeds the default packet size limit
+	hs, err := codec.FramingVarint.Encode(packet.Handshake, nil)
+	c.Assert(err, IsNil)
+	ack, err := codec.FramingVarint.Encode(packet.HandshakeAck, nil)
+	c.Assert(err, IsNil)
+	ping, err := proto.Marshal(&testdata.Ping{Content: strings.Repeat("x", 100*1024)})
+	c.Assert(err, IsNil)
+	data, err := message.Encode(&message.Message{Type: message.Request, ID: 1, Route: "LoginComponent.Login", Data: ping})
+	c.Assert(err, IsNil)
+	req, err := codec.FramingVarint.Encode(packet.Data, data)
+	c.Assert(err, IsNil)
+	_, err = conn.Write(append(append(hs, ack...), req...))
+	c.Assert(err, IsNil)
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	decoder := codec.NewFramingDecoder(codec.FramingVarint, 0)
+	var packets []*packet.Packet
+	for len(packets) < 2 {
+		buf := make([]byte, 1024)
+		n, err := conn.Read(buf)
+		c.Assert(err, IsNil)
+		ps, err := decoder.Decode(buf[:n])
+		c.Assert(err, IsNil)
+		packets = append(packets, ps...)
+	}
+	c.Assert(packets[0].Type, Equals, packet.Type(packet.Handshake))
+	c.Assert(packets[1].Type, Equals, packet.Type(packet.Data))
+	msg, err := message.Decode(packets[1].Data)
+	c.Assert(err, IsNil)
+	pong := &testdata.Pong{}
+	c.Assert(proto.Unmarshal(msg.Data, pong), IsNil)
+	c.Assert(pong.Content, Equals, "logged in")
+}
+
+type userKey struct{}
+
+type ContextComponent struct{ component.Base }
+
+func (c *ContextComponent) Whoami(ctx context.Context, s *session.Session, _ *testdata.Ping) error {
+	user, _ := ctx.Value(userKey{}).(string)
+	return s.Response(&testdata.Pong{Content: user})
+}
+
+func (s *clusterSuite) TestRequestContext(c *C) {
+	// scheduler will be closed by the node suite
+	go scheduler.Sched()
+
+	pipe := pipeline.New()
+	pipe.Inbound().PushBack(func(s *session.Session, msg *pipeline.Message) error {
+		msg.WithValue(userKey{}, "alice")
+		return nil
+	})
+
+	comps := &component.Components{}
+	comps.Register(&ContextComponent{})
+	node := &cluster.Node{
+		Options: cluster.Options{
+			IsMaster:   true,
+			Components: comps,
+			Pipeline:   pipe,
+			ClientAddr: "127.0.0.1:14660",
+		},
+		ServiceAddr: "127.0.0.1:4660",
+	}
+	err := node.Startup()
+	c.Assert(err, IsNil)
+	defer node.Shutdown()
+
+	onResult := make(chan string, 1)
+	client := connect(c, "127.0.0.1:14660")
+	defer client.Close()
+	c.Assert(client.Request("ContextComponent.Whoami", &testdata.Ping{Content: "ping"}, func(data interface{}) {
+		onResult <- string(data.([]byte))
+	}), IsNil)
+
+	pong := &testdata.Pong{}
+	c.Assert(proto.Unmarshal([]byte(<-onResult), pong), IsNil)
+	c.Assert(pong.Content, Equals, "alice")
+}
+
+type HoldComponent struct {
+	component.Base
+	entered chan struct{}
+	release chan struct{}
+}
+
+func (c *HoldComponent) Hold(s *session.Session, _ *testdata.Ping) error {
+	c.entered <- struct{}{}
+	<-c.release
+	return nil
+}
+
+func (s *clusterSuite) TestSessionQueueLimit(c *C) {
+	// scheduler will be closed by the node suite
+	go scheduler.Sched()
+
+	hold := &HoldComponent{entered: make(chan struct{}, 1), release: make(chan struct{})}
+	comps := &component.Components{}
+	comps.Register(hold)
+	node := &cluster.Node{
+		Options: cluster.Options{
+			IsMaster:          true,
+			Components:        comps,
+			ClientAddr:        "127.0.0.1:14670",
+			SessionQueueLimit: 1,
+			SessionQueueKick:  true,
+		},
+		ServiceAddr: "127.0.0.1:4670",
+	}
+	err := node.Startup()
+	c.Assert(err, IsNil)
+	defer node.Shutdown()
+
+	var conn net.Conn
+	for i := 0; i < 10; i++ {
+		if conn, err = net.Dial("tcp", "127.0.0.1:14670"); err == nil {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	c.Assert(err, IsNil)
+	defer conn.Close()
+
+	hs, err := codec.Encode(packet.Handshake, nil)
+	c.Assert(err, IsNil)
+	ack, err := codec.Encode(packet.HandshakeAck, nil)
+	c.Assert(err, IsNil)
+	ping, err := proto.Marshal(&testdata.Ping{Content: "ping"})
+	c.Assert(err, IsNil)
+	data, err := message.Encode(&message.Message{Type: message.Notify, Route: "HoldComponent.Hold", Data: ping})
+	c.Assert(err, IsNil)
+	notify, err := codec.Encode(packet.Data, data)
+	c.Assert(err, IsNil)
+	_, err = conn.Write(append(append(hs, ack...), notify...))
+	c.Assert(err, IsNil)
+
+	// the first message is held by the handler
+	<-hold.entered
+	var depth []int
+	node.ForEachSession(func(s *session.Session) bool {
+		depth = append(depth, s.QueueDepth())
+		return true
+	})
+	c.Assert(depth, DeepEquals, []int{1})
+
+	// the second message overflows the queue
+	_, err = conn.Write(notify)
+	c.Assert(err, IsNil)
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	decoder := codec.NewDecoder()
+	var packets []*packet.Packet
+	for len(packets) < 2 {
+		buf := make([]byte, 1024)
+		n, err := conn.Read(buf)
+		c.Assert(err, IsNil)
+		ps, err := decoder.Decode(buf[:n])
+		c.Assert(err, IsNil)
+		packets = append(packets, ps...)
+	}
+	c.Assert(packets[0].Type, Equals, packet.Type(packet.Handshake))
+	c.Assert(packets[1].Type, Equals, packet.Type(packet.Kick))
+	c.Assert(string(packets[1].Data), Equals, "message queue overflow")
+	close(hold.release)
+}
+
+// leCodec frames the packets with 2 bytes little endian length followed by the type
+type leCodec struct{}
+
+func (leCodec) Encode(p pcodec.Packet) ([]byte, error) {
+	if len(p.Data) > 0xffff {
+		return nil, pcodec.ErrPacketSizeExceed
+	}
+	buf := make([]byte, 3+len(p.Data))
+	binary.LittleEndian.PutUint16(buf, uint16(len(p.Data)))
+	buf[2] = byte(p.Type)
+	copy(buf[3:], p.Data)
+	return buf, nil
+}
+
+func (leCodec) Decode(r goio.Reader) (pcodec.Packet, error) {
+	var header [3]byte
+	if _, err := goio.ReadFull(r, header[:1]); err != nil {
+		return pcodec.Packet{}, err
+	}
+	if _, err := goio.ReadFull(r, header[1:]); err != nil {
+		return pcodec.Packet{}, goio.ErrUnexpectedEOF
+	}
+	size := int(binary.LittleEndian.Uint16(header[:]))
+	data := make([]byte, size)
+	if _, err := goio.ReadFull(r, data); err != nil {
+		return pcodec.Packet{}, goio.ErrUnexpectedEOF
+	}
+	return pcodec.Packet{Type: pcodec.Type(header[2]), Length: size, Data: data}, nil
+}
+
+func TestLECodecConformance(t *testing.T) {
+	codectest.TestPacketCodec(t, leCodec{})
+}
+
+func (s *clusterSuite) TestPacketCodec(c *C) {
+	// scheduler will be closed by the node suite
+	go scheduler.Sched()
+
+	comps := &component.Components{}
+	comps.Register(&LoginComponent{})
+	node := &cluster.Node{
+		Options: cluster.Options{
+			IsMaster:    true,
+			Components:  comps,
+			ClientAddr:  "127.0.0.1:14680",
+			PacketCodec: leCodec{},
+		},
+		ServiceAddr: "127.0.0.1:4680",
+	}
+	err := node.Startup()
+	c.Assert(err, IsNil)
+	defer node.Shutdown()
+
+	var conn net.Conn
+	for i := 0; i < 10; i++ {
+		if conn, err = net.Dial("tcp", "127.0.0.1:14680"); err == nil {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	c.Assert(err, IsNil)
+	defer conn.Close()
+
+	ping, err := proto.Marshal(&testdata.Ping{Content: "ping"})
+	c.Assert(err, IsNil)
+	data, err := message.Encode(&message.Message{Type: message.Request, ID: 1, Route: "LoginComponent.Login", Data: ping})
+	c.Assert(err, IsNil)
+	var out []byte
+	for _, p := range []pcodec.Packet{{Type: pcodec.Handshake}, {Type: pcodec.HandshakeAck}, {Type: pcodec.Data, Data: data}} {
+		b, err := leCodec{}.Encode(p)
+		c.Assert(err, IsNil)
+		out = append(out, b...)
+	}
+	_, err = conn.Write(out)
+	c.Assert(err, IsNil)
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	r := bufio.NewReader(conn)
+	p, err := leCodec{}.Decode(r)
+	c.Assert(err, IsNil)
+	c.Assert(p.Type, Equals, pcodec.Handshake)
+	p, err = leCodec{}.Decode(r)
+	c.Assert(err, IsNil)
+	c.Assert(p.Type, Equals, pcodec.Data)
+	msg, err := message.Decode(p.Data)
+	c.Assert(err, IsNil)
+	pong := &testdata.Pong{}
+	c.Assert(proto.Unmarshal(msg.Data, pong), IsNil)
+	c.Assert(pong.Content, Equals, "logged in")
+
+	var bytesIn []int64
+	node.ForEachSession(func(s *session.Session) bool {
+		bytesIn = append(bytesIn, s.BytesIn())
+		return true
+	})
+	c.Assert(bytesIn, DeepEquals, []int64{int64(len(out))})
+}
+
+type DeadlineComponent struct {
+	component.Base
+	done chan error
+}
+
+// Wait responds after the context of request is done
+func (c *DeadlineComponent) Wait(ctx context.Context, s *session.Session, _ *testdata.Ping) error {
+	<-ctx.Done()
+	c.done <- ctx.Err()
+	return s.Response(&testdata.Pong{Content: "late"})
+}
+
+func (c *DeadlineComponent) Echo(s *session.Session, msg *testdata.Ping) error {
+	return s.Response(&testdata.Pong{Content: msg.Content})
+}
+
+func (s *clusterSuite) TestRequestTimeout(c *C) {
+	// scheduler will be closed by the node suite
+	go scheduler.Sched()
+
+	comp := &DeadlineComponent{done: make(chan error, 1)}
+	comps := &component.Components{}
+	comps.Register(comp)
+	node := &cluster.Node{
+		Options: cluster.Options{
+			IsMaster:   true,
+			Components: comps,
+			ClientAddr: "127.0.0.1:14690",
+		},
+		ServiceAddr: "127.0.0.1:4690",
+	}
+	err := node.Startup()
+	c.Assert(err, IsNil)
+	defer node.Shutdown()
+
+	var conn net.Conn
+	for i := 0; i < 10; i++ {
+		if conn, err = net.Dial("tcp", "127.0.0.1:14690"); err == nil {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	c.Assert(err, IsNil)
+	defer conn.Close()
+
+	request := func(id uint64, route string, timeout time.Duration) []byte {
+		ping, err := proto.Marshal(&testdata.Ping{Content: route})
+		c.Assert(err, IsNil)
+		data, err := message.Encode(&message.Message{Type: message.Request, ID: id, Route: route, Data: ping, Timeout: timeout})
+		c.Assert(err, IsNil)
+		p, err := codec.Encode(packet.Data, data)
+		c.Assert(err, IsNil)
+		return p
+	}
+	hs, err := codec.Encode(packet.Handshake, nil)
+	c.Assert(err, IsNil)
+	ack, err := codec.Encode(packet.HandshakeAck, nil)
+	c.Assert(err, IsNil)
+	out := append(append(hs, ack...), request(1, "DeadlineComponent.Wait", 50*time.Millisecond)...)
+	_, err = conn.Write(append(out, request(2, "DeadlineComponent.Echo", 0)...))
+	c.Assert(err, IsNil)
+
+	// the context of handler is canceled after the timeout
+	c.Assert(<-comp.done, Equals, context.DeadlineExceeded)
+
+	// the late response is dropped, only the response of the second request is received
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	decoder := codec.NewDecoder()
+	var packets []*packet.Packet
+	for len(packets) < 2 {
+		buf := make([]byte, 1024)
+		n, err := conn.Read(buf)
+		c.Assert(err, IsNil)
+		ps, err := decoder.Decode(buf[:n])
+		c.Assert(err, IsNil)
+		packets = append(packets, ps...)
+	}
+	c.Assert(packets, HasLen, 2)
+	msg, err := message.Decode(packets[1].Data)
+	c.Assert(err, IsNil)
+	c.Assert(msg.ID, Equals, uint64(2))
+}
+
+func (s *clusterSuite) TestMasterAsMember(c *C) {
+	// scheduler will be closed by the node suite
+	go scheduler.Sched()
+
+	masterComps := &component.Components{}
+	masterComps.Register(&LoginComponent{})
+	masterNode := &cluster.Node{
+		Options: cluster.Options{
+			IsMaster:   true,
+			Components: masterComps,
+			ClientAddr: "127.0.0.1:14700",
+		},
+		ServiceAddr: "127.0.0.1:4700",
+	}
+	err := masterNode.Startup()
+	c.Assert(err, IsNil)
+
+	memberComps := &component.Components{}
+	memberComps.Register(&GameComponent{})
+	memberNode := &cluster.Node{
+		Options: cluster.Options{
+			AdvertiseAddr: "127.0.0.1:4700",
+			Components:    memberComps,
+		},
+		ServiceAddr: "127.0.0.1:4701",
+	}
+	err = memberNode.Startup()
+	c.Assert(err, IsNil)
+	defer memberNode.Shutdown()
+
+	// the master serves its own routes and forwards the others to the member
+	c.Assert(memberNode.Handler().RemoteService(), DeepEquals, []string{"LoginComponent"})
+	client := connect(c, "127.0.0.1:14700")
+	defer client.Close()
+	onResult := make(chan string, 2)
+	for _, route := range []string{"LoginComponent.Login", "GameComponent.Test2"} {
+		c.Assert(client.Request(route, &testdata.Ping{Content: "ping"}, func(data interface{}) {
+			pong := &testdata.Pong{}
+			c.Assert(proto.Unmarshal(data.([]byte), pong), IsNil)
+			onResult <- pong.Content
+		}), IsNil)
+		select {
+		case content := <-onResult:
+			c.Assert(content == "logged in" || content == "game server pong2", IsTrue)
+		case <-time.After(2 * time.Second):
+			c.Fatalf("request %s timeout", route)
+		}
+	}
+
+	// the connection from the master to the member is closed by shutdown
+	ready := metrics.Default.Gauge("nano_member_ready", "target", "127.0.0.1:4701")
+	c.Assert(ready.Value(), Equals, int64(1))
+	masterNode.Shutdown()
+	for i := 0; i < 20 && ready.Value() != 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	c.Assert(ready.Value(), Equals, int64(0))
+}
+
+type PauseComponent struct {
+	component.Base
+	paused chan *session.Session
+}
+
+func (c *PauseComponent) Pause(s *session.Session, _ *testdata.Ping) error {
+	s.Pause()
+	c.paused <- s
+	return s.Response(&testdata.Pong{Content: "paused"})
+}
+
+func (c *PauseComponent) Echo(s *session.Session, msg *testdata.Ping) error {
+	return s.Response(&testdata.Pong{Content: msg.Content})
+}
+
+func (s *clusterSuite) TestSessionPause(c *C) {
+	// scheduler will be closed by the node suite
+	go scheduler.Sched()
+
+	comp := &PauseComponent{paused: make(chan *session.Session, 1)}
+	comps := &component.Components{}
+	comps.Register(comp)
+	node := &cluster.Node{
+		Options: cluster.Options{
+			IsMaster:    true,
+			Components:  comps,
+			ClientAddr:  "127.0.0.1:14710",
+			PauseBuffer: 2,
+		},
+		ServiceAddr: "127.0.0.1:4710",
+	}
+	err := node.Startup()
+	c.Assert(err, IsNil)
+	defer node.Shutdown()
+
+	var conn net.Conn
+	for i := 0; i < 10; i++ {
+		if conn, err = net.Dial("tcp", "127.0.0.1:14710"); err == nil {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	c.Assert(err, IsNil)
+	defer conn.Close()
+
+	request := func(id uint64, route, content string) []byte {
+		ping, err := proto.Marshal(&testdata.Ping{Content: content})
+		c.Assert(err, IsNil)
+		data, err := message.Encode(&message.Message{Type: message.Request, ID: id, Route: route, Data: ping})
+		c.Assert(err, IsNil)
+		p, err := codec.Encode(packet.Data, data)
+		c.Assert(err, IsNil)
+		return p
+	}
+	decoder := codec.NewDecoder()
+	var pending []*packet.Packet
+	read := func() *message.Message {
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		for len(pending) == 0 {
+			buf := make([]byte, 1024)
+			n, err := conn.Read(buf)
+			c.Assert(err, IsNil)
+			ps, err := decoder.Decode(buf[:n])
+			c.Assert(err, IsNil)
+			for _, p := range ps {
+				// skip the handshake response
+				if p.Type == packet.Data {
+					pending = append(pending, p)
+				}
+			}
+		}
+		msg, err := message.Decode(pending[0].Data)
+		c.Assert(err, IsNil)
+		pending = pending[1:]
+		return msg
+	}
+
+	hs, err := codec.Encode(packet.Handshake, nil)
+	c.Assert(err, IsNil)
+	ack, err := codec.Encode(packet.HandshakeAck, nil)
+	c.Assert(err, IsNil)
+	_, err = conn.Write(append(append(hs, ack...), request(1, "PauseComponent.Pause", "load")...))
+	c.Assert(err, IsNil)
+	sess := <-comp.paused
+	c.Assert(sess.Paused(), Equals, true)
+	c.Assert(read().ID, Equals, uint64(1))
+
+	// the requests are buffered while paused, the one beyond the buffer is dropped
+	out := append(request(2, "PauseComponent.Echo", "a"), request(3, "PauseComponent.Echo", "b")...)
+	_, err = conn.Write(append(out, request(4, "PauseComponent.Echo", "c")...))
+	c.Assert(err, IsNil)
+	msg := read()
+	c.Assert(msg.ID, Equals, uint64(4))
+	c.Assert(msg.Err, Equals, true)
+
+	// the buffered requests are handled in order after resumed
+	sess.Resume()
+	c.Assert(sess.Paused(), Equals, false)
+	for i, content := range []string{"a", "b"} {
+		msg := read()
+		c.Assert(msg.ID, Equals, uint64(i+2))
+		pong := &testdata.Pong{}
+		c.Assert(proto.Unmarshal(msg.Data, pong), IsNil)
+		c.Assert(pong.Content, Equals, content)
+	}
+}
+
+func (s *clusterSuite) TestRegisterAgain(c *C) {
+	masterNode := &cluster.Node{
+		Options: cluster.Options{
+			IsMaster:   true,
+			Components: &component.Components{},
+		},
+		ServiceAddr: "127.0.0.1:4720",
+	}
+	err := masterNode.Startup()
+	c.Assert(err, IsNil)
+	defer masterNode.Shutdown()
+
+	memberNode := &cluster.Node{
+		Options: cluster.Options{
+			AdvertiseAddr: "127.0.0.1:4720",
+			Components:    &component.Components{},
+		},
+		ServiceAddr: "127.0.0.1:4721",
+	}
+	err = memberNode.Startup()
+	c.Assert(err, IsNil)
+	defer memberNode.Shutdown()
+
+	conn, err := grpc.Dial("127.0.0.1:4720", grpc.WithInsecure())
+	c.Assert(err, IsNil)
+	defer conn.Close()
+
+	// the restarted member registers again with less services before unregistered
+	client := clusterpb.NewMasterClient(conn)
+	for _, services := range [][]string{{"GameComponent", "RoomComponent"}, {"GameComponent"}} {
+		resp, err := client.Register(context.Background(), &clusterpb.RegisterRequest{
+			MemberInfo: &clusterpb.MemberInfo{
+				ServiceAddr: "127.0.0.1:4722",
+				Services:    services,
+			},
+		})
+		c.Assert(err, IsNil)
+		for _, m := range resp.Members {
+			c.Assert(m.ServiceAddr, Not(Equals), "127.0.0.1:4722")
+		}
+	}
+
+	var registered []*clusterpb.MemberInfo
+	for _, m := range masterNode.ExportRegistry() {
+		if m.ServiceAddr == "127.0.0.1:4722" {
+			registered = append(registered, m)
+		}
+	}
+	c.Assert(registered, HasLen, 1)
+	c.Assert(registered[0].Services, DeepEquals, []string{"GameComponent"})
+	c.Assert(masterNode.Handler().RemoteService(), DeepEquals, []string{"GameComponent"})
+	c.Assert(memberNode.Handler().RemoteService(), DeepEquals, []string{"GameComponent"})
+}
+
+// keyCipher prefixes the payload with the key, which fails to decrypt the payloads
+// encrypted by another key, the keys are numbered in order
+type keyCipher struct{ keys uint32 }
+
+func (k *keyCipher) NewKey(_ *session.Session) ([]byte, []byte, error) {
+	key := []byte{byte(atomic.AddUint32(&k.keys, 1))}
+	return key, key, nil
+}
+
+func (k *keyCipher) Encrypt(key, payload []byte) ([]byte, error) {
+	return append(append([]byte{}, key...), payload...), nil
+}
+
+func (k *keyCipher) Decrypt(key, payload []byte) ([]byte, error) {
+	if len(payload) < 1 || payload[0] != key[0] {
+		return nil, errors.New("wrong key")
+	}
+	return payload[1:], nil
+}
+
+type KeyComponent struct {
+	component.Base
+	sessions chan *session.Session
+}
+
+func (c *KeyComponent) Echo(s *session.Session, msg *testdata.Ping) error {
+	select {
+	case c.sessions <- s:
+	default:
+	}
+	return s.Response(&testdata.Pong{Content: msg.Content})
+}
+
+func (s *clusterSuite) TestKeyRotation(c *C) {
+	// scheduler will be closed by the node suite
+	go scheduler.Sched()
+
+	comp := &KeyComponent{sessions: make(chan *session.Session, 1)}
+	comps := &component.Components{}
+	comps.Register(comp)
+	node := &cluster.Node{
+		Options: cluster.Options{
+			IsMaster:       true,
+			Components:     comps,
+			ClientAddr:     "127.0.0.1:14730",
+			PayloadCipher:  &keyCipher{},
+			KeyGraceWindow: time.Minute,
+		},
+		ServiceAddr: "127.0.0.1:4730",
+	}
+	err := node.Startup()
+	c.Assert(err, IsNil)
+	defer node.Shutdown()
+
+	var conn net.Conn
+	for i := 0; i < 10; i++ {
+		if conn, err = net.Dial("tcp", "127.0.0.1:14730"); err == nil {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	c.Assert(err, IsNil)
+	defer conn.Close()
+
+	request := func(id uint64, key byte) []byte {
+		ping, err := proto.Marshal(&testdata.Ping{Content: "ping"})
+		c.Assert(err, IsNil)
+		data, err := message.Encode(&message.Message{Type: message.Request, ID: id, Route: "KeyComponent.Echo", Data: append([]byte{key}, ping...)})
+		c.Assert(err, IsNil)
+		p, err := codec.Encode(packet.Data, data)
+		c.Assert(err, IsNil)
+		return p
+	}
+	decoder := codec.NewDecoder()
+	var pending []*packet.Packet
+	read := func() *packet.Packet {
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		for len(pending) == 0 {
+			buf := make([]byte, 1024)
+			n, err := conn.Read(buf)
+			c.Assert(err, IsNil)
+			ps, err := decoder.Decode(buf[:n])
+			c.Assert(err, IsNil)
+			pending = append(pending, ps...)
+		}
+		p := pending[0]
+		pending = pending[1:]
+		return p
+	}
+	response := func(id uint64, key byte) {
+		p := read()
+		c.Assert(p.Type, Equals, packet.Type(packet.Data))
+		msg, err := message.Decode(p.Data)
+		c.Assert(err, IsNil)
+		c.Assert(msg.ID, Equals, id)
+		c.Assert(msg.Data[0], Equals, key)
+	}
+
+	// the initial key material is sent in the handshake response
+	hs, err := codec.Encode(packet.Handshake, []byte(`{"sys":{"protocol":2}}`))
+	c.Assert(err, IsNil)
+	_, err = conn.Write(hs)
+	c.Assert(err, IsNil)
+	p := read()
+	c.Assert(p.Type, Equals, packet.Type(packet.Handshake))
+	var handshake struct {
+		Sys struct{ Key []byte } `json:"sys"`
+	}
+	c.Assert(json.Unmarshal(p.Data, &handshake), IsNil)
+	c.Assert(handshake.Sys.Key, DeepEquals, []byte{1})
+
+	ack, err := codec.Encode(packet.HandshakeAck, nil)
+	c.Assert(err, IsNil)
+	_, err = conn.Write(append(ack, request(1, 1)...))
+	c.Assert(err, IsNil)
+	response(1, 1)
+
+	// the new key material is encrypted by the replaced key
+	c.Assert((<-comp.sessions).RotateKey(), IsNil)
+	p = read()
+	c.Assert(p.Type, Equals, packet.Type(packet.Rekey))
+	c.Assert(p.Data, DeepEquals, []byte{1, 2})
+
+	// the replaced key is accepted until acknowledged
+	_, err = conn.Write(request(2, 1))
+	c.Assert(err, IsNil)
+	response(2, 2)
+	rekey, err := codec.Encode(packet.Rekey, nil)
+	c.Assert(err, IsNil)
+	_, err = conn.Write(append(rekey, request(3, 2)...))
+	c.Assert(err, IsNil)
+	response(3, 2)
+
+	// the session is closed on the payload encrypted by the dropped key
+	_, err = conn.Write(request(4, 1))
+	c.Assert(err, IsNil)
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, err = conn.Read(make([]byte, 1024))
+	c.Assert(err, Equals, goio.EOF)
+}
+
+type TickerComponent struct{ component.Base }
+
+// Subscribe streams the ticks, and ends the stream with the last one
+func (c *TickerComponent) Subscribe(s *session.Session, _ *testdata.Ping) error {
+	mid := s.LastMid()
+	go func() {
+		for i := 0; i < 3; i++ {
+			if err := s.StreamResponseMID(mid, &testdata.Pong{Content: fmt.Sprintf("tick-%d", i)}); err != nil {
+				return
+			}
+		}
+		s.ResponseMID(mid, &testdata.Pong{Content: "end"})
+	}()
+	return nil
+}
+
+func (s *clusterSuite) TestStreamResponse(c *C) {
+	// scheduler will be closed by the node suite
+	go scheduler.Sched()
+
+	masterNode := &cluster.Node{
+		Options: cluster.Options{
+			IsMaster:   true,
+			Components: &component.Components{},
+		},
+		ServiceAddr: "127.0.0.1:4740",
+	}
+	err := masterNode.Startup()
+	c.Assert(err, IsNil)
+	defer masterNode.Shutdown()
+
+	gateNode := &cluster.Node{
+		Options: cluster.Options{
+			AdvertiseAddr: "127.0.0.1:4740",
+			ClientAddr:    "127.0.0.1:14742",
+			Components:    &component.Components{},
+		},
+		ServiceAddr: "127.0.0.1:14741",
+	}
+	err = gateNode.Startup()
+	c.Assert(err, IsNil)
+	defer gateNode.Shutdown()
+
+	comps := &component.Components{}
+	comps.Register(&TickerComponent{})
+	tickerNode := &cluster.Node{
+		Options: cluster.Options{
+			AdvertiseAddr: "127.0.0.1:4740",
+			Components:    comps,
+		},
+		ServiceAddr: "127.0.0.1:24741",
+	}
+	err = tickerNode.Startup()
+	c.Assert(err, IsNil)
+	defer tickerNode.Shutdown()
+
+	var conn net.Conn
+	for i := 0; i < 10; i++ {
+		if conn, err = net.Dial("tcp", "127.0.0.1:14742"); err == nil {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	c.Assert(err, IsNil)
+	defer conn.Close()
+
+	ping, err := proto.Marshal(&testdata.Ping{Content: "price"})
+	c.Assert(err, IsNil)
+	data, err := message.Encode(&message.Message{Type: message.Request, ID: 1, Route: "TickerComponent.Subscribe", Data: ping})
+	c.Assert(err, IsNil)
+	req, err := codec.Encode(packet.Data, data)
+	c.Assert(err, IsNil)
+	hs, err := codec.Encode(packet.Handshake, []byte(`{"sys":{"protocol":2}}`))
+	c.Assert(err, IsNil)
+	ack, err := codec.Encode(packet.HandshakeAck, nil)
+	c.Assert(err, IsNil)
+	_, err = conn.Write(append(append(hs, ack...), req...))
+	c.Assert(err, IsNil)
+
+	// the responses of the request are correlated by the message id, the last one ends
+	// the stream
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	decoder := codec.NewDecoder()
+	var responses []*message.Message
+	for len(responses) < 4 {
+		buf := make([]byte, 1024)
+		n, err := conn.Read(buf)
+		c.Assert(err, IsNil)
+		ps, err := decoder.Decode(buf[:n])
+		c.Assert(err, IsNil)
+		for _, p := range ps {
+			if p.Type != packet.Data {
+				continue
+			}
+			msg, err := message.Decode(p.Data)
+			c.Assert(err, IsNil)
+			responses = append(responses, msg)
+		}
+	}
+	for i, msg := range responses {
+		c.Assert(msg.ID, Equals, uint64(1))
+		c.Assert(msg.More, Equals, i < 3)
+		pong := &testdata.Pong{}
+		c.Assert(proto.Unmarshal(msg.Data, pong), IsNil)
+		if i < 3 {
+			c.Assert(pong.Content, Equals, fmt.Sprintf("tick-%d", i))
+		} else {
+			c.Assert(pong.Content, Equals, "end")
+		}
+	}
+}
+
+type RetryComponent struct {
+	component.Base
+	name string
+}
+
+func (c *RetryComponent) Echo(s *session.Session, msg *testdata.Ping) error {
+	return s.Response(&testdata.Pong{Content: c.name})
+}
+
+func (c *RetryComponent) Create(s *session.Session, msg *testdata.Ping) error {
+	return s.Response(&testdata.Pong{Content: c.name})
+}
+
+func (s *clusterSuite) TestForwardMemberGone(c *C) {
+	// scheduler will be closed by the node suite
+	go scheduler.Sched()
+
+	masterNode := &cluster.Node{
+		Options: cluster.Options{
+			IsMaster:   true,
+			Components: &component.Components{},
+		},
+		ServiceAddr: "127.0.0.1:4750",
+	}
+	err := masterNode.Startup()
+	c.Assert(err, IsNil)
+	defer masterNode.Shutdown()
+
+	gateNode := &cluster.Node{
+		Options: cluster.Options{
+			AdvertiseAddr:    "127.0.0.1:4750",
+			ClientAddr:       "127.0.0.1:14752",
+			Components:       &component.Components{},
+			IdempotentRoutes: map[string]bool{"RetryComponent.Echo": true},
+		},
+		ServiceAddr: "127.0.0.1:14751",
+	}
+	err = gateNode.Startup()
+	c.Assert(err, IsNil)
+	defer gateNode.Shutdown()
+
+	var members []*cluster.Node
+	for _, addr := range []string{"127.0.0.1:24751", "127.0.0.1:24752"} {
+		comps := &component.Components{}
+		comps.Register(&RetryComponent{name: addr})
+		member := &cluster.Node{
+			Options: cluster.Options{
+				AdvertiseAddr: "127.0.0.1:4750",
+				Components:    comps,
+			},
+			ServiceAddr: addr,
+		}
+		err = member.Startup()
+		c.Assert(err, IsNil)
+		members = append(members, member)
+	}
+	defer members[1].Shutdown()
+
+	// wait until the gate knows both members
+	owners := map[string]bool{}
+	for i := 0; i < 100 && len(owners) < 2; i++ {
+		for k := 0; k < 64; k++ {
+			if addr, ok := gateNode.Handler().ShardOwner("RetryComponent", fmt.Sprint(k)); ok {
+				owners[addr] = true
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	c.Assert(owners, HasLen, 2)
+
+	var conn net.Conn
+	for i := 0; i < 10; i++ {
+		if conn, err = net.Dial("tcp", "127.0.0.1:14752"); err == nil {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	c.Assert(err, IsNil)
+	defer conn.Close()
+
+	request := func(id uint64, route string) []byte {
+		ping, err := proto.Marshal(&testdata.Ping{Content: "retry"})
+		c.Assert(err, IsNil)
+		data, err := message.Encode(&message.Message{Type: message.Request, ID: id, Route: route, Data: ping})
+		c.Assert(err, IsNil)
+		p, err := codec.Encode(packet.Data, data)
+		c.Assert(err, IsNil)
+		return p
+	}
+	decoder := codec.NewDecoder()
+	read := func() *message.Message {
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		for {
+			buf := make([]byte, 1024)
+			n, err := conn.Read(buf)
+			c.Assert(err, IsNil)
+			ps, err := decoder.Decode(buf[:n])
+			c.Assert(err, IsNil)
+			for _, p := range ps {
+				// skip the handshake response
+				if p.Type == packet.Data {
+					msg, err := message.Decode(p.Data)
+					c.Assert(err, IsNil)
+					return msg
+				}
+			}
+		}
+	}
+
+	hs, err := codec.Encode(packet.Handshake, nil)
+	c.Assert(err, IsNil)
+	ack, err := codec.Encode(packet.HandshakeAck, nil)
+	c.Assert(err, IsNil)
+	_, err = conn.Write(append(hs, ack...))
+	c.Assert(err, IsNil)
+
+	var sess *session.Session
+	for i := 0; i < 100 && sess == nil; i++ {
+		gateNode.ForEachSession(func(s *session.Session) bool {
+			sess = s
+			return false
+		})
+		time.Sleep(10 * time.Millisecond)
+	}
+	c.Assert(sess, NotNil)
+
+	// the member leaves after it was selected by the session
+	members[0].Shutdown()
+	sess.Router().Bind("RetryComponent", "127.0.0.1:24751")
+
+	// the idempotent request is retried on the other member
+	_, err = conn.Write(request(1, "RetryComponent.Echo"))
+	c.Assert(err, IsNil)
+	msg := read()
+	c.Assert(msg.ID, Equals, uint64(1))
+	c.Assert(msg.Err, Equals, false)
+	pong := &testdata.Pong{}
+	c.Assert(proto.Unmarshal(msg.Data, pong), IsNil)
+	c.Assert(pong.Content, Equals, "127.0.0.1:24752")
+	addr, found := sess.Router().Find("RetryComponent")
+	c.Assert(found, Equals, true)
+	c.Assert(addr, Equals, "127.0.0.1:24752")
+
+	// the other requests receive an error response
+	sess.Router().Bind("RetryComponent", "127.0.0.1:24751")
+	_, err = conn.Write(request(2, "RetryComponent.Create"))
+	c.Assert(err, IsNil)
+	msg = read()
+	c.Assert(msg.ID, Equals, uint64(2))
+	c.Assert(msg.Err, Equals, true)
+	c.Assert(string(msg.Data), Matches, `.*"code":503.*`)
+}
+
+func (s *clusterSuite) TestResolveMemberAddr(c *C) {
+	masterNode := &cluster.Node{
+		Options: cluster.Options{
+			IsMaster:   true,
+			Components: &component.Components{},
+		},
+		ServiceAddr: "127.0.0.1:4760",
+	}
+	err := masterNode.Startup()
+	c.Assert(err, IsNil)
+	defer masterNode.Shutdown()
+
+	// the member binds to all interfaces and registers the routable address
+	memberNode := &cluster.Node{
+		Options: cluster.Options{
+			AdvertiseAddr: "127.0.0.1:4760",
+			Components:    &component.Components{},
+			ResolveMemberAddr: func() (string, error) {
+				return "localhost:4761", nil
+			},
+		},
+		ServiceAddr: "0.0.0.0:4761",
+	}
+	err = memberNode.Startup()
+	c.Assert(err, IsNil)
+	defer memberNode.Shutdown()
+	c.Assert(memberNode.ServiceAddr, Equals, "localhost:4761")
+	c.Assert(memberNode.BoundServiceAddr(), Equals, "0.0.0.0:4761")
+
+	var addrs []string
+	for _, m := range masterNode.ExportRegistry() {
+		addrs = append(addrs, m.ServiceAddr)
+	}
+	c.Assert(addrs, DeepEquals, []string{"localhost:4761"})
+
+	failedNode := &cluster.Node{
+		Options: cluster.Options{
+			AdvertiseAddr: "127.0.0.1:4760",
+			Components:    &component.Components{},
+			ResolveMemberAddr: func() (string, error) {
+				return "", errors.New("metadata unavailable")
+			},
+		},
+		ServiceAddr: "127.0.0.1:4762",
+	}
+	err = failedNode.Startup()
+	c.Assert(err, ErrorMatches, ".*metadata unavailable")
+}
+
+func (s *clusterSuite) TestMinProtocolVersion(c *C) {
+	node := &cluster.Node{
+		Options: cluster.Options{
+			IsMaster:           true,
+			Components:         &component.Components{},
+			ClientAddr:         "127.0.0.1:14770",
+			MinProtocolVersion: cluster.ProtocolStream,
+		},
+		ServiceAddr: "127.0.0.1:4770",
+	}
+	err := node.Startup()
+	c.Assert(err, IsNil)
+	defer node.Shutdown()
+
+	handshake := func(data []byte) map[string]interface{} {
+		var conn net.Conn
+		var err error
+		for i := 0; i < 10; i++ {
+			if conn, err = net.Dial("tcp", "127.0.0.1:14770"); err == nil {
+				break
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+		c.Assert(err, IsNil)
+		defer conn.Close()
+
+		hs, err := codec.Encode(packet.Handshake, data)
+		c.Assert(err, IsNil)
+		_, err = conn.Write(hs)
+		c.Assert(err, IsNil)
+
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		decoder := codec.NewDecoder()
+		for {
+			buf := make([]byte, 1024)
+			n, err := conn.Read(buf)
+			c.Assert(err, IsNil)
+			ps, err := decoder.Decode(buf[:n])
+			c.Assert(err, IsNil)
+			for _, p := range ps {
+				if p.Type == packet.Handshake {
+					response := map[string]interface{}{}
+					c.Assert(json.Unmarshal(p.Data, &response), IsNil)
+					return response
+				}
+			}
+		}
+	}
+
+	// the legacy clients declare no protocol version
+	response := handshake(nil)
+	c.Assert(response["code"], Equals, float64(501))
+	c.Assert(response["msg"], Matches, ".*minimum version is 2")
+
+	response = handshake([]byte(`{"sys":{"protocol":3}}`))
+	c.Assert(response["code"], Equals, float64(200))
+	sys := response["sys"].(map[string]interface{})
+	c.Assert(sys["protocol"], Equals, float64(cluster.ProtocolVersion))
+	c.Assert(sys["protocols"], DeepEquals, []interface{}{float64(cluster.ProtocolStream), float64(cluster.ProtocolReliable)})
+}
+
+type RegionComponent struct {
+	component.Base
+	region string
+}
+
+func (c *RegionComponent) Echo(s *session.Session, msg *testdata.Ping) error {
+	return s.Response(&testdata.Pong{Content: c.region})
+}
+
+func (s *clusterSuite) TestMemberLabelsBalancer(c *C) {
+	// scheduler will be closed by the node suite
+	go scheduler.Sched()
+
+	masterNode := &cluster.Node{
+		Options: cluster.Options{
+			IsMaster:   true,
+			Components: &component.Components{},
+		},
+		ServiceAddr: "127.0.0.1:4780",
+	}
+	err := masterNode.Startup()
+	c.Assert(err, IsNil)
+	defer masterNode.Shutdown()
+
+	labels := make(chan map[string]string, 2)
+	gateNode := &cluster.Node{
+		Options: cluster.Options{
+			AdvertiseAddr: "127.0.0.1:4780",
+			ClientAddr:    "127.0.0.1:14782",
+			Components:    &component.Components{},
+			Balancer: func(s *session.Session, service string, members []*clusterpb.MemberInfo) string {
+				for _, m := range members {
+					labels <- m.Labels
+				}
+				for _, m := range members {
+					if m.Labels["region"] == "eu" {
+						return m.ServiceAddr
+					}
+				}
+				return ""
+			},
+		},
+		ServiceAddr: "127.0.0.1:14781",
+	}
+	err = gateNode.Startup()
+	c.Assert(err, IsNil)
+	defer gateNode.Shutdown()
+
+	for addr, region := range map[string]string{"127.0.0.1:24781": "us", "127.0.0.1:24782": "eu"} {
+		comps := &component.Components{}
+		comps.Register(&RegionComponent{region: region})
+		member := &cluster.Node{
+			Options: cluster.Options{
+				AdvertiseAddr: "127.0.0.1:4780",
+				Components:    comps,
+				MemberLabels:  map[string]string{"region": region},
+			},
+			ServiceAddr: addr,
+		}
+		err = member.Startup()
+		c.Assert(err, IsNil)
+		defer member.Shutdown()
+	}
+
+	// wait until the gate knows both members
+	owners := map[string]bool{}
+	for i := 0; i < 100 && len(owners) < 2; i++ {
+		for k := 0; k < 64; k++ {
+			if addr, ok := gateNode.Handler().ShardOwner("RegionComponent", fmt.Sprint(k)); ok {
+				owners[addr] = true
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	c.Assert(owners, HasLen, 2)
+
+	var conn net.Conn
+	for i := 0; i < 10; i++ {
+		if conn, err = net.Dial("tcp", "127.0.0.1:14782"); err == nil {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	c.Assert(err, IsNil)
+	defer conn.Close()
+
+	ping, err := proto.Marshal(&testdata.Ping{Content: "region"})
+	c.Assert(err, IsNil)
+	data, err := message.Encode(&message.Message{Type: message.Request, ID: 1, Route: "RegionComponent.Echo", Data: ping})
+	c.Assert(err, IsNil)
+	req, err := codec.Encode(packet.Data, data)
+	c.Assert(err, IsNil)
+	hs, err := codec.Encode(packet.Handshake, nil)
+	c.Assert(err, IsNil)
+	ack, err := codec.Encode(packet.HandshakeAck, nil)
+	c.Assert(err, IsNil)
+	_, err = conn.Write(append(append(hs, ack...), req...))
+	c.Assert(err, IsNil)
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	decoder := codec.NewDecoder()
+	var msg *message.Message
+	for msg == nil {
+		buf := make([]byte, 1024)
+		n, err := conn.Read(buf)
+		c.Assert(err, IsNil)
+		ps, err := decoder.Decode(buf[:n])
+		c.Assert(err, IsNil)
+		for _, p := range ps {
+			if p.Type == packet.Data {
+				msg, err = message.Decode(p.Data)
+				c.Assert(err, IsNil)
+			}
+		}
+	}
+	pong := &testdata.Pong{}
+	c.Assert(proto.Unmarshal(msg.Data, pong), IsNil)
+	c.Assert(pong.Content, Equals, "eu")
+
+	// the labels are propagated to the gate by the announcement of members
+	regions := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		regions[(<-labels)["region"]] = true
+	}
+	c.Assert(regions, DeepEquals, map[string]bool{"us": true, "eu": true})
+}
+
+type LateComponent struct{ component.Base }
+
+func (c *LateComponent) Echo(s *session.Session, msg *testdata.Ping) error {
+	return s.Response(&testdata.Pong{Content: msg.Content})
+}
+
+func (s *clusterSuite) TestRequiredServices(c *C) {
+	masterNode := &cluster.Node{
+		Options: cluster.Options{
+			IsMaster:   true,
+			Components: &component.Components{},
+		},
+		ServiceAddr: "127.0.0.1:4790",
+	}
+	err := masterNode.Startup()
+	c.Assert(err, IsNil)
+	defer masterNode.Shutdown()
+
+	gateNode := &cluster.Node{
+		Options: cluster.Options{
+			AdvertiseAddr:    "127.0.0.1:4790",
+			ClientAddr:       "127.0.0.1:14792",
+			Components:       &component.Components{},
+			RequiredServices: []string{"LateComponent"},
+			ReadinessTimeout: 5 * time.Second,
+		},
+		ServiceAddr: "127.0.0.1:14791",
+	}
+	started := make(chan error, 1)
+	go func() { started <- gateNode.Startup() }()
+	defer gateNode.Shutdown()
+
+	// the clients are not served before the backend member registered
+	time.Sleep(100 * time.Millisecond)
+	select {
+	case err := <-started:
+		c.Fatalf("gate started without required services: %v", err)
+	default:
+	}
+	_, err = net.Dial("tcp", "127.0.0.1:14792")
+	c.Assert(err, NotNil)
+
+	comps := &component.Components{}
+	comps.Register(&LateComponent{})
+	memberNode := &cluster.Node{
+		Options: cluster.Options{
+			AdvertiseAddr: "127.0.0.1:4790",
+			Components:    comps,
+		},
+		ServiceAddr: "127.0.0.1:24791",
+	}
+	err = memberNode.Startup()
+	c.Assert(err, IsNil)
+	defer memberNode.Shutdown()
+
+	select {
+	case err := <-started:
+		c.Assert(err, IsNil)
+	case <-time.After(2 * time.Second):
+		c.Fatal("gate should start after required services are ready")
+	}
+	conn, err := net.Dial("tcp", "127.0.0.1:14792")
+	c.Assert(err, IsNil)
+	conn.Close()
+
+	// the clients are served anyway after the readiness timeout
+	timeoutNode := &cluster.Node{
+		Options: cluster.Options{
+			AdvertiseAddr:    "127.0.0.1:4790",
+			ClientAddr:       "127.0.0.1:14794",
+			Components:       &component.Components{},
+			RequiredServices: []string{"LateComponent", "MissingComponent"},
+			ReadinessTimeout: 100 * time.Millisecond,
+		},
+		ServiceAddr: "127.0.0.1:14793",
+	}
+	err = timeoutNode.Startup()
+	c.Assert(err, IsNil)
+	defer timeoutNode.Shutdown()
+	conn, err = net.Dial("tcp", "127.0.0.1:14794")
+	c.Assert(err, IsNil)
+	conn.Close()
+}
+
+func (s *clusterSuite) TestRegistrySnapshot(c *C) {
+	path := filepath.Join(c.MkDir(), "registry.json")
+	newMaster := func() *cluster.Node {
+		return &cluster.Node{
+			Options: cluster.Options{
+				IsMaster:                 true,
+				Components:               &component.Components{},
+				RegistrySnapshotPath:     path,
+				RegistrySnapshotInterval: 20 * time.Millisecond,
+			},
+			ServiceAddr: "127.0.0.1:4810",
+		}
+	}
+	masterNode := newMaster()
+	err := masterNode.Startup()
+	c.Assert(err, IsNil)
+
+	memberNode := &cluster.Node{
+		Options: cluster.Options{
+			AdvertiseAddr: "127.0.0.1:4810",
+			Components:    &component.Components{},
+		},
+		ServiceAddr: "127.0.0.1:4811",
+	}
+	err = memberNode.Startup()
+	c.Assert(err, IsNil)
+	defer memberNode.Shutdown()
+
+	var members []*clusterpb.MemberInfo
+	for i := 0; i < 100 && len(members) == 0; i++ {
+		time.Sleep(20 * time.Millisecond)
+		if data, err := ioutil.ReadFile(path); err == nil {
+			c.Assert(json.Unmarshal(data, &members), IsNil)
+		}
+	}
+	c.Assert(members, HasLen, 1)
+	c.Assert(members[0].ServiceAddr, Equals, "127.0.0.1:4811")
+	masterNode.Shutdown()
+
+	// the member which has gone since the snapshot is dropped by the restarted master
+	members = append(members, &clusterpb.MemberInfo{ServiceAddr: "127.0.0.1:4812", Services: []string{"Gone"}})
+	data, err := json.Marshal(members)
+	c.Assert(err, IsNil)
+	c.Assert(ioutil.WriteFile(path, data, 0644), IsNil)
+
+	masterNode = newMaster()
+	err = masterNode.Startup()
+	c.Assert(err, IsNil)
+	defer masterNode.Shutdown()
+
+	var addrs []string
+	for _, m := range masterNode.ExportRegistry() {
+		addrs = append(addrs, m.ServiceAddr)
+	}
+	c.Assert(addrs, DeepEquals, []string{"127.0.0.1:4811"})
+}
+
+func (s *clusterSuite) TestCodecError(c *C) {
+	phases := make(chan string, 8)
+	node := &cluster.Node{
+		Options: cluster.Options{
+			IsMaster:   true,
+			Components: &component.Components{},
+			ClientAddr: "127.0.0.1:14820",
+			OnCodecError: func(_ *session.Session, _ error, phase string) {
+				phases <- phase
+			},
+			CodecErrorLimit: 2,
+		},
+		ServiceAddr: "127.0.0.1:4820",
+	}
+	err := node.Startup()
+	c.Assert(err, IsNil)
+	defer node.Shutdown()
+
+	connect := func() net.Conn {
+		var conn net.Conn
+		var err error
+		for i := 0; i < 10; i++ {
+			if conn, err = net.Dial("tcp", "127.0.0.1:14820"); err == nil {
+				break
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+		c.Assert(err, IsNil)
+		for _, typ := range []packet.Type{packet.Handshake, packet.HandshakeAck} {
+			p, err := codec.Encode(typ, nil)
+			c.Assert(err, IsNil)
+			_, err = conn.Write(p)
+			c.Assert(err, IsNil)
+		}
+		return conn
+	}
+	closed := func(conn net.Conn) bool {
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		buf := make([]byte, 1024)
+		for {
+			if _, err := conn.Read(buf); err != nil {
+				ne, ok := err.(net.Error)
+				return !ok || !ne.Timeout()
+			}
+		}
+	}
+	phase := func() string {
+		select {
+		case p := <-phases:
+			return p
+		case <-time.After(2 * time.Second):
+			return "timeout"
+		}
+	}
+
+	// the messages failed to decode are dropped until the limit reached
+	conn := connect()
+	defer conn.Close()
+	garbage, err := codec.Encode(packet.Data, []byte{0xff, 0xff, 0xff})
+	c.Assert(err, IsNil)
+	_, err = conn.Write(garbage)
+	c.Assert(err, IsNil)
+	c.Assert(phase(), Equals, cluster.CodecPhaseMessage)
+	_, err = conn.Write(garbage)
+	c.Assert(err, IsNil)
+	c.Assert(phase(), Equals, cluster.CodecPhaseMessage)
+	c.Assert(closed(conn), Equals, true)
+
+	// the corrupt frame closes the connection at once
+	conn = connect()
+	defer conn.Close()
+	_, err = conn.Write([]byte{0xff, 0x00, 0x00, 0x01, 0x00})
+	c.Assert(err, IsNil)
+	c.Assert(phase(), Equals, cluster.CodecPhasePacket)
+	c.Assert(closed(conn), Equals, true)
+}
+
+func (s *clusterSuite) TestValidate(c *C) {
+	// scheduler will be closed by the node suite
+	go scheduler.Sched()
+
+	comps := &component.Components{}
+	comps.Register(&LoginComponent{})
+	node := &cluster.Node{
+		Options: cluster.Options{
+			IsMaster:    true,
+			Components:  comps,
+			ClientAddr:  "127.0.0.1:14830",
+			Serializers: map[string]serialize.Serializer{"json": jsonserializer.NewSerializer()},
+			Validate: func(route string, msg interface{}) error {
+				if ping, ok := msg.(*testdata.Ping); ok && ping.Content == "" {
+					return errors.New("content is required")
+				}
+				return nil
+			},
+		},
+		ServiceAddr: "127.0.0.1:4830",
+	}
+	err := node.Startup()
+	c.Assert(err, IsNil)
+	defer node.Shutdown()
+
+	conn, err := net.Dial("tcp", "127.0.0.1:14830")
+	c.Assert(err, IsNil)
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+
+	recv := func() *packet.Packet {
+		buf := make([]byte, 1024)
+		n, err := conn.Read(buf)
+		c.Assert(err, IsNil)
+		packets, err := codec.NewDecoder().Decode(buf[:n])
+		c.Assert(err, IsNil)
+		c.Assert(packets, HasLen, 1)
+		return packets[0]
+	}
+	request := func(id uint64, payload string) *message.Message {
+		data, err := message.Encode(&message.Message{Type: message.Request, ID: id, Route: "LoginComponent.Login", Data: []byte(payload)})
+		c.Assert(err, IsNil)
+		req, err := codec.Encode(packet.Data, data)
+		c.Assert(err, IsNil)
+		_, err = conn.Write(req)
+		c.Assert(err, IsNil)
+		msg, err := message.Decode(recv().Data)
+		c.Assert(err, IsNil)
+		return msg
+	}
+
+	p, err := codec.Encode(packet.Handshake, []byte(`{"sys":{"serializer":["json"]}}`))
+	c.Assert(err, IsNil)
+	_, err = conn.Write(p)
+	c.Assert(err, IsNil)
+	recv()
+	ack, err := codec.Encode(packet.HandshakeAck, nil)
+	c.Assert(err, IsNil)
+	_, err = conn.Write(ack)
+	c.Assert(err, IsNil)
+
+	// the invalid request is responded with the validation error without dispatching
+	msg := request(1, `{"Content":""}`)
+	c.Assert(msg.ID, Equals, uint64(1))
+	c.Assert(msg.Err, Equals, true)
+	c.Assert(string(msg.Data), Matches, `.*"code":400.*content is required.*`)
+
+	msg = request(2, `{"Content":"ping"}`)
+	c.Assert(msg.ID, Equals, uint64(2))
+	c.Assert(msg.Err, Equals, false)
+	c.Assert(string(msg.Data), Equals, `{"Content":"logged in"}`)
+}
+
+func (s *clusterSuite) TestLocateUID(c *C) {
+	// scheduler will be closed by the node suite
+	go scheduler.Sched()
+
+	comps := &component.Components{}
+	comps.Register(&LoginComponent{})
+	node := &cluster.Node{
+		Options: cluster.Options{
+			IsMaster:   true,
+			Components: comps,
+			ClientAddr: "127.0.0.1:14840",
+		},
+		ServiceAddr: "127.0.0.1:4840",
+	}
+	err := node.Startup()
+	c.Assert(err, IsNil)
+	defer node.Shutdown()
+
+	_, found := node.LocateUID(1)
+	c.Assert(found, Equals, false)
+
+	var conn net.Conn
+	for i := 0; i < 10; i++ {
+		if conn, err = net.Dial("tcp", "127.0.0.1:14840"); err == nil {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	c.Assert(err, IsNil)
+	defer conn.Close()
+
+	hs, err := codec.Encode(packet.Handshake, nil)
+	c.Assert(err, IsNil)
+	ack, err := codec.Encode(packet.HandshakeAck, nil)
+	c.Assert(err, IsNil)
+	ping, err := proto.Marshal(&testdata.Ping{Content: "ping"})
+	c.Assert(err, IsNil)
+	data, err := message.Encode(&message.Message{Type: message.Request, ID: 1, Route: "LoginComponent.Login", Data: ping})
+	c.Assert(err, IsNil)
+	req, err := codec.Encode(packet.Data, data)
+	c.Assert(err, IsNil)
+	_, err = conn.Write(append(append(hs, ack...), req...))
+	c.Assert(err, IsNil)
+
+	// the uid is located to the member once bound, and released once the session closed
+	located := func(expected bool) bool {
+		for i := 0; i < 40; i++ {
+			if _, found := node.LocateUID(1); found == expected {
+				return true
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+		return false
+	}
+	c.Assert(located(true), Equals, true)
+	addr, _ := node.LocateUID(1)
+	c.Assert(addr, Equals, "127.0.0.1:4840")
+
+	conn.Close()
+	c.Assert(located(false), Equals, true)
+}
+
+func (s *clusterSuite) TestGateway(c *C) {
+	// scheduler will be closed by the node suite
+	go scheduler.Sched()
+
+	comps := &component.Components{}
+	comps.Register(&LoginComponent{})
+	masterNode := &cluster.Node{
+		Options: cluster.Options{
+			IsMaster:   true,
+			Components: comps,
+		},
+		ServiceAddr: "127.0.0.1:4850",
+	}
+	err := masterNode.Startup()
+	c.Assert(err, IsNil)
+	defer masterNode.Shutdown()
+
+	// the gateway holds no components and forwards everything to the backend
+	gateway := &cluster.Node{
+		Options: cluster.Options{
+			AdvertiseAddr: "127.0.0.1:4850",
+			ClientAddr:    "127.0.0.1:14851",
+			IsGateway:     true,
+		},
+		ServiceAddr: "127.0.0.1:4851",
+	}
+	err = gateway.Startup()
+	c.Assert(err, IsNil)
+	defer gateway.Shutdown()
+
+	var registered *clusterpb.MemberInfo
+	for _, m := range masterNode.ExportRegistry() {
+		if m.ServiceAddr == "127.0.0.1:4851" {
+			registered = m
+		}
+	}
+	c.Assert(registered, NotNil)
+	c.Assert(registered.Gateway, Equals, true)
+	c.Assert(registered.Services, HasLen, 0)
+
+	var conn net.Conn
+	for i := 0; i < 10; i++ {
+		if conn, err = net.Dial("tcp", "127.0.0.1:14851"); err == nil {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	c.Assert(err, IsNil)
+	defer conn.Close()
+
+	hs, err := codec.Encode(packet.Handshake, nil)
+	c.Assert(err, IsNil)
+	ack, err := codec.Encode(packet.HandshakeAck, nil)
+	c.Assert(err, IsNil)
+	ping, err := proto.Marshal(&testdata.Ping{Content: "ping"})
+	c.Assert(err, IsNil)
+	data, err := message.Encode(&message.Message{Type: message.Request, ID: 1, Route: "LoginComponent.Login", Data: ping})
+	c.Assert(err, IsNil)
+	req, err := codec.Encode(packet.Data, data)
+	c.Assert(err, IsNil)
+	_, err = conn.Write(append(append(hs, ack...), req...))
+	c.Assert(err, IsNil)
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	decoder := codec.NewDecoder()
+	var packets []*packet.Packet
+	for len(packets) < 2 {
+		buf := make([]byte, 1024)
+		n, err := conn.Read(buf)
+		c.Assert(err, IsNil)
+		decoded, err := decoder.Decode(buf[:n])
+		c.Assert(err, IsNil)
+		packets = append(packets, decoded...)
+	}
+	c.Assert(packets[0].Type, Equals, packet.Type(packet.Handshake))
+	msg, err := message.Decode(packets[1].Data)
+	c.Assert(err, IsNil)
+	pong := &testdata.Pong{}
+	c.Assert(proto.Unmarshal(msg.Data, pong), IsNil)
+	c.Assert(pong.Content, Equals, "logged in")
+}
+
+type HeaderComponent struct{ component.Base }
+
+func (c *HeaderComponent) Locale(ctx context.Context, s *session.Session, _ *testdata.Ping) error {
+	return s.Response(&testdata.Pong{Content: session.HeaderFrom(ctx)["locale"]})
+}
+
+func (s *clusterSuite) TestMessageHeader(c *C) {
+	// scheduler will be closed by the node suite
+	go scheduler.Sched()
+
+	masterNode := &cluster.Node{
+		Options: cluster.Options{
+			IsMaster:   true,
+			ClientAddr: "127.0.0.1:14860",
+			IsGateway:  true,
+		},
+		ServiceAddr: "127.0.0.1:4860",
+	}
+	err := masterNode.Startup()
+	c.Assert(err, IsNil)
+	defer masterNode.Shutdown()
+
+	comps := &component.Components{}
+	comps.Register(&HeaderComponent{})
+	memberNode := &cluster.Node{
+		Options: cluster.Options{
+			AdvertiseAddr: "127.0.0.1:4860",
+			Components:    comps,
+		},
+		ServiceAddr: "127.0.0.1:4861",
+	}
+	err = memberNode.Startup()
+	c.Assert(err, IsNil)
+	defer memberNode.Shutdown()
+
+	var conn net.Conn
+	for i := 0; i < 10; i++ {
+		if conn, err = net.Dial("tcp", "127.0.0.1:14860"); err == nil {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	c.Assert(err, IsNil)
+	defer conn.Close()
+
+	// the header is carried to the handler of the member which the request forwarded to
+	hs, err := codec.Encode(packet.Handshake, nil)
+	c.Assert(err, IsNil)
+	ack, err := codec.Encode(packet.HandshakeAck, nil)
+	c.Assert(err, IsNil)
+	ping, err := proto.Marshal(&testdata.Ping{Content: "ping"})
+	c.Assert(err, IsNil)
+	data, err := message.Encode(&message.Message{
+		Type:   message.Request,
+		ID:     1,
+		Route:  "HeaderComponent.Locale",
+		Data:   ping,
+		Header: map[string]string{"locale": "fr-FR"},
+	})
+	c.Assert(err, IsNil)
+	req, err := codec.Encode(packet.Data, data)
+	c.Assert(err, IsNil)
+	_, err = conn.Write(append(append(hs, ack...), req...))
+	c.Assert(err, IsNil)
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	decoder := codec.NewDecoder()
+	var packets []*packet.Packet
+	for len(packets) < 2 {
+		buf := make([]byte, 1024)
+		n, err := conn.Read(buf)
+		c.Assert(err, IsNil)
+		decoded, err := decoder.Decode(buf[:n])
+		c.Assert(err, IsNil)
+		packets = append(packets, decoded...)
+	}
+	msg, err := message.Decode(packets[1].Data)
+	c.Assert(err, IsNil)
+	pong := &testdata.Pong{}
+	c.Assert(proto.Unmarshal(msg.Data, pong), IsNil)
+	c.Assert(pong.Content, Equals, "fr-FR")
+}
+
+type TeardownComponent struct {
+	component.Base
+	reasons chan component.ShutdownReason
+}
+
+func (c *TeardownComponent) Ping(s *session.Session, _ *testdata.Ping) error {
+	return s.Response(&testdata.Pong{Content: "pong"})
+}
+
+func (c *TeardownComponent) BeforeShutdownContext(_ context.Context) {}
+
+func (c *TeardownComponent) ShutdownContext(ctx context.Context) {
+	c.reasons <- component.ShutdownReasonFrom(ctx)
+}
+
+func (s *clusterSuite) TestClusterShutdown(c *C) {
+	masterNode := &cluster.Node{
+		Options: cluster.Options{
+			IsMaster:   true,
+			Components: &component.Components{},
+		},
+		ServiceAddr: "127.0.0.1:4870",
+	}
+	err := masterNode.Startup()
+	c.Assert(err, IsNil)
+	defer masterNode.Shutdown()
+
+	reasons := make(chan component.ShutdownReason, 2)
+	var members []*cluster.Node
+	for _, addr := range []string{"127.0.0.1:4871", "127.0.0.1:4872"} {
+		comps := &component.Components{}
+		comps.Register(&TeardownComponent{reasons: reasons})
+		member := &cluster.Node{
+			Options: cluster.Options{
+				AdvertiseAddr: "127.0.0.1:4870",
+				Components:    comps,
+			},
+			ServiceAddr: addr,
+		}
+		c.Assert(member.Startup(), IsNil)
+		defer member.Shutdown()
+		members = append(members, member)
+	}
+	c.Assert(members[0].ClusterShutdown(context.Background()), Equals, cluster.ErrNotMaster)
+
+	// the members are drained and have left before the master shuts down, the master
+	// polls the members by the manual clock
+	defer func(c clock.Clock) { env.Clock = c }(env.Clock)
+	manual := clock.NewManual(time.Unix(1000, 0))
+	env.Clock = manual
+	ctx, cancel := context.WithTimeout(component.WithShutdownReason(context.Background(), component.ShutdownSignal), 5*time.Second)
+	defer cancel()
+	result := make(chan error, 1)
+	go func() { result <- masterNode.ClusterShutdown(ctx) }()
+	var shutdownErr error
+POLL:
+	for {
+		select {
+		case shutdownErr = <-result:
+			break POLL
+		case <-ctx.Done():
+			c.Fatal("cluster is not shut down in time")
+		default:
+		}
+		if manual.Waiters() > 0 {
+			manual.Advance(time.Second)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	c.Assert(shutdownErr, IsNil)
+	for _, member := range members {
+		select {
+		case <-member.Done():
+		default:
+			c.Fatal("member is not shut down")
+		}
+	}
+	c.Assert(<-reasons, Equals, component.ShutdownSignal)
+	c.Assert(<-reasons, Equals, component.ShutdownSignal)
+	c.Assert(masterNode.ExportRegistry(), HasLen, 0)
+	select {
+	case <-masterNode.Done():
+	default:
+		c.Fatal("master is not shut down")
+	}
+}
+
+type CacheComponent struct {
+	component.Base
+	calls int32
+}
+
+func (c *CacheComponent) Profile(s *session.Session, ping *testdata.Ping) error {
+	n := atomic.AddInt32(&c.calls, 1)
+	return s.Response(&testdata.Pong{Content: fmt.Sprintf("%s-%d", ping.Content, n)})
+}
+
+func (s *clusterSuite) TestResponseCache(c *C) {
+	// scheduler will be closed by the node suite
+	go scheduler.Sched()
+
+	comp := &CacheComponent{}
+	comps := &component.Components{}
+	comps.Register(comp, component.WithCache(time.Minute))
+	node := &cluster.Node{
+		Options: cluster.Options{
+			IsMaster:   true,
+			Components: comps,
+			ClientAddr: "127.0.0.1:14880",
+		},
+		ServiceAddr: "127.0.0.1:4880",
+	}
+	err := node.Startup()
+	c.Assert(err, IsNil)
+	defer node.Shutdown()
+
+	conn, err := net.Dial("tcp", "127.0.0.1:14880")
+	c.Assert(err, IsNil)
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+
+	recv := func() *packet.Packet {
+		buf := make([]byte, 1024)
+		n, err := conn.Read(buf)
+		c.Assert(err, IsNil)
+		packets, err := codec.NewDecoder().Decode(buf[:n])
+		c.Assert(err, IsNil)
+		c.Assert(packets, HasLen, 1)
+		return packets[0]
+	}
+	request := func(id uint64, content string) string {
+		payload, err := proto.Marshal(&testdata.Ping{Content: content})
+		c.Assert(err, IsNil)
+		data, err := message.Encode(&message.Message{Type: message.Request, ID: id, Route: "CacheComponent.Profile", Data: payload})
+		c.Assert(err, IsNil)
+		req, err := codec.Encode(packet.Data, data)
+		c.Assert(err, IsNil)
+		_, err = conn.Write(req)
+		c.Assert(err, IsNil)
+		msg, err := message.Decode(recv().Data)
+		c.Assert(err, IsNil)
+		c.Assert(msg.ID, Equals, id)
+		pong := &testdata.Pong{}
+		c.Assert(proto.Unmarshal(msg.Data, pong), IsNil)
+		return pong.Content
+	}
+
+	p, err := codec.Encode(packet.Handshake, nil)
+	c.Assert(err, IsNil)
+	_, err = conn.Write(p)
+	c.Assert(err, IsNil)
+	recv()
+	ack, err := codec.Encode(packet.HandshakeAck, nil)
+	c.Assert(err, IsNil)
+	_, err = conn.Write(ack)
+	c.Assert(err, IsNil)
+
+	// the same request is responded from the cache, the other payload is not
+	c.Assert(request(1, "alice"), Equals, "alice-1")
+	c.Assert(request(2, "alice"), Equals, "alice-1")
+	c.Assert(request(3, "bob"), Equals, "bob-2")
+	c.Assert(atomic.LoadInt32(&comp.calls), Equals, int32(2))
+
+	node.InvalidateCache("CacheComponent.Profile", 0)
+	c.Assert(request(4, "alice"), Equals, "alice-3")
+	c.Assert(request(5, "alice"), Equals, "alice-3")
+}
+
+type RebindComponent struct {
+	component.Base
+}
+
+func (c *RebindComponent) Switch(s *session.Session, ping *testdata.Ping) error {
+	uid, err := strconv.ParseInt(ping.Content, 10, 64)
+	if err != nil {
+		return err
+	}
+	if err := s.Rebind(uid); err != nil {
+		return err
+	}
+	return s.Response(&testdata.Pong{Content: ping.Content})
+}
+
+func (s *clusterSuite) TestRebindUID(c *C) {
+	// scheduler will be closed by the node suite
+	go scheduler.Sched()
+
+	comps := &component.Components{}
+	comps.Register(&RebindComponent{})
+	node := &cluster.Node{
+		Options: cluster.Options{
+			IsMaster:            true,
+			Components:          comps,
+			ClientAddr:          "127.0.0.1:14890",
+			SingleSessionPerUID: true,
+		},
+		ServiceAddr: "127.0.0.1:4890",
+	}
+	err := node.Startup()
+	c.Assert(err, IsNil)
+	defer node.Shutdown()
+
+	hs, err := codec.Encode(packet.Handshake, nil)
+	c.Assert(err, IsNil)
+	ack, err := codec.Encode(packet.HandshakeAck, nil)
+	c.Assert(err, IsNil)
+	connect := func() net.Conn {
+		conn, err := net.Dial("tcp", "127.0.0.1:14890")
+		c.Assert(err, IsNil)
+		_, err = conn.Write(append(hs, ack...))
+		c.Assert(err, IsNil)
+		return conn
+	}
+	switchUID := func(conn net.Conn, id uint64, uid string) {
+		ping, err := proto.Marshal(&testdata.Ping{Content: uid})
+		c.Assert(err, IsNil)
+		data, err := message.Encode(&message.Message{Type: message.Request, ID: id, Route: "RebindComponent.Switch", Data: ping})
+		c.Assert(err, IsNil)
+		req, err := codec.Encode(packet.Data, data)
+		c.Assert(err, IsNil)
+		_, err = conn.Write(req)
+		c.Assert(err, IsNil)
+	}
+	located := func(uid int64, expected bool) bool {
+		for i := 0; i < 40; i++ {
+			if _, found := node.LocateUID(uid); found == expected {
+				return true
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+		return false
+	}
+
+	rebound := make(chan int64, 8)
+	session.Lifetime.OnRebind(func(s *session.Session, oldUID int64) {
+		select {
+		case rebound <- oldUID:
+		default:
+		}
+	})
+
+	first := connect()
+	defer first.Close()
+	switchUID(first, 1, "1")
+	c.Assert(located(1, true), Equals, true)
+
+	second := connect()
+	defer second.Close()
+	switchUID(second, 1, "2")
+	c.Assert(located(2, true), Equals, true)
+
+	// the guest uid is released and the previous session of the new uid is kicked
+	switchUID(second, 2, "1")
+	select {
+	case old := <-rebound:
+		c.Assert(old, Equals, int64(2))
+	case <-time.After(2 * time.Second):
+		c.Fatal("rebind event is not fired")
+	}
+	c.Assert(located(2, false), Equals, true)
+	c.Assert(located(1, true), Equals, true)
+
+	first.SetReadDeadline(time.Now().Add(2 * time.Second))
+	buf := make([]byte, 1024)
+	for {
+		if _, err = first.Read(buf); err != nil {
+			break
+		}
+	}
+	c.Assert(err, Equals, goio.EOF)
+}
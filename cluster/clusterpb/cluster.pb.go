@@ -13,6 +13,14 @@ It has these top-level messages:
 	RegisterResponse
 	UnregisterRequest
 	UnregisterResponse
+	SyncMembersRequest
+	SyncMembersResponse
+	BindSessionRequest
+	BindSessionResponse
+	LocateSessionRequest
+	LocateSessionResponse
+	UnbindSessionRequest
+	UnbindSessionResponse
 	RequestMessage
 	NotifyMessage
 	ResponseMessage
@@ -20,12 +28,17 @@ It has these top-level messages:
 	MemberHandleResponse
 	NewMemberRequest
 	NewMemberResponse
+	NewMembersRequest
 	DelMemberRequest
 	DelMemberResponse
 	SessionClosedRequest
 	SessionClosedResponse
 	CloseSessionRequest
 	CloseSessionResponse
+	MasterChangedRequest
+	MasterChangedResponse
+	DrainRequest
+	DrainResponse
 */
 package clusterpb
 
@@ -50,9 +63,13 @@ var _ = math.Inf
 const _ = proto.ProtoPackageIsVersion2 // please upgrade the proto package
 
 type MemberInfo struct {
-	Label       string   `protobuf:"bytes,1,opt,name=label" json:"label"`
-	ServiceAddr string   `protobuf:"bytes,2,opt,name=serviceAddr" json:"serviceAddr"`
-	Services    []string `protobuf:"bytes,3,rep,name=services" json:"services"`
+	Label       string            `protobuf:"bytes,1,opt,name=label" json:"label"`
+	ServiceAddr string            `protobuf:"bytes,2,opt,name=serviceAddr" json:"serviceAddr"`
+	Services    []string          `protobuf:"bytes,3,rep,name=services" json:"services"`
+	Serializer  string            `protobuf:"bytes,4,opt,name=serializer" json:"serializer"`
+	Observer    bool              `protobuf:"varint,5,opt,name=observer" json:"observer"`
+	Labels      map[string]string `protobuf:"bytes,6,rep,name=labels" json:"labels" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	Gateway     bool              `protobuf:"varint,7,opt,name=gateway" json:"gateway"`
 }
 
 func (m *MemberInfo) Reset()                    { *m = MemberInfo{} }
@@ -81,6 +98,34 @@ func (m *MemberInfo) GetServices() []string {
 	return nil
 }
 
+func (m *MemberInfo) GetSerializer() string {
+	if m != nil {
+		return m.Serializer
+	}
+	return ""
+}
+
+func (m *MemberInfo) GetObserver() bool {
+	if m != nil {
+		return m.Observer
+	}
+	return false
+}
+
+func (m *MemberInfo) GetLabels() map[string]string {
+	if m != nil {
+		return m.Labels
+	}
+	return nil
+}
+
+func (m *MemberInfo) GetGateway() bool {
+	if m != nil {
+		return m.Gateway
+	}
+	return false
+}
+
 type RegisterRequest struct {
 	MemberInfo *MemberInfo `protobuf:"bytes,1,opt,name=memberInfo" json:"memberInfo"`
 }
@@ -137,18 +182,164 @@ func (m *UnregisterResponse) String() string            { return proto.CompactTe
 func (*UnregisterResponse) ProtoMessage()               {}
 func (*UnregisterResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{4} }
 
+type SyncMembersRequest struct {
+}
+
+func (m *SyncMembersRequest) Reset()                    { *m = SyncMembersRequest{} }
+func (m *SyncMembersRequest) String() string            { return proto.CompactTextString(m) }
+func (*SyncMembersRequest) ProtoMessage()               {}
+func (*SyncMembersRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{5} }
+
+type SyncMembersResponse struct {
+	Members []*MemberInfo `protobuf:"bytes,1,rep,name=members" json:"members"`
+}
+
+func (m *SyncMembersResponse) Reset()                    { *m = SyncMembersResponse{} }
+func (m *SyncMembersResponse) String() string            { return proto.CompactTextString(m) }
+func (*SyncMembersResponse) ProtoMessage()               {}
+func (*SyncMembersResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{6} }
+
+func (m *SyncMembersResponse) GetMembers() []*MemberInfo {
+	if m != nil {
+		return m.Members
+	}
+	return nil
+}
+
+type BindSessionRequest struct {
+	Uid       int64  `protobuf:"varint,1,opt,name=uid" json:"uid"`
+	GateAddr  string `protobuf:"bytes,2,opt,name=gateAddr" json:"gateAddr"`
+	SessionId int64  `protobuf:"varint,3,opt,name=sessionId" json:"sessionId"`
+}
+
+func (m *BindSessionRequest) Reset()                    { *m = BindSessionRequest{} }
+func (m *BindSessionRequest) String() string            { return proto.CompactTextString(m) }
+func (*BindSessionRequest) ProtoMessage()               {}
+func (*BindSessionRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{7} }
+
+func (m *BindSessionRequest) GetUid() int64 {
+	if m != nil {
+		return m.Uid
+	}
+	return 0
+}
+
+func (m *BindSessionRequest) GetGateAddr() string {
+	if m != nil {
+		return m.GateAddr
+	}
+	return ""
+}
+
+func (m *BindSessionRequest) GetSessionId() int64 {
+	if m != nil {
+		return m.SessionId
+	}
+	return 0
+}
+
+type BindSessionResponse struct {
+}
+
+func (m *BindSessionResponse) Reset()                    { *m = BindSessionResponse{} }
+func (m *BindSessionResponse) String() string            { return proto.CompactTextString(m) }
+func (*BindSessionResponse) ProtoMessage()               {}
+func (*BindSessionResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{8} }
+
+type LocateSessionRequest struct {
+	Uid int64 `protobuf:"varint,1,opt,name=uid" json:"uid"`
+}
+
+func (m *LocateSessionRequest) Reset()                    { *m = LocateSessionRequest{} }
+func (m *LocateSessionRequest) String() string            { return proto.CompactTextString(m) }
+func (*LocateSessionRequest) ProtoMessage()               {}
+func (*LocateSessionRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{9} }
+
+func (m *LocateSessionRequest) GetUid() int64 {
+	if m != nil {
+		return m.Uid
+	}
+	return 0
+}
+
+type LocateSessionResponse struct {
+	GateAddr  string `protobuf:"bytes,1,opt,name=gateAddr" json:"gateAddr"`
+	SessionId int64  `protobuf:"varint,2,opt,name=sessionId" json:"sessionId"`
+}
+
+func (m *LocateSessionResponse) Reset()                    { *m = LocateSessionResponse{} }
+func (m *LocateSessionResponse) String() string            { return proto.CompactTextString(m) }
+func (*LocateSessionResponse) ProtoMessage()               {}
+func (*LocateSessionResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{10} }
+
+func (m *LocateSessionResponse) GetGateAddr() string {
+	if m != nil {
+		return m.GateAddr
+	}
+	return ""
+}
+
+func (m *LocateSessionResponse) GetSessionId() int64 {
+	if m != nil {
+		return m.SessionId
+	}
+	return 0
+}
+
+type UnbindSessionRequest struct {
+	Uid       int64  `protobuf:"varint,1,opt,name=uid" json:"uid"`
+	GateAddr  string `protobuf:"bytes,2,opt,name=gateAddr" json:"gateAddr"`
+	SessionId int64  `protobuf:"varint,3,opt,name=sessionId" json:"sessionId"`
+}
+
+func (m *UnbindSessionRequest) Reset()                    { *m = UnbindSessionRequest{} }
+func (m *UnbindSessionRequest) String() string            { return proto.CompactTextString(m) }
+func (*UnbindSessionRequest) ProtoMessage()               {}
+func (*UnbindSessionRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{11} }
+
+func (m *UnbindSessionRequest) GetUid() int64 {
+	if m != nil {
+		return m.Uid
+	}
+	return 0
+}
+
+func (m *UnbindSessionRequest) GetGateAddr() string {
+	if m != nil {
+		return m.GateAddr
+	}
+	return ""
+}
+
+func (m *UnbindSessionRequest) GetSessionId() int64 {
+	if m != nil {
+		return m.SessionId
+	}
+	return 0
+}
+
+type UnbindSessionResponse struct {
+}
+
+func (m *UnbindSessionResponse) Reset()                    { *m = UnbindSessionResponse{} }
+func (m *UnbindSessionResponse) String() string            { return proto.CompactTextString(m) }
+func (*UnbindSessionResponse) ProtoMessage()               {}
+func (*UnbindSessionResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{12} }
+
 type RequestMessage struct {
 	GateAddr  string `protobuf:"bytes,1,opt,name=gateAddr" json:"gateAddr"`
 	SessionId int64  `protobuf:"varint,2,opt,name=sessionId" json:"sessionId"`
 	Id        uint64 `protobuf:"varint,3,opt,name=id" json:"id"`
 	Route     string `protobuf:"bytes,4,opt,name=route" json:"route"`
 	Data      []byte `protobuf:"bytes,5,opt,name=data,proto3" json:"data"`
+	Uid       int64  `protobuf:"varint,6,opt,name=uid" json:"uid"`
+	Timeout   int64  `protobuf:"varint,7,opt,name=timeout" json:"timeout"`
 }
 
 func (m *RequestMessage) Reset()                    { *m = RequestMessage{} }
 func (m *RequestMessage) String() string            { return proto.CompactTextString(m) }
 func (*RequestMessage) ProtoMessage()               {}
-func (*RequestMessage) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{5} }
+func (*RequestMessage) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{13} }
 
 func (m *RequestMessage) GetGateAddr() string {
 	if m != nil {
@@ -185,17 +376,32 @@ func (m *RequestMessage) GetData() []byte {
 	return nil
 }
 
+func (m *RequestMessage) GetUid() int64 {
+	if m != nil {
+		return m.Uid
+	}
+	return 0
+}
+
+func (m *RequestMessage) GetTimeout() int64 {
+	if m != nil {
+		return m.Timeout
+	}
+	return 0
+}
+
 type NotifyMessage struct {
 	GateAddr  string `protobuf:"bytes,1,opt,name=gateAddr" json:"gateAddr"`
 	SessionId int64  `protobuf:"varint,2,opt,name=sessionId" json:"sessionId"`
 	Route     string `protobuf:"bytes,3,opt,name=route" json:"route"`
 	Data      []byte `protobuf:"bytes,4,opt,name=data,proto3" json:"data"`
+	Uid       int64  `protobuf:"varint,5,opt,name=uid" json:"uid"`
 }
 
 func (m *NotifyMessage) Reset()                    { *m = NotifyMessage{} }
 func (m *NotifyMessage) String() string            { return proto.CompactTextString(m) }
 func (*NotifyMessage) ProtoMessage()               {}
-func (*NotifyMessage) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{6} }
+func (*NotifyMessage) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{14} }
 
 func (m *NotifyMessage) GetGateAddr() string {
 	if m != nil {
@@ -225,16 +431,24 @@ func (m *NotifyMessage) GetData() []byte {
 	return nil
 }
 
+func (m *NotifyMessage) GetUid() int64 {
+	if m != nil {
+		return m.Uid
+	}
+	return 0
+}
+
 type ResponseMessage struct {
 	SessionId int64  `protobuf:"varint,1,opt,name=sessionId" json:"sessionId"`
 	Id        uint64 `protobuf:"varint,2,opt,name=id" json:"id"`
 	Data      []byte `protobuf:"bytes,3,opt,name=data,proto3" json:"data"`
+	More      bool   `protobuf:"varint,4,opt,name=more" json:"more"`
 }
 
 func (m *ResponseMessage) Reset()                    { *m = ResponseMessage{} }
 func (m *ResponseMessage) String() string            { return proto.CompactTextString(m) }
 func (*ResponseMessage) ProtoMessage()               {}
-func (*ResponseMessage) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{7} }
+func (*ResponseMessage) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{15} }
 
 func (m *ResponseMessage) GetSessionId() int64 {
 	if m != nil {
@@ -257,6 +471,13 @@ func (m *ResponseMessage) GetData() []byte {
 	return nil
 }
 
+func (m *ResponseMessage) GetMore() bool {
+	if m != nil {
+		return m.More
+	}
+	return false
+}
+
 type PushMessage struct {
 	SessionId int64  `protobuf:"varint,1,opt,name=sessionId" json:"sessionId"`
 	Route     string `protobuf:"bytes,2,opt,name=route" json:"route"`
@@ -266,7 +487,7 @@ type PushMessage struct {
 func (m *PushMessage) Reset()                    { *m = PushMessage{} }
 func (m *PushMessage) String() string            { return proto.CompactTextString(m) }
 func (*PushMessage) ProtoMessage()               {}
-func (*PushMessage) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{8} }
+func (*PushMessage) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{16} }
 
 func (m *PushMessage) GetSessionId() int64 {
 	if m != nil {
@@ -295,7 +516,7 @@ type MemberHandleResponse struct {
 func (m *MemberHandleResponse) Reset()                    { *m = MemberHandleResponse{} }
 func (m *MemberHandleResponse) String() string            { return proto.CompactTextString(m) }
 func (*MemberHandleResponse) ProtoMessage()               {}
-func (*MemberHandleResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{9} }
+func (*MemberHandleResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{17} }
 
 type NewMemberRequest struct {
 	MemberInfo *MemberInfo `protobuf:"bytes,1,opt,name=memberInfo" json:"memberInfo"`
@@ -304,7 +525,7 @@ type NewMemberRequest struct {
 func (m *NewMemberRequest) Reset()                    { *m = NewMemberRequest{} }
 func (m *NewMemberRequest) String() string            { return proto.CompactTextString(m) }
 func (*NewMemberRequest) ProtoMessage()               {}
-func (*NewMemberRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{10} }
+func (*NewMemberRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{18} }
 
 func (m *NewMemberRequest) GetMemberInfo() *MemberInfo {
 	if m != nil {
@@ -319,7 +540,23 @@ type NewMemberResponse struct {
 func (m *NewMemberResponse) Reset()                    { *m = NewMemberResponse{} }
 func (m *NewMemberResponse) String() string            { return proto.CompactTextString(m) }
 func (*NewMemberResponse) ProtoMessage()               {}
-func (*NewMemberResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{11} }
+func (*NewMemberResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{19} }
+
+type NewMembersRequest struct {
+	MemberInfos []*MemberInfo `protobuf:"bytes,1,rep,name=memberInfos" json:"memberInfos"`
+}
+
+func (m *NewMembersRequest) Reset()                    { *m = NewMembersRequest{} }
+func (m *NewMembersRequest) String() string            { return proto.CompactTextString(m) }
+func (*NewMembersRequest) ProtoMessage()               {}
+func (*NewMembersRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{20} }
+
+func (m *NewMembersRequest) GetMemberInfos() []*MemberInfo {
+	if m != nil {
+		return m.MemberInfos
+	}
+	return nil
+}
 
 type DelMemberRequest struct {
 	ServiceAddr string `protobuf:"bytes,1,opt,name=serviceAddr" json:"serviceAddr"`
@@ -328,7 +565,7 @@ type DelMemberRequest struct {
 func (m *DelMemberRequest) Reset()                    { *m = DelMemberRequest{} }
 func (m *DelMemberRequest) String() string            { return proto.CompactTextString(m) }
 func (*DelMemberRequest) ProtoMessage()               {}
-func (*DelMemberRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{12} }
+func (*DelMemberRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{21} }
 
 func (m *DelMemberRequest) GetServiceAddr() string {
 	if m != nil {
@@ -343,16 +580,17 @@ type DelMemberResponse struct {
 func (m *DelMemberResponse) Reset()                    { *m = DelMemberResponse{} }
 func (m *DelMemberResponse) String() string            { return proto.CompactTextString(m) }
 func (*DelMemberResponse) ProtoMessage()               {}
-func (*DelMemberResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{13} }
+func (*DelMemberResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{22} }
 
 type SessionClosedRequest struct {
-	SessionId int64 `protobuf:"varint,1,opt,name=sessionId" json:"sessionId"`
+	SessionId int64  `protobuf:"varint,1,opt,name=sessionId" json:"sessionId"`
+	Reason    string `protobuf:"bytes,2,opt,name=reason" json:"reason"`
 }
 
 func (m *SessionClosedRequest) Reset()                    { *m = SessionClosedRequest{} }
 func (m *SessionClosedRequest) String() string            { return proto.CompactTextString(m) }
 func (*SessionClosedRequest) ProtoMessage()               {}
-func (*SessionClosedRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{14} }
+func (*SessionClosedRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{23} }
 
 func (m *SessionClosedRequest) GetSessionId() int64 {
 	if m != nil {
@@ -361,22 +599,30 @@ func (m *SessionClosedRequest) GetSessionId() int64 {
 	return 0
 }
 
+func (m *SessionClosedRequest) GetReason() string {
+	if m != nil {
+		return m.Reason
+	}
+	return ""
+}
+
 type SessionClosedResponse struct {
 }
 
 func (m *SessionClosedResponse) Reset()                    { *m = SessionClosedResponse{} }
 func (m *SessionClosedResponse) String() string            { return proto.CompactTextString(m) }
 func (*SessionClosedResponse) ProtoMessage()               {}
-func (*SessionClosedResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{15} }
+func (*SessionClosedResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{24} }
 
 type CloseSessionRequest struct {
-	SessionId int64 `protobuf:"varint,1,opt,name=sessionId" json:"sessionId"`
+	SessionId int64  `protobuf:"varint,1,opt,name=sessionId" json:"sessionId"`
+	Reason    string `protobuf:"bytes,2,opt,name=reason" json:"reason"`
 }
 
 func (m *CloseSessionRequest) Reset()                    { *m = CloseSessionRequest{} }
 func (m *CloseSessionRequest) String() string            { return proto.CompactTextString(m) }
 func (*CloseSessionRequest) ProtoMessage()               {}
-func (*CloseSessionRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{16} }
+func (*CloseSessionRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{25} }
 
 func (m *CloseSessionRequest) GetSessionId() int64 {
 	if m != nil {
@@ -385,13 +631,76 @@ func (m *CloseSessionRequest) GetSessionId() int64 {
 	return 0
 }
 
+func (m *CloseSessionRequest) GetReason() string {
+	if m != nil {
+		return m.Reason
+	}
+	return ""
+}
+
 type CloseSessionResponse struct {
 }
 
 func (m *CloseSessionResponse) Reset()                    { *m = CloseSessionResponse{} }
 func (m *CloseSessionResponse) String() string            { return proto.CompactTextString(m) }
 func (*CloseSessionResponse) ProtoMessage()               {}
-func (*CloseSessionResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{17} }
+func (*CloseSessionResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{26} }
+
+type MasterChangedRequest struct {
+	Master *MemberInfo `protobuf:"bytes,1,opt,name=master" json:"master"`
+}
+
+func (m *MasterChangedRequest) Reset()                    { *m = MasterChangedRequest{} }
+func (m *MasterChangedRequest) String() string            { return proto.CompactTextString(m) }
+func (*MasterChangedRequest) ProtoMessage()               {}
+func (*MasterChangedRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{27} }
+
+func (m *MasterChangedRequest) GetMaster() *MemberInfo {
+	if m != nil {
+		return m.Master
+	}
+	return nil
+}
+
+type MasterChangedResponse struct {
+}
+
+func (m *MasterChangedResponse) Reset()                    { *m = MasterChangedResponse{} }
+func (m *MasterChangedResponse) String() string            { return proto.CompactTextString(m) }
+func (*MasterChangedResponse) ProtoMessage()               {}
+func (*MasterChangedResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{28} }
+
+type DrainRequest struct {
+	Reason  string `protobuf:"bytes,1,opt,name=reason" json:"reason"`
+	Timeout int64  `protobuf:"varint,2,opt,name=timeout" json:"timeout"`
+}
+
+func (m *DrainRequest) Reset()                    { *m = DrainRequest{} }
+func (m *DrainRequest) String() string            { return proto.CompactTextString(m) }
+func (*DrainRequest) ProtoMessage()               {}
+func (*DrainRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{29} }
+
+func (m *DrainRequest) GetReason() string {
+	if m != nil {
+		return m.Reason
+	}
+	return ""
+}
+
+func (m *DrainRequest) GetTimeout() int64 {
+	if m != nil {
+		return m.Timeout
+	}
+	return 0
+}
+
+type DrainResponse struct {
+}
+
+func (m *DrainResponse) Reset()                    { *m = DrainResponse{} }
+func (m *DrainResponse) String() string            { return proto.CompactTextString(m) }
+func (*DrainResponse) ProtoMessage()               {}
+func (*DrainResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{30} }
 
 func init() {
 	proto.RegisterType((*MemberInfo)(nil), "clusterpb.MemberInfo")
@@ -399,6 +708,14 @@ func init() {
 	proto.RegisterType((*RegisterResponse)(nil), "clusterpb.RegisterResponse")
 	proto.RegisterType((*UnregisterRequest)(nil), "clusterpb.UnregisterRequest")
 	proto.RegisterType((*UnregisterResponse)(nil), "clusterpb.UnregisterResponse")
+	proto.RegisterType((*SyncMembersRequest)(nil), "clusterpb.SyncMembersRequest")
+	proto.RegisterType((*SyncMembersResponse)(nil), "clusterpb.SyncMembersResponse")
+	proto.RegisterType((*BindSessionRequest)(nil), "clusterpb.BindSessionRequest")
+	proto.RegisterType((*BindSessionResponse)(nil), "clusterpb.BindSessionResponse")
+	proto.RegisterType((*LocateSessionRequest)(nil), "clusterpb.LocateSessionRequest")
+	proto.RegisterType((*LocateSessionResponse)(nil), "clusterpb.LocateSessionResponse")
+	proto.RegisterType((*UnbindSessionRequest)(nil), "clusterpb.UnbindSessionRequest")
+	proto.RegisterType((*UnbindSessionResponse)(nil), "clusterpb.UnbindSessionResponse")
 	proto.RegisterType((*RequestMessage)(nil), "clusterpb.RequestMessage")
 	proto.RegisterType((*NotifyMessage)(nil), "clusterpb.NotifyMessage")
 	proto.RegisterType((*ResponseMessage)(nil), "clusterpb.ResponseMessage")
@@ -406,12 +723,17 @@ func init() {
 	proto.RegisterType((*MemberHandleResponse)(nil), "clusterpb.MemberHandleResponse")
 	proto.RegisterType((*NewMemberRequest)(nil), "clusterpb.NewMemberRequest")
 	proto.RegisterType((*NewMemberResponse)(nil), "clusterpb.NewMemberResponse")
+	proto.RegisterType((*NewMembersRequest)(nil), "clusterpb.NewMembersRequest")
 	proto.RegisterType((*DelMemberRequest)(nil), "clusterpb.DelMemberRequest")
 	proto.RegisterType((*DelMemberResponse)(nil), "clusterpb.DelMemberResponse")
 	proto.RegisterType((*SessionClosedRequest)(nil), "clusterpb.SessionClosedRequest")
 	proto.RegisterType((*SessionClosedResponse)(nil), "clusterpb.SessionClosedResponse")
 	proto.RegisterType((*CloseSessionRequest)(nil), "clusterpb.CloseSessionRequest")
 	proto.RegisterType((*CloseSessionResponse)(nil), "clusterpb.CloseSessionResponse")
+	proto.RegisterType((*MasterChangedRequest)(nil), "clusterpb.MasterChangedRequest")
+	proto.RegisterType((*MasterChangedResponse)(nil), "clusterpb.MasterChangedResponse")
+	proto.RegisterType((*DrainRequest)(nil), "clusterpb.DrainRequest")
+	proto.RegisterType((*DrainResponse)(nil), "clusterpb.DrainResponse")
 }
 
 // Reference imports to suppress errors if they are not otherwise used.
@@ -427,6 +749,10 @@ const _ = grpc.SupportPackageIsVersion4
 type MasterClient interface {
 	Register(ctx context.Context, in *RegisterRequest, opts ...grpc.CallOption) (*RegisterResponse, error)
 	Unregister(ctx context.Context, in *UnregisterRequest, opts ...grpc.CallOption) (*UnregisterResponse, error)
+	SyncMembers(ctx context.Context, in *SyncMembersRequest, opts ...grpc.CallOption) (*SyncMembersResponse, error)
+	BindSession(ctx context.Context, in *BindSessionRequest, opts ...grpc.CallOption) (*BindSessionResponse, error)
+	LocateSession(ctx context.Context, in *LocateSessionRequest, opts ...grpc.CallOption) (*LocateSessionResponse, error)
+	UnbindSession(ctx context.Context, in *UnbindSessionRequest, opts ...grpc.CallOption) (*UnbindSessionResponse, error)
 }
 
 type masterClient struct {
@@ -455,11 +781,51 @@ func (c *masterClient) Unregister(ctx context.Context, in *UnregisterRequest, op
 	return out, nil
 }
 
+func (c *masterClient) SyncMembers(ctx context.Context, in *SyncMembersRequest, opts ...grpc.CallOption) (*SyncMembersResponse, error) {
+	out := new(SyncMembersResponse)
+	err := grpc.Invoke(ctx, "/clusterpb.Master/SyncMembers", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *masterClient) BindSession(ctx context.Context, in *BindSessionRequest, opts ...grpc.CallOption) (*BindSessionResponse, error) {
+	out := new(BindSessionResponse)
+	err := grpc.Invoke(ctx, "/clusterpb.Master/BindSession", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *masterClient) LocateSession(ctx context.Context, in *LocateSessionRequest, opts ...grpc.CallOption) (*LocateSessionResponse, error) {
+	out := new(LocateSessionResponse)
+	err := grpc.Invoke(ctx, "/clusterpb.Master/LocateSession", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *masterClient) UnbindSession(ctx context.Context, in *UnbindSessionRequest, opts ...grpc.CallOption) (*UnbindSessionResponse, error) {
+	out := new(UnbindSessionResponse)
+	err := grpc.Invoke(ctx, "/clusterpb.Master/UnbindSession", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // Server API for Master service
 
 type MasterServer interface {
 	Register(context.Context, *RegisterRequest) (*RegisterResponse, error)
 	Unregister(context.Context, *UnregisterRequest) (*UnregisterResponse, error)
+	SyncMembers(context.Context, *SyncMembersRequest) (*SyncMembersResponse, error)
+	BindSession(context.Context, *BindSessionRequest) (*BindSessionResponse, error)
+	LocateSession(context.Context, *LocateSessionRequest) (*LocateSessionResponse, error)
+	UnbindSession(context.Context, *UnbindSessionRequest) (*UnbindSessionResponse, error)
 }
 
 func RegisterMasterServer(s *grpc.Server, srv MasterServer) {
@@ -502,6 +868,78 @@ func _Master_Unregister_Handler(srv interface{}, ctx context.Context, dec func(i
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Master_SyncMembers_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SyncMembersRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MasterServer).SyncMembers(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/clusterpb.Master/SyncMembers",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MasterServer).SyncMembers(ctx, req.(*SyncMembersRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Master_BindSession_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BindSessionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MasterServer).BindSession(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/clusterpb.Master/BindSession",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MasterServer).BindSession(ctx, req.(*BindSessionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Master_LocateSession_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(LocateSessionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MasterServer).LocateSession(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/clusterpb.Master/LocateSession",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MasterServer).LocateSession(ctx, req.(*LocateSessionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Master_UnbindSession_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UnbindSessionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MasterServer).UnbindSession(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/clusterpb.Master/UnbindSession",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MasterServer).UnbindSession(ctx, req.(*UnbindSessionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 var _Master_serviceDesc = grpc.ServiceDesc{
 	ServiceName: "clusterpb.Master",
 	HandlerType: (*MasterServer)(nil),
@@ -514,6 +952,22 @@ var _Master_serviceDesc = grpc.ServiceDesc{
 			MethodName: "Unregister",
 			Handler:    _Master_Unregister_Handler,
 		},
+		{
+			MethodName: "SyncMembers",
+			Handler:    _Master_SyncMembers_Handler,
+		},
+		{
+			MethodName: "BindSession",
+			Handler:    _Master_BindSession_Handler,
+		},
+		{
+			MethodName: "LocateSession",
+			Handler:    _Master_LocateSession_Handler,
+		},
+		{
+			MethodName: "UnbindSession",
+			Handler:    _Master_UnbindSession_Handler,
+		},
 	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "cluster.proto",
@@ -527,9 +981,12 @@ type MemberClient interface {
 	HandlePush(ctx context.Context, in *PushMessage, opts ...grpc.CallOption) (*MemberHandleResponse, error)
 	HandleResponse(ctx context.Context, in *ResponseMessage, opts ...grpc.CallOption) (*MemberHandleResponse, error)
 	NewMember(ctx context.Context, in *NewMemberRequest, opts ...grpc.CallOption) (*NewMemberResponse, error)
+	NewMembers(ctx context.Context, in *NewMembersRequest, opts ...grpc.CallOption) (*NewMemberResponse, error)
 	DelMember(ctx context.Context, in *DelMemberRequest, opts ...grpc.CallOption) (*DelMemberResponse, error)
 	SessionClosed(ctx context.Context, in *SessionClosedRequest, opts ...grpc.CallOption) (*SessionClosedResponse, error)
 	CloseSession(ctx context.Context, in *CloseSessionRequest, opts ...grpc.CallOption) (*CloseSessionResponse, error)
+	MasterChanged(ctx context.Context, in *MasterChangedRequest, opts ...grpc.CallOption) (*MasterChangedResponse, error)
+	Drain(ctx context.Context, in *DrainRequest, opts ...grpc.CallOption) (*DrainResponse, error)
 }
 
 type memberClient struct {
@@ -585,6 +1042,15 @@ func (c *memberClient) NewMember(ctx context.Context, in *NewMemberRequest, opts
 	return out, nil
 }
 
+func (c *memberClient) NewMembers(ctx context.Context, in *NewMembersRequest, opts ...grpc.CallOption) (*NewMemberResponse, error) {
+	out := new(NewMemberResponse)
+	err := grpc.Invoke(ctx, "/clusterpb.Member/NewMembers", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 func (c *memberClient) DelMember(ctx context.Context, in *DelMemberRequest, opts ...grpc.CallOption) (*DelMemberResponse, error) {
 	out := new(DelMemberResponse)
 	err := grpc.Invoke(ctx, "/clusterpb.Member/DelMember", in, out, c.cc, opts...)
@@ -612,6 +1078,24 @@ func (c *memberClient) CloseSession(ctx context.Context, in *CloseSessionRequest
 	return out, nil
 }
 
+func (c *memberClient) MasterChanged(ctx context.Context, in *MasterChangedRequest, opts ...grpc.CallOption) (*MasterChangedResponse, error) {
+	out := new(MasterChangedResponse)
+	err := grpc.Invoke(ctx, "/clusterpb.Member/MasterChanged", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *memberClient) Drain(ctx context.Context, in *DrainRequest, opts ...grpc.CallOption) (*DrainResponse, error) {
+	out := new(DrainResponse)
+	err := grpc.Invoke(ctx, "/clusterpb.Member/Drain", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // Server API for Member service
 
 type MemberServer interface {
@@ -620,9 +1104,12 @@ type MemberServer interface {
 	HandlePush(context.Context, *PushMessage) (*MemberHandleResponse, error)
 	HandleResponse(context.Context, *ResponseMessage) (*MemberHandleResponse, error)
 	NewMember(context.Context, *NewMemberRequest) (*NewMemberResponse, error)
+	NewMembers(context.Context, *NewMembersRequest) (*NewMemberResponse, error)
 	DelMember(context.Context, *DelMemberRequest) (*DelMemberResponse, error)
 	SessionClosed(context.Context, *SessionClosedRequest) (*SessionClosedResponse, error)
 	CloseSession(context.Context, *CloseSessionRequest) (*CloseSessionResponse, error)
+	MasterChanged(context.Context, *MasterChangedRequest) (*MasterChangedResponse, error)
+	Drain(context.Context, *DrainRequest) (*DrainResponse, error)
 }
 
 func RegisterMemberServer(s *grpc.Server, srv MemberServer) {
@@ -719,6 +1206,24 @@ func _Member_NewMember_Handler(srv interface{}, ctx context.Context, dec func(in
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Member_NewMembers_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(NewMembersRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MemberServer).NewMembers(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/clusterpb.Member/NewMembers",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MemberServer).NewMembers(ctx, req.(*NewMembersRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 func _Member_DelMember_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
 	in := new(DelMemberRequest)
 	if err := dec(in); err != nil {
@@ -773,6 +1278,42 @@ func _Member_CloseSession_Handler(srv interface{}, ctx context.Context, dec func
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Member_MasterChanged_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(MasterChangedRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MemberServer).MasterChanged(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/clusterpb.Member/MasterChanged",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MemberServer).MasterChanged(ctx, req.(*MasterChangedRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Member_Drain_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DrainRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MemberServer).Drain(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/clusterpb.Member/Drain",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MemberServer).Drain(ctx, req.(*DrainRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 var _Member_serviceDesc = grpc.ServiceDesc{
 	ServiceName: "clusterpb.Member",
 	HandlerType: (*MemberServer)(nil),
@@ -797,6 +1338,10 @@ var _Member_serviceDesc = grpc.ServiceDesc{
 			MethodName: "NewMember",
 			Handler:    _Member_NewMember_Handler,
 		},
+		{
+			MethodName: "NewMembers",
+			Handler:    _Member_NewMembers_Handler,
+		},
 		{
 			MethodName: "DelMember",
 			Handler:    _Member_DelMember_Handler,
@@ -809,6 +1354,14 @@ var _Member_serviceDesc = grpc.ServiceDesc{
 			MethodName: "CloseSession",
 			Handler:    _Member_CloseSession_Handler,
 		},
+		{
+			MethodName: "MasterChanged",
+			Handler:    _Member_MasterChanged_Handler,
+		},
+		{
+			MethodName: "Drain",
+			Handler:    _Member_Drain_Handler,
+		},
 	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "cluster.proto",
@@ -817,42 +1370,67 @@ var _Member_serviceDesc = grpc.ServiceDesc{
 func init() { proto.RegisterFile("cluster.proto", fileDescriptor0) }
 
 var fileDescriptor0 = []byte{
-	// 591 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xa4, 0x95, 0x5d, 0x73, 0xd2, 0x4c,
-	0x14, 0xc7, 0x09, 0xa1, 0x3c, 0xe5, 0xf0, 0xf2, 0xc0, 0x81, 0x62, 0x8c, 0xa8, 0x99, 0x5c, 0x71,
-	0x85, 0x33, 0xd4, 0x7e, 0x00, 0xa7, 0x3a, 0xc2, 0x38, 0x54, 0x4d, 0xed, 0xbd, 0xa1, 0xd9, 0x62,
-	0x66, 0x52, 0x82, 0xd9, 0xa0, 0xe3, 0xbd, 0x1f, 0xc3, 0x6f, 0xea, 0x8d, 0x93, 0x6c, 0xb2, 0x9c,
-	0x0d, 0x41, 0x99, 0xe9, 0x1d, 0xbb, 0x67, 0xcf, 0xef, 0xbc, 0xfe, 0x09, 0xb4, 0x6f, 0x83, 0x2d,
-	0x8f, 0x59, 0x34, 0xd9, 0x44, 0x61, 0x1c, 0x62, 0x23, 0x3b, 0x6e, 0x96, 0xf6, 0x67, 0x80, 0x05,
-	0xbb, 0x5f, 0xb2, 0x68, 0xbe, 0xbe, 0x0b, 0x71, 0x00, 0x27, 0x81, 0xbb, 0x64, 0x81, 0xa1, 0x59,
-	0xda, 0xb8, 0xe1, 0x88, 0x03, 0x5a, 0xd0, 0xe4, 0x2c, 0xfa, 0xe6, 0xdf, 0xb2, 0x57, 0x9e, 0x17,
-	0x19, 0xd5, 0xd4, 0x46, 0xaf, 0xd0, 0x84, 0xd3, 0xec, 0xc8, 0x0d, 0xdd, 0xd2, 0xc7, 0x0d, 0x47,
-	0x9e, 0xed, 0x19, 0xfc, 0xef, 0xb0, 0x95, 0x9f, 0xc4, 0x73, 0xd8, 0xd7, 0x2d, 0xe3, 0x31, 0x5e,
-	0x00, 0xdc, 0xcb, 0xa0, 0x69, 0xac, 0xe6, 0xf4, 0x6c, 0x22, 0x93, 0x9a, 0xec, 0x32, 0x72, 0xc8,
-	0x43, 0xfb, 0x12, 0xba, 0x3b, 0x12, 0xdf, 0x84, 0x6b, 0xce, 0xf0, 0x05, 0xfc, 0x27, 0x5e, 0x70,
-	0x43, 0xb3, 0xf4, 0xc3, 0x9c, 0xfc, 0x95, 0x7d, 0x01, 0xbd, 0x9b, 0x75, 0x54, 0x48, 0xa8, 0x50,
-	0xa1, 0xb6, 0x57, 0xa1, 0x3d, 0x00, 0xa4, 0x6e, 0x22, 0xba, 0xfd, 0x53, 0x83, 0x4e, 0xc6, 0x58,
-	0x30, 0xce, 0xdd, 0x15, 0x4b, 0x5a, 0xb1, 0x72, 0x63, 0xca, 0x91, 0x67, 0x1c, 0x41, 0x83, 0x33,
-	0xce, 0xfd, 0x70, 0x3d, 0xf7, 0xd2, 0x36, 0xea, 0xce, 0xee, 0x02, 0x3b, 0x50, 0xf5, 0x3d, 0x43,
-	0xb7, 0xb4, 0x71, 0xcd, 0xa9, 0xfa, 0x5e, 0x32, 0x8c, 0x28, 0xdc, 0xc6, 0xcc, 0xa8, 0x89, 0x61,
-	0xa4, 0x07, 0x44, 0xa8, 0x79, 0x6e, 0xec, 0x1a, 0x27, 0x96, 0x36, 0x6e, 0x39, 0xe9, 0x6f, 0x9b,
-	0x43, 0xfb, 0x2a, 0x8c, 0xfd, 0xbb, 0x1f, 0x0f, 0x4f, 0x42, 0x06, 0xd5, 0xcb, 0x82, 0xd6, 0x48,
-	0xd0, 0xeb, 0x64, 0xae, 0xa2, 0x0f, 0x79, 0x58, 0x05, 0xad, 0x95, 0xd7, 0x57, 0x95, 0xf5, 0xe5,
-	0x50, 0x9d, 0x40, 0x6f, 0xa0, 0xf9, 0x61, 0xcb, 0xbf, 0x1c, 0x07, 0x94, 0xb9, 0x56, 0xcb, 0x72,
-	0xa5, 0xd8, 0x21, 0x0c, 0xc4, 0x2e, 0xcc, 0xdc, 0xb5, 0x17, 0x30, 0x39, 0xbf, 0x39, 0x74, 0xaf,
-	0xd8, 0x77, 0x61, 0x7a, 0xe0, 0x72, 0xf6, 0xa1, 0x47, 0x50, 0x19, 0xff, 0x25, 0x74, 0x5f, 0xb3,
-	0x40, 0xe5, 0xff, 0x7b, 0xd7, 0xfa, 0xd0, 0x23, 0x5e, 0x12, 0x35, 0xb8, 0x16, 0x95, 0x5f, 0x06,
-	0x21, 0x67, 0x5e, 0x8e, 0xfb, 0x6b, 0x8b, 0xec, 0x47, 0x70, 0x56, 0xf0, 0xca, 0x70, 0xe7, 0xd0,
-	0x4f, 0x6f, 0x32, 0xeb, 0x71, 0xb4, 0x21, 0x0c, 0x54, 0x27, 0x01, 0x9b, 0xfe, 0xd2, 0xa0, 0xbe,
-	0x70, 0x93, 0xfe, 0xe0, 0x1b, 0x38, 0xcd, 0x35, 0x8a, 0x26, 0xe9, 0x5a, 0xe1, 0x2f, 0xc0, 0x7c,
-	0x52, 0x6a, 0xcb, 0x92, 0xab, 0xe0, 0x3b, 0x80, 0x9d, 0xdc, 0x70, 0x44, 0x1e, 0xef, 0x89, 0xd7,
-	0x7c, 0x7a, 0xc0, 0x9a, 0xc3, 0xa6, 0xbf, 0x6b, 0x50, 0x17, 0xdd, 0xc4, 0x05, 0xb4, 0xf3, 0x15,
-	0x10, 0x05, 0x3f, 0x56, 0xf2, 0xa0, 0x4a, 0x36, 0x9f, 0xef, 0x0d, 0xbd, 0xb0, 0x3d, 0x49, 0x9a,
-	0x2d, 0x71, 0x27, 0xe4, 0x87, 0x06, 0x71, 0x51, 0x14, 0x79, 0x0c, 0xec, 0x2d, 0x80, 0xb8, 0x4b,
-	0x14, 0x80, 0x43, 0xe2, 0x40, 0x24, 0x71, 0x0c, 0xe8, 0x3d, 0x74, 0xd4, 0xbb, 0xc2, 0x24, 0x14,
-	0xd1, 0x1e, 0x03, 0x9c, 0x41, 0x43, 0xee, 0x36, 0xd2, 0xc9, 0x15, 0xc5, 0x63, 0x8e, 0xca, 0x8d,
-	0x94, 0x24, 0x57, 0x5b, 0x21, 0x15, 0x65, 0xa2, 0x90, 0xf6, 0xd5, 0x50, 0xc1, 0x4f, 0xd0, 0x56,
-	0x36, 0x1b, 0x69, 0x1d, 0x65, 0x4a, 0x31, 0xad, 0xc3, 0x0f, 0x24, 0xf5, 0x23, 0xb4, 0xe8, 0x86,
-	0xe3, 0x33, 0xe2, 0x53, 0xa2, 0x17, 0xa5, 0x79, 0x65, 0xd2, 0xb0, 0x2b, 0xcb, 0x7a, 0xfa, 0xcd,
-	0x3d, 0xff, 0x13, 0x00, 0x00, 0xff, 0xff, 0x80, 0x79, 0x99, 0xc0, 0x84, 0x07, 0x00, 0x00,
+	// 984 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xb4, 0x57, 0x51, 0x53, 0xe4, 0x44,
+	0x10, 0x36, 0xc9, 0x12, 0xd8, 0x5e, 0x16, 0x96, 0xd9, 0x85, 0x8b, 0x11, 0x21, 0xe6, 0x69, 0x5f,
+	0xc4, 0x2a, 0xf4, 0x4a, 0xcf, 0xf2, 0x41, 0xe5, 0x50, 0xf0, 0x00, 0xbd, 0x9c, 0xbc, 0x9b, 0x25,
+	0x73, 0x7b, 0x29, 0xb3, 0x09, 0x66, 0xb2, 0x77, 0xb5, 0xbe, 0xfb, 0x6f, 0x7c, 0xf0, 0xff, 0xf8,
+	0x07, 0xfc, 0x19, 0x56, 0x32, 0x93, 0x49, 0x4f, 0x36, 0x81, 0x2d, 0xa8, 0x7b, 0x4b, 0xf7, 0x74,
+	0x7f, 0xfd, 0x75, 0xcf, 0x74, 0x77, 0x05, 0xfa, 0x37, 0xd1, 0x9c, 0x65, 0x34, 0x3d, 0xba, 0x4d,
+	0x93, 0x2c, 0x21, 0x5d, 0x21, 0xde, 0x4e, 0xdc, 0xbf, 0x75, 0x80, 0x4b, 0x3a, 0x9b, 0xd0, 0xf4,
+	0x3c, 0x7e, 0x9d, 0x90, 0x11, 0xac, 0x45, 0xfe, 0x84, 0x46, 0x96, 0xe6, 0x68, 0xe3, 0xae, 0xc7,
+	0x05, 0xe2, 0x40, 0x8f, 0xd1, 0xf4, 0x6d, 0x78, 0x43, 0xbf, 0x0b, 0x82, 0xd4, 0xd2, 0x8b, 0x33,
+	0xac, 0x22, 0x36, 0x6c, 0x08, 0x91, 0x59, 0x86, 0x63, 0x8c, 0xbb, 0x9e, 0x94, 0xc9, 0x01, 0x00,
+	0xa3, 0x69, 0xe8, 0x47, 0xe1, 0x9f, 0x34, 0xb5, 0x3a, 0x85, 0x33, 0xd2, 0xe4, 0xbe, 0xc9, 0x24,
+	0xb7, 0xa6, 0xa9, 0xb5, 0xe6, 0x68, 0xe3, 0x0d, 0x4f, 0xca, 0xe4, 0x19, 0x98, 0x05, 0x05, 0x66,
+	0x99, 0x8e, 0x31, 0xee, 0x1d, 0x7f, 0x72, 0x24, 0xa9, 0x1f, 0x55, 0xb4, 0x8f, 0x2e, 0x0a, 0x9b,
+	0xd3, 0x38, 0x4b, 0x17, 0x9e, 0x70, 0x20, 0x16, 0xac, 0x4f, 0xfd, 0x8c, 0xbe, 0xf3, 0x17, 0xd6,
+	0x7a, 0x81, 0x5a, 0x8a, 0xf6, 0x33, 0xe8, 0x21, 0x07, 0x32, 0x00, 0xe3, 0x77, 0xba, 0x10, 0x19,
+	0xe7, 0x9f, 0x79, 0x15, 0xde, 0xfa, 0xd1, 0x9c, 0x8a, 0x4c, 0xb9, 0xf0, 0xb5, 0xfe, 0x95, 0xe6,
+	0x9e, 0xc1, 0xb6, 0x47, 0xa7, 0x61, 0xce, 0xc0, 0xa3, 0x7f, 0xcc, 0x29, 0xcb, 0xc8, 0x53, 0x80,
+	0x99, 0x64, 0x52, 0xa0, 0xf4, 0x8e, 0x77, 0x1b, 0x69, 0x7a, 0xc8, 0xd0, 0x3d, 0x81, 0x41, 0x85,
+	0xc4, 0x6e, 0x93, 0x98, 0x51, 0xf2, 0x19, 0xac, 0x73, 0x0b, 0x66, 0x69, 0x8e, 0xd1, 0x8e, 0x53,
+	0x5a, 0xb9, 0x4f, 0x61, 0xe7, 0x3a, 0x4e, 0x6b, 0x84, 0x6a, 0xb7, 0xa5, 0x2d, 0xdd, 0x96, 0x3b,
+	0x02, 0x82, 0xdd, 0x78, 0xf4, 0x5c, 0xfb, 0x6a, 0x11, 0xdf, 0xf0, 0x38, 0x4c, 0xa0, 0xb9, 0x3f,
+	0xc0, 0x50, 0xd1, 0x3e, 0x94, 0xea, 0x6f, 0x40, 0xbe, 0x0f, 0xe3, 0xe0, 0x15, 0x65, 0x2c, 0x4c,
+	0xe2, 0x92, 0xeb, 0x00, 0x8c, 0x79, 0x18, 0x14, 0x1c, 0x0d, 0x2f, 0xff, 0xcc, 0x5f, 0x43, 0x7e,
+	0x4f, 0xe8, 0xa1, 0x49, 0x99, 0xec, 0x43, 0x97, 0x71, 0xff, 0xf3, 0xc0, 0x32, 0x0a, 0x9f, 0x4a,
+	0xe1, 0xee, 0xc2, 0x50, 0x89, 0x20, 0xd2, 0x1a, 0xc3, 0xe8, 0x22, 0xb9, 0xf1, 0x33, 0x7a, 0x5f,
+	0x68, 0xf7, 0x25, 0xec, 0xd6, 0x2c, 0x45, 0xb2, 0x98, 0x93, 0x76, 0x17, 0x27, 0xbd, 0xce, 0x69,
+	0x02, 0xa3, 0xeb, 0x78, 0xf2, 0x7e, 0xf3, 0x7e, 0x02, 0xbb, 0xb5, 0x18, 0x22, 0xf3, 0x7f, 0x34,
+	0xd8, 0x12, 0x01, 0x2f, 0x29, 0x63, 0xfe, 0xf4, 0x11, 0x99, 0x90, 0x2d, 0xd0, 0x43, 0x1e, 0xbc,
+	0xe3, 0xe9, 0x61, 0x90, 0xf7, 0x48, 0x9a, 0xcc, 0x33, 0x2a, 0x1a, 0x9a, 0x0b, 0x84, 0x40, 0x27,
+	0xf0, 0x33, 0xbf, 0xe8, 0xe3, 0x4d, 0xaf, 0xf8, 0x2e, 0x73, 0x35, 0xab, 0x5c, 0x2d, 0x58, 0xcf,
+	0xc2, 0x19, 0x4d, 0xe6, 0x59, 0xd1, 0x9a, 0x86, 0x57, 0x8a, 0xee, 0x5f, 0x1a, 0xf4, 0xaf, 0x92,
+	0x2c, 0x7c, 0xbd, 0x78, 0x3c, 0x63, 0xc9, 0xd0, 0x68, 0x62, 0xd8, 0x59, 0x66, 0xb8, 0x56, 0x3d,
+	0x85, 0x29, 0x6c, 0x97, 0x65, 0x2c, 0x89, 0x28, 0xc1, 0xb4, 0xe6, 0xf2, 0xe8, 0xb2, 0x3c, 0x65,
+	0x18, 0x03, 0x85, 0x21, 0xd0, 0x99, 0x25, 0x29, 0xaf, 0xd8, 0x86, 0x57, 0x7c, 0xbb, 0xd7, 0xd0,
+	0xfb, 0x65, 0xce, 0xde, 0xac, 0x16, 0x44, 0x66, 0xa4, 0x37, 0x65, 0x84, 0x42, 0xb9, 0x7b, 0x30,
+	0xe2, 0x4d, 0x78, 0xe6, 0xc7, 0x41, 0x44, 0xe5, 0x93, 0x38, 0x87, 0xc1, 0x15, 0x7d, 0xc7, 0x8f,
+	0x1e, 0x39, 0xc0, 0x86, 0xb0, 0x83, 0xa0, 0x04, 0xfe, 0x05, 0x52, 0x96, 0x23, 0x84, 0x7c, 0x09,
+	0xbd, 0xca, 0xef, 0x9e, 0x79, 0x81, 0x2d, 0xdd, 0x2f, 0x60, 0xf0, 0x9c, 0x46, 0x2a, 0xdb, 0xfb,
+	0xa7, 0xdb, 0x10, 0x76, 0x90, 0x97, 0x24, 0x36, 0x12, 0xed, 0x71, 0x12, 0x25, 0x8c, 0x06, 0x25,
+	0xdc, 0xdd, 0x05, 0xdf, 0x03, 0x33, 0xa5, 0x3e, 0x4b, 0x62, 0x51, 0x71, 0x21, 0xe5, 0x2d, 0x57,
+	0x43, 0x13, 0x61, 0x5e, 0xc0, 0xb0, 0xd0, 0xd4, 0xda, 0xfd, 0x61, 0x51, 0xf6, 0x60, 0xa4, 0x82,
+	0x89, 0x20, 0xa7, 0x30, 0xba, 0xf4, 0xf3, 0xd2, 0x9d, 0xbc, 0xf1, 0xe3, 0x69, 0x95, 0xcb, 0xa7,
+	0x60, 0xce, 0x0a, 0xfd, 0xdd, 0x97, 0x28, 0x8c, 0xf2, 0x24, 0x6a, 0x30, 0x02, 0xff, 0x5b, 0xd8,
+	0x7c, 0x9e, 0xfa, 0xa1, 0x64, 0x5f, 0xf1, 0xd3, 0x30, 0x3f, 0xdc, 0xc6, 0xba, 0xda, 0xc6, 0xdb,
+	0xd0, 0x17, 0x08, 0x1c, 0xf2, 0xf8, 0x3f, 0x03, 0x4c, 0x1e, 0x8c, 0x9c, 0xc2, 0x46, 0xb9, 0xf8,
+	0x88, 0x8d, 0x18, 0xd6, 0xf6, 0xaa, 0xfd, 0x51, 0xe3, 0x99, 0xa0, 0xf8, 0x01, 0x79, 0x01, 0x50,
+	0xed, 0x30, 0xb2, 0x8f, 0x8c, 0x97, 0x36, 0xa2, 0xfd, 0x71, 0xcb, 0xa9, 0x04, 0xbb, 0x82, 0x1e,
+	0x5a, 0x72, 0x04, 0xdb, 0x2f, 0xaf, 0x44, 0xfb, 0xa0, 0xed, 0x18, 0xe3, 0xa1, 0x55, 0xa4, 0xe0,
+	0x2d, 0x2f, 0x41, 0xfb, 0xa0, 0xed, 0x58, 0xe2, 0xfd, 0x0a, 0x7d, 0x65, 0x33, 0x91, 0x43, 0xe4,
+	0xd2, 0xb4, 0xdd, 0x6c, 0xa7, 0xdd, 0x00, 0xa3, 0x2a, 0x8b, 0x43, 0x41, 0x6d, 0x5a, 0x5b, 0xb6,
+	0xd3, 0x6e, 0x50, 0xa2, 0x1e, 0xff, 0x6b, 0x82, 0xc9, 0x2b, 0x42, 0x2e, 0xa1, 0x5f, 0xce, 0x1f,
+	0xfe, 0x92, 0x3e, 0x54, 0xee, 0x14, 0x6f, 0x26, 0xfb, 0x70, 0xe9, 0xb1, 0xd6, 0x46, 0x57, 0x7e,
+	0xe5, 0x9b, 0x5c, 0xc7, 0x37, 0x04, 0xb1, 0x90, 0x8b, 0xb2, 0x34, 0x56, 0x01, 0xfb, 0x11, 0x80,
+	0xeb, 0xf2, 0xf1, 0x4b, 0xf6, 0x90, 0x03, 0x9a, 0xc7, 0xab, 0x00, 0xfd, 0x0c, 0x5b, 0xaa, 0xae,
+	0xf6, 0xaa, 0x95, 0x2d, 0xb2, 0x0a, 0xe0, 0x19, 0x74, 0xe5, 0x0c, 0x25, 0xb8, 0x0b, 0xea, 0x93,
+	0xdb, 0xde, 0x6f, 0x3e, 0x94, 0x48, 0x3f, 0x01, 0x48, 0x35, 0x23, 0x8d, 0xd6, 0x6c, 0x55, 0xac,
+	0x33, 0xe8, 0xca, 0xa9, 0xaa, 0xb0, 0xaa, 0x4f, 0x68, 0x7b, 0xbf, 0xf9, 0x10, 0x3f, 0x3b, 0x65,
+	0x78, 0x2a, 0xcf, 0xae, 0x69, 0x48, 0xdb, 0x4e, 0xbb, 0x81, 0x44, 0x7d, 0x09, 0x9b, 0x78, 0x58,
+	0x12, 0xdc, 0x54, 0x0d, 0x23, 0xd9, 0x3e, 0x6c, 0x3d, 0xc7, 0x44, 0x95, 0x01, 0xa9, 0x10, 0x6d,
+	0x9a, 0xc0, 0xb6, 0xd3, 0x6e, 0x20, 0x51, 0xbf, 0x81, 0xb5, 0x62, 0x36, 0x92, 0x27, 0xb8, 0x4e,
+	0x68, 0xde, 0xda, 0xd6, 0xf2, 0x41, 0xe9, 0x3d, 0x31, 0x8b, 0x3f, 0xb8, 0xcf, 0xff, 0x1f, 0x00,
+	0xc2, 0xd0, 0x0e, 0xe9, 0xd2, 0x0d, 0x00, 0x00,
 }
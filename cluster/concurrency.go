@@ -0,0 +1,97 @@
+package cluster
+
+import (
+	"sync"
+
+	"github.com/lonng/nano/metrics"
+	"github.com/lonng/nano/scheduler"
+)
+
+// Metrics of the routes limited by concurrency, labeled by route and the service address
+// of current node, nano_route_rejected_total counts the requests rejected by a full queue
+const (
+	metricRouteRunning  = "nano_route_running_handlers"
+	metricRouteQueued   = "nano_route_queued_handlers"
+	metricRouteRejected = "nano_route_rejected_total"
+)
+
+type (
+	// routeLimiter limits the handlers of a route running concurrently across all the
+	// sessions, the handlers beyond the limit wait in a bounded queue and are scheduled
+	// when the running ones completed, so the scheduler is never blocked by the limit
+	routeLimiter struct {
+		mu      sync.Mutex
+		limit   int
+		backlog int
+		running int
+		queued  []queuedHandler
+
+		runningGauge *metrics.Gauge
+		queuedGauge  *metrics.Gauge
+		rejected     *metrics.Counter
+	}
+
+	queuedHandler struct {
+		schedule func(scheduler.Task)
+		task     scheduler.Task
+	}
+)
+
+func newRouteLimiter(limit, backlog int, route, member string) *routeLimiter {
+	return &routeLimiter{
+		limit:        limit,
+		backlog:      backlog,
+		runningGauge: metrics.Default.Gauge(metricRouteRunning, "route", route, "member", member),
+		queuedGauge:  metrics.Default.Gauge(metricRouteQueued, "route", route, "member", member),
+		rejected:     metrics.Default.Counter(metricRouteRejected, "route", route, "member", member),
+	}
+}
+
+// schedule schedules the task if the running handlers are below the limit, otherwise
+// queues it, false will be returned if the queue is full and the task is dropped
+func (l *routeLimiter) schedule(schedule func(scheduler.Task), task scheduler.Task) bool {
+	l.mu.Lock()
+	if l.running >= l.limit {
+		if len(l.queued) >= l.backlog {
+			l.mu.Unlock()
+			l.rejected.Inc()
+			return false
+		}
+		l.queued = append(l.queued, queuedHandler{schedule: schedule, task: task})
+		l.queuedGauge.Set(int64(len(l.queued)))
+		l.mu.Unlock()
+		return true
+	}
+	l.running++
+	l.runningGauge.Set(int64(l.running))
+	l.mu.Unlock()
+
+	schedule(l.wrap(task))
+	return true
+}
+
+// wrap releases the slot of the task after it completed, even if it panicked
+func (l *routeLimiter) wrap(task scheduler.Task) scheduler.Task {
+	return func() {
+		defer l.release()
+		task()
+	}
+}
+
+// release passes the slot of a completed task to the first queued one, which is
+// scheduled asynchronously as the scheduler may be running the completed task
+func (l *routeLimiter) release() {
+	l.mu.Lock()
+	if len(l.queued) == 0 {
+		l.running--
+		l.runningGauge.Set(int64(l.running))
+		l.mu.Unlock()
+		return
+	}
+	next := l.queued[0]
+	l.queued = l.queued[1:]
+	l.queuedGauge.Set(int64(len(l.queued)))
+	l.mu.Unlock()
+
+	go next.schedule(l.wrap(next.task))
+}
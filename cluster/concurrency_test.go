@@ -0,0 +1,63 @@
+package cluster
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lonng/nano/scheduler"
+)
+
+func TestRouteLimiter(t *testing.T) {
+	l := newRouteLimiter(1, 1, "Match.Join", "127.0.0.1:4810")
+	schedule := func(task scheduler.Task) { go task() }
+
+	release := make(chan struct{})
+	started := make(chan int, 3)
+	task := func(i int) scheduler.Task {
+		return func() {
+			started <- i
+			<-release
+		}
+	}
+
+	if !l.schedule(schedule, task(1)) {
+		t.Fatal("first handler should be scheduled")
+	}
+	if !l.schedule(schedule, task(2)) {
+		t.Fatal("second handler should be queued")
+	}
+	if l.schedule(schedule, task(3)) {
+		t.Fatal("third handler should be rejected by the full queue")
+	}
+	if got := <-started; got != 1 {
+		t.Fatalf("handler 1 should run first, got %d", got)
+	}
+	select {
+	case i := <-started:
+		t.Fatalf("handler %d should wait for the limit", i)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if l.runningGauge.Value() != 1 || l.queuedGauge.Value() != 1 || l.rejected.Value() != 1 {
+		t.Fatalf("unexpected metrics, running=%d, queued=%d, rejected=%d",
+			l.runningGauge.Value(), l.queuedGauge.Value(), l.rejected.Value())
+	}
+
+	release <- struct{}{}
+	select {
+	case got := <-started:
+		if got != 2 {
+			t.Fatalf("queued handler 2 should run, got %d", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("queued handler should run after the slot released")
+	}
+	release <- struct{}{}
+
+	deadline := time.Now().Add(time.Second)
+	for l.runningGauge.Value() != 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if l.runningGauge.Value() != 0 || l.queuedGauge.Value() != 0 {
+		t.Fatalf("slots should be released, running=%d, queued=%d", l.runningGauge.Value(), l.queuedGauge.Value())
+	}
+}
@@ -23,43 +23,99 @@ package cluster
 import (
 	"context"
 	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/lonng/nano/internal/env"
+	"github.com/lonng/nano/metrics"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
 )
 
+// Metrics of dialing the members, labeled by the target address
+const (
+	metricDialAttempts  = "nano_rpc_dial_total"
+	metricDialSuccesses = "nano_rpc_dial_success_total"
+	metricMemberReady   = "nano_member_ready" // 1 if the connection to member is ready, otherwise 0
+)
+
+// Default backoff of re-dialing a member after the dial failed, the delay is doubled
+// for each consecutive failure and randomized by the jitter fraction
+const (
+	defaultDialBackoffBase = 100 * time.Millisecond
+	defaultDialBackoffMax  = 10 * time.Second
+	dialBackoffJitter      = 0.2
+)
+
+// poolCloseGrace is the delay of closing a pool removed from rpcClient, the calls which
+// have retrieved a connection from it are not canceled by the close
+const poolCloseGrace = 10 * time.Second
+
 type connPool struct {
-	index uint32
-	v     []*grpc.ClientConn
+	index    uint32
+	v        []*grpc.ClientConn
+	lastUsed int64 // unix nano of the last Get
+}
+
+// dialBackoff records the consecutive dial failures of a target
+type dialBackoff struct {
+	failures int
+	retryAt  time.Time
 }
 
 type rpcClient struct {
 	sync.RWMutex
 	isClosed bool
 	pools    map[string]*connPool
+
+	maxTargets  int           // max cached targets, zero means unlimited
+	idleTimeout time.Duration // pools idle longer than it will be closed, zero disables
+
+	// reports whether the target is never evicted as the least recently used one, e.g:
+	// the master, nil if no target is pinned
+	pinned func(addr string) bool
+
+	backoffBase time.Duration // zero means defaultDialBackoffBase
+	backoffMax  time.Duration // zero means defaultDialBackoffMax
+	muBackoff   sync.Mutex
+	backoffs    map[string]*dialBackoff // targets failed to dial
+
+	// scheme of the gRPC resolver which resolves the addresses of targets, empty if the
+	// addresses are passed to the dialer as is
+	resolver string
+
+	// dials the connections to the targets, nil if the standard dialer is used
+	dialer func(ctx context.Context, addr string) (net.Conn, error)
+
+	// called when the state of connection to a target changed, nil if not watched
+	onStateChange func(addr string, from, to connectivity.State)
 }
 
-func newConnArray(maxSize uint, addr string) (*connPool, error) {
+func newConnArray(maxSize uint, addr string, opts ...grpc.DialOption) (*connPool, error) {
 	a := &connPool{
-		index: 0,
-		v:     make([]*grpc.ClientConn, maxSize),
+		index:    0,
+		v:        make([]*grpc.ClientConn, maxSize),
+		lastUsed: env.Clock.Now().UnixNano(),
 	}
-	if err := a.init(addr); err != nil {
+	if err := a.init(addr, opts...); err != nil {
 		return nil, err
 	}
 	return a, nil
 }
 
-func (a *connPool) init(addr string) error {
+func (a *connPool) init(addr string, opts ...grpc.DialOption) error {
+	opts = append(append([]grpc.DialOption{}, env.GrpcOptions...), opts...)
 	for i := range a.v {
 		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
 		conn, err := grpc.DialContext(
 			ctx,
 			addr,
-			env.GrpcOptions...,
+			opts...,
 		)
 		cancel()
 		if err != nil {
@@ -74,6 +130,7 @@ func (a *connPool) init(addr string) error {
 }
 
 func (a *connPool) Get() *grpc.ClientConn {
+	atomic.StoreInt64(&a.lastUsed, env.Clock.Now().UnixNano())
 	next := atomic.AddUint32(&a.index, 1) % uint32(len(a.v))
 	return a.v[next]
 }
@@ -90,9 +147,17 @@ func (a *connPool) Close() {
 	}
 }
 
-func newRPCClient() *rpcClient {
+// closeLater closes the pool after poolCloseGrace
+func (a *connPool) closeLater() {
+	env.Clock.AfterFunc(poolCloseGrace, a.Close)
+}
+
+func newRPCClient(maxTargets int, idleTimeout time.Duration) *rpcClient {
 	return &rpcClient{
-		pools: make(map[string]*connPool),
+		pools:       make(map[string]*connPool),
+		maxTargets:  maxTargets,
+		idleTimeout: idleTimeout,
+		backoffs:    make(map[string]*dialBackoff),
 	}
 }
 
@@ -115,21 +180,193 @@ func (c *rpcClient) getConnPool(addr string) (*connPool, error) {
 }
 
 func (c *rpcClient) createConnPool(addr string) (*connPool, error) {
+	// the target failed recently, do not hammer it before the backoff elapsed
+	now := env.Clock.Now()
+	if wait := c.backoffWait(addr, now); wait > 0 {
+		return nil, fmt.Errorf("%v: %s, retry after %s", ErrDialBackoff, addr, wait)
+	}
+
+	var opts []grpc.DialOption
+	if c.backoffMax > 0 {
+		opts = append(opts, grpc.WithBackoffMaxDelay(c.backoffMax))
+	}
+	if c.dialer != nil {
+		opts = append(opts, grpc.WithContextDialer(c.dialer))
+	}
+
+	// the lock is not held across the dial, which may block until the dial timeout
+	metrics.Default.Counter(metricDialAttempts, "target", addr).Inc()
+	// TODO: make conn count configurable
+	array, err := newConnArray(10, c.target(addr), opts...)
+	if err != nil {
+		c.dialFailed(addr, now)
+		return nil, err
+	}
+
+	conn := array.v[0] // the pool may be closed as soon as it is published
+
 	c.Lock()
-	defer c.Unlock()
-	array, ok := c.pools[addr]
-	if !ok {
-		var err error
-		// TODO: make conn count configurable
-		array, err = newConnArray(10, addr)
-		if err != nil {
-			return nil, err
-		}
-		c.pools[addr] = array
+	if c.isClosed {
+		c.Unlock()
+		array.Close()
+		return nil, errors.New("rpc client is closed")
+	}
+	if exist, ok := c.pools[addr]; ok {
+		// dialed by another goroutine meanwhile
+		c.Unlock()
+		array.Close()
+		return exist, nil
 	}
+	if c.maxTargets > 0 && len(c.pools) >= c.maxTargets {
+		c.evictLeastRecentUsed()
+	}
+	c.pools[addr] = array
+	c.Unlock()
+
+	go c.watchState(addr, array, conn)
 	return array, nil
 }
 
+// target returns the dial target of the member address, the address is resolved by the
+// resolver of rpcClient unless it specifies a scheme itself, e.g: dns:///member:4000
+func (c *rpcClient) target(addr string) string {
+	if c.resolver == "" || strings.Contains(addr, "://") {
+		return addr
+	}
+	return c.resolver + ":///" + addr
+}
+
+// watchState watches the state changes of the connection to the target until it is
+// closed, the first connection of a pool represents the state of the target because
+// all connections of the pool dial the same address. The dial is non-blocking by
+// default, so the dial failures are recorded here: the pool of a failed target is
+// dropped, and the target is re-dialed by createConnPool after the backoff elapsed
+func (c *rpcClient) watchState(addr string, pool *connPool, conn *grpc.ClientConn) {
+	ready := metrics.Default.Gauge(metricMemberReady, "target", addr)
+	state := conn.GetState()
+	for {
+		switch state {
+		case connectivity.Ready:
+			ready.Set(1)
+			metrics.Default.Counter(metricDialSuccesses, "target", addr).Inc()
+			c.dialSucceeded(addr)
+		case connectivity.TransientFailure:
+			ready.Set(0)
+			c.dialFailed(addr, env.Clock.Now())
+			c.dropPool(addr, pool)
+			// the reconnections of the dropped pool are not recorded
+			return
+		default:
+			ready.Set(0)
+		}
+		if state == connectivity.Shutdown || !conn.WaitForStateChange(context.Background(), state) {
+			return
+		}
+		from := state
+		state = conn.GetState()
+		if c.onStateChange != nil {
+			c.onStateChange(addr, from, state)
+		}
+	}
+}
+
+// backoffWait returns the remaining duration before the target can be dialed again
+func (c *rpcClient) backoffWait(addr string, now time.Time) time.Duration {
+	c.muBackoff.Lock()
+	defer c.muBackoff.Unlock()
+	b, found := c.backoffs[addr]
+	if !found {
+		return 0
+	}
+	return b.retryAt.Sub(now)
+}
+
+func (c *rpcClient) dialFailed(addr string, now time.Time) {
+	c.muBackoff.Lock()
+	defer c.muBackoff.Unlock()
+	b, found := c.backoffs[addr]
+	if !found {
+		b = &dialBackoff{}
+		c.backoffs[addr] = b
+	}
+	b.failures++
+	b.retryAt = now.Add(c.backoffDelay(b.failures))
+}
+
+func (c *rpcClient) dialSucceeded(addr string) {
+	c.muBackoff.Lock()
+	delete(c.backoffs, addr)
+	c.muBackoff.Unlock()
+}
+
+// backoffDelay returns the jittered exponential delay after the consecutive failures
+func (c *rpcClient) backoffDelay(failures int) time.Duration {
+	base, max := c.backoffBase, c.backoffMax
+	if base <= 0 {
+		base = defaultDialBackoffBase
+	}
+	if max <= 0 {
+		max = defaultDialBackoffMax
+	}
+	delay := base
+	for i := 1; i < failures && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max {
+		delay = max
+	}
+	jitter := 1 + dialBackoffJitter*(rand.Float64()*2-1)
+	return time.Duration(float64(delay) * jitter)
+}
+
+// dropPool removes the pool of target unless it has been replaced, and closes it after
+// the calls in flight finished
+func (c *rpcClient) dropPool(addr string, pool *connPool) {
+	c.Lock()
+	dropped := c.pools[addr] == pool
+	if dropped {
+		delete(c.pools, addr)
+	}
+	c.Unlock()
+	if dropped {
+		pool.closeLater()
+	}
+}
+
+// evictLeastRecentUsed closes the least recently used pool except the pinned ones, the
+// caller must hold the lock. The pool is closed after the calls in flight finished
+func (c *rpcClient) evictLeastRecentUsed() {
+	var (
+		target string
+		oldest int64
+	)
+	for addr, array := range c.pools {
+		if c.pinned != nil && c.pinned(addr) {
+			continue
+		}
+		if used := atomic.LoadInt64(&array.lastUsed); target == "" || used < oldest {
+			target, oldest = addr, used
+		}
+	}
+	if target != "" {
+		c.pools[target].closeLater()
+		delete(c.pools, target)
+	}
+}
+
+// evictIdle closes the pools which have not been used since the idle timeout
+func (c *rpcClient) evictIdle(now time.Time) {
+	deadline := now.Add(-c.idleTimeout).UnixNano()
+	c.Lock()
+	for addr, array := range c.pools {
+		if atomic.LoadInt64(&array.lastUsed) < deadline {
+			array.Close()
+			delete(c.pools, addr)
+		}
+	}
+	c.Unlock()
+}
+
 func (c *rpcClient) closePool() {
 	c.Lock()
 	if !c.isClosed {
@@ -0,0 +1,317 @@
+package cluster
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lonng/nano/cluster/clusterpb"
+	"github.com/lonng/nano/internal/clock"
+	"github.com/lonng/nano/internal/env"
+	"github.com/lonng/nano/metrics"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/resolver/manual"
+	"google.golang.org/grpc/status"
+)
+
+func startGRPCServer(t *testing.T) (string, func()) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := grpc.NewServer()
+	go server.Serve(listener)
+	return listener.Addr().String(), server.Stop
+}
+
+func assertClosed(t *testing.T, pool *connPool, closed bool) {
+	for i, conn := range pool.v {
+		if closed && conn != nil {
+			t.Fatalf("connection %d is not closed", i)
+		}
+		if !closed && (conn == nil || conn.GetState() == connectivity.Shutdown) {
+			t.Fatalf("connection %d is closed", i)
+		}
+	}
+}
+
+func TestRPCClientEvictLeastRecentUsed(t *testing.T) {
+	defer func(c clock.Clock) { env.Clock = c }(env.Clock)
+	manual := clock.NewManual(time.Unix(1000, 0))
+	env.Clock = manual
+
+	var pinned string
+	client := newRPCClient(2, 0)
+	client.pinned = func(addr string) bool { return addr == pinned }
+	var pools []*connPool
+	for i := 0; i < 3; i++ {
+		addr, stop := startGRPCServer(t)
+		defer stop()
+		if i == 0 {
+			// the pinned target is never evicted though it is the least recently used
+			pinned = addr
+		}
+		pool, err := client.getConnPool(addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pools = append(pools, pool)
+		manual.Advance(time.Millisecond)
+	}
+	if len(client.pools) != 2 {
+		t.Fatalf("expect 2 cached targets, got %d", len(client.pools))
+	}
+	if _, found := client.pools[pinned]; !found {
+		t.Fatal("pinned target is evicted")
+	}
+
+	// the evicted pool is closed after the calls in flight finished
+	assertClosed(t, pools[1], false)
+	for manual.Waiters() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	manual.Advance(poolCloseGrace)
+	for deadline := time.Now().Add(time.Second); pools[1].v[0] != nil; {
+		if time.Now().After(deadline) {
+			t.Fatal("evicted pool is not closed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	assertClosed(t, pools[0], false)
+	assertClosed(t, pools[2], false)
+}
+
+func TestRPCClientEvictIdle(t *testing.T) {
+	client := newRPCClient(0, time.Minute)
+	addr, stop := startGRPCServer(t)
+	defer stop()
+	pool, err := client.getConnPool(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := pool.Get()
+
+	client.evictIdle(time.Now())
+	assertClosed(t, pool, false)
+
+	client.evictIdle(time.Now().Add(2 * time.Minute))
+	assertClosed(t, pool, true)
+	if state := conn.GetState(); state != connectivity.Shutdown {
+		t.Fatalf("expect idle connection closed, got %s", state)
+	}
+	if len(client.pools) != 0 {
+		t.Fatalf("expect no cached targets, got %d", len(client.pools))
+	}
+}
+
+func TestRPCClientBackoffDelay(t *testing.T) {
+	client := newRPCClient(0, 0)
+	client.backoffBase = 100 * time.Millisecond
+	client.backoffMax = time.Second
+	for i, expect := range []time.Duration{100, 200, 400, 800, 1000, 1000} {
+		failures := i + 1
+		expect *= time.Millisecond
+		delay := client.backoffDelay(failures)
+		if delay < expect*8/10 || delay > expect*12/10 {
+			t.Fatalf("backoff delay of %d failures %s, expect %s with jitter", failures, delay, expect)
+		}
+	}
+}
+
+func TestRPCClientDialBackoff(t *testing.T) {
+	// reserve an address which refuses the connections
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+
+	client := newRPCClient(0, 0)
+	client.backoffBase = 100 * time.Millisecond
+	defer client.closePool()
+	attempts := metrics.Default.Counter(metricDialAttempts, "target", addr)
+	successes := metrics.Default.Counter(metricDialSuccesses, "target", addr)
+
+	// the dial is non-blocking, the failure is recorded once the connection failed
+	if _, err := client.getConnPool(addr); err != nil {
+		t.Fatal(err)
+	}
+	for deadline := time.Now().Add(3 * time.Second); client.backoffWait(addr, time.Now()) <= 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("dial failure is not recorded")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	// the target is not dialed again before the backoff elapsed
+	if _, err := client.getConnPool(addr); err == nil || !strings.Contains(err.Error(), ErrDialBackoff.Error()) {
+		t.Fatalf("expect backoff error, got %v", err)
+	}
+	if attempts.Value() != 1 {
+		t.Fatalf("dial attempts %d, expect 1", attempts.Value())
+	}
+
+	listener, err = net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := grpc.NewServer()
+	go server.Serve(listener)
+	defer server.Stop()
+
+	time.Sleep(150 * time.Millisecond)
+	if _, err := client.getConnPool(addr); err != nil {
+		t.Fatal(err)
+	}
+	for deadline := time.Now().Add(3 * time.Second); successes.Value() != 1; {
+		if time.Now().After(deadline) {
+			t.Fatal("dial success is not recorded")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if attempts.Value() != 2 {
+		t.Fatalf("dial attempts %d, expect 2", attempts.Value())
+	}
+	if wait := client.backoffWait(addr, time.Now()); wait > 0 {
+		t.Fatalf("backoff is not reset after dial succeeded: %s", wait)
+	}
+}
+
+func TestRPCClientDialBlocking(t *testing.T) {
+	opts := env.GrpcOptions
+	env.GrpcOptions = append([]grpc.DialOption{grpc.WithBlock(), grpc.FailOnNonTempDialError(true)}, opts...)
+	defer func() { env.GrpcOptions = opts }()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+
+	client := newRPCClient(0, 0)
+	defer client.closePool()
+	if _, err := client.getConnPool(addr); err == nil || strings.Contains(err.Error(), ErrDialBackoff.Error()) {
+		t.Fatalf("expect dial error, got %v", err)
+	}
+	if _, err := client.getConnPool(addr); err == nil || !strings.Contains(err.Error(), ErrDialBackoff.Error()) {
+		t.Fatalf("expect backoff error, got %v", err)
+	}
+}
+
+func TestRPCClientWatchState(t *testing.T) {
+	type change struct{ from, to connectivity.State }
+	changes := make(chan change, 16)
+	client := newRPCClient(0, 0)
+	client.onStateChange = func(addr string, from, to connectivity.State) {
+		changes <- change{from, to}
+	}
+	defer client.closePool()
+
+	addr, stop := startGRPCServer(t)
+	if _, err := client.getConnPool(addr); err != nil {
+		t.Fatal(err)
+	}
+
+	wait := func(expect func(c change) bool) {
+		for {
+			select {
+			case c := <-changes:
+				if expect(c) {
+					return
+				}
+			case <-time.After(3 * time.Second):
+				t.Fatal("state change not observed")
+			}
+		}
+	}
+	ready := metrics.Default.Gauge(metricMemberReady, "target", addr)
+	wait(func(c change) bool { return c.to == connectivity.Ready })
+	if ready.Value() != 1 {
+		t.Fatalf("member ready %d, expect 1", ready.Value())
+	}
+
+	stop()
+	wait(func(c change) bool { return c.from == connectivity.Ready })
+	time.Sleep(10 * time.Millisecond)
+	if ready.Value() != 0 {
+		t.Fatalf("member ready %d, expect 0", ready.Value())
+	}
+}
+
+func TestRPCClientDialer(t *testing.T) {
+	addr, stop := startGRPCServer(t)
+	defer stop()
+
+	// the member address is resolved by the custom dialer only
+	dialed := make(chan string, 8)
+	client := newRPCClient(0, 0)
+	client.dialer = func(ctx context.Context, target string) (net.Conn, error) {
+		dialed <- target
+		return (&net.Dialer{}).DialContext(ctx, "tcp", addr)
+	}
+	defer client.closePool()
+
+	pool, err := client.getConnPool("member.mesh:4000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	_, err = clusterpb.NewMemberClient(pool.Get()).DelMember(ctx, &clusterpb.DelMemberRequest{})
+	if status.Code(err) != codes.Unimplemented {
+		t.Fatalf("expect the server reached through the dialer, got %v", err)
+	}
+	if target := <-dialed; target != "member.mesh:4000" {
+		t.Fatalf("expect dial member.mesh:4000, got %s", target)
+	}
+}
+
+func TestRPCClientResolver(t *testing.T) {
+	addr, stop := startGRPCServer(t)
+	defer stop()
+
+	// the address refusing the connections stands for the unreachable stack
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	unreachable := listener.Addr().String()
+	listener.Close()
+
+	r, cleanup := manual.GenerateAndRegisterManualResolver()
+	defer cleanup()
+	r.InitialState(resolver.State{Addresses: []resolver.Address{{Addr: unreachable}, {Addr: addr}}})
+
+	_, port, _ := net.SplitHostPort(addr)
+	for _, c := range []struct {
+		scheme string
+		addr   string
+	}{
+		{r.Scheme(), "member.dual:4000"}, // fails over to the reachable address
+		{"dns", net.JoinHostPort("localhost", port)},
+	} {
+		client := newRPCClient(0, 0)
+		client.resolver = c.scheme
+		if target := client.target("dns:///" + c.addr); target != "dns:///"+c.addr {
+			t.Fatalf("expect the target with scheme unchanged, got %s", target)
+		}
+
+		pool, err := client.getConnPool(c.addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		_, err = clusterpb.NewMemberClient(pool.Get()).DelMember(ctx, &clusterpb.DelMemberRequest{}, grpc.WaitForReady(true))
+		cancel()
+		client.closePool()
+		if status.Code(err) != codes.Unimplemented {
+			t.Fatalf("expect %s resolved by %s, got %v", c.addr, c.scheme, err)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package cluster
+
+import (
+	"sync"
+	"time"
+
+	"github.com/lonng/nano/internal/env"
+)
+
+type (
+	dedupEntry struct {
+		mid      uint64
+		at       time.Time       // time of the first request
+		response *pendingMessage // nil if the request is being processed
+	}
+
+	// dedupCache records the recent requests of a session by message id, the
+	// client resends a request with the same id will receive the cached response
+	// instead of processing the request again
+	dedupCache struct {
+		mu      sync.Mutex
+		window  time.Duration
+		entries map[uint64]*dedupEntry
+		queue   []*dedupEntry // entries in the order of time, the expired ones are at the head
+	}
+)
+
+func newDedupCache(window time.Duration) *dedupCache {
+	return &dedupCache{
+		window:  window,
+		entries: map[uint64]*dedupEntry{},
+	}
+}
+
+// begin records the request, it returns false if the request is a duplicate, and
+// the cached response will be returned if the previous one has been responded
+func (d *dedupCache) begin(mid uint64) (*pendingMessage, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := env.Clock.Now()
+	for len(d.queue) > 0 && now.Sub(d.queue[0].at) > d.window {
+		e := d.queue[0]
+		d.queue[0] = nil
+		d.queue = d.queue[1:]
+		if d.entries[e.mid] == e {
+			delete(d.entries, e.mid)
+		}
+	}
+
+	if e, found := d.entries[mid]; found {
+		return e.response, false
+	}
+	e := &dedupEntry{mid: mid, at: now}
+	d.entries[mid] = e
+	d.queue = append(d.queue, e)
+	return nil, true
+}
+
+// finish caches the response of the request
+func (d *dedupCache) finish(m pendingMessage) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if e, found := d.entries[m.mid]; found && e.response == nil {
+		e.response = &m
+	}
+}
@@ -0,0 +1,42 @@
+package cluster
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lonng/nano/internal/clock"
+	"github.com/lonng/nano/internal/env"
+)
+
+func TestDedupCacheExpire(t *testing.T) {
+	defer func(c clock.Clock) { env.Clock = c }(env.Clock)
+	manual := clock.NewManual(time.Unix(1000, 0))
+	env.Clock = manual
+
+	d := newDedupCache(time.Second)
+	d.begin(1)
+	manual.Advance(500 * time.Millisecond)
+	d.begin(2)
+	d.finish(pendingMessage{mid: 2, payload: "pong"})
+
+	if _, ok := d.begin(1); ok {
+		t.Fatal("expect the duplicate request")
+	}
+	manual.Advance(600 * time.Millisecond)
+	// the first request expired, the second one is cached
+	if _, ok := d.begin(1); !ok {
+		t.Fatal("expect the expired request processed again")
+	}
+	if resp, ok := d.begin(2); ok || resp == nil || resp.payload != "pong" {
+		t.Fatalf("expect the cached response, got %v", resp)
+	}
+	if len(d.entries) != 2 || len(d.queue) != 2 {
+		t.Fatalf("expect 2 entries, got %d in map and %d in queue", len(d.entries), len(d.queue))
+	}
+
+	manual.Advance(2 * time.Second)
+	d.begin(3)
+	if len(d.entries) != 1 || len(d.queue) != 1 {
+		t.Fatalf("expect the expired entries removed, got %d in map and %d in queue", len(d.entries), len(d.queue))
+	}
+}
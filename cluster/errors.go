@@ -24,7 +24,17 @@ import "errors"
 
 // Errors that could be occurred during message handling.
 var (
-	ErrSessionOnNotify    = errors.New("current session working on notify mode")
-	ErrCloseClosedSession = errors.New("close closed session")
-	ErrInvalidRegisterReq = errors.New("invalid register request")
+	ErrSessionOnNotify       = errors.New("current session working on notify mode")
+	ErrCloseClosedSession    = errors.New("close closed session")
+	ErrInvalidRegisterReq    = errors.New("invalid register request")
+	ErrRequestNotSupported   = errors.New("request to client is only supported on the gate node")
+	ErrNotMaster             = errors.New("current node is not the master")
+	ErrInvalidBindSessionReq = errors.New("invalid bind session request")
+	ErrSerializerMismatch    = errors.New("serializer mismatch with the master")
+	ErrAdminTokenRequired    = errors.New("admin token cannot be empty")
+	ErrDialBackoff           = errors.New("dial member backing off")
+	ErrCircuitOpen           = errors.New("circuit breaker of member is open")
+	ErrHotRestartDisabled    = errors.New("hot restart is not enabled")
+	ErrHotRestartMaster      = errors.New("hot restart is not supported by the master node")
+	ErrUpgrading             = errors.New("current node is upgrading")
 )
@@ -0,0 +1,114 @@
+package cluster
+
+import (
+	"bytes"
+	"fmt"
+	"runtime"
+	"runtime/pprof"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lonng/nano/internal/env"
+	"github.com/lonng/nano/internal/log"
+	"github.com/lonng/nano/metrics"
+)
+
+const (
+	// metricGoroutines is the goroutines of the process sampled by current node
+	metricGoroutines = "nano_goroutines"
+	// metricGoroutineRefused counts the client connections refused by the goroutine limit
+	metricGoroutineRefused = "nano_goroutine_limit_refused_total"
+)
+
+const (
+	// goroutineSampleInterval is the interval that the goroutine gauge is sampled in
+	goroutineSampleInterval = time.Second
+	// goroutineWarnInterval is the min interval between the warnings of the goroutine limit
+	goroutineWarnInterval = time.Minute
+	// goroutineTopStacks is the number of the most common stacks logged by the warning
+	goroutineTopStacks = 5
+)
+
+// goroutineGuard samples the goroutines of the process, and refuses the new client
+// connections while they exceed the soft limit, e.g: leaked by the handlers
+type goroutineGuard struct {
+	limit   int // zero means unlimited
+	gauge   *metrics.Gauge
+	refused *metrics.Counter
+
+	mu       sync.Mutex
+	warnedAt time.Time
+}
+
+func newGoroutineGuard(limit int, member string) *goroutineGuard {
+	return &goroutineGuard{
+		limit:   limit,
+		gauge:   metrics.Default.Gauge(metricGoroutines, "member", member),
+		refused: metrics.Default.Counter(metricGoroutineRefused, "member", member),
+	}
+}
+
+// sample updates the gauge with the goroutines of the process and returns it
+func (g *goroutineGuard) sample() int {
+	count := runtime.NumGoroutine()
+	g.gauge.Set(int64(count))
+	return count
+}
+
+// admit reports whether a new client connection can be served, the refused one is
+// counted and the most common stacks are logged at most once per goroutineWarnInterval
+func (g *goroutineGuard) admit() bool {
+	if g == nil {
+		return true
+	}
+	count := g.sample()
+	if g.limit <= 0 || count <= g.limit {
+		return true
+	}
+	g.refused.Inc()
+
+	g.mu.Lock()
+	now := env.Clock.Now()
+	warn := g.warnedAt.IsZero() || now.Sub(g.warnedAt) >= goroutineWarnInterval
+	if warn {
+		g.warnedAt = now
+	}
+	g.mu.Unlock()
+
+	if warn {
+		log.Println(fmt.Sprintf("Goroutines %d exceed the limit %d, new client connections are refused, top stacks:\n%s",
+			count, g.limit, topGoroutineStacks(goroutineTopStacks)))
+	}
+	return false
+}
+
+// watch samples the goroutines periodically until die closed
+func (g *goroutineGuard) watch(die <-chan struct{}) {
+	ticker := env.Clock.NewTicker(goroutineSampleInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C():
+			g.sample()
+		case <-die:
+			return
+		}
+	}
+}
+
+// topGoroutineStacks returns the n most common stacks of the goroutine profile, which
+// are grouped by stack and sorted by count descending
+func topGoroutineStacks(n int) string {
+	buf := &bytes.Buffer{}
+	if err := pprof.Lookup("goroutine").WriteTo(buf, 1); err != nil {
+		return err.Error()
+	}
+	// the first record is the profile header
+	records := strings.SplitN(buf.String(), "\n\n", n+2)
+	if len(records) > n+1 {
+		records = records[:n+1]
+	}
+	return strings.Join(records, "\n\n")
+}
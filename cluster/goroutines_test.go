@@ -0,0 +1,60 @@
+package cluster
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lonng/nano/internal/clock"
+	"github.com/lonng/nano/internal/env"
+)
+
+func TestGoroutineGuard(t *testing.T) {
+	defer func(c clock.Clock) { env.Clock = c }(env.Clock)
+	manual := clock.NewManual(time.Unix(1000, 0))
+	env.Clock = manual
+
+	g := newGoroutineGuard(1, "127.0.0.1:4904")
+	refused := g.refused.Value()
+	if g.admit() {
+		t.Fatal("connection should be refused over the limit")
+	}
+	if g.refused.Value()-refused != 1 || g.gauge.Value() <= 1 {
+		t.Fatalf("unexpected refused %d, goroutines %d", g.refused.Value()-refused, g.gauge.Value())
+	}
+	warnedAt := g.warnedAt
+	if !warnedAt.Equal(manual.Now()) {
+		t.Fatal("limit hit should be warned")
+	}
+
+	// the warnings are rate limited
+	manual.Advance(time.Second)
+	g.admit()
+	if !g.warnedAt.Equal(warnedAt) {
+		t.Fatal("limit hit should not be warned again within the interval")
+	}
+	manual.Advance(goroutineWarnInterval)
+	g.admit()
+	if g.warnedAt.Equal(warnedAt) {
+		t.Fatal("limit hit should be warned after the interval")
+	}
+
+	g.limit = 0
+	if !g.admit() {
+		t.Fatal("connection should be admitted without limit")
+	}
+	var nilGuard *goroutineGuard
+	if !nilGuard.admit() {
+		t.Fatal("nil guard should admit all connections")
+	}
+}
+
+func TestTopGoroutineStacks(t *testing.T) {
+	stacks := topGoroutineStacks(1)
+	if !strings.HasPrefix(stacks, "goroutine profile: total") {
+		t.Fatalf("unexpected stacks %q", stacks)
+	}
+	if strings.Count(stacks, "\n\n") != 1 {
+		t.Fatalf("unexpected stacks count %q", stacks)
+	}
+}
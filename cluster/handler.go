@@ -21,7 +21,10 @@
 package cluster
 
 import (
+	"bufio"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"math/rand"
@@ -30,6 +33,7 @@ import (
 	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -40,29 +44,93 @@ import (
 	"github.com/lonng/nano/internal/log"
 	"github.com/lonng/nano/internal/message"
 	"github.com/lonng/nano/internal/packet"
+	"github.com/lonng/nano/metrics"
 	"github.com/lonng/nano/pipeline"
 	"github.com/lonng/nano/scheduler"
+	"github.com/lonng/nano/serialize"
 	"github.com/lonng/nano/session"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 )
 
 var (
 	// cached serialized data
-	hrd []byte // handshake response data
-	hbd []byte // heartbeat packet data
+	hrd  []byte // handshake response data
+	hrdc []byte // handshake response data which accepts the compression
+	hbd  []byte // heartbeat packet data of the default framing
 )
 
+// compressDeflate is the only compression algorithm supported now
+const compressDeflate = "deflate"
+
+// metricRouteDispatch counts the routing decisions, labeled by route, mode(local/remote)
+// and the service address of destination member. The remote decisions are labeled by the
+// service instead of the route, which is not validated until it reaches the remote member
+const metricRouteDispatch = "nano_route_dispatch_total"
+
+// metricRequestExpired counts the requests whose timeout elapsed, labeled by the stage
+// (dispatch/response) at which the request was dropped
+const metricRequestExpired = "nano_request_expired_total"
+
+// Metrics of the client connections, labeled by the service address of current node
+const (
+	metricConnections = "nano_connections"
+	metricMessagesIn  = "nano_messages_in_total"
+	metricMessagesOut = "nano_messages_out_total"
+)
+
+// Metrics of the per-session queues, labeled by the service address of current node,
+// nano_session_queue_full_total is also labeled by the action(backpressure/kick)
+const (
+	metricSessionQueued    = "nano_session_queued_messages"
+	metricSessionQueueFull = "nano_session_queue_full_total"
+)
+
+// sessionQueueKickReason is the kick reason of the sessions overflowing the queue
+const sessionQueueKickReason = "message queue overflow"
+
+// Read rate limit, the connections exceeding MaxReadBytesPerSec are throttled or kicked,
+// nano_read_throttled_total is labeled by the action(throttle/kick)
+const (
+	metricReadThrottled = "nano_read_throttled_total"
+	readRateKickReason  = "read rate exceeded"
+)
+
+// Slow consumer detection, the sessions whose send queue stays near full are kicked
+const (
+	metricSlowConsumer     = "nano_slow_consumer_kick_total"
+	slowConsumerKickReason = "slow connection"
+)
+
+// Phases of the codec errors reported to OnCodecError
+const (
+	CodecPhasePacket  = "packet"  // decoding the packets from the connection
+	CodecPhaseMessage = "message" // decoding the message of a data packet
+	CodecPhasePayload = "payload" // deserializing the payload for the handler
+	CodecPhaseEncode  = "encode"  // serializing the outbound payload
+)
+
+// metricCodecErrors counts the codec errors, labeled by phase and the service address
+// of current node
+const metricCodecErrors = "nano_codec_errors_total"
+
+// defaultCodecErrorLimit is the decode failures of a connection tolerated if not configured
+const defaultCodecErrorLimit = 3
+
+// defaultPauseBuffer is the messages buffered for a paused session if not configured
+const defaultPauseBuffer = 64
+
 type rpcHandler func(session *session.Session, msg *message.Message, noCopy bool)
 
 func cache() {
-	data, err := json.Marshal(map[string]interface{}{
-		"code": 200,
-		"sys":  map[string]float64{"heartbeat": env.Heartbeat.Seconds()},
-	})
+	var err error
+	hrd, err = handshakeResponse(false, "", "", nil, ProtocolVersion, supportedProtocols(ProtocolLegacy))
 	if err != nil {
 		panic(err)
 	}
 
-	hrd, err = codec.Encode(packet.Handshake, data)
+	hrdc, err = handshakeResponse(true, "", "", nil, ProtocolVersion, supportedProtocols(ProtocolLegacy))
 	if err != nil {
 		panic(err)
 	}
@@ -73,25 +141,82 @@ func cache() {
 	}
 }
 
+// handshakeResponse encodes the handshake response data with the negotiated compression,
+// serializer and protocol version, the serializer is absent if the application serializer
+// is used, and the key material is present if the payload encryption is enabled. The
+// dict is the id of the compression dictionary, absent if no dictionary negotiated
+func handshakeResponse(compress bool, dict string, serializer string, key []byte, protocol int, protocols []int) ([]byte, error) {
+	sys := map[string]interface{}{
+		"heartbeat": env.Heartbeat.Seconds(),
+		"protocol":  protocol,
+		"protocols": protocols,
+	}
+	if compress {
+		sys["compress"] = compressDeflate
+	}
+	if dict != "" {
+		sys["compressDict"] = dict
+	}
+	if serializer != "" {
+		sys["serializer"] = serializer
+	}
+	if key != nil {
+		// the material of the initial key of the payload encryption, in base64
+		sys["key"] = key
+	}
+	return json.Marshal(map[string]interface{}{"code": 200, "sys": sys})
+}
+
 type LocalHandler struct {
-	localServices map[string]*component.Service // all registered service
-	localHandlers map[string]*component.Handler // all handler method
+	localServices map[string]*component.Service    // all registered service
+	localHandlers map[string]*component.Handler    // all handler method
+	workers       map[string]*scheduler.WorkerPool // dedicated worker pools by service name
+	limiters      map[string]*routeLimiter         // concurrency limiters by route
+	caches        *responseCache                   // response caches of the cached routes
 
 	mu             sync.RWMutex
 	remoteServices map[string][]*clusterpb.MemberInfo
+	rings          map[string]*hashRing             // consistent hash rings by service name
+	virtualNodes   int                              // virtual nodes per member of the rings
+	observers      map[string]*clusterpb.MemberInfo // observer members by service address
 
 	pipeline    pipeline.Pipeline
 	currentNode *Node
+	tracer      *messageTracer   // nil if the message tracing disabled
+	watchdog    *stallWatchdog   // nil if the dispatcher stall detection disabled
+	inflight    *inflightLimiter // nil if the forwards to a member are unlimited
+	breakers    *circuitBreakers // nil if the circuit breakers disabled
+	goroutines  *goroutineGuard  // nil before the service address resolved
 }
 
 func NewHandler(currentNode *Node, pipeline pipeline.Pipeline) *LocalHandler {
 	h := &LocalHandler{
 		localServices:  make(map[string]*component.Service),
 		localHandlers:  make(map[string]*component.Handler),
+		workers:        map[string]*scheduler.WorkerPool{},
+		limiters:       map[string]*routeLimiter{},
+		caches:         newResponseCache(),
 		remoteServices: map[string][]*clusterpb.MemberInfo{},
+		rings:          map[string]*hashRing{},
+		observers:      map[string]*clusterpb.MemberInfo{},
 		pipeline:       pipeline,
 		currentNode:    currentNode,
 	}
+	if currentNode != nil {
+		h.virtualNodes = currentNode.ShardVirtualNodes
+		if currentNode.TraceMessages {
+			h.tracer = newMessageTracer(currentNode.TraceSampleRate, currentNode.TraceRedact)
+		}
+		if threshold := currentNode.DispatcherStallThreshold; threshold > 0 {
+			h.watchdog = newStallWatchdog(threshold)
+		}
+		if limit := currentNode.MaxInflightPerMember; limit > 0 {
+			h.inflight = newInflightLimiter(limit, currentNode.InflightQueuePerMember)
+		}
+		if currentNode.BreakerFailureRate > 0 {
+			h.breakers = newCircuitBreakers(currentNode)
+		}
+	}
 
 	return h
 }
@@ -99,20 +224,50 @@ func NewHandler(currentNode *Node, pipeline pipeline.Pipeline) *LocalHandler {
 func (h *LocalHandler) register(comp component.Component, opts []component.Option) error {
 	s := component.NewService(comp, opts)
 
-	if _, ok := h.localServices[s.Name]; ok {
-		return fmt.Errorf("handler: service already defined: %s", s.Name)
+	if exist, ok := h.localServices[s.Name]; ok {
+		return fmt.Errorf("handler: service %s of component %s already defined by component %s",
+			s.Name, s.Type, exist.Type)
 	}
 
 	if err := s.ExtractHandler(); err != nil {
 		return err
 	}
+	if err := h.checkRejected(s); err != nil {
+		return err
+	}
+
+	// check route conflicts before registering anything, so a failed component
+	// leaves no partial routes behind
+	for name := range s.Handlers {
+		n := fmt.Sprintf("%s.%s", s.Name, name)
+		if exist, ok := h.localHandlers[n]; ok {
+			return fmt.Errorf("handler: route %s of component %s conflicts with component %s",
+				n, s.Type, exist.Receiver.Type())
+		}
+	}
 
 	// register all localHandlers
 	h.localServices[s.Name] = s
+	if ns := s.MetricsNamespace; ns != "" {
+		metrics.Default.SetNamespace(s.Name, ns)
+	}
+	if s.Workers > 0 {
+		h.workers[s.Name] = scheduler.NewWorkerPool(s.Workers)
+	}
+	var member string
+	if h.currentNode != nil {
+		member = h.currentNode.ServiceAddr
+	}
 	for name, handler := range s.Handlers {
 		n := fmt.Sprintf("%s.%s", s.Name, name)
 		log.Println("Register local handler", n)
 		h.localHandlers[n] = handler
+		if s.MaxConcurrency > 0 {
+			h.limiters[n] = newRouteLimiter(s.MaxConcurrency, s.ConcurrencyQueue, n, member)
+		}
+		if s.CacheTTL > 0 {
+			h.caches.register(n, s.CacheTTL, member)
+		}
 	}
 	return nil
 }
@@ -127,17 +282,92 @@ func (h *LocalHandler) addRemoteService(member *clusterpb.MemberInfo) {
 	h.mu.Lock()
 	defer h.mu.Unlock()
 
+	// observers are excluded from the request routing
+	if member.Observer {
+		log.Println("Register observer", member.ServiceAddr)
+		h.observers[member.ServiceAddr] = member
+		return
+	}
+
+	delete(h.observers, member.ServiceAddr)
+
+	// the member registered again may provide less services than before
+	provided := map[string]bool{}
+	for _, s := range member.Services {
+		provided[s] = true
+	}
+	for name, members := range h.remoteServices {
+		if provided[name] {
+			continue
+		}
+		for i, m := range members {
+			if m.ServiceAddr != member.ServiceAddr {
+				continue
+			}
+			members = append(members[:i:i], members[i+1:]...)
+			if len(members) == 0 {
+				delete(h.remoteServices, name)
+				delete(h.rings, name)
+			} else {
+				h.remoteServices[name] = members
+				h.rings[name] = newHashRing(members, h.virtualNodes)
+			}
+			break
+		}
+	}
+
 	for _, s := range member.Services {
 		log.Println("Register remote service", s)
-		h.remoteServices[s] = append(h.remoteServices[s], member)
+		members := h.remoteServices[s]
+		found := false
+		for i, m := range members {
+			// replace the existing member instead of appending a duplicated one
+			if m.ServiceAddr == member.ServiceAddr {
+				members[i] = member
+				found = true
+				break
+			}
+		}
+		if !found {
+			h.remoteServices[s] = append(members, member)
+		}
+		h.rings[s] = newHashRing(h.remoteServices[s], h.virtualNodes)
 	}
 }
 
+// syncRemoteService rebuilds the remote services with the authoritative member list
+func (h *LocalHandler) syncRemoteService(members []*clusterpb.MemberInfo) {
+	remoteServices := map[string][]*clusterpb.MemberInfo{}
+	observers := map[string]*clusterpb.MemberInfo{}
+	for _, m := range members {
+		if m.Observer {
+			observers[m.ServiceAddr] = m
+			continue
+		}
+		for _, s := range m.Services {
+			remoteServices[s] = append(remoteServices[s], m)
+		}
+	}
+	rings := make(map[string]*hashRing, len(remoteServices))
+	for s, members := range remoteServices {
+		rings[s] = newHashRing(members, h.virtualNodes)
+	}
+
+	h.mu.Lock()
+	h.remoteServices = remoteServices
+	h.rings = rings
+	h.observers = observers
+	h.mu.Unlock()
+}
+
 func (h *LocalHandler) delMember(addr string) {
 	h.mu.Lock()
 	defer h.mu.Unlock()
 
+	delete(h.observers, addr)
+
 	for name, members := range h.remoteServices {
+		removed := false
 		for i, maddr := range members {
 			if addr == maddr.ServiceAddr {
 				if i == len(members)-1 {
@@ -145,12 +375,17 @@ func (h *LocalHandler) delMember(addr string) {
 				} else {
 					members = append(members[:i], members[i+1:]...)
 				}
+				removed = true
 			}
 		}
 		if len(members) == 0 {
 			delete(h.remoteServices, name)
+			delete(h.rings, name)
 		} else {
 			h.remoteServices[name] = members
+			if removed {
+				h.rings[name] = newHashRing(members, h.virtualNodes)
+			}
 		}
 	}
 }
@@ -176,10 +411,78 @@ func (h *LocalHandler) RemoteService() []string {
 	return result
 }
 
-func (h *LocalHandler) handle(conn net.Conn) {
+// handle serves the client connection, the uid authenticated before the connection
+// established is bound to the session if positive
+func (h *LocalHandler) handle(conn net.Conn, uid int64) {
+	if h.currentNode.isDraining() || !h.goroutines.admit() {
+		conn.Close()
+		return
+	}
+
 	// create a client agent and startup write gorontine
 	agent := newAgent(conn, h.pipeline, h.remoteProcess)
+	agent.onBind = h.currentNode.bindSession
+	agent.onRebind = h.currentNode.rebindSession
+	agent.linger = h.currentNode.linger
+	agent.setFraming(h.currentNode.Framing, h.currentNode.MaxPacketSize)
+	if c := h.currentNode.PacketCodec; c != nil {
+		agent.setPacketCodec(c)
+	}
+	agent.outbound = h.currentNode.OutboundTransform
+	agent.closeFlush = h.currentNode.CloseFlushTimeout
+	if limit := h.currentNode.SystemPacketLimit; limit > 0 {
+		agent.sysLimiter = newPacketLimiter(limit, h.currentNode.SystemPacketWindow)
+	}
+	if rate := h.currentNode.MaxReadBytesPerSec; rate > 0 {
+		agent.readLimiter = newByteLimiter(rate, h.currentNode.ReadBurstBytes)
+		agent.readRateKick = h.currentNode.ReadRateKick
+		agent.readThrottled = metrics.Default.Counter(metricReadThrottled, "member", h.currentNode.ServiceAddr, "action", "throttle")
+		agent.readRateKicks = metrics.Default.Counter(metricReadThrottled, "member", h.currentNode.ServiceAddr, "action", "kick")
+	}
+	agent.messagesIn = metrics.Default.Counter(metricMessagesIn, "member", h.currentNode.ServiceAddr)
+	agent.messagesOut = metrics.Default.Counter(metricMessagesOut, "member", h.currentNode.ServiceAddr)
+	agent.queueGauge = metrics.Default.Gauge(metricSessionQueued, "member", h.currentNode.ServiceAddr)
+	if limit := h.currentNode.SessionQueueLimit; limit > 0 {
+		agent.queueSlots = make(chan struct{}, limit)
+	}
+	agent.reliable = newReliableWindow(h.currentNode.ReliableWindow)
+	if window := h.currentNode.SlowConsumerWindow; window > 0 {
+		agent.slowWindow = window
+		agent.slowThreshold = h.currentNode.SlowConsumerThreshold
+		if agent.slowThreshold <= 0 || agent.slowThreshold > agentWriteBacklog {
+			agent.slowThreshold = agentWriteBacklog
+		}
+		agent.slowKicks = metrics.Default.Counter(metricSlowConsumer, "member", h.currentNode.ServiceAddr)
+	}
+	agent.pauseLimit = h.currentNode.PauseBuffer
+	if agent.pauseLimit == 0 {
+		agent.pauseLimit = defaultPauseBuffer
+	}
+	agent.resumed = func(msg *message.Message) { h.dispatchMessage(agent, msg) }
+	agent.tracer = h.tracer
+	agent.localizer = h.currentNode.Localizer
+	agent.onCodecError = func(err error, phase string) bool { return h.codecError(agent, err, phase) }
+	if h.caches.enabled() {
+		agent.onRespond = func(mid uint64, data []byte, more bool) { h.caches.fill(agent.session, mid, data, more) }
+	}
+	if c := h.currentNode.PayloadCipher; c != nil {
+		agent.cipher = c
+		agent.keyGrace = h.currentNode.KeyGraceWindow
+		if agent.keyGrace <= 0 {
+			agent.keyGrace = defaultKeyGraceWindow
+		}
+		agent.keyRotation = h.currentNode.KeyRotationInterval
+	}
+	connections := metrics.Default.Gauge(metricConnections, "member", h.currentNode.ServiceAddr)
+	connections.Add(1)
+	if h.currentNode.RawMode {
+		agent.raw = true
+		agent.setStatus(statusWorking)
+	}
 	h.currentNode.storeSession(agent.session)
+	if uid > 0 {
+		agent.session.Bind(uid)
+	}
 
 	// startup write goroutine
 	go agent.write()
@@ -188,10 +491,18 @@ func (h *LocalHandler) handle(conn net.Conn) {
 		log.Println(fmt.Sprintf("New session established: %s", agent.String()))
 	}
 
+	// the reason of closing the connection if the read loop exits, the connection may
+	// have been closed by the write goroutine for another reason
+	reason := session.ReadError
+
 	// guarantee agent related resource be destroyed
 	defer func() {
+		agent.close(reason)
+		h.currentNode.removeSession(agent.session)
+		h.currentNode.unbindSession(agent.session)
 		request := &clusterpb.SessionClosedRequest{
 			SessionId: agent.session.ID(),
+			Reason:    string(agent.closedFor()),
 		}
 
 		members := h.currentNode.cluster.remoteAddrs()
@@ -213,25 +524,52 @@ func (h *LocalHandler) handle(conn net.Conn) {
 			}
 		}
 
-		agent.Close()
+		connections.Add(-1)
 		if env.Debug {
 			log.Println(fmt.Sprintf("Session read goroutine exit, SessionID=%d, UID=%d", agent.session.ID(), agent.session.UID()))
 		}
 	}()
 
+	// read loop of the custom codec, the packets are decoded from the buffered connection
+	if c := agent.packetCodec; c != nil {
+		reader := &agentReader{agent: agent}
+		r := bufio.NewReader(reader)
+		for {
+			p, err := c.Decode(r)
+			if err != nil && err == reader.err {
+				reason = h.readError(agent, err)
+				return
+			}
+			if err != nil {
+				log.Println(fmt.Sprintf("Read message error: %s, session will be closed immediately", err.Error()))
+				h.codecError(agent, err, CodecPhasePacket)
+				return
+			}
+			if err := h.processPacket(agent, &p); err != nil {
+				log.Println(err.Error())
+				return
+			}
+		}
+	}
+
 	// read loop
 	buf := make([]byte, 2048)
 	for {
 		n, err := conn.Read(buf)
 		if err != nil {
-			log.Println(fmt.Sprintf("Read message error: %s, session will be closed immediately", err.Error()))
+			reason = h.readError(agent, err)
 			return
 		}
+		atomic.AddInt64(&agent.bytesIn, int64(n))
+		if !agent.throttleRead(n) {
+			continue
+		}
 
 		// TODO(warning): decoder use slice for performance, packet data should be copy before next Decode
 		packets, err := agent.decoder.Decode(buf[:n])
 		if err != nil {
 			log.Println(err.Error())
+			h.codecError(agent, err, CodecPhasePacket)
 			return
 		}
 
@@ -250,13 +588,83 @@ func (h *LocalHandler) handle(conn net.Conn) {
 }
 
 func (h *LocalHandler) processPacket(agent *agent, p *packet.Packet) error {
+	if agent.raw && p.Type != packet.Data {
+		return fmt.Errorf("receive packet type %d in raw mode, session will be closed immediately, remote=%s",
+			p.Type, agent.conn.RemoteAddr().String())
+	}
+
+	// system packets do not go through the route dispatch, limit them separately
+	if agent.sysLimiter != nil && p.Type != packet.Data && !agent.sysLimiter.allow(env.Clock.Now()) {
+		return fmt.Errorf("too many system packets, session will be closed immediately, remote=%s",
+			agent.conn.RemoteAddr().String())
+	}
+
 	switch p.Type {
 	case packet.Handshake:
 		if err := env.HandshakeValidator(p.Data); err != nil {
 			return err
 		}
+		if locale := handshakeLocale(p.Data); locale != "" {
+			agent.session.SetLocale(locale)
+		}
+		protocol := negotiateProtocol(p.Data)
+		if err := h.checkProtocol(agent, protocol); err != nil {
+			return err
+		}
+		agent.setProtocol(protocol)
+		if auth := h.currentNode.Authenticator; auth != nil {
+			if err := h.authenticate(agent, auth, p.Data); err != nil {
+				return err
+			}
+		}
 
-		if _, err := agent.conn.Write(hrd); err != nil {
+		response := hrd
+		if acceptCompression(p.Data) {
+			agent.compress = true
+			response = hrdc
+			if dict := h.currentNode.CompressionDictionary; acceptDictionary(p.Data, dict) {
+				agent.compressDict = dict
+			}
+		}
+		name, serializer := h.negotiateSerializer(p.Data)
+		if serializer != nil {
+			agent.serializer = serializer
+		}
+		negotiated := negotiation{serializer: name}
+		if agent.compress {
+			negotiated.compression = compressDeflate
+		}
+		agent.negotiated.Store(negotiated)
+		var material []byte
+		if agent.cipher != nil {
+			var key []byte
+			var err error
+			key, material, err = agent.cipher.NewKey(agent.session)
+			if err != nil {
+				return fmt.Errorf("generate key failed: %v, session will be closed immediately, remote=%s",
+					err, agent.conn.RemoteAddr().String())
+			}
+			agent.keys.rotate(key, 0)
+		}
+		// the cached response only fits the default negotiation
+		if serializer != nil || material != nil || agent.compressDict != nil || protocol != ProtocolVersion ||
+			h.currentNode.MinProtocolVersion > ProtocolLegacy {
+			var dict string
+			if agent.compressDict != nil {
+				dict = dictionaryID(agent.compressDict)
+			}
+			data, err := handshakeResponse(agent.compress, dict, name, material, protocol,
+				supportedProtocols(h.currentNode.MinProtocolVersion))
+			if err != nil {
+				return err
+			}
+			response = data
+		}
+		p, err := agent.encodePacket(packet.Handshake, response)
+		if err != nil {
+			return err
+		}
+		if err := agent.writeFull(p); err != nil {
 			return err
 		}
 
@@ -279,24 +687,257 @@ func (h *LocalHandler) processPacket(agent *agent, p *packet.Packet) error {
 
 		msg, err := message.Decode(p.Data)
 		if err != nil {
-			return err
+			log.Println(fmt.Sprintf("Decode message error: %s, UID=%d", err.Error(), agent.session.UID()))
+			if h.codecError(agent, err, CodecPhaseMessage) {
+				return fmt.Errorf("too many decode failures, session will be closed immediately, remote=%s",
+					agent.conn.RemoteAddr().String())
+			}
+			break
+		}
+		if agent.messagesIn != nil {
+			agent.messagesIn.Inc()
+		}
+		if agent.cipher != nil {
+			if msg.Data, err = agent.decrypt(msg.Data); err != nil {
+				return fmt.Errorf("decrypt inbound payload failed: %v, session will be closed immediately, remote=%s",
+					err, agent.conn.RemoteAddr().String())
+			}
+		}
+		if transform := h.currentNode.InboundTransform; transform != nil {
+			if msg.Data, err = transform(msg.Data); err != nil {
+				return fmt.Errorf("transform inbound payload failed: %v, session will be closed immediately, remote=%s",
+					err, agent.conn.RemoteAddr().String())
+			}
+		}
+		if msg.Deflated {
+			data, err := message.InflateDict(msg.Data, agent.compressDict)
+			if err != nil {
+				return err
+			}
+			msg.Data, msg.Deflated = data, false
 		}
 		h.processMessage(agent, msg)
 
+	case packet.Rekey:
+		// client switched to the new key, the replaced one is dropped
+		agent.keys.acknowledged()
+
+	case packet.Ack:
+		cumulative, ranges, err := decodeAck(p.Data)
+		if err != nil {
+			return fmt.Errorf("%v, session will be closed immediately, remote=%s", err, agent.conn.RemoteAddr().String())
+		}
+		agent.reliable.ack(cumulative, ranges)
+
 	case packet.Heartbeat:
 		// expected
 	}
 
-	agent.lastAt = time.Now().Unix()
+	atomic.StoreInt64(&agent.lastAt, env.Clock.Now().Unix())
+	return nil
+}
+
+// authenticate validates the handshake data by the authenticator, and binds the uid
+// to the session before the handshake completes. The client is rejected with an
+// error handshake response if the authentication failed
+func (h *LocalHandler) authenticate(agent *agent, auth Authenticator, data []byte) error {
+	ctx := context.Background()
+	if timeout := h.currentNode.AuthTimeout; timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	uid, err := auth(ctx, data)
+	if err != nil {
+		response, e := json.Marshal(map[string]interface{}{"code": codeUnauthorized, "msg": agent.localize(err.Error())})
+		if e == nil {
+			if p, e := agent.encodePacket(packet.Handshake, response); e == nil {
+				agent.conn.Write(p)
+			}
+		}
+		return fmt.Errorf("authenticate failed: %v, session will be closed immediately, remote=%s",
+			err, agent.conn.RemoteAddr().String())
+	}
+	return agent.session.Bind(uid)
+}
+
+// checkProtocol rejects the client whose protocol version is below the minimum version
+// of current node with an error handshake response
+func (h *LocalHandler) checkProtocol(agent *agent, protocol int) error {
+	min := h.currentNode.MinProtocolVersion
+	if protocol >= min {
+		return nil
+	}
+	msg := fmt.Sprintf("protocol version %d is not supported, minimum version is %d", protocol, min)
+	response, err := json.Marshal(map[string]interface{}{
+		"code": codeIncompatible,
+		"msg":  agent.localize("protocol version %d is not supported, minimum version is %d", protocol, min),
+		"sys":  map[string]interface{}{"protocols": supportedProtocols(min)},
+	})
+	if err == nil {
+		if p, err := agent.encodePacket(packet.Handshake, response); err == nil {
+			agent.conn.Write(p)
+		}
+	}
+	return fmt.Errorf("%s, session will be closed immediately, remote=%s", msg, agent.conn.RemoteAddr().String())
+}
+
+// handshakeLocale returns the locale of the client in the handshake data, e.g: "en-US",
+// empty if not declared
+func handshakeLocale(data []byte) string {
+	if len(data) == 0 {
+		return ""
+	}
+	handshake := struct {
+		Sys struct {
+			Locale string `json:"locale"`
+		} `json:"sys"`
+	}{}
+	if err := json.Unmarshal(data, &handshake); err != nil {
+		return ""
+	}
+	return handshake.Sys.Locale
+}
+
+// acceptCompression returns true if the client offers deflate in the handshake data,
+// and the server has not declined it
+func acceptCompression(data []byte) bool {
+	if env.CompressThreshold <= 0 || len(data) == 0 {
+		return false
+	}
+	handshake := struct {
+		Sys struct {
+			Compress []string `json:"compress"`
+		} `json:"sys"`
+	}{}
+	if err := json.Unmarshal(data, &handshake); err != nil {
+		return false
+	}
+	for _, c := range handshake.Sys.Compress {
+		if c == compressDeflate {
+			return env.CompressionFilter == nil || env.CompressionFilter(data)
+		}
+	}
+	return false
+}
+
+// acceptDictionary returns true if the client declares the id of the compression
+// dictionary of server in the handshake data, the dictionary is used only if both sides
+// have the same one
+func acceptDictionary(data []byte, dict []byte) bool {
+	if len(dict) == 0 {
+		return false
+	}
+	handshake := struct {
+		Sys struct {
+			CompressDict string `json:"compressDict"`
+		} `json:"sys"`
+	}{}
+	if err := json.Unmarshal(data, &handshake); err != nil {
+		return false
+	}
+	return handshake.Sys.CompressDict == dictionaryID(dict)
+}
+
+// dictionaryID identifies the compression dictionary by the first 8 bytes of its SHA-256
+// digest in hex
+func dictionaryID(dict []byte) string {
+	sum := sha256.Sum256(dict)
+	return hex.EncodeToString(sum[:8])
+}
+
+// checkSerializers checks whether the argument types of all local handlers can be
+// serialized by every serializer which may be negotiated by clients
+func (h *LocalHandler) checkSerializers(serializers map[string]serialize.Serializer) error {
+	names := make([]string, 0, len(serializers))
+	for name := range serializers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	routes := make([]string, 0, len(h.localHandlers))
+	for route := range h.localHandlers {
+		routes = append(routes, route)
+	}
+	sort.Strings(routes)
+
+	for _, name := range names {
+		for _, route := range routes {
+			handler := h.localHandlers[route]
+			if handler.IsRawArg {
+				continue
+			}
+			v := reflect.New(handler.Type.Elem()).Interface()
+			if _, err := serializers[name].Marshal(v); err != nil {
+				return fmt.Errorf("handler %s argument %s is incompatible with serializer %s: %v",
+					route, handler.Type, name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// checkRejected reports the methods of the service which take a session argument but
+// are rejected by signature, which fails the registration in strict mode
+func (h *LocalHandler) checkRejected(s *component.Service) error {
+	names := make([]string, 0, len(s.Rejected))
+	for name := range s.Rejected {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	strict := h.currentNode != nil && h.currentNode.StrictHandlerRegistration
+	for _, name := range names {
+		if strict {
+			return fmt.Errorf("handler: method %s of component %s has unsupported signature: %v", name, s.Type, s.Rejected[name])
+		}
+		log.Println(fmt.Sprintf("Skip method %s of component %s, unsupported handler signature: %v", name, s.Type, s.Rejected[name]))
+	}
 	return nil
 }
 
+// negotiateSerializer selects the first serializer offered by client in the handshake
+// which is registered in current node, nil will be returned if none of them is available
+func (h *LocalHandler) negotiateSerializer(data []byte) (string, serialize.Serializer) {
+	if h.currentNode == nil || len(h.currentNode.Serializers) == 0 || len(data) == 0 {
+		return "", nil
+	}
+	handshake := struct {
+		Sys struct {
+			Serializer []string `json:"serializer"`
+		} `json:"sys"`
+	}{}
+	if err := json.Unmarshal(data, &handshake); err != nil {
+		return "", nil
+	}
+	for _, name := range handshake.Sys.Serializer {
+		if serializer, found := h.currentNode.Serializers[name]; found {
+			return name, serializer
+		}
+	}
+	return "", nil
+}
+
 func (h *LocalHandler) findMembers(service string) []*clusterpb.MemberInfo {
 	h.mu.RLock()
 	defer h.mu.RUnlock()
 	return h.remoteServices[service]
 }
 
+// ShardOwner returns the service address of the remote member owning the shard key
+// of the service on the consistent hash ring, false if no member provides the service
+func (h *LocalHandler) ShardOwner(service, key string) (string, bool) {
+	h.mu.RLock()
+	ring := h.rings[service]
+	h.mu.RUnlock()
+
+	m := ring.owner(key)
+	if m == nil {
+		return "", false
+	}
+	return m.ServiceAddr, true
+}
+
 func (h *LocalHandler) remoteProcess(session *session.Session, msg *message.Message, noCopy bool) {
 	index := strings.LastIndex(msg.Route, ".")
 	if index < 0 {
@@ -312,19 +953,23 @@ func (h *LocalHandler) remoteProcess(session *session.Session, msg *message.Mess
 	}
 
 	// Select a remote service address
-	// 1. Use the service address directly if the router contains binding item
-	// 2. Select a remote service address randomly and bind to router
+	// 1. Use the member of the shard key if the message has one
+	// 2. Use the service address directly if the router contains binding item
+	// 3. Select a remote service address randomly and bind to router
 	var remoteAddr string
-	if addr, found := session.Router().Find(service); found {
-		remoteAddr = addr
-	} else {
-		remoteAddr = members[rand.Intn(len(members))].ServiceAddr
-		session.Router().Bind(service, remoteAddr)
+	if shard := h.currentNode.ShardKey; shard != nil {
+		if key := shard(session, msg.Route, msg.Data); key != "" {
+			remoteAddr, _ = h.ShardOwner(service, key)
+		}
 	}
-	pool, err := h.currentNode.rpcClient.getConnPool(remoteAddr)
-	if err != nil {
-		log.Println(err)
-		return
+	bound := remoteAddr == ""
+	if remoteAddr == "" {
+		if addr, found := session.Router().Find(service); found {
+			remoteAddr = addr
+		} else {
+			remoteAddr = h.balance(session, service, members)
+			session.Router().Bind(service, remoteAddr)
+		}
 	}
 	var data = msg.Data
 	if !noCopy && len(msg.Data) > 0 {
@@ -332,6 +977,88 @@ func (h *LocalHandler) remoteProcess(session *session.Session, msg *message.Mess
 		copy(data, msg.Data)
 	}
 
+	// The member may leave after selected, the idempotent messages are retried on the
+	// other members, and the client is responded an error if no member is available
+	tried := map[string]bool{}
+	for {
+		metrics.Default.Counter(metricRouteDispatch, "route", service, "mode", "remote", "member", remoteAddr).Inc()
+		err := ErrCircuitOpen
+		if allowed, probe := h.breakers.allow(remoteAddr); allowed {
+			release, ok := h.inflight.acquire(remoteAddr)
+			if !ok {
+				h.breakers.done(remoteAddr, probe, nil)
+				log.Println(fmt.Sprintf("Member %s is busy, drop remote message (%d:%s)", remoteAddr, msg.ID, msg.Route))
+				if a, ok := session.NetworkEntity().(*agent); ok && msg.Type == message.Request {
+					if err := a.responseError(msg.ID, msg.Route, codeUnavailable, "member busy"); err != nil {
+						log.Println(err.Error())
+					}
+				}
+				return
+			}
+			err = h.forward(remoteAddr, session, msg, data)
+			release()
+			h.breakers.done(remoteAddr, probe, err)
+		}
+		if err == nil {
+			return
+		}
+		if !memberGone(err) {
+			log.Println(fmt.Sprintf("Process remote message (%d:%s) error: %+v", msg.ID, msg.Route, err))
+			return
+		}
+
+		tried[remoteAddr] = true
+		next := ""
+		if h.idempotent(msg.Route) {
+			for _, m := range h.findMembers(service) {
+				if !tried[m.ServiceAddr] {
+					next = m.ServiceAddr
+					break
+				}
+			}
+		}
+		if next == "" {
+			log.Println(fmt.Sprintf("Service of remote message (%d:%s) unavailable: %+v", msg.ID, msg.Route, err))
+			if a, ok := session.NetworkEntity().(*agent); ok && msg.Type == message.Request {
+				if err := a.responseError(msg.ID, msg.Route, codeUnavailable, "service unavailable"); err != nil {
+					log.Println(err.Error())
+				}
+			}
+			return
+		}
+
+		log.Println(fmt.Sprintf("Retry remote message (%d:%s) on %s, member %s unavailable: %+v",
+			msg.ID, msg.Route, next, remoteAddr, err))
+		if bound {
+			session.Router().Bind(service, next)
+		}
+		remoteAddr = next
+	}
+}
+
+// balance selects a member of the service by the balancer, or selects one randomly if
+// the balancer is absent or returns an address which does not provide the service
+func (h *LocalHandler) balance(session *session.Session, service string, members []*clusterpb.MemberInfo) string {
+	if balancer := h.currentNode.Balancer; balancer != nil {
+		if addr := balancer(session, service, members); addr != "" {
+			for _, m := range members {
+				if m.ServiceAddr == addr {
+					return addr
+				}
+			}
+			log.Println(fmt.Sprintf("Balancer selected %s which does not provide service %s", addr, service))
+		}
+	}
+	return members[rand.Intn(len(members))].ServiceAddr
+}
+
+// forward forwards the message to the remote member
+func (h *LocalHandler) forward(remoteAddr string, session *session.Session, msg *message.Message, data []byte) error {
+	pool, err := h.currentNode.rpcClient.getConnPool(remoteAddr)
+	if err != nil {
+		return err
+	}
+
 	// Retrieve gate address and session id
 	gateAddr := h.currentNode.ServiceAddr
 	sessionId := session.ID()
@@ -341,6 +1068,16 @@ func (h *LocalHandler) remoteProcess(session *session.Session, msg *message.Mess
 		sessionId = v.sid
 	}
 
+	// the header is carried by the metadata of the call
+	ctx := context.Background()
+	if len(msg.Header) > 0 {
+		header, err := message.EncodeHeader(msg.Header)
+		if err != nil {
+			return err
+		}
+		ctx = metadata.NewOutgoingContext(ctx, metadata.Pairs(headerMetadataKey, string(header)))
+	}
+
 	client := clusterpb.NewMemberClient(pool.Get())
 	switch msg.Type {
 	case message.Request:
@@ -350,52 +1087,188 @@ func (h *LocalHandler) remoteProcess(session *session.Session, msg *message.Mess
 			Id:        msg.ID,
 			Route:     msg.Route,
 			Data:      data,
+			Uid:       session.UID(),
+			Timeout:   int64(msg.Timeout / time.Millisecond),
 		}
-		_, err = client.HandleRequest(context.Background(), request)
+		_, err = client.HandleRequest(ctx, request)
 	case message.Notify:
 		request := &clusterpb.NotifyMessage{
 			GateAddr:  gateAddr,
 			SessionId: sessionId,
 			Route:     msg.Route,
 			Data:      data,
+			Uid:       session.UID(),
 		}
-		_, err = client.HandleNotify(context.Background(), request)
+		_, err = client.HandleNotify(ctx, request)
 	}
+	return err
+}
+
+// headerMetadataKey is the key of the gRPC metadata carrying the header of the forwarded
+// message, which is binary to keep the case of the header keys
+const headerMetadataKey = "nano-header-bin"
+
+// headerFromMetadata returns the header of the forwarded message carried by the gRPC
+// metadata of the call, nil will be returned if the message has no header
+func headerFromMetadata(ctx context.Context) map[string]string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok || len(md[headerMetadataKey]) == 0 {
+		return nil
+	}
+	header, _, err := message.DecodeHeader([]byte(md[headerMetadataKey][0]))
 	if err != nil {
-		log.Println(fmt.Sprintf("Process remote message (%d:%s) error: %+v", msg.ID, msg.Route, err))
+		log.Println("Decode header of forwarded message failed", err)
+		return nil
 	}
+	return header
+}
+
+// handlerContext returns the context of the handler, which carries the header of the
+// message besides the values set by the inbound pipeline
+func handlerContext(msg *message.Message) context.Context {
+	ctx := msg.Context()
+	if len(msg.Header) > 0 {
+		ctx = session.WithHeader(ctx, msg.Header)
+	}
+	return ctx
+}
+
+// memberGone reports whether the error of forwarding indicates the member is not
+// reachable, e.g: it left the cluster after selected
+func memberGone(err error) bool {
+	if _, ok := status.FromError(err); !ok {
+		// failed to retrieve the connection pool, or the circuit breaker is open
+		return true
+	}
+	return status.Code(err) == codes.Unavailable
+}
+
+// idempotent reports whether the route is idempotent, by the exact route or the
+// service wildcard (e.g: "Room.*")
+func (h *LocalHandler) idempotent(route string) bool {
+	if h.currentNode == nil || len(h.currentNode.IdempotentRoutes) == 0 {
+		return false
+	}
+	routes := h.currentNode.IdempotentRoutes
+	if routes[route] {
+		return true
+	}
+	index := strings.LastIndex(route, ".")
+	return index >= 0 && routes[route[:index]+".*"]
 }
 
 func (h *LocalHandler) processMessage(agent *agent, msg *message.Message) {
+	if msg.Type == message.Request || msg.Type == message.Notify {
+		if held, overflow := agent.hold(msg); overflow {
+			log.Println(fmt.Sprintf("Drop message of paused session, SessionID=%d, UID=%d, Message={%s}",
+				agent.session.ID(), agent.session.UID(), msg.String()))
+			if msg.Type == message.Request {
+				if err := agent.responseError(msg.ID, msg.Route, codeUnavailable, "session paused"); err != nil {
+					log.Println(err.Error())
+				}
+			}
+			return
+		} else if held {
+			return
+		}
+	}
+	h.dispatchMessage(agent, msg)
+}
+
+// dispatchMessage dispatches the message to the handler, the messages of the paused
+// session are dispatched after resumed
+func (h *LocalHandler) dispatchMessage(agent *agent, msg *message.Message) {
 	var lastMid uint64
 	switch msg.Type {
 	case message.Request:
 		lastMid = msg.ID
+		if msg.Timeout > 0 {
+			agent.setDeadline(msg.ID, env.Clock.Now().Add(msg.Timeout))
+		}
+		if agent.dedup != nil {
+			if cached, ok := agent.dedup.begin(msg.ID); !ok {
+				// duplicate request, replay the response if it has been responded,
+				// otherwise the response of the previous one will be received
+				if cached != nil {
+					agent.send(*cached)
+				}
+				return
+			}
+		}
 	case message.Notify:
 		lastMid = 0
+	case message.Response:
+		// response of server initiated request
+		if !agent.onResponse(msg.ID, msg.Data) {
+			log.Println(fmt.Sprintf("Response to unknown request(id: %d), UID=%d", msg.ID, agent.session.UID()))
+		}
+		return
 	default:
 		log.Println("Invalid message type: " + msg.Type.String())
 		return
 	}
 
+	if !h.allowed(agent.session, msg.Route) {
+		log.Println(fmt.Sprintf("Route %s is not allowed for session, SessionID=%d, UID=%d",
+			msg.Route, agent.session.ID(), agent.session.UID()))
+		if msg.Type == message.Request {
+			if err := agent.responseError(msg.ID, msg.Route, codeForbidden, "permission denied"); err != nil {
+				log.Println(err.Error())
+			}
+		}
+		return
+	}
+	h.teeNotify(agent.session, msg)
+
 	handler, found := h.localHandlers[msg.Route]
 	if !found {
+		h.tracer.trace("in", agent.session, msg.Type, msg.Route, msg.ID, msg.Data)
 		h.remoteProcess(agent.session, msg, false)
 	} else {
 		h.localProcess(handler, lastMid, agent.session, msg)
 	}
 }
 
-func (h *LocalHandler) handleWS(conn *websocket.Conn) {
+// allowed checks the access control lists of the route, the lists of exact route
+// and the service wildcard (e.g: "Admin.*") both must be satisfied
+func (h *LocalHandler) allowed(s *session.Session, route string) bool {
+	if h.currentNode == nil || len(h.currentNode.RouteACL) == 0 {
+		return true
+	}
+	acl := h.currentNode.RouteACL
+	if allow, found := acl[route]; found && !allow(s) {
+		return false
+	}
+	if index := strings.LastIndex(route, "."); index >= 0 {
+		if allow, found := acl[route[:index]+".*"]; found && !allow(s) {
+			return false
+		}
+	}
+	return true
+}
+
+func (h *LocalHandler) handleWS(conn *websocket.Conn, uid int64) {
 	c, err := newWSConn(conn)
 	if err != nil {
 		log.Println(err)
 		return
 	}
-	go h.handle(c)
+	go h.handle(c, uid)
 }
 
 func (h *LocalHandler) localProcess(handler *component.Handler, lastMid uint64, session *session.Session, msg *message.Message) {
+	var member string
+	if h.currentNode != nil {
+		member = h.currentNode.ServiceAddr
+	}
+	metrics.Default.Counter(metricRouteDispatch, "route", msg.Route, "mode", "local", "member", member).Inc()
+
+	// the handler is skipped if the timeout of request elapsed before dispatched
+	var deadline time.Time
+	if msg.Type == message.Request && msg.Timeout > 0 {
+		deadline = env.Clock.Now().Add(msg.Timeout)
+	}
+
 	if pipe := h.pipeline; pipe != nil {
 		err := pipe.Inbound().Process(session, msg)
 		if err != nil {
@@ -404,15 +1277,43 @@ func (h *LocalHandler) localProcess(handler *component.Handler, lastMid uint64,
 		}
 	}
 
+	if msg.Type == message.Request {
+		if cached, ok := h.caches.lookup(session, msg.ID, msg.Route, msg.Data); ok && cached != nil {
+			if err := session.ResponseMID(msg.ID, cached); err != nil {
+				log.Println(err.Error())
+			}
+			return
+		}
+	}
+
 	var payload = msg.Data
 	var data interface{}
 	if handler.IsRawArg {
 		data = payload
 	} else {
+		serializer := env.Serializer
+		if a, ok := session.NetworkEntity().(*agent); ok {
+			serializer = a.payloadSerializer()
+		}
 		data = reflect.New(handler.Type.Elem()).Interface()
-		err := env.Serializer.Unmarshal(payload, data)
+		err := serializer.Unmarshal(payload, data)
 		if err != nil {
 			log.Println(fmt.Sprintf("Deserialize to %T failed: %+v (%v)", data, err, payload))
+			if a, ok := session.NetworkEntity().(*agent); ok {
+				h.codecError(a, err, CodecPhasePayload)
+			}
+			return
+		}
+	}
+
+	if h.currentNode != nil && h.currentNode.Validate != nil {
+		if err := h.currentNode.Validate(msg.Route, data); err != nil {
+			log.Println(fmt.Sprintf("Validate message failed, UID=%d, Route=%s, Error=%s", session.UID(), msg.Route, err.Error()))
+			if a, ok := session.NetworkEntity().(*agent); ok && msg.Type == message.Request {
+				if err := a.responseError(msg.ID, msg.Route, codeBadRequest, err.Error()); err != nil {
+					log.Println(err.Error())
+				}
+			}
 			return
 		}
 	}
@@ -420,14 +1321,36 @@ func (h *LocalHandler) localProcess(handler *component.Handler, lastMid uint64,
 	if env.Debug {
 		log.Println(fmt.Sprintf("UID=%d, Message={%s}, Data=%+v", session.UID(), msg.String(), data))
 	}
+	h.tracer.trace("in", session, msg.Type, msg.Route, msg.ID, data)
 
-	args := []reflect.Value{handler.Receiver, reflect.ValueOf(session), reflect.ValueOf(data)}
 	task := func() {
+		if !deadline.IsZero() && !env.Clock.Now().Before(deadline) {
+			metrics.Default.Counter(metricRequestExpired, "stage", "dispatch").Inc()
+			if env.Debug {
+				log.Println(fmt.Sprintf("Skip expired request, UID=%d, Message={%s}", session.UID(), msg.String()))
+			}
+			return
+		}
+
+		args := []reflect.Value{handler.Receiver}
+		if handler.Context {
+			// the context of handler is canceled when the timeout of request elapsed
+			ctx := handlerContext(msg)
+			if !deadline.IsZero() {
+				var cancel context.CancelFunc
+				// the deadline is of env.Clock, which may not be the wall clock
+				ctx, cancel = context.WithTimeout(ctx, deadline.Sub(env.Clock.Now()))
+				defer cancel()
+			}
+			args = append(args, reflect.ValueOf(ctx))
+		}
+		args = append(args, reflect.ValueOf(session), reflect.ValueOf(data))
+
 		switch v := session.NetworkEntity().(type) {
 		case *agent:
-			v.lastMid = lastMid
+			v.setLast(lastMid, msg.Route)
 		case *acceptor:
-			v.lastMid = lastMid
+			atomic.StoreUint64(&v.lastMid, lastMid)
 		}
 
 		result := handler.Method.Func.Call(args)
@@ -446,6 +1369,8 @@ func (h *LocalHandler) localProcess(handler *component.Handler, lastMid uint64,
 
 	// A message can be dispatch to global thread or a user customized thread
 	service := msg.Route[:index]
+	schedule := scheduler.PushTask
+	onDispatcher := true
 	if s, found := h.localServices[service]; found && s.SchedName != "" {
 		sched := session.Value(s.SchedName)
 		if sched == nil {
@@ -459,8 +1384,121 @@ func (h *LocalHandler) localProcess(handler *component.Handler, lastMid uint64,
 				sched))
 			return
 		}
-		local.Schedule(task)
-	} else {
-		scheduler.PushTask(task)
+		schedule = local.Schedule
+		onDispatcher = false
+	} else if pool, found := h.workers[service]; found {
+		schedule = pool.Schedule
+		onDispatcher = false
+	}
+	if onDispatcher {
+		task = h.watchdog.wrap(msg.Route, task)
+	}
+
+	// the messages of client connections are counted in the queue of session until handled
+	a, isAgent := session.NetworkEntity().(*agent)
+	if isAgent {
+		if !h.enqueue(a) {
+			return
+		}
+		handle := task
+		task = func() {
+			defer a.dequeue()
+			handle()
+		}
+	}
+
+	limiter, found := h.limiters[msg.Route]
+	if !found {
+		schedule(task)
+		return
+	}
+	if limiter.schedule(schedule, task) {
+		return
+	}
+	log.Println(fmt.Sprintf("Route %s is busy, UID=%d, Message={%s}", msg.Route, session.UID(), msg.String()))
+	if isAgent {
+		a.dequeue()
+		if msg.Type == message.Request {
+			if err := a.responseError(msg.ID, msg.Route, codeUnavailable, "route busy"); err != nil {
+				log.Println(err.Error())
+			}
+		}
+	}
+}
+
+// enqueue reserves a slot in the queue of the session, the session overflowing the
+// queue is kicked if SessionQueueKick, otherwise the reading of the connection waits
+// for a slot, which pushes back on the client
+func (h *LocalHandler) enqueue(a *agent) bool {
+	if a.enqueue(false) {
+		return true
+	}
+
+	var member string
+	if h.currentNode != nil {
+		member = h.currentNode.ServiceAddr
+	}
+	if h.currentNode != nil && h.currentNode.SessionQueueKick {
+		metrics.Default.Counter(metricSessionQueueFull, "member", member, "action", "kick").Inc()
+		log.Println(fmt.Sprintf("Session queue overflow, ID=%d, UID=%d, Depth=%d", a.session.ID(), a.session.UID(), a.QueueDepth()))
+		a.Kick(sessionQueueKickReason)
+		return false
+	}
+	metrics.Default.Counter(metricSessionQueueFull, "member", member, "action", "backpressure").Inc()
+	return a.enqueue(true)
+}
+
+// readError returns the close reason of the connection whose read failed, the clean
+// close of client is only logged in debug mode
+func (h *LocalHandler) readError(agent *agent, err error) session.CloseReason {
+	reason := readCloseReason(err)
+	if reason != session.ClientClosed {
+		log.Println(fmt.Sprintf("Read message error: %s, session will be closed immediately", err.Error()))
+	} else if env.Debug {
+		log.Println(fmt.Sprintf("Client closed connection, SessionID=%d, UID=%d", agent.session.ID(), agent.session.UID()))
+	}
+	return reason
+}
+
+// codecError counts the codec error of the connection and reports it to OnCodecError.
+// The connection is closed on the packet decode failure as the framing of the stream is
+// lost, and the messages failed to decode are dropped until the failures of connection
+// reached CodecErrorLimit, true will be returned if the connection should be closed, the
+// connection failed to decode the payloads is closed by it
+func (h *LocalHandler) codecError(a *agent, err error, phase string) bool {
+	var member string
+	limit := defaultCodecErrorLimit
+	if h.currentNode != nil {
+		member = h.currentNode.ServiceAddr
+		if h.currentNode.CodecErrorLimit > 0 {
+			limit = h.currentNode.CodecErrorLimit
+		}
+		if fn := h.currentNode.OnCodecError; fn != nil {
+			fn(a.session, err, phase)
+		}
+	}
+	metrics.Default.Counter(metricCodecErrors, "phase", phase, "member", member).Inc()
+
+	switch phase {
+	case CodecPhasePacket:
+		return true
+	case CodecPhaseEncode:
+		return false
+	}
+	if int(atomic.AddInt32(&a.decodeFailures, 1)) < limit {
+		return false
+	}
+	if phase == CodecPhasePayload {
+		// the payloads are decoded out of the read loop, close the connection here
+		log.Println(fmt.Sprintf("Too many decode failures, session will be closed immediately, UID=%d", a.session.UID()))
+		a.close(session.ReadError)
+	}
+	return true
+}
+
+// closeWorkers stops the dedicated worker pools after the queued tasks completed
+func (h *LocalHandler) closeWorkers() {
+	for _, pool := range h.workers {
+		pool.Close()
 	}
 }
@@ -0,0 +1,89 @@
+package cluster_test
+
+import (
+	"strings"
+
+	"github.com/lonng/nano/cluster"
+	"github.com/lonng/nano/component"
+	"github.com/lonng/nano/session"
+	. "github.com/pingcap/check"
+)
+
+type handlerSuite struct{}
+
+var _ = Suite(&handlerSuite{})
+
+type (
+	RoomComponent struct{ component.Base }
+	ChatComponent struct{ component.Base }
+)
+
+func (c *RoomComponent) Send(session *session.Session, _ []byte) error { return nil }
+func (c *ChatComponent) Send(session *session.Session, _ []byte) error { return nil }
+
+func (s *handlerSuite) TestRegisterConflictRoute(c *C) {
+	comps := &component.Components{}
+	comps.Register(&RoomComponent{}, component.WithName("Room.Chat"))
+	comps.Register(&ChatComponent{}, component.WithName("Room"), component.WithNameFunc(func(name string) string {
+		return "Chat." + name
+	}))
+	node := &cluster.Node{
+		Options:     cluster.Options{Components: comps},
+		ServiceAddr: "127.0.0.1:34450",
+	}
+	err := node.Startup()
+	c.Assert(err, NotNil)
+	c.Assert(strings.Contains(err.Error(), "Room.Chat.Send"), IsTrue)
+	c.Assert(strings.Contains(err.Error(), "RoomComponent"), IsTrue)
+	c.Assert(strings.Contains(err.Error(), "ChatComponent"), IsTrue)
+}
+
+func (s *handlerSuite) TestRegisterDuplicateService(c *C) {
+	comps := &component.Components{}
+	comps.Register(&RoomComponent{}, component.WithName("Lobby"))
+	comps.Register(&ChatComponent{}, component.WithName("Lobby"))
+	node := &cluster.Node{
+		Options:     cluster.Options{Components: comps},
+		ServiceAddr: "127.0.0.1:34451",
+	}
+	err := node.Startup()
+	c.Assert(err, NotNil)
+	c.Assert(strings.Contains(err.Error(), "RoomComponent"), IsTrue)
+	c.Assert(strings.Contains(err.Error(), "ChatComponent"), IsTrue)
+}
+
+type TypoComponent struct{ component.Base }
+
+func (c *TypoComponent) Send(session *session.Session, _ []byte) error { return nil }
+
+// Join takes the payload by value, which is not a handler
+func (c *TypoComponent) Join(session *session.Session, _ RoomComponent) error { return nil }
+
+// Name is a helper which is not intended to be a handler
+func (c *TypoComponent) Name() string { return "typo" }
+
+func (s *handlerSuite) TestStrictHandlerRegistration(c *C) {
+	comps := &component.Components{}
+	comps.Register(&TypoComponent{})
+	node := &cluster.Node{
+		Options:     cluster.Options{Components: comps, StrictHandlerRegistration: true},
+		ServiceAddr: "127.0.0.1:34452",
+	}
+	err := node.Startup()
+	c.Assert(err, ErrorMatches, "handler: method Join of component \\*cluster_test.TypoComponent has unsupported signature: "+
+		"the payload argument must be \\[\\]byte or a pointer, got cluster_test.RoomComponent")
+
+	// the rejected method is skipped by default
+	node = &cluster.Node{
+		Options:     cluster.Options{Components: comps},
+		ServiceAddr: "127.0.0.1:34453",
+	}
+	c.Assert(node.Startup(), IsNil)
+	defer node.Shutdown()
+	routes := map[string]bool{}
+	for _, r := range node.Handler().RouteTable().Routes {
+		routes[r.Route] = true
+	}
+	c.Assert(routes["TypoComponent.Send"], IsTrue)
+	c.Assert(routes["TypoComponent.Join"], IsFalse)
+}
@@ -0,0 +1,317 @@
+package cluster
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/lonng/nano/component"
+	"github.com/lonng/nano/internal/env"
+	"github.com/lonng/nano/internal/log"
+)
+
+// envHotRestart lists the kinds of the listeners handed to the new process, e.g:
+// "client,client,service", which are passed as the files from fd 3 onwards in order,
+// followed by the pipe which is written once the new process is ready
+const envHotRestart = "NANO_HOT_RESTART"
+
+const (
+	// hotRestartReadyTimeout is the time that the new process starts up in, it is
+	// killed and the old process keeps serving if not ready in time
+	hotRestartReadyTimeout = time.Minute
+	// hotRestartDrainInterval is the interval that the old process checks whether its
+	// sessions have closed
+	hotRestartDrainInterval = 100 * time.Millisecond
+)
+
+const (
+	listenerClient  = "client"
+	listenerService = "service"
+)
+
+// inheritedListeners holds the listeners inherited from the old process by hot restart,
+// which are taken by the listen functions instead of listening again
+type inheritedListeners struct {
+	client  []net.Listener
+	service net.Listener
+	pipe    *os.File // notifies the old process of readiness
+}
+
+// inheritListeners returns the listeners handed by the old process, nil if current
+// process is not started by hot restart
+func inheritListeners() (*inheritedListeners, error) {
+	kinds := os.Getenv(envHotRestart)
+	if kinds == "" {
+		return nil, nil
+	}
+	// the processes started by current process do not inherit the files
+	os.Unsetenv(envHotRestart)
+
+	inherited := &inheritedListeners{}
+	names := strings.Split(kinds, ",")
+	for i, name := range names {
+		f := os.NewFile(uintptr(3+i), name)
+		listener, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			inherited.close()
+			return nil, fmt.Errorf("inherit %s listener failed: %v", name, err)
+		}
+		switch name {
+		case listenerClient:
+			inherited.client = append(inherited.client, listener)
+		case listenerService:
+			inherited.service = listener
+		default:
+			listener.Close()
+		}
+	}
+	inherited.pipe = os.NewFile(uintptr(3+len(names)), "ready")
+	return inherited, nil
+}
+
+func (i *inheritedListeners) takeClient() net.Listener {
+	if i == nil || len(i.client) == 0 {
+		return nil
+	}
+	listener := i.client[0]
+	i.client = i.client[1:]
+	return listener
+}
+
+func (i *inheritedListeners) takeService() net.Listener {
+	if i == nil {
+		return nil
+	}
+	listener := i.service
+	i.service = nil
+	return listener
+}
+
+// ready notifies the old process that current process serves the clients, and closes
+// the listeners not taken, e.g: the old process bound more listeners by ReusePort
+func (i *inheritedListeners) ready() {
+	if _, err := i.pipe.Write([]byte{1}); err != nil {
+		log.Println("Notify the old process of readiness failed", err)
+	}
+	i.close()
+}
+
+func (i *inheritedListeners) close() {
+	for _, listener := range i.client {
+		listener.Close()
+	}
+	i.client = nil
+	if i.service != nil {
+		i.service.Close()
+		i.service = nil
+	}
+	if i.pipe != nil {
+		i.pipe.Close()
+		i.pipe = nil
+	}
+}
+
+// Upgrade starts a new process of the executable with the same arguments, and hands the
+// client and service listeners to it, e.g: after the binary replaced. Once the new process
+// started up, current node refuses the new client connections, which are accepted by the
+// new process, and drains its sessions before shutting down. Current node keeps serving
+// if the new process failed to start up, and the error is returned
+func (n *Node) Upgrade() error {
+	if !n.EnableHotRestart {
+		return ErrHotRestartDisabled
+	}
+	if n.IsMaster {
+		return ErrHotRestartMaster
+	}
+	if n.isShutdown() || !atomic.CompareAndSwapInt32(&n.upgrading, 0, 1) {
+		return ErrUpgrading
+	}
+
+	pid, err := n.handOver()
+	if err != nil {
+		atomic.StoreInt32(&n.upgrading, 0)
+		return err
+	}
+
+	atomic.StoreInt32(&n.draining, 1)
+	atomic.StoreInt32(&n.upgraded, 1)
+	for _, listener := range n.clientListeners {
+		listener.Close()
+	}
+	log.Println(fmt.Sprintf("Listeners are handed to the new process %d, draining the sessions", pid))
+	go n.drainUpgraded()
+	return nil
+}
+
+// handOver starts the new process with the listeners, and waits until it is ready
+func (n *Node) handOver() (int, error) {
+	var (
+		kinds []string
+		files []*os.File
+	)
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	add := func(kind string, listener net.Listener) error {
+		l, ok := listener.(interface{ File() (*os.File, error) })
+		if !ok {
+			return fmt.Errorf("%s listener %s cannot be handed over", kind, listener.Addr())
+		}
+		f, err := l.File()
+		if err != nil {
+			return err
+		}
+		kinds = append(kinds, kind)
+		files = append(files, f)
+		return nil
+	}
+	for _, listener := range n.clientListeners {
+		if err := add(listenerClient, listener); err != nil {
+			return 0, err
+		}
+	}
+	if n.serviceListener != nil {
+		if err := add(listenerService, n.serviceListener); err != nil {
+			return 0, err
+		}
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		return 0, err
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+
+	// the write end is closed once started, the read end gets EOF if the new process exited
+	fds := []uintptr{os.Stdin.Fd(), os.Stdout.Fd(), os.Stderr.Fd()}
+	for _, f := range append(files, w) {
+		fd, err := fileFd(f)
+		if err != nil {
+			w.Close()
+			return 0, err
+		}
+		fds = append(fds, fd)
+	}
+	pid, _, err := syscall.StartProcess(executable, os.Args, &syscall.ProcAttr{
+		Env:   append(environ(), envHotRestart+"="+strings.Join(kinds, ",")),
+		Files: fds,
+	})
+	w.Close()
+	if err != nil {
+		return 0, err
+	}
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return 0, err
+	}
+
+	exited := make(chan error, 1)
+	go func() {
+		state, err := process.Wait()
+		if err == nil {
+			err = errors.New(state.String())
+		}
+		exited <- err
+	}()
+	ready := make(chan error, 1)
+	go func() {
+		_, err := r.Read(make([]byte, 1))
+		ready <- err
+	}()
+
+	select {
+	case err := <-ready:
+		if err != nil {
+			// the pipe is closed without notification if the new process exited
+			return 0, fmt.Errorf("new process is not ready: %v", err)
+		}
+	case err := <-exited:
+		return 0, fmt.Errorf("new process exited: %v", err)
+	case <-env.Clock.After(hotRestartReadyTimeout):
+		process.Kill()
+		return 0, errors.New("new process is not ready in time")
+	}
+	return pid, nil
+}
+
+// fileFd returns the descriptor of f, unlike f.Fd it keeps the non-blocking mode, which
+// is shared by the listener of current process duplicated to f
+func fileFd(f *os.File) (uintptr, error) {
+	rc, err := f.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+	var fd uintptr
+	if err := rc.Control(func(s uintptr) { fd = s }); err != nil {
+		return 0, err
+	}
+	return fd, nil
+}
+
+// environ returns the environment of current process without the listeners inherited
+func environ() []string {
+	var envs []string
+	for _, e := range os.Environ() {
+		if !strings.HasPrefix(e, envHotRestart+"=") {
+			envs = append(envs, e)
+		}
+	}
+	return envs
+}
+
+// drainUpgraded shuts down current node once its sessions closed or the drain timeout
+// elapsed after the listeners handed to the new process
+func (n *Node) drainUpgraded() {
+	var timeout <-chan time.Time
+	if n.HotRestartDrainTimeout > 0 {
+		timeout = env.Clock.After(n.HotRestartDrainTimeout)
+	}
+	ticker := env.Clock.NewTicker(hotRestartDrainInterval)
+	defer ticker.Stop()
+
+DRAIN:
+	for n.clientCount() > 0 {
+		select {
+		case <-ticker.C():
+		case <-timeout:
+			log.Println("Hot restart drain timeout, sessions are still open", n.clientCount())
+			break DRAIN
+		case <-n.chDie:
+			return
+		}
+	}
+
+	n.ShutdownContext(component.WithShutdownReason(context.Background(), component.ShutdownUpgrade))
+}
+
+// isUpgraded reports whether the listeners have been handed to a new process
+func (n *Node) isUpgraded() bool {
+	return atomic.LoadInt32(&n.upgraded) == 1
+}
+
+// clientCount returns the number of the client connections open on current node
+func (n *Node) clientCount() int {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+
+	var count int
+	for _, s := range n.sessions {
+		if a, ok := s.NetworkEntity().(*agent); ok && a.status() != statusClosed {
+			count++
+		}
+	}
+	return count
+}
@@ -0,0 +1,190 @@
+package cluster
+
+import (
+	"bufio"
+	"net"
+	"os"
+	goruntime "runtime"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/lonng/nano/component"
+	"github.com/lonng/nano/internal/codec"
+	"github.com/lonng/nano/internal/message"
+	"github.com/lonng/nano/internal/packet"
+	"github.com/lonng/nano/scheduler"
+	"github.com/lonng/nano/session"
+)
+
+type HotRestartComponent struct {
+	component.Base
+	served chan struct{}
+}
+
+func (c *HotRestartComponent) Pid(s *session.Session, _ []byte) error {
+	defer close(c.served)
+	return s.Response([]byte(strconv.Itoa(os.Getpid())))
+}
+
+// TestHotRestartChild is the new process started by TestHotRestart, it serves a client
+// on the inherited listener and exits once the client closed
+func TestHotRestartChild(t *testing.T) {
+	if os.Getenv(envHotRestart) == "" {
+		t.Skip("started by TestHotRestart only")
+	}
+	go scheduler.Sched()
+	defer scheduler.Close()
+
+	comp := &HotRestartComponent{served: make(chan struct{})}
+	comps := &component.Components{}
+	comps.Register(comp)
+	node := &Node{
+		Options: Options{
+			EnableHotRestart: true,
+			ClientAddr:       "127.0.0.1:0",
+			Components:       comps,
+		},
+		ServiceAddr: "127.0.0.1:0",
+	}
+	if err := node.Startup(); err != nil {
+		t.Fatal(err)
+	}
+	defer node.Shutdown()
+
+	select {
+	case <-comp.served:
+	case <-time.After(10 * time.Second):
+		t.Fatal("no client served by the new process")
+	}
+	for deadline := time.Now().Add(5 * time.Second); node.clientCount() > 0 && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestHotRestart(t *testing.T) {
+	if goruntime.GOOS == "windows" {
+		t.Skip("hot restart is unsupported on windows")
+	}
+
+	node := &Node{
+		Options: Options{
+			EnableHotRestart: true,
+			ClientAddr:       "127.0.0.1:0",
+			Components:       &component.Components{},
+		},
+		ServiceAddr: "127.0.0.1:0",
+	}
+	if err := node.Startup(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the new process runs the child test only
+	args := os.Args
+	os.Args = []string{args[0], "-test.run=^TestHotRestartChild$"}
+	err := node.Upgrade()
+	os.Args = args
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := node.Upgrade(); err != ErrUpgrading {
+		t.Fatalf("upgrade again should fail, got %v", err)
+	}
+
+	// the old node shuts down since it has no clients to drain
+	select {
+	case <-node.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("old node is not shut down after upgrade")
+	}
+
+	// the listener handed over is served by the new process
+	conn, err := net.Dial("tcp", node.BoundClientAddr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	reader := bufio.NewReader(conn)
+	decoder := codec.NewDecoder()
+	send := func(typ packet.Type, data []byte) {
+		p, err := codec.Encode(typ, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := conn.Write(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	recv := func() *packet.Packet {
+		buf := make([]byte, 1024)
+		for {
+			n, err := reader.Read(buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			packets, err := decoder.Decode(buf[:n])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(packets) > 0 {
+				return packets[0]
+			}
+		}
+	}
+
+	send(packet.Handshake, []byte(`{"sys":{}}`))
+	if p := recv(); p.Type != packet.Handshake {
+		t.Fatalf("unexpected handshake response %v", p)
+	}
+	send(packet.HandshakeAck, nil)
+	m, err := message.Encode(&message.Message{Type: message.Request, ID: 1, Route: "HotRestartComponent.Pid", Data: []byte("?")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	send(packet.Data, m)
+	res, err := message.Decode(recv().Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pid := string(res.Data); pid == "" || pid == strconv.Itoa(os.Getpid()) {
+		t.Fatalf("unexpected pid %q of the new process", pid)
+	}
+}
+
+func TestNodeRemoveClosedSession(t *testing.T) {
+	node := &Node{
+		Options: Options{
+			ClientAddr: "127.0.0.1:0",
+			Components: &component.Components{},
+		},
+		ServiceAddr: "127.0.0.1:0",
+	}
+	if err := node.Startup(); err != nil {
+		t.Fatal(err)
+	}
+	defer node.Shutdown()
+
+	sessions := func() int {
+		node.mu.RLock()
+		defer node.mu.RUnlock()
+		return len(node.sessions)
+	}
+	wait := func(expect int) {
+		for deadline := time.Now().Add(5 * time.Second); sessions() != expect; {
+			if time.Now().After(deadline) {
+				t.Fatalf("expect %d sessions, got %d", expect, sessions())
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	conn, err := net.Dial("tcp", node.BoundClientAddr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	wait(1)
+	conn.Close()
+	wait(0)
+}
@@ -0,0 +1,102 @@
+package cluster
+
+import (
+	"sync"
+
+	"github.com/lonng/nano/metrics"
+)
+
+// Metrics of the forwards limited by member, labeled by the target address,
+// nano_forward_rejected_total counts the forwards rejected by a full queue
+const (
+	metricForwardInflight = "nano_forward_inflight"
+	metricForwardQueued   = "nano_forward_queued"
+	metricForwardRejected = "nano_forward_rejected_total"
+)
+
+type (
+	// inflightLimiter caps the forwards in flight to each member, the forwards beyond the
+	// cap wait in a bounded queue of the member and the ones overflowing the queue fail
+	// fast, so a slow member sheds the load instead of piling up the forwards. It is nil
+	// unless the cap configured
+	inflightLimiter struct {
+		limit   int
+		backlog int
+
+		mu      sync.Mutex
+		targets map[string]*inflightTarget
+	}
+
+	inflightTarget struct {
+		slots chan struct{} // holds a token for each forward in flight
+
+		mu     sync.Mutex
+		queued int
+
+		inflight    *metrics.Gauge
+		queuedGauge *metrics.Gauge
+		rejected    *metrics.Counter
+	}
+)
+
+func newInflightLimiter(limit, backlog int) *inflightLimiter {
+	return &inflightLimiter{
+		limit:   limit,
+		backlog: backlog,
+		targets: map[string]*inflightTarget{},
+	}
+}
+
+func (l *inflightLimiter) target(addr string) *inflightTarget {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	t, found := l.targets[addr]
+	if !found {
+		t = &inflightTarget{
+			slots:       make(chan struct{}, l.limit),
+			inflight:    metrics.Default.Gauge(metricForwardInflight, "target", addr),
+			queuedGauge: metrics.Default.Gauge(metricForwardQueued, "target", addr),
+			rejected:    metrics.Default.Counter(metricForwardRejected, "target", addr),
+		}
+		l.targets[addr] = t
+	}
+	return t
+}
+
+// acquire reserves a slot of the member for a forward, it waits in the queue if the
+// member is saturated, false will be returned if the queue is full as well. The slot
+// must be released after the forward completed
+func (l *inflightLimiter) acquire(addr string) (release func(), ok bool) {
+	if l == nil {
+		return func() {}, true
+	}
+
+	t := l.target(addr)
+	select {
+	case t.slots <- struct{}{}:
+	default:
+		t.mu.Lock()
+		if t.queued >= l.backlog {
+			t.mu.Unlock()
+			t.rejected.Inc()
+			return nil, false
+		}
+		t.queued++
+		t.queuedGauge.Set(int64(t.queued))
+		t.mu.Unlock()
+
+		t.slots <- struct{}{}
+
+		t.mu.Lock()
+		t.queued--
+		t.queuedGauge.Set(int64(t.queued))
+		t.mu.Unlock()
+	}
+	t.inflight.Add(1)
+
+	return func() {
+		t.inflight.Add(-1)
+		<-t.slots
+	}, true
+}
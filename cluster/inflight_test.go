@@ -0,0 +1,67 @@
+package cluster
+
+import (
+	"testing"
+	"time"
+)
+
+func TestInflightLimiter(t *testing.T) {
+	l := newInflightLimiter(1, 1)
+	addr := "127.0.0.1:4900"
+	rejected := l.target(addr).rejected.Value()
+
+	release, ok := l.acquire(addr)
+	if !ok {
+		t.Fatal("first forward should acquire the slot")
+	}
+
+	acquired := make(chan func(), 1)
+	go func() {
+		release, ok := l.acquire(addr)
+		if ok {
+			acquired <- release
+		}
+	}()
+	target := l.target(addr)
+	for queued := 0; queued != 1; {
+		time.Sleep(time.Millisecond)
+		target.mu.Lock()
+		queued = target.queued
+		target.mu.Unlock()
+	}
+	if _, ok := l.acquire(addr); ok {
+		t.Fatal("third forward should be rejected by the full queue")
+	}
+	if target.inflight.Value() != 1 || target.rejected.Value()-rejected != 1 {
+		t.Fatalf("unexpected metrics, inflight=%d, rejected=%d", target.inflight.Value(), target.rejected.Value()-rejected)
+	}
+
+	// the other members are not affected
+	if release, ok := l.acquire("127.0.0.1:4901"); !ok {
+		t.Fatal("forward to another member should acquire the slot")
+	} else {
+		release()
+	}
+
+	release()
+	select {
+	case release = <-acquired:
+	case <-time.After(time.Second):
+		t.Fatal("queued forward should acquire the released slot")
+	}
+	if target.inflight.Value() != 1 || target.queuedGauge.Value() != 0 {
+		t.Fatalf("unexpected metrics, inflight=%d, queued=%d", target.inflight.Value(), target.queuedGauge.Value())
+	}
+	release()
+	if target.inflight.Value() != 0 {
+		t.Fatalf("expect no forward in flight, got %d", target.inflight.Value())
+	}
+
+	// nil limiter is unlimited
+	var unlimited *inflightLimiter
+	if release, ok := unlimited.acquire(addr); !ok {
+		t.Fatal("unlimited forward should never be rejected")
+	} else {
+		release()
+	}
+}
@@ -0,0 +1,60 @@
+package cluster
+
+import "time"
+
+// packetLimiter limits the packets of a connection in a fixed window, it is only
+// used by the read goroutine of the connection
+type packetLimiter struct {
+	limit   int
+	window  time.Duration
+	start   time.Time
+	packets int
+}
+
+func newPacketLimiter(limit int, window time.Duration) *packetLimiter {
+	return &packetLimiter{limit: limit, window: window}
+}
+
+// allow records a packet, it returns false if the packets exceed the limit in the
+// current window
+func (l *packetLimiter) allow(now time.Time) bool {
+	if now.Sub(l.start) >= l.window {
+		l.start = now
+		l.packets = 0
+	}
+	l.packets++
+	return l.packets <= l.limit
+}
+
+// byteLimiter limits the bytes read from a connection by a token bucket, which allows
+// bursts up to the capacity, it is only used by the read goroutine of the connection
+type byteLimiter struct {
+	rate   float64 // tokens refilled per second
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newByteLimiter(rate, burst int) *byteLimiter {
+	if burst < rate {
+		burst = rate
+	}
+	return &byteLimiter{rate: float64(rate), burst: float64(burst), tokens: float64(burst)}
+}
+
+// reserve takes n bytes from the bucket, it returns the time to wait until the bucket
+// refilled the deficit, zero if the bytes are within the rate
+func (l *byteLimiter) reserve(n int, now time.Time) time.Duration {
+	if !l.last.IsZero() {
+		l.tokens += now.Sub(l.last).Seconds() * l.rate
+		if l.tokens > l.burst {
+			l.tokens = l.burst
+		}
+	}
+	l.last = now
+	l.tokens -= float64(n)
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / l.rate * float64(time.Second))
+}
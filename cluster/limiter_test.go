@@ -0,0 +1,29 @@
+package cluster
+
+import (
+	"testing"
+	"time"
+)
+
+func TestByteLimiter(t *testing.T) {
+	now := time.Now()
+	l := newByteLimiter(1000, 0)
+
+	// the burst is one second of the rate at least
+	if wait := l.reserve(1000, now); wait != 0 {
+		t.Fatalf("expect no wait within the burst, got %v", wait)
+	}
+	if wait := l.reserve(500, now); wait != 500*time.Millisecond {
+		t.Fatalf("expect 500ms to refill the deficit, got %v", wait)
+	}
+
+	// the bucket is refilled by the elapsed time
+	now = now.Add(time.Second)
+	if wait := l.reserve(500, now); wait != 0 {
+		t.Fatalf("expect no wait after refilled, got %v", wait)
+	}
+	now = now.Add(time.Hour)
+	if wait := l.reserve(1001, now); wait != time.Millisecond {
+		t.Fatalf("expect the refill capped by burst, got %v", wait)
+	}
+}
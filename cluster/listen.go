@@ -0,0 +1,53 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"syscall"
+
+	"github.com/lonng/nano/internal/log"
+)
+
+// listenClient opens the client listener by the control function and the backlog of
+// the options, a backlog which cannot be applied is logged instead of failing
+func (n *Node) listenClient() (net.Listener, error) {
+	if listener := n.inherited.takeClient(); listener != nil {
+		return listener, nil
+	}
+	control := n.ListenControl
+	if n.ReusePort {
+		control = func(network, address string, c syscall.RawConn) error {
+			var serr error
+			if err := c.Control(func(fd uintptr) { serr = setReusePort(fd) }); err != nil {
+				return err
+			}
+			if serr != nil {
+				return serr
+			}
+			if n.ListenControl != nil {
+				return n.ListenControl(network, address, c)
+			}
+			return nil
+		}
+	}
+	lc := net.ListenConfig{Control: control}
+	listener, err := lc.Listen(context.Background(), "tcp", n.ClientAddr)
+	if err != nil {
+		return nil, err
+	}
+	if n.ListenBacklog > 0 {
+		if err := setBacklog(listener, n.ListenBacklog); err != nil {
+			log.Println(fmt.Sprintf("Set listen backlog %d failed: %v", n.ListenBacklog, err))
+		}
+	}
+	return listener, nil
+}
+
+// listenService opens the service listener, which is inherited by hot restart if any
+func (n *Node) listenService() (net.Listener, error) {
+	if listener := n.inherited.takeService(); listener != nil {
+		return listener, nil
+	}
+	return net.Listen("tcp", n.ServiceAddr)
+}
@@ -0,0 +1,111 @@
+package cluster
+
+import (
+	"net"
+	"runtime"
+	"syscall"
+	"testing"
+)
+
+func TestListenClient(t *testing.T) {
+	var controlled string
+	n := &Node{
+		Options: Options{
+			ClientAddr:    "127.0.0.1:0",
+			ListenBacklog: 4096,
+			ListenControl: func(network, address string, c syscall.RawConn) error {
+				controlled = network + " " + address
+				return nil
+			},
+		},
+	}
+	listener, err := n.listenClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	if controlled != "tcp4 127.0.0.1:0" {
+		t.Fatalf("control function should be called before bind, got %q", controlled)
+	}
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	accepted, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	accepted.Close()
+}
+
+func TestListenClientReusePort(t *testing.T) {
+	n := &Node{Options: Options{ClientAddr: "127.0.0.1:0", ReusePort: true}}
+	first, err := n.listenClient()
+	if err != nil {
+		t.Skip(err)
+	}
+	defer first.Close()
+
+	n.ClientAddr = first.Addr().String()
+	second, err := n.listenClient()
+	if err != nil {
+		t.Fatalf("listeners should share the port, got %v", err)
+	}
+	defer second.Close()
+	if second.Addr().String() != first.Addr().String() {
+		t.Fatalf("listeners should bind %s, got %s", first.Addr(), second.Addr())
+	}
+}
+
+func BenchmarkAccept(b *testing.B) {
+	b.Run("Single", func(b *testing.B) { benchmarkAccept(b, false) })
+	b.Run("ReusePort", func(b *testing.B) { benchmarkAccept(b, true) })
+}
+
+// benchmarkAccept measures the rate of the connects accepted by an accept loop per
+// GOMAXPROCS with reuse port, or by a single accept loop
+func benchmarkAccept(b *testing.B, reusePort bool) {
+	n := &Node{Options: Options{ClientAddr: "127.0.0.1:0", ReusePort: reusePort, ListenBacklog: 4096}}
+	loops := 1
+	if reusePort {
+		loops = runtime.GOMAXPROCS(0)
+	}
+
+	var listeners []net.Listener
+	defer func() {
+		for _, listener := range listeners {
+			listener.Close()
+		}
+	}()
+	for i := 0; i < loops; i++ {
+		listener, err := n.listenClient()
+		if err != nil {
+			b.Skip(err)
+		}
+		n.ClientAddr = listener.Addr().String()
+		listeners = append(listeners, listener)
+		go func() {
+			for {
+				conn, err := listener.Accept()
+				if err != nil {
+					return
+				}
+				conn.Close()
+			}
+		}()
+	}
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			conn, err := net.Dial("tcp", n.ClientAddr)
+			if err != nil {
+				b.Error(err)
+				return
+			}
+			conn.Close()
+		}
+	})
+}
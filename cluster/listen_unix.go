@@ -0,0 +1,30 @@
+// +build !windows
+
+package cluster
+
+import (
+	"errors"
+	"net"
+	"syscall"
+)
+
+// setBacklog calls listen(2) again on the listening socket, which replaces the backlog
+// passed by net.Listen. The backlog is still capped by the system, e.g: net.core.somaxconn
+// on Linux and kern.ipc.somaxconn on BSD and macOS
+func setBacklog(listener net.Listener, backlog int) error {
+	tl, ok := listener.(*net.TCPListener)
+	if !ok {
+		return errors.New("not a tcp listener")
+	}
+	rc, err := tl.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var lerr error
+	if err := rc.Control(func(fd uintptr) {
+		lerr = syscall.Listen(int(fd), backlog)
+	}); err != nil {
+		return err
+	}
+	return lerr
+}
@@ -0,0 +1,12 @@
+package cluster
+
+import (
+	"errors"
+	"net"
+)
+
+// setBacklog is unsupported on Windows, where calling listen on a listening socket does
+// not change its backlog
+func setBacklog(_ net.Listener, _ int) error {
+	return errors.New("listen backlog unsupported on windows")
+}
@@ -22,26 +22,68 @@ package cluster
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"net"
 	"net/http"
+	"runtime"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/gorilla/websocket"
 	"github.com/lonng/nano/cluster/clusterpb"
+	pcodec "github.com/lonng/nano/codec"
 	"github.com/lonng/nano/component"
+	"github.com/lonng/nano/internal/codec"
 	"github.com/lonng/nano/internal/env"
 	"github.com/lonng/nano/internal/log"
 	"github.com/lonng/nano/internal/message"
 	"github.com/lonng/nano/pipeline"
 	"github.com/lonng/nano/scheduler"
+	"github.com/lonng/nano/serialize"
 	"github.com/lonng/nano/session"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/status"
 )
 
+// defaultKickReason is the reason of kicking the older session of an uid
+const defaultKickReason = "logged in elsewhere"
+
+// Authenticator validates the handshake data of a client, e.g: the token in the user
+// data, and returns the uid which will be bound to the session. The connection will
+// be rejected if an error is returned. It is called in the goroutine of connection,
+// so it can call an external auth service without blocking the other clients
+type Authenticator func(ctx context.Context, handshake []byte) (uid int64, err error)
+
+// Localizer translates the message generated by server into the locale of the client,
+// the key is the message in English, which may be a format with the args, e.g: the kick
+// reasons and the error responses. The key should be returned formatted if the locale
+// or the key is unknown
+type Localizer func(locale, key string, args ...interface{}) string
+
+func localize(localizer Localizer, locale, key string, args ...interface{}) string {
+	if localizer != nil {
+		return localizer(locale, key, args...)
+	}
+	if len(args) > 0 {
+		return fmt.Sprintf(key, args...)
+	}
+	return key
+}
+
+// Balancer returns the service address of the member serving the service for the session,
+// the members are the ones providing the service, whose labels are in MemberInfo.Labels
+type Balancer func(s *session.Session, service string, members []*clusterpb.MemberInfo) string
+
 // Options contains some configurations for current node
 type Options struct {
 	Pipeline       pipeline.Pipeline
@@ -54,14 +96,355 @@ type Options struct {
 	IsWebsocket    bool
 	TSLCertificate string
 	TSLKey         string
+
+	// SessionLinger is the window that the state of a closed session which has bound
+	// an uid is retained in the linger store of current node, a new session binding
+	// the same uid can take it back by session.Reattach within the window. Zero
+	// disables linger, the state is released as soon as the session closed
+	SessionLinger time.Duration
+
+	// IsGateway marks current node as a gateway which holds the client sessions and
+	// forwards the messages to the backend members, it registers with the master but
+	// never advertises the services, so the other members never route to it. The
+	// components of a gateway are optional and only serve its own clients
+	IsGateway bool
+
+	// Authenticator authenticates the clients during the handshake, the sessions are
+	// created pre-authenticated, AuthTimeout bounds the authentication if positive
+	Authenticator Authenticator
+	AuthTimeout   time.Duration
+
+	// Localizer localizes the messages generated by server for the client by the locale
+	// of the session, which is set from sys.locale of the handshake. The messages are
+	// sent in English if nil
+	Localizer Localizer
+
+	// Serializers are the serializers can be negotiated by clients in the handshake by
+	// name, the application serializer is used if none of the offered is registered.
+	// The negotiated serializer applies to the local handlers of the gate node only
+	Serializers map[string]serialize.Serializer
+
+	// ShardKey extracts the shard key of the messages forwarded to the remote members,
+	// the messages of the same key are routed to the same member by consistent hashing
+	ShardKey ShardKeyFunc
+	// ShardVirtualNodes is the virtual nodes per member of the hash ring, 160 if not positive
+	ShardVirtualNodes int
+
+	// MemberLabels are advertised in the member info of current node, e.g: the region or
+	// zone, which are available to the Balancer of the other members
+	MemberLabels map[string]string
+
+	// Balancer selects the member of a service for the session which has not bound to one,
+	// e.g: the member in the region of the player by the member labels, the member will be
+	// bound to the session. A random member is selected if nil or an empty address returned
+	Balancer Balancer
+
+	// RegistrationMode is RegistrationStrict by default, which aborts the startup once a
+	// component failed to register. In RegistrationLenient the failed components are skipped
+	// and Startup returns a *RegistrationError listing them after the node started up
+	RegistrationMode RegistrationMode
+
+	// StrictHandlerRegistration fails the startup if a component method taking a session
+	// argument has an unsupported handler signature, which is only logged by default
+	StrictHandlerRegistration bool
+
+	// SingleSessionPerUID limits an uid to one active connection in the cluster, binding
+	// an uid which has been bound by another session kicks the older one with KickReason
+	SingleSessionPerUID bool
+	KickReason          string
+
+	// Observe makes current node an observer member if not nil, which registers with
+	// the master but never serves the routes, the copies of teed traffic are passed to it
+	Observe func(*Observation)
+
+	// TeeRoutes are the notify and broadcast routes whose copies are sent to the observer
+	// members, a route can be a full route or a service wildcard like "Chat.*"
+	TeeRoutes []string
+
+	// RawMode skips the handshake and heartbeat, the session is created on connect and
+	// the client connection carries data packets only, see docs/communication_protocol.md
+	RawMode bool
+
+	// SystemPacketLimit is the max handshake and heartbeat packets of a connection in
+	// SystemPacketWindow, the connection exceeding it will be closed, zero means unlimited
+	SystemPacketLimit  int
+	SystemPacketWindow time.Duration
+
+	// Framing is the length encoding of the client packets, which is fixed per deployment
+	// and must be the same as the clients. MaxPacketSize limits the packets received from
+	// clients, codec.MaxPacketSize is used if zero, see docs/communication_protocol.md
+	Framing       codec.Framing
+	MaxPacketSize int
+
+	// PacketCodec frames the client packets instead of the built-in codec if not nil,
+	// which adapts nano to the clients speaking a non-standard framing
+	PacketCodec pcodec.PacketCodec
+
+	// SessionQueueLimit caps the messages of a client connection which are dispatched
+	// to the handlers but not handled yet, zero means unlimited. The connection reaching
+	// the limit stops being read until a message handled, or it is kicked with reason
+	// "message queue overflow" if SessionQueueKick, see session.Session.QueueDepth
+	SessionQueueLimit int
+	SessionQueueKick  bool
+
+	// MaxReadBytesPerSec limits the bandwidth of a client connection, which complements
+	// the limits of the messages, zero means unlimited. The bursts up to ReadBurstBytes
+	// (one second of the rate at least) are allowed. The connection exceeding it is not
+	// read until the rate recovered, or it is kicked with reason "read rate exceeded" if
+	// ReadRateKick
+	MaxReadBytesPerSec int
+	ReadBurstBytes     int
+	ReadRateKick       bool
+
+	// SlowConsumerWindow and SlowConsumerThreshold detect the slow consumers, a session
+	// whose send queue holds at least the threshold messages for the window is kicked
+	// with the reason "slow connection" instead of dropping the pushes beyond the queue.
+	// Zero window disables the detection, zero threshold means a full queue
+	SlowConsumerWindow    time.Duration
+	SlowConsumerThreshold int
+
+	// CloseFlushTimeout flushes the messages queued before a client connection closed,
+	// e.g: the kick reason or the last pushes before shutdown, the connection is closed
+	// once they are written or the timeout elapsed. Zero drops them
+	CloseFlushTimeout time.Duration
+
+	// TraceMessages logs the messages exchanged with clients in the readable form for
+	// debugging the protocol in development, TraceSampleRate samples the messages if it
+	// is in (0, 1), and the values of the TraceRedact fields are redacted
+	TraceMessages   bool
+	TraceSampleRate float64
+	TraceRedact     []string
+
+	// PayloadCipher encrypts the payloads exchanged with clients by the key of each
+	// connection, which is rotated every KeyRotationInterval if positive, or by
+	// session.Session.RotateKey. The replaced key is accepted in KeyGraceWindow after
+	// a rotation, 10 seconds if not positive, unless client acknowledged the rotation
+	PayloadCipher       PayloadCipher
+	KeyRotationInterval time.Duration
+	KeyGraceWindow      time.Duration
+
+	// PauseBuffer caps the messages buffered for a paused session, the messages beyond
+	// it are dropped and the requests are responded an error, 64 if zero and all of the
+	// messages are dropped if negative, see session.Session.Pause
+	PauseBuffer int
+
+	// ClientCAFile is the PEM file of the certificate authorities which verify the
+	// client certificates on the websocket TLS connections, RequireClientCert rejects
+	// the clients without a verified certificate, see session.ClientCertificate
+	ClientCAFile      string
+	RequireClientCert bool
+
+	// WSResponseHeader returns the headers which will be included in the response of
+	// the websocket upgrade, e.g: Sec-WebSocket-Protocol to negotiate the subprotocol
+	WSResponseHeader func(*http.Request) http.Header
+
+	// WSAuthenticate authenticates the websocket upgrade request by the token carried
+	// in the query parameters or the headers, the upgrade is refused with 401 if it
+	// returns an error, otherwise the returned uid is bound to the session
+	WSAuthenticate func(*http.Request) (int64, error)
+
+	// TCPNoDelay controls whether the Nagle's algorithm is disabled on the accepted
+	// client connections, nano.Listen enables it by default
+	TCPNoDelay bool
+
+	// TCPKeepAlive specifies the keep-alive period of the accepted client connections,
+	// zero keeps the system default, negative disables the keep-alive
+	TCPKeepAlive time.Duration
+
+	// ReadBufferSize and WriteBufferSize set the sizes of the OS receive and send buffers
+	// of the accepted client connections if positive, the system defaults are kept
+	// otherwise. They are applied to the TCP connections only, unlike the buffers of
+	// the websocket upgrader
+	ReadBufferSize  int
+	WriteBufferSize int
+
+	// OutboundTransform transforms the payloads sent to clients after serialization
+	// and compression, e.g: encryption, InboundTransform reverses it on the payloads
+	// received from clients before decompression and deserialization
+	OutboundTransform func([]byte) []byte
+	InboundTransform  func([]byte) ([]byte, error)
+
+	// RouteACL contains the predicates of routes which are checked before dispatching
+	// the client messages, a route is keyed by its full name or the service wildcard
+	// (e.g: "Admin.*"), the requests rejected by a predicate receive an error response
+	RouteACL map[string]func(*session.Session) bool
+
+	// IdempotentRoutes contains the routes keyed by the full name or the service wildcard
+	// (e.g: "Room.*") which are retried on another member if the selected member left
+	// before the message was forwarded, the other routes receive an error response
+	IdempotentRoutes map[string]bool
+
+	// AdminAddr is the address of the admin server which serves the management
+	// operations of current node, empty disables the admin server
+	AdminAddr string
+
+	// AdminToken is the bearer token that every admin request must carry
+	AdminToken string
+
+	// AdminReload will be called by the reload operation of the admin server
+	AdminReload func() error
+
+	// InitTimeout is the duration that Startup waits for the Init/AfterInit of each
+	// component, zero disables the timeout, see component.WithInitTimeout
+	InitTimeout time.Duration
+
+	// RPCClientMaxTargets is the max number of members whose connections are cached,
+	// the least recently used ones are closed if exceeded, zero means unlimited. The
+	// connections to the master are never evicted
+	RPCClientMaxTargets int
+
+	// MaxInflightPerMember caps the forwarded messages in flight to each member, the
+	// forwards beyond the cap wait in a queue of the member bounded by
+	// InflightQueuePerMember, and the ones overflowing the queue fail fast, the requests
+	// are responded with the 503 error. It protects the gate from the slow members, zero
+	// is unlimited
+	MaxInflightPerMember   int
+	InflightQueuePerMember int
+
+	// BreakerFailureRate enables the circuit breaker of the forwards to each member, the
+	// breaker trips open once the failed forwards reached the rate (0, 1] of at least
+	// BreakerMinRequests forwards in a BreakerWindow, then the forwards to the member fail
+	// fast until BreakerOpenTimeout elapsed, after which BreakerProbes forwards probe the
+	// member and close the breaker if all succeeded. Zero disables the circuit breakers
+	BreakerFailureRate float64
+	BreakerMinRequests int
+	BreakerWindow      time.Duration
+	BreakerOpenTimeout time.Duration
+	BreakerProbes      int
+
+	// RPCClientIdleTimeout closes the cached connections to a member which have not
+	// been used for the duration, zero keeps them until shutdown
+	RPCClientIdleTimeout time.Duration
+
+	// RPCDialBackoffBase and RPCDialBackoffMax are the jittered exponential backoff of
+	// re-dialing a member which failed to dial, the delay starts from the base and is
+	// doubled for each consecutive failure up to the max, zero means the defaults
+	RPCDialBackoffBase time.Duration
+	RPCDialBackoffMax  time.Duration
+
+	// GRPCDialer dials the connections to the other members, e.g: through a service mesh
+	// sidecar, a SOCKS proxy or an in-memory listener of tests, the standard dialer is
+	// used if nil
+	GRPCDialer func(ctx context.Context, addr string) (net.Conn, error)
+
+	// DispatcherStallThreshold reports the handler which blocks the shared dispatcher
+	// longer than the threshold, e.g: a synchronous database call, with the route and
+	// the stack of the dispatcher, all the sessions are stalled meanwhile. The handlers
+	// of the worker pools and the local schedulers are not watched, zero disables
+	DispatcherStallThreshold time.Duration
+
+	// MaxGoroutines is the soft limit of the goroutines of the process, the new client
+	// connections are refused while exceeded and the most common stacks are logged, e.g:
+	// the goroutines leaked by the handlers. The goroutines are sampled to the metric
+	// nano_goroutines, zero means unlimited
+	MaxGoroutines int
+
+	// RPCResolver is the scheme of the gRPC resolver which resolves the addresses of the
+	// other members, e.g: "dns" resolves a hostname to all its A and AAAA records, gRPC
+	// fails over between them and re-resolves the hostname when the connection is lost,
+	// which suits the dual-stack members advertising a hostname. GRPCDialer receives the
+	// resolved addresses then. The custom resolvers can be registered by resolver.Register,
+	// empty passes the addresses to the dialer as is
+	RPCResolver string
+
+	// OnMemberStateChange is called when the state of the connection to a member changed,
+	// e.g: from Ready to TransientFailure, which reflects the health of members before
+	// the heartbeat timeout. It is called in the watching goroutine of each member
+	OnMemberStateChange func(addr string, from, to connectivity.State)
+
+	// ShutdownTimeout is the grace window of shutdown, which is passed to components
+	// as the deadline of the shutdown context, zero means no deadline
+	ShutdownTimeout time.Duration
+
+	// SyncMembersInterval is the interval that a member reconciles its member
+	// list against the master, zero disables the reconciliation
+	SyncMembersInterval time.Duration
+
+	// RegistrySnapshotPath is the file which the master snapshots the registered members
+	// to periodically, a restarted master reloads the members from it and drops the ones
+	// which are unreachable, instead of waiting for the members to register again. The
+	// snapshot is taken every RegistrySnapshotInterval, zero means the default (10s)
+	RegistrySnapshotPath     string
+	RegistrySnapshotInterval time.Duration
+
+	// NewMemberDebounce is the window in which the master coalesces the new
+	// members into a single announcement, zero announces every member at once
+	NewMemberDebounce time.Duration
+
+	// OnCodecError is called with the phase (CodecPhasePacket, etc.) if a packet, message
+	// or payload of a client connection failed to decode or encode, which makes the
+	// protocol mismatch and corruption visible
+	OnCodecError func(s *session.Session, err error, phase string)
+
+	// CodecErrorLimit is the decode failures of the messages and payloads tolerated for a
+	// client connection, the connection is closed if reached, zero means the default (3).
+	// The packet decode failure always closes the connection
+	CodecErrorLimit int
+
+	// Validate is called with the route and the decoded message before the handler is
+	// dispatched, the message is dropped if an error is returned, and the request of the
+	// client is responded with a validation error (code 400) carrying the error text
+	Validate func(route string, msg interface{}) error
+
+	// CompressionDictionary is the preset dictionary of the payload compression, which
+	// improves the ratio of the small and repetitive payloads. It is used by the
+	// connections whose client declares the same dictionary in handshake, see the
+	// communication protocol document
+	CompressionDictionary []byte
+
+	// ReliableWindow caps the reliable pushes of a session which are not acknowledged by
+	// client, Session.PushReliable fails if exceeded, zero means the default (256)
+	ReliableWindow int
+
+	// MinProtocolVersion is the minimum protocol version of the clients, the clients
+	// declaring a lower version in the handshake are rejected, zero accepts all versions
+	MinProtocolVersion int
+
+	// ListenBacklog is the backlog of the client listener, which absorbs the bursts of
+	// connects before accepted, zero means the system default. It is capped by the system
+	// limit (e.g: net.core.somaxconn on Linux), and unsupported on Windows
+	ListenBacklog int
+
+	// ListenControl is called with the raw socket of the client listener before it binds,
+	// which sets the socket options, e.g: SO_REUSEPORT
+	ListenControl func(network, address string, c syscall.RawConn) error
+
+	// ReusePort binds a client listener per GOMAXPROCS to the client address with
+	// SO_REUSEPORT, each of which runs its own accept loop, which scales the accept rate
+	// on many-core machines. It is supported by the tcp listener on Linux, BSD and macOS
+	ReusePort bool
+
+	// RequiredServices are the services which must be provided by current node or the
+	// discovered members before the client listener is opened, Startup waits for them up
+	// to ReadinessTimeout and then serves the clients anyway, zero timeout waits forever
+	RequiredServices []string
+	ReadinessTimeout time.Duration
+
+	// ResolveMemberAddr derives the address which is registered to the cluster and
+	// dialed by the other members, e.g: the pod IP when the service address binds
+	// to 0.0.0.0 in Kubernetes. It is called after the service address is listened,
+	// the service address is registered if it is nil or returns an empty address
+	ResolveMemberAddr func() (string, error)
+
+	// EnableHotRestart lets Upgrade hand the listeners to a new process of the executable
+	// for a zero downtime binary upgrade, and the new process inherits them instead of
+	// listening. The old process keeps serving its sessions until they closed or
+	// HotRestartDrainTimeout elapsed, and then shuts down, zero waits forever. It is
+	// supported on Linux, BSD and macOS, except the master node
+	EnableHotRestart       bool
+	HotRestartDrainTimeout time.Duration
 }
 
+// readinessCheckInterval is the interval that Startup checks the required services
+const readinessCheckInterval = 50 * time.Millisecond
+
 // Node represents a node in nano cluster, which will contains a group of services.
 // All services will register to cluster and messages will be forwarded to the node
 // which provides respective service
 type Node struct {
 	Options            // current node options
 	ServiceAddr string // current server service address (RPC)
+	boundAddr   string // address which the service listener bound to
 
 	cluster   *cluster
 	handler   *LocalHandler
@@ -70,53 +453,309 @@ type Node struct {
 
 	mu       sync.RWMutex
 	sessions map[int64]*session.Session
+	linger   *session.LingerStore // retains the state of the closed sessions of current node
+
+	chDie        chan struct{}
+	shutdown     int32                       // set by the first shutdown
+	shutdownDone chan struct{}               // closed once the first shutdown finished
+	components   []component.CompWithOptions // components in initialization order
+
+	muMaster   sync.RWMutex
+	masterAddr string // service address of current master, changed by master handoff
+
+	admin           *http.Server
+	clientListeners []net.Listener // more than one if ReusePort
+	draining        int32          // refuses the new client connections if set by the admin server
+	serviceListener net.Listener   // nil in singleton mode
+	inherited       *inheritedListeners
+	upgrading       int32 // the listeners are being handed to a new process
+	upgraded        int32 // the listeners have been handed to a new process
 }
 
 func (n *Node) Startup() error {
 	if n.ServiceAddr == "" {
 		return errors.New("service address cannot be empty in master node")
 	}
-	n.sessions = map[int64]*session.Session{}
-	n.cluster = newCluster(n)
-	n.handler = NewHandler(n, n.Pipeline)
-	components := n.Components.List()
-	for _, c := range components {
-		err := n.handler.register(c.Comp, c.Opts)
+	if n.IsMaster && n.IsObserver() {
+		return errors.New("observer cannot be the master node")
+	}
+	if n.IsGateway && n.IsObserver() {
+		return errors.New("observer cannot be a gateway node")
+	}
+	if n.IsGateway && n.ClientAddr == "" {
+		return errors.New("client address cannot be empty in gateway node")
+	}
+	if n.RawMode && n.Authenticator != nil {
+		return errors.New("authenticator cannot be used in raw mode which skips the handshake")
+	}
+	if n.ReusePort && n.IsWebsocket {
+		return errors.New("reuse port is only supported by the tcp client listener")
+	}
+	if n.RPCResolver != "" && resolver.Get(n.RPCResolver) == nil {
+		return fmt.Errorf("gRPC resolver %s is not registered", n.RPCResolver)
+	}
+	if n.MinProtocolVersion > ProtocolVersion {
+		return fmt.Errorf("minimum protocol version %d exceeds the latest version %d", n.MinProtocolVersion, ProtocolVersion)
+	}
+	if n.EnableHotRestart {
+		inherited, err := inheritListeners()
 		if err != nil {
 			return err
 		}
+		n.inherited = inherited
+	}
+	n.sessions = map[int64]*session.Session{}
+	n.chDie = make(chan struct{})
+	n.shutdownDone = make(chan struct{})
+	n.masterAddr = n.AdvertiseAddr
+	n.linger = session.NewLingerStore(n.SessionLinger)
+	n.cluster = newCluster(n)
+	n.handler = NewHandler(n, n.Pipeline)
+	if n.Components == nil {
+		// gateway nodes may hold no components
+		n.Components = &component.Components{}
+	}
+	components, err := n.Components.Sorted()
+	if err != nil {
+		return err
+	}
+	components, regErr, err := n.registerComponents(components)
+	if err != nil {
+		return err
+	}
+	if err := n.handler.checkSerializers(n.Serializers); err != nil {
+		return err
 	}
 
 	cache()
 	if err := n.initNode(); err != nil {
 		return err
 	}
+	if n.handler.watchdog != nil {
+		go n.handler.watchdog.watch(n.chDie)
+	}
+	n.handler.goroutines = newGoroutineGuard(n.MaxGoroutines, n.ServiceAddr)
+	go n.handler.goroutines.watch(n.chDie)
 
-	// Initialize all components
+	// Initialize all components, only the initialized ones are shut down if the startup
+	// failed
 	for _, c := range components {
-		c.Comp.Init()
+		if err := initComponent(c, "Init", c.Comp.Init, c.InitTimeout(n.InitTimeout)); err != nil {
+			return n.abortStartup(err)
+		}
+		n.components = append(n.components, c)
 	}
 	for _, c := range components {
-		c.Comp.AfterInit()
+		if err := initComponent(c, "AfterInit", c.Comp.AfterInit, c.InitTimeout(n.InitTimeout)); err != nil {
+			return n.abortStartup(err)
+		}
+	}
+
+	if n.AdminAddr != "" {
+		if err := n.startAdmin(); err != nil {
+			return n.abortStartup(err)
+		}
 	}
 
 	if n.ClientAddr != "" {
+		n.awaitServices()
+		tlsConfig, err := n.clientTLSConfig()
+		if err != nil {
+			return n.abortStartup(err)
+		}
+		listener, err := n.listenClient()
+		if err != nil {
+			return n.abortStartup(err)
+		}
+		if isEphemeral(n.ClientAddr) {
+			n.ClientAddr = listener.Addr().String()
+		}
+		n.clientListeners = []net.Listener{listener}
+		if n.ReusePort {
+			// the listeners bind the same port, and the kernel balances the connects among
+			// the accept loops of them
+			for i := 1; i < runtime.GOMAXPROCS(0); i++ {
+				l, err := n.listenClient()
+				if err != nil {
+					return n.abortStartup(err)
+				}
+				n.clientListeners = append(n.clientListeners, l)
+				go n.listenAndServe(l)
+			}
+		}
+
 		go func() {
 			if n.IsWebsocket {
 				if len(n.TSLCertificate) != 0 {
-					n.listenAndServeWSTLS()
+					n.listenAndServeWSTLS(listener, tlsConfig)
 				} else {
-					n.listenAndServeWS()
+					n.listenAndServeWS(listener)
 				}
 			} else {
-				n.listenAndServe()
+				n.listenAndServe(listener)
 			}
 		}()
 	}
 
+	if n.inherited != nil {
+		n.inherited.ready()
+	}
+	if regErr != nil {
+		return regErr
+	}
+	return nil
+}
+
+// abortStartup tears down the node which failed to start up after it joined the cluster:
+// the initialized components are shut down, current node is unregistered from the master,
+// and the servers and goroutines are stopped. The err is returned as is
+func (n *Node) abortStartup(err error) error {
+	log.Println(fmt.Sprintf("Startup failed, shut down current node: %v", err))
+	n.ShutdownContext(context.Background())
+	return err
+}
+
+// awaitServices waits until all required services are provided by current node or the
+// members discovered, or the readiness timeout elapsed, before the clients are served
+func (n *Node) awaitServices() {
+	if len(n.RequiredServices) == 0 {
+		return
+	}
+
+	var timeout <-chan time.Time
+	if n.ReadinessTimeout > 0 {
+		timer := env.Clock.NewTimer(n.ReadinessTimeout)
+		defer timer.Stop()
+		timeout = timer.C()
+	}
+	ticker := env.Clock.NewTicker(readinessCheckInterval)
+	defer ticker.Stop()
+
+	start := env.Clock.Now()
+	for {
+		missing := n.missingServices()
+		if len(missing) == 0 {
+			log.Println(fmt.Sprintf("Required services are ready in %s", env.Clock.Now().Sub(start)))
+			return
+		}
+		select {
+		case <-ticker.C():
+		case <-timeout:
+			log.Println(fmt.Sprintf("Serve clients without required services %v after %s", missing, n.ReadinessTimeout))
+			return
+		case <-n.chDie:
+			return
+		}
+	}
+}
+
+// memberInfo returns the information of current node registered to the master, which
+// is also announced by the master after handoff
+func (n *Node) memberInfo() *clusterpb.MemberInfo {
+	return &clusterpb.MemberInfo{
+		Label:       n.Label,
+		ServiceAddr: n.ServiceAddr,
+		Services:    n.advertisedServices(),
+		Serializer:  serializerName(),
+		Observer:    n.IsObserver(),
+		Gateway:     n.IsGateway,
+		Labels:      n.MemberLabels,
+	}
+}
+
+// advertisedServices returns the local services advertised to the other members, the
+// observers and gateways never serve the routes of the other members
+func (n *Node) advertisedServices() []string {
+	if n.IsObserver() || n.IsGateway {
+		return nil
+	}
+	return n.handler.LocalService()
+}
+
+// missingServices returns the required services which are not provided in the cluster
+func (n *Node) missingServices() []string {
+	provided := map[string]bool{}
+	for _, s := range n.handler.LocalService() {
+		provided[s] = true
+	}
+	for _, s := range n.handler.RemoteService() {
+		provided[s] = true
+	}
+	var missing []string
+	for _, s := range n.RequiredServices {
+		if !provided[s] {
+			missing = append(missing, s)
+		}
+	}
+	return missing
+}
+
+// initComponent calls the init hook of component, an error naming the component
+// will be returned if the hook does not return in timeout, zero disables the timeout
+func initComponent(c component.CompWithOptions, phase string, hook func(), timeout time.Duration) error {
+	name := c.Name()
+	log.Println(fmt.Sprintf("Component %s %s starting", name, phase))
+	start := env.Clock.Now()
+	if timeout <= 0 {
+		hook()
+	} else {
+		done := make(chan struct{})
+		go func() {
+			hook()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-env.Clock.After(timeout):
+			return fmt.Errorf("component %s %s timeout after %s", name, phase, timeout)
+		}
+	}
+	log.Println(fmt.Sprintf("Component %s %s finished in %s", name, phase, env.Clock.Now().Sub(start)))
 	return nil
 }
 
+// clientTLSConfig returns the TLS configurations to authenticate the client certificates
+func (n *Node) clientTLSConfig() (*tls.Config, error) {
+	config := &tls.Config{}
+	if n.ClientCAFile != "" {
+		data, err := ioutil.ReadFile(n.ClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificate found in client CA file %s", n.ClientCAFile)
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	if n.RequireClientCert {
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, nil
+}
+
+// isEphemeral reports whether the port of addr is 0, which will be assigned by system
+func isEphemeral(addr string) bool {
+	_, port, err := net.SplitHostPort(addr)
+	return err == nil && port == "0"
+}
+
+// BoundServiceAddr returns the service address which current node listens on, the
+// port is resolved after Startup if the configured service address uses port 0
+func (n *Node) BoundServiceAddr() string {
+	if n.boundAddr != "" {
+		return n.boundAddr
+	}
+	return n.ServiceAddr
+}
+
+// BoundClientAddr returns the client address which current node listens on, the
+// port is resolved after Startup if the configured client address uses port 0
+func (n *Node) BoundClientAddr() string {
+	return n.ClientAddr
+}
+
 func (n *Node) Handler() *LocalHandler {
 	return n.handler
 }
@@ -128,15 +767,45 @@ func (n *Node) initNode() error {
 		return nil
 	}
 
-	listener, err := net.Listen("tcp", n.ServiceAddr)
+	listener, err := n.listenService()
 	if err != nil {
 		return err
 	}
+	n.serviceListener = listener
+	// advertise the port assigned by system instead of the unroutable port 0
+	if isEphemeral(n.ServiceAddr) {
+		n.ServiceAddr = listener.Addr().String()
+	}
+	n.boundAddr = n.ServiceAddr
+	if n.ResolveMemberAddr != nil {
+		addr, err := n.ResolveMemberAddr()
+		if err != nil {
+			listener.Close()
+			return fmt.Errorf("resolve member address failed: %v", err)
+		}
+		if addr != "" {
+			n.ServiceAddr = addr
+		}
+	}
 
 	// Initialize the gRPC server and register service
 	n.server = grpc.NewServer()
-	n.rpcClient = newRPCClient()
+	n.rpcClient = newRPCClient(n.RPCClientMaxTargets, n.RPCClientIdleTimeout)
+	n.rpcClient.backoffBase = n.RPCDialBackoffBase
+	n.rpcClient.backoffMax = n.RPCDialBackoffMax
+	n.rpcClient.dialer = n.GRPCDialer
+	n.rpcClient.resolver = n.RPCResolver
+	n.rpcClient.onStateChange = n.OnMemberStateChange
+	n.rpcClient.pinned = func(addr string) bool { return addr == n.master() }
+	if n.RPCClientIdleTimeout > 0 {
+		go n.evictIdleConns()
+	}
 	clusterpb.RegisterMemberServer(n.server, n)
+	if n.IsMaster {
+		// the services must be registered before serving, the master is also a member
+		// serving the routes of its own components on the same server
+		clusterpb.RegisterMasterServer(n.server, n.cluster)
+	}
 
 	go func() {
 		err := n.server.Serve(listener)
@@ -146,29 +815,28 @@ func (n *Node) initNode() error {
 	}()
 
 	if n.IsMaster {
-		clusterpb.RegisterMasterServer(n.server, n.cluster)
 		member := &Member{
-			isMaster: true,
-			memberInfo: &clusterpb.MemberInfo{
-				Label:       n.Label,
-				ServiceAddr: n.ServiceAddr,
-				Services:    n.handler.LocalService(),
-			},
+			isMaster:   true,
+			memberInfo: n.memberInfo(),
 		}
 		n.cluster.members = append(n.cluster.members, member)
 		n.cluster.setRpcClient(n.rpcClient)
+		if n.RegistrySnapshotPath != "" {
+			members, err := readRegistrySnapshot(n.RegistrySnapshotPath)
+			if err != nil {
+				log.Println("Read registry snapshot failed", n.RegistrySnapshotPath, err)
+			}
+			n.cluster.restoreRegistry(members)
+			go n.snapshotRegistry()
+		}
 	} else {
-		pool, err := n.rpcClient.getConnPool(n.AdvertiseAddr)
+		pool, err := n.rpcClient.getConnPool(n.master())
 		if err != nil {
 			return err
 		}
 		client := clusterpb.NewMasterClient(pool.Get())
 		request := &clusterpb.RegisterRequest{
-			MemberInfo: &clusterpb.MemberInfo{
-				Label:       n.Label,
-				ServiceAddr: n.ServiceAddr,
-				Services:    n.handler.LocalService(),
-			},
+			MemberInfo: n.memberInfo(),
 		}
 		for {
 			resp, err := client.Register(context.Background(), request)
@@ -177,32 +845,344 @@ func (n *Node) initNode() error {
 				n.cluster.initMembers(resp.Members)
 				break
 			}
+			if status.Code(err) == codes.FailedPrecondition {
+				return errors.New(status.Convert(err).Message())
+			}
 			log.Println("Register current node to cluster failed", err, "and will retry in", n.RetryInterval.String())
-			time.Sleep(n.RetryInterval)
+			<-env.Clock.After(n.RetryInterval)
 		}
 
+		if n.SyncMembersInterval > 0 {
+			go n.syncMembers()
+		}
 	}
 
 	return nil
 }
 
+// evictIdleConns closes the idle connections to the departed members periodically
+func (n *Node) evictIdleConns() {
+	ticker := env.Clock.NewTicker(n.RPCClientIdleTimeout / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C():
+			n.rpcClient.evictIdle(now)
+		case <-n.chDie:
+			return
+		}
+	}
+}
+
+// syncMembers reconciles the member list against the master periodically, which
+// heals the missed member notifications
+func (n *Node) syncMembers() {
+	ticker := env.Clock.NewTicker(n.SyncMembersInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C():
+			pool, err := n.rpcClient.getConnPool(n.master())
+			if err != nil {
+				log.Println("Retrieve master address error", err)
+				continue
+			}
+			client := clusterpb.NewMasterClient(pool.Get())
+			resp, err := client.SyncMembers(context.Background(), &clusterpb.SyncMembersRequest{})
+			if err != nil {
+				log.Println("Sync members from master failed", err)
+				continue
+			}
+			var members []*clusterpb.MemberInfo
+			for _, m := range resp.Members {
+				if m.ServiceAddr != n.ServiceAddr {
+					members = append(members, m)
+				}
+			}
+			n.handler.syncRemoteService(members)
+			n.cluster.syncMembers(members)
+
+		case <-n.chDie:
+			return
+		}
+	}
+}
+
+// bindSession reports the current node holds the session of the uid to master,
+// which is used to route the responses to the gate that the client migrated to
+func (n *Node) bindSession(s *session.Session) {
+	if n.SingleSessionPerUID {
+		n.kickPrevious(s)
+	}
+	if !n.IsMaster && n.AdvertiseAddr == "" {
+		return
+	}
+	request := &clusterpb.BindSessionRequest{
+		Uid:       s.UID(),
+		GateAddr:  n.ServiceAddr,
+		SessionId: s.ID(),
+	}
+	if n.IsMaster {
+		n.cluster.BindSession(context.Background(), request)
+		return
+	}
+	pool, err := n.rpcClient.getConnPool(n.master())
+	if err != nil {
+		log.Println("Retrieve master address error", err)
+		return
+	}
+	client := clusterpb.NewMasterClient(pool.Get())
+	if _, err := client.BindSession(context.Background(), request); err != nil {
+		log.Println("Bind session to master failed", s.UID(), err)
+	}
+}
+
+// unbindSession reports the session of the uid has been closed to master, which
+// keeps the uid located to the member that currently holds it
+func (n *Node) unbindSession(s *session.Session) {
+	n.unbindUID(s, s.UID())
+}
+
+// rebindSession reports the session has rebound from the old uid to master, the old
+// uid is unbound and the new one is bound by the single session policy
+func (n *Node) rebindSession(s *session.Session, oldUID int64) {
+	n.unbindUID(s, oldUID)
+	n.bindSession(s)
+}
+
+// unbindUID removes the binding of the uid to the session from the registry of master
+func (n *Node) unbindUID(s *session.Session, uid int64) {
+	if uid < 1 || (!n.IsMaster && n.AdvertiseAddr == "") {
+		return
+	}
+	request := &clusterpb.UnbindSessionRequest{
+		Uid:       uid,
+		GateAddr:  n.ServiceAddr,
+		SessionId: s.ID(),
+	}
+	if n.IsMaster {
+		n.cluster.UnbindSession(context.Background(), request)
+		return
+	}
+	pool, err := n.rpcClient.getConnPool(n.master())
+	if err != nil {
+		log.Println("Retrieve master address error", err)
+		return
+	}
+	client := clusterpb.NewMasterClient(pool.Get())
+	if _, err := client.UnbindSession(context.Background(), request); err != nil {
+		log.Println("Unbind session from master failed", uid, err)
+	}
+}
+
+// kickPrevious kicks the other sessions which have bound the uid of the session, the
+// session held by another gate is located by the registry of master
+func (n *Node) kickPrevious(s *session.Session) {
+	reason := n.KickReason
+	if reason == "" {
+		reason = defaultKickReason
+	}
+
+	uid := s.UID()
+	var previous []*session.Session
+	n.ForEachSession(func(other *session.Session) bool {
+		if other.UID() == uid && other.ID() != s.ID() {
+			previous = append(previous, other)
+		}
+		return true
+	})
+	for _, p := range previous {
+		log.Println(fmt.Sprintf("Kick previous session, ID=%d, UID=%d", p.ID(), uid))
+		p.Kick(reason)
+	}
+
+	if !n.IsMaster && n.AdvertiseAddr == "" {
+		return
+	}
+	loc, err := n.locateSession(uid)
+	if err != nil || loc.GateAddr == n.ServiceAddr {
+		// not bound yet, or the previous one is held by current gate
+		return
+	}
+	pool, err := n.rpcClient.getConnPool(loc.GateAddr)
+	if err != nil {
+		log.Println("Retrieve gate address error", loc.GateAddr, err)
+		return
+	}
+	request := &clusterpb.CloseSessionRequest{SessionId: loc.SessionId, Reason: reason}
+	if _, err := clusterpb.NewMemberClient(pool.Get()).CloseSession(context.Background(), request); err != nil {
+		log.Println("Kick previous session failed", loc.GateAddr, uid, err)
+	}
+}
+
+// locateSession returns the gate and session which currently holds the uid
+func (n *Node) locateSession(uid int64) (*clusterpb.LocateSessionResponse, error) {
+	request := &clusterpb.LocateSessionRequest{Uid: uid}
+	if n.IsMaster {
+		return n.cluster.LocateSession(context.Background(), request)
+	}
+	pool, err := n.rpcClient.getConnPool(n.master())
+	if err != nil {
+		return nil, err
+	}
+	return clusterpb.NewMasterClient(pool.Get()).LocateSession(context.Background(), request)
+}
+
+// LocateUID returns the address of the member which currently holds the session of
+// the uid, it is looked up in the registry of master, which is updated as the sessions
+// bind and close, false will be returned if the uid is not bound to any session
+func (n *Node) LocateUID(uid int64) (string, bool) {
+	loc, err := n.locateSession(uid)
+	if err != nil {
+		return "", false
+	}
+	return loc.GateAddr, true
+}
+
+// InvalidateCache drops the cached responses of the route for the uid, or all the cached
+// responses of the route if uid is zero, the responses cached for the sessions not bound
+// an uid can only be dropped as a whole
+func (n *Node) InvalidateCache(route string, uid int64) {
+	n.handler.caches.invalidate(route, uid)
+}
+
+// Localize translates the system message into the locale of the session by the Localizer,
+// e.g: the notices broadcast to the sessions of different locales. The message is
+// formatted as is if no localizer configured
+func (n *Node) Localize(s *session.Session, key string, args ...interface{}) string {
+	return localize(n.Localizer, s.Locale(), key, args...)
+}
+
+// PushMany pushes the message to the sessions, the message will be serialized once
+// and compressed once for the connections which negotiated compression, which saves
+// CPU for large fan-outs. The last error will be returned if push to some session failed
+func (n *Node) PushMany(sessions []*session.Session, route string, v interface{}) error {
+	data, err := message.Serialize(v)
+	if err != nil {
+		return err
+	}
+
+	n.TeeBroadcast(route, data)
+
+	var deflated, deflatedDict []byte
+	if env.CompressThreshold > 0 && len(data) >= env.CompressThreshold {
+		deflated, err = message.Deflate(data)
+		if err != nil {
+			return err
+		}
+		if dict := n.CompressionDictionary; len(dict) > 0 {
+			if deflatedDict, err = message.DeflateDict(data, dict); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, s := range sessions {
+		var e error
+		if a, ok := s.NetworkEntity().(*agent); ok && a.serializer != nil {
+			// the connection negotiated its own serializer
+			e = a.Push(route, v)
+		} else if ok {
+			shared := deflated
+			if a.compressDict != nil {
+				shared = deflatedDict
+			}
+			e = a.pushShared(route, data, shared)
+		} else {
+			e = s.Push(route, data)
+		}
+		if e != nil {
+			log.Println(fmt.Sprintf("Session push message error, ID=%d, UID=%d, Error=%s", s.ID(), s.UID(), e.Error()))
+			err = e
+		}
+	}
+	return err
+}
+
+func (n *Node) master() string {
+	n.muMaster.RLock()
+	defer n.muMaster.RUnlock()
+	return n.masterAddr
+}
+
+// ExportRegistry returns the members registered to current master, which can be
+// imported by a replacement master via ImportRegistry for a planned handoff
+func (n *Node) ExportRegistry() []*clusterpb.MemberInfo {
+	if !n.IsMaster {
+		return nil
+	}
+	return n.cluster.exportRegistry()
+}
+
+// ImportRegistry registers the members exported from the previous master to current
+// master, and notifies each member to switch to current master. The members which
+// failed to be notified still point to the previous master, the last notify error
+// will be returned
+func (n *Node) ImportRegistry(members []*clusterpb.MemberInfo) error {
+	if !n.IsMaster {
+		return ErrNotMaster
+	}
+	return n.cluster.importRegistry(members)
+}
+
 // Shutdowns all components registered by application, that
-// call by reverse order against register
+// call by reverse order against initialization
 func (n *Node) Shutdown() {
-	// reverse call `BeforeShutdown` hooks
-	components := n.Components.List()
-	length := len(components)
-	for i := length - 1; i >= 0; i-- {
-		components[i].Comp.BeforeShutdown()
+	ctx := component.WithShutdownReason(context.Background(), component.ShutdownGraceful)
+	n.ShutdownContext(ctx)
+}
+
+// ShutdownContext shutdowns the node like Shutdown, the ctx carrying the shutdown reason
+// and deadline is passed to the components implementing component.ContextShutdowner,
+// the deadline is set by ShutdownTimeout if ctx has no deadline, and the components not
+// stopped by the deadline are abandoned. The later calls wait for the first one to
+// finish, e.g: the node drained by the master is shutting down
+func (n *Node) ShutdownContext(ctx context.Context) {
+	if !atomic.CompareAndSwapInt32(&n.shutdown, 0, 1) {
+		<-n.shutdownDone
+		return
 	}
+	defer close(n.shutdownDone)
+	// refuses the new client connections before the components stopped
+	atomic.StoreInt32(&n.draining, 1)
+	close(n.chDie)
+
+	if _, ok := ctx.Deadline(); !ok && n.ShutdownTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, n.ShutdownTimeout)
+		defer cancel()
+	}
+
+	// the hooks are abandoned once the deadline exceeded, so a stuck component does not
+	// block the shutdown forever
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		// reverse call `BeforeShutdown` hooks
+		components := n.components
+		length := len(components)
+		for i := length - 1; i >= 0; i-- {
+			component.BeforeShutdown(ctx, components[i].Comp)
+		}
 
-	// reverse call `Shutdown` hooks
-	for i := length - 1; i >= 0; i-- {
-		components[i].Comp.Shutdown()
+		// reverse call `Shutdown` hooks
+		for i := length - 1; i >= 0; i-- {
+			component.Shutdown(ctx, components[i].Comp)
+		}
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		log.Println(fmt.Sprintf("Components shutdown abandoned: %v", ctx.Err()))
 	}
+	n.handler.closeWorkers()
 
-	if !n.IsMaster && n.AdvertiseAddr != "" {
-		pool, err := n.rpcClient.getConnPool(n.AdvertiseAddr)
+	// the new process of hot restart has registered the same address
+	if !n.IsMaster && n.AdvertiseAddr != "" && !n.isUpgraded() {
+		pool, err := n.rpcClient.getConnPool(n.master())
 		if err != nil {
 			log.Println("Retrieve master address error", err)
 			goto EXIT
@@ -219,31 +1199,106 @@ func (n *Node) Shutdown() {
 	}
 
 EXIT:
+	for _, listener := range n.clientListeners {
+		listener.Close()
+	}
+	if n.admin != nil {
+		n.admin.Close()
+	}
 	if n.server != nil {
 		n.server.GracefulStop()
 	}
+	// the connections to the other members are closed after the in-flight RPCs completed
+	if n.rpcClient != nil {
+		n.rpcClient.closePool()
+	}
 }
 
-// Enable current server accept connection
-func (n *Node) listenAndServe() {
-	listener, err := net.Listen("tcp", n.ClientAddr)
-	if err != nil {
-		log.Fatal(err.Error())
+// isShutdown reports whether Shutdown has been called
+func (n *Node) isShutdown() bool {
+	select {
+	case <-n.chDie:
+		return true
+	default:
+		return false
+	}
+}
+
+// setSocketOptions applies the TCP options to the accepted connection, the
+// connections of other networks are left as they are
+func (n *Node) setSocketOptions(conn net.Conn) {
+	tc, ok := conn.(*net.TCPConn)
+	if !ok {
+		return
+	}
+	if err := tc.SetNoDelay(n.TCPNoDelay); err != nil {
+		log.Println("Set TCP no-delay failed", err)
+	}
+	switch {
+	case n.TCPKeepAlive > 0:
+		if err := tc.SetKeepAlive(true); err != nil {
+			log.Println("Enable TCP keep-alive failed", err)
+		} else if err := tc.SetKeepAlivePeriod(n.TCPKeepAlive); err != nil {
+			log.Println("Set TCP keep-alive period failed", err)
+		}
+	case n.TCPKeepAlive < 0:
+		if err := tc.SetKeepAlive(false); err != nil {
+			log.Println("Disable TCP keep-alive failed", err)
+		}
 	}
+	if n.ReadBufferSize > 0 {
+		if err := tc.SetReadBuffer(n.ReadBufferSize); err != nil {
+			log.Println("Set TCP read buffer failed", err)
+		}
+	}
+	if n.WriteBufferSize > 0 {
+		if err := tc.SetWriteBuffer(n.WriteBufferSize); err != nil {
+			log.Println("Set TCP write buffer failed", err)
+		}
+	}
+}
 
+// Enable current server accept connection
+func (n *Node) listenAndServe(listener net.Listener) {
 	defer listener.Close()
 	for {
 		conn, err := listener.Accept()
 		if err != nil {
+			if n.isShutdown() || n.isUpgraded() {
+				return
+			}
 			log.Println(err.Error())
 			continue
 		}
+		n.setSocketOptions(conn)
+
+		go n.handler.handle(conn, 0)
+	}
+}
 
-		go n.handler.handle(conn)
+func (n *Node) wsResponseHeader(r *http.Request) http.Header {
+	if n.WSResponseHeader == nil {
+		return nil
 	}
+	return n.WSResponseHeader(r)
 }
 
-func (n *Node) listenAndServeWS() {
+// authenticateWS authenticates the websocket upgrade request, the request is responded
+// with 401 and false returned if it fails
+func (n *Node) authenticateWS(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	if n.WSAuthenticate == nil {
+		return 0, true
+	}
+	uid, err := n.WSAuthenticate(r)
+	if err != nil {
+		log.Println(fmt.Sprintf("Authenticate failure, URI=%s, Error=%s", r.RequestURI, err.Error()))
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return 0, false
+	}
+	return uid, true
+}
+
+func (n *Node) listenAndServeWS(listener net.Listener) {
 	var upgrader = websocket.Upgrader{
 		ReadBufferSize:  1024,
 		WriteBufferSize: 1024,
@@ -251,21 +1306,25 @@ func (n *Node) listenAndServeWS() {
 	}
 
 	http.HandleFunc("/"+strings.TrimPrefix(env.WSPath, "/"), func(w http.ResponseWriter, r *http.Request) {
-		conn, err := upgrader.Upgrade(w, r, nil)
+		uid, ok := n.authenticateWS(w, r)
+		if !ok {
+			return
+		}
+		conn, err := upgrader.Upgrade(w, r, n.wsResponseHeader(r))
 		if err != nil {
 			log.Println(fmt.Sprintf("Upgrade failure, URI=%s, Error=%s", r.RequestURI, err.Error()))
 			return
 		}
 
-		n.handler.handleWS(conn)
+		n.handler.handleWS(conn, uid)
 	})
 
-	if err := http.ListenAndServe(n.ClientAddr, nil); err != nil {
+	if err := http.Serve(listener, nil); err != nil && !n.isShutdown() && !n.isUpgraded() {
 		log.Fatal(err.Error())
 	}
 }
 
-func (n *Node) listenAndServeWSTLS() {
+func (n *Node) listenAndServeWSTLS(listener net.Listener, tlsConfig *tls.Config) {
 	var upgrader = websocket.Upgrader{
 		ReadBufferSize:  1024,
 		WriteBufferSize: 1024,
@@ -273,16 +1332,21 @@ func (n *Node) listenAndServeWSTLS() {
 	}
 
 	http.HandleFunc("/"+strings.TrimPrefix(env.WSPath, "/"), func(w http.ResponseWriter, r *http.Request) {
-		conn, err := upgrader.Upgrade(w, r, nil)
+		uid, ok := n.authenticateWS(w, r)
+		if !ok {
+			return
+		}
+		conn, err := upgrader.Upgrade(w, r, n.wsResponseHeader(r))
 		if err != nil {
 			log.Println(fmt.Sprintf("Upgrade failure, URI=%s, Error=%s", r.RequestURI, err.Error()))
 			return
 		}
 
-		n.handler.handleWS(conn)
+		n.handler.handleWS(conn, uid)
 	})
 
-	if err := http.ListenAndServeTLS(n.ClientAddr, n.TSLCertificate, n.TSLKey, nil); err != nil {
+	server := &http.Server{TLSConfig: tlsConfig}
+	if err := server.ServeTLS(listener, n.TSLCertificate, n.TSLKey); err != nil && !n.isShutdown() && !n.isUpgraded() {
 		log.Fatal(err.Error())
 	}
 }
@@ -293,6 +1357,16 @@ func (n *Node) storeSession(s *session.Session) {
 	n.mu.Unlock()
 }
 
+// removeSession removes the closed session of a local client, the sessions of remote
+// clients are removed once their gates notified
+func (n *Node) removeSession(s *session.Session) {
+	n.mu.Lock()
+	if n.sessions[s.ID()] == s {
+		delete(n.sessions, s.ID())
+	}
+	n.mu.Unlock()
+}
+
 func (n *Node) findSession(sid int64) *session.Session {
 	n.mu.RLock()
 	s := n.sessions[sid]
@@ -300,6 +1374,28 @@ func (n *Node) findSession(sid int64) *session.Session {
 	return s
 }
 
+// ForEachSession calls fn for each session of the clients connected to current node
+// until fn returns false. It iterates a point-in-time snapshot of the sessions, the
+// sessions connected after the snapshot are not visited, and the visited sessions
+// may be closed by the time fn is called. The sessions created on behalf of other
+// members (the backend sessions of remote clients) are excluded
+func (n *Node) ForEachSession(fn func(s *session.Session) bool) {
+	n.mu.RLock()
+	sessions := make([]*session.Session, 0, len(n.sessions))
+	for _, s := range n.sessions {
+		if a, ok := s.NetworkEntity().(*agent); ok && a.status() != statusClosed {
+			sessions = append(sessions, s)
+		}
+	}
+	n.mu.RUnlock()
+
+	for _, s := range sessions {
+		if !fn(s) {
+			return
+		}
+	}
+}
+
 func (n *Node) findOrCreateSession(sid int64, gateAddr string) (*session.Session, error) {
 	n.mu.RLock()
 	s, found := n.sessions[sid]
@@ -314,6 +1410,7 @@ func (n *Node) findOrCreateSession(sid int64, gateAddr string) (*session.Session
 			gateClient: clusterpb.NewMemberClient(conns.Get()),
 			rpcHandler: n.handler.remoteProcess,
 			gateAddr:   gateAddr,
+			node:       n,
 		}
 		s = session.New(ac)
 		ac.session = s
@@ -324,7 +1421,7 @@ func (n *Node) findOrCreateSession(sid int64, gateAddr string) (*session.Session
 	return s, nil
 }
 
-func (n *Node) HandleRequest(_ context.Context, req *clusterpb.RequestMessage) (*clusterpb.MemberHandleResponse, error) {
+func (n *Node) HandleRequest(ctx context.Context, req *clusterpb.RequestMessage) (*clusterpb.MemberHandleResponse, error) {
 	handler, found := n.handler.localHandlers[req.Route]
 	if !found {
 		return nil, fmt.Errorf("service not found in current node: %v", req.Route)
@@ -333,17 +1430,33 @@ func (n *Node) HandleRequest(_ context.Context, req *clusterpb.RequestMessage) (
 	if err != nil {
 		return nil, err
 	}
+	if req.Uid > 0 && s.UID() != req.Uid {
+		s.Bind(req.Uid)
+	}
 	msg := &message.Message{
-		Type:  message.Request,
-		ID:    req.Id,
-		Route: req.Route,
-		Data:  req.Data,
+		Type:    message.Request,
+		ID:      req.Id,
+		Route:   req.Route,
+		Data:    req.Data,
+		Timeout: time.Duration(req.Timeout) * time.Millisecond,
+		Header:  headerFromMetadata(ctx),
 	}
 	n.handler.localProcess(handler, req.Id, s, msg)
 	return &clusterpb.MemberHandleResponse{}, nil
 }
 
-func (n *Node) HandleNotify(_ context.Context, req *clusterpb.NotifyMessage) (*clusterpb.MemberHandleResponse, error) {
+func (n *Node) HandleNotify(ctx context.Context, req *clusterpb.NotifyMessage) (*clusterpb.MemberHandleResponse, error) {
+	if n.IsObserver() {
+		n.observe(&Observation{
+			Kind:      ObserveNotify,
+			Gate:      req.GateAddr,
+			SessionID: req.SessionId,
+			UID:       req.Uid,
+			Route:     req.Route,
+			Data:      req.Data,
+		})
+		return &clusterpb.MemberHandleResponse{}, nil
+	}
 	handler, found := n.handler.localHandlers[req.Route]
 	if !found {
 		return nil, fmt.Errorf("service not found in current node: %v", req.Route)
@@ -352,16 +1465,24 @@ func (n *Node) HandleNotify(_ context.Context, req *clusterpb.NotifyMessage) (*c
 	if err != nil {
 		return nil, err
 	}
+	if req.Uid > 0 && s.UID() != req.Uid {
+		s.Bind(req.Uid)
+	}
 	msg := &message.Message{
-		Type:  message.Notify,
-		Route: req.Route,
-		Data:  req.Data,
+		Type:   message.Notify,
+		Route:  req.Route,
+		Data:   req.Data,
+		Header: headerFromMetadata(ctx),
 	}
 	n.handler.localProcess(handler, 0, s, msg)
 	return &clusterpb.MemberHandleResponse{}, nil
 }
 
 func (n *Node) HandlePush(_ context.Context, req *clusterpb.PushMessage) (*clusterpb.MemberHandleResponse, error) {
+	if n.IsObserver() {
+		n.observe(&Observation{Kind: ObserveBroadcast, Route: req.Route, Data: req.Data})
+		return &clusterpb.MemberHandleResponse{}, nil
+	}
 	s := n.findSession(req.SessionId)
 	if s == nil {
 		return &clusterpb.MemberHandleResponse{}, fmt.Errorf("session not found: %v", req.SessionId)
@@ -374,6 +1495,9 @@ func (n *Node) HandleResponse(_ context.Context, req *clusterpb.ResponseMessage)
 	if s == nil {
 		return &clusterpb.MemberHandleResponse{}, fmt.Errorf("session not found: %v", req.SessionId)
 	}
+	if req.More {
+		return &clusterpb.MemberHandleResponse{}, s.StreamResponseMID(req.Id, req.Data)
+	}
 	return &clusterpb.MemberHandleResponse{}, s.ResponseMID(req.Id, req.Data)
 }
 
@@ -383,6 +1507,15 @@ func (n *Node) NewMember(_ context.Context, req *clusterpb.NewMemberRequest) (*c
 	return &clusterpb.NewMemberResponse{}, nil
 }
 
+// NewMembers implements the MemberServer interface
+func (n *Node) NewMembers(_ context.Context, req *clusterpb.NewMembersRequest) (*clusterpb.NewMemberResponse, error) {
+	for _, info := range req.MemberInfos {
+		n.handler.addRemoteService(info)
+		n.cluster.addMember(info)
+	}
+	return &clusterpb.NewMemberResponse{}, nil
+}
+
 func (n *Node) DelMember(_ context.Context, req *clusterpb.DelMemberRequest) (*clusterpb.DelMemberResponse, error) {
 	n.handler.delMember(req.ServiceAddr)
 	n.cluster.delMember(req.ServiceAddr)
@@ -396,7 +1529,8 @@ func (n *Node) SessionClosed(_ context.Context, req *clusterpb.SessionClosedRequ
 	delete(n.sessions, req.SessionId)
 	n.mu.Unlock()
 	if found {
-		scheduler.PushTask(func() { session.Lifetime.Close(s) })
+		reason := session.CloseReason(req.Reason)
+		scheduler.PushTask(func() { session.Lifetime.CloseWithReason(s, reason) })
 	}
 	return &clusterpb.SessionClosedResponse{}, nil
 }
@@ -407,8 +1541,30 @@ func (n *Node) CloseSession(_ context.Context, req *clusterpb.CloseSessionReques
 	s, found := n.sessions[req.SessionId]
 	delete(n.sessions, req.SessionId)
 	n.mu.Unlock()
-	if found {
+	if found && req.Reason != "" {
+		s.Kick(req.Reason)
+	} else if found {
 		s.Close()
 	}
 	return &clusterpb.CloseSessionResponse{}, nil
 }
+
+// MasterChanged implements the MemberServer interface
+func (n *Node) MasterChanged(_ context.Context, req *clusterpb.MasterChangedRequest) (*clusterpb.MasterChangedResponse, error) {
+	if req.Master == nil {
+		return nil, ErrInvalidRegisterReq
+	}
+	n.muMaster.Lock()
+	prev := n.masterAddr
+	n.masterAddr = req.Master.ServiceAddr
+	n.muMaster.Unlock()
+
+	if prev != req.Master.ServiceAddr {
+		n.handler.delMember(prev)
+		n.cluster.delMember(prev)
+	}
+	n.handler.addRemoteService(req.Master)
+	n.cluster.addMember(req.Master)
+	log.Println("Master changed", prev, "->", req.Master.ServiceAddr)
+	return &clusterpb.MasterChangedResponse{}, nil
+}
@@ -1,13 +1,17 @@
 package cluster_test
 
 import (
+	"net/http"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/gorilla/websocket"
 	"github.com/lonng/nano/benchmark/io"
 	"github.com/lonng/nano/benchmark/testdata"
 	"github.com/lonng/nano/cluster"
 	"github.com/lonng/nano/component"
+	"github.com/lonng/nano/metrics"
 	"github.com/lonng/nano/scheduler"
 	"github.com/lonng/nano/session"
 	. "github.com/pingcap/check"
@@ -54,9 +58,11 @@ func (s *nodeSuite) TestNodeStartup(c *C) {
 	masterComps := &component.Components{}
 	masterComps.Register(&MasterComponent{})
 	masterNode := &cluster.Node{
-		IsMaster:    true,
+		Options: cluster.Options{
+			IsMaster:   true,
+			Components: masterComps,
+		},
 		ServiceAddr: "127.0.0.1:4450",
-		Components:  masterComps,
 	}
 	err := masterNode.Startup()
 	c.Assert(err, IsNil)
@@ -66,10 +72,12 @@ func (s *nodeSuite) TestNodeStartup(c *C) {
 	member1Comps := &component.Components{}
 	member1Comps.Register(&GateComponent{})
 	memberNode1 := &cluster.Node{
-		AdvertiseAddr: "127.0.0.1:4450",
-		ServiceAddr:   "127.0.0.1:14451",
-		ClientAddr:    "127.0.0.1:14452",
-		Components:    member1Comps,
+		Options: cluster.Options{
+			AdvertiseAddr: "127.0.0.1:4450",
+			ClientAddr:    "127.0.0.1:14452",
+			Components:    member1Comps,
+		},
+		ServiceAddr: "127.0.0.1:14451",
 	}
 	err = memberNode1.Startup()
 	c.Assert(err, IsNil)
@@ -82,9 +90,11 @@ func (s *nodeSuite) TestNodeStartup(c *C) {
 	member2Comps := &component.Components{}
 	member2Comps.Register(&GameComponent{})
 	memberNode2 := &cluster.Node{
-		AdvertiseAddr: "127.0.0.1:4450",
-		ServiceAddr:   "127.0.0.1:24451",
-		Components:    member2Comps,
+		Options: cluster.Options{
+			AdvertiseAddr: "127.0.0.1:4450",
+			Components:    member2Comps,
+		},
+		ServiceAddr: "127.0.0.1:24451",
 	}
 	err = memberNode2.Startup()
 	c.Assert(err, IsNil)
@@ -135,4 +145,83 @@ func (s *nodeSuite) TestNodeStartup(c *C) {
 	err = connector.Notify("MasterComponent.Test", &testdata.Ping{Content: "ping"})
 	c.Assert(err, IsNil)
 	c.Assert(strings.Contains(<-onResult, "master server pong"), IsTrue)
+
+	// routing decisions of the gate and the forwarded target
+	const name = "nano_route_dispatch_total"
+	c.Assert(metrics.Default.Counter(name, "route", "GateComponent.Test", "mode", "local", "member", "127.0.0.1:14451").Value(), Equals, int64(1))
+	c.Assert(metrics.Default.Counter(name, "route", "GameComponent", "mode", "remote", "member", "127.0.0.1:24451").Value(), Equals, int64(2))
+	c.Assert(metrics.Default.Counter(name, "route", "GameComponent.Test", "mode", "local", "member", "127.0.0.1:24451").Value(), Equals, int64(1))
+}
+
+func (s *nodeSuite) TestWSResponseHeader(c *C) {
+	comps := &component.Components{}
+	comps.Register(&GateComponent{})
+	node := &cluster.Node{
+		Options: cluster.Options{
+			ClientAddr:  "127.0.0.1:14512",
+			IsWebsocket: true,
+			Components:  comps,
+			WSResponseHeader: func(r *http.Request) http.Header {
+				return http.Header{
+					"Sec-Websocket-Protocol": {r.Header.Get("Sec-Websocket-Protocol")},
+					"X-Nano":                 {"test"},
+				}
+			},
+		},
+		ServiceAddr: "127.0.0.1:4510",
+	}
+	err := node.Startup()
+	c.Assert(err, IsNil)
+	defer node.Shutdown()
+
+	dialer := websocket.Dialer{Subprotocols: []string{"nano"}}
+	var conn *websocket.Conn
+	var resp *http.Response
+	for i := 0; i < 10; i++ {
+		conn, resp, err = dialer.Dial("ws://127.0.0.1:14512/", nil)
+		if err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	c.Assert(err, IsNil)
+	defer conn.Close()
+	c.Assert(conn.Subprotocol(), Equals, "nano")
+	c.Assert(resp.Header.Get("X-Nano"), Equals, "test")
+}
+
+// EmptyComponent has no handlers, which fails to register
+type EmptyComponent struct{ component.Base }
+
+func (s *nodeSuite) TestRegistrationMode(c *C) {
+	newNode := func(mode cluster.RegistrationMode) *cluster.Node {
+		comps := &component.Components{}
+		comps.Register(&GateComponent{})
+		comps.Register(&EmptyComponent{})
+		comps.Register(&GameComponent{}, component.WithDependsOn("EmptyComponent"))
+		return &cluster.Node{
+			Options: cluster.Options{
+				Components:       comps,
+				RegistrationMode: mode,
+			},
+			ServiceAddr: "127.0.0.1:0",
+		}
+	}
+
+	node := newNode(cluster.RegistrationStrict)
+	err := node.Startup()
+	c.Assert(err, NotNil)
+	_, partial := err.(*cluster.RegistrationError)
+	c.Assert(partial, IsFalse)
+
+	node = newNode(cluster.RegistrationLenient)
+	err = node.Startup()
+	c.Assert(err, NotNil)
+	defer node.Shutdown()
+	regErr, partial := err.(*cluster.RegistrationError)
+	c.Assert(partial, IsTrue)
+	c.Assert(regErr.Failures, HasLen, 2)
+	c.Assert(regErr.Failures[0].Component, Equals, "EmptyComponent")
+	c.Assert(regErr.Failures[1].Component, Equals, "GameComponent")
+	c.Assert(strings.Contains(regErr.Error(), "depends on the failed component EmptyComponent"), IsTrue)
 }
@@ -0,0 +1,125 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/lonng/nano/cluster/clusterpb"
+	"github.com/lonng/nano/internal/log"
+	"github.com/lonng/nano/internal/message"
+	"github.com/lonng/nano/session"
+)
+
+// Kinds of the traffic teed to the observer members
+const (
+	ObserveNotify    = "notify"
+	ObserveBroadcast = "broadcast"
+)
+
+// Observation is a copy of the traffic teed to an observer member
+type Observation struct {
+	Kind      string // ObserveNotify or ObserveBroadcast
+	Gate      string // service address of the gate, empty for the broadcasts
+	SessionID int64  // zero for the broadcasts
+	UID       int64  // zero for the broadcasts
+	Route     string
+	Data      []byte // payload encoded by the application serializer
+}
+
+// IsObserver returns whether current node is an observer member, which receives
+// the copies of teed traffic and never serves the routes
+func (n *Node) IsObserver() bool {
+	return n.Observe != nil
+}
+
+// TeeBroadcast sends a copy of the broadcast to the observer members if the route
+// is teed, the data must be encoded by the application serializer
+func (n *Node) TeeBroadcast(route string, data []byte) {
+	if n.handler == nil {
+		return
+	}
+	n.handler.tee(&Observation{Kind: ObserveBroadcast, Route: route, Data: data})
+}
+
+// observe delivers the teed traffic to the observer callback
+func (n *Node) observe(o *Observation) {
+	defer func() {
+		if err := recover(); err != nil {
+			log.Println(fmt.Sprintf("Observe %s %s panic: %+v", o.Kind, o.Route, err))
+		}
+	}()
+	n.Observe(o)
+}
+
+// teeNotify sends a copy of the notify message from client to the observer members
+func (h *LocalHandler) teeNotify(s *session.Session, msg *message.Message) {
+	if msg.Type != message.Notify || h.currentNode == nil {
+		return
+	}
+	h.tee(&Observation{
+		Kind:      ObserveNotify,
+		Gate:      h.currentNode.ServiceAddr,
+		SessionID: s.ID(),
+		UID:       s.UID(),
+		Route:     msg.Route,
+		Data:      msg.Data,
+	})
+}
+
+// tee sends the observation to all observer members asynchronously, the delivery is
+// best-effort and never blocks or fails the normal processing
+func (h *LocalHandler) tee(o *Observation) {
+	if h.currentNode == nil || !teed(h.currentNode.TeeRoutes, o.Route) {
+		return
+	}
+
+	h.mu.RLock()
+	observers := make([]string, 0, len(h.observers))
+	for addr := range h.observers {
+		observers = append(observers, addr)
+	}
+	h.mu.RUnlock()
+
+	for _, addr := range observers {
+		go func(addr string) {
+			pool, err := h.currentNode.rpcClient.getConnPool(addr)
+			if err != nil {
+				log.Println(fmt.Sprintf("Tee %s to observer %s failed: %v", o.Route, addr, err))
+				return
+			}
+			client := clusterpb.NewMemberClient(pool.Get())
+			if o.Kind == ObserveNotify {
+				_, err = client.HandleNotify(context.Background(), &clusterpb.NotifyMessage{
+					GateAddr:  o.Gate,
+					SessionId: o.SessionID,
+					Route:     o.Route,
+					Data:      o.Data,
+					Uid:       o.UID,
+				})
+			} else {
+				_, err = client.HandlePush(context.Background(), &clusterpb.PushMessage{
+					Route: o.Route,
+					Data:  o.Data,
+				})
+			}
+			if err != nil {
+				log.Println(fmt.Sprintf("Tee %s to observer %s failed: %v", o.Route, addr, err))
+			}
+		}(addr)
+	}
+}
+
+// teed returns whether the route matches a full route or a service wildcard (e.g: "Chat.*")
+func teed(routes []string, route string) bool {
+	service := route
+	if index := strings.LastIndex(route, "."); index >= 0 {
+		service = route[:index] + ".*"
+	}
+	for _, r := range routes {
+		if r == route || r == service {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,55 @@
+package cluster
+
+import (
+	"encoding/json"
+)
+
+// Versions of the wire protocol, a client declares the latest version it speaks by
+// sys.protocol in the handshake, and the connection uses the lower one of it and
+// ProtocolVersion, the features introduced by a later version are disabled
+const (
+	// ProtocolLegacy is assumed for the clients whose handshake carries no version
+	ProtocolLegacy = 1
+	// ProtocolStream introduces the streamed responses (message flag 0x80) and the
+	// rekey packet of the payload encryption
+	ProtocolStream = 2
+	// ProtocolReliable introduces the reliable pushes carrying a sequence number
+	// (message flag 0x80 of push) and the ack packet
+	ProtocolReliable = 3
+	// ProtocolVersion is the latest version of the wire protocol
+	ProtocolVersion = ProtocolReliable
+)
+
+// codeIncompatible is the handshake response code of the clients whose protocol version
+// is below the minimum version of current node
+const codeIncompatible = 501
+
+// negotiateProtocol returns the protocol version of the connection by the version
+// declared in the handshake data
+func negotiateProtocol(data []byte) int {
+	handshake := struct {
+		Sys struct {
+			Protocol int `json:"protocol"`
+		} `json:"sys"`
+	}{}
+	if len(data) == 0 || json.Unmarshal(data, &handshake) != nil || handshake.Sys.Protocol < ProtocolLegacy {
+		return ProtocolLegacy
+	}
+	if handshake.Sys.Protocol > ProtocolVersion {
+		return ProtocolVersion
+	}
+	return handshake.Sys.Protocol
+}
+
+// supportedProtocols returns the protocol versions accepted by current node which are
+// advertised in the handshake response
+func supportedProtocols(min int) []int {
+	if min < ProtocolLegacy {
+		min = ProtocolLegacy
+	}
+	versions := make([]int, 0, ProtocolVersion-min+1)
+	for v := min; v <= ProtocolVersion; v++ {
+		versions = append(versions, v)
+	}
+	return versions
+}
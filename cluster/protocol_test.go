@@ -0,0 +1,62 @@
+package cluster
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/lonng/nano/session"
+)
+
+type nopCipher struct{}
+
+func (nopCipher) NewKey(_ *session.Session) ([]byte, []byte, error) { return []byte{1}, []byte{1}, nil }
+func (nopCipher) Encrypt(_, payload []byte) ([]byte, error)         { return payload, nil }
+func (nopCipher) Decrypt(_, payload []byte) ([]byte, error)         { return payload, nil }
+
+func TestNegotiateProtocol(t *testing.T) {
+	cases := []struct {
+		data     string
+		protocol int
+	}{
+		{"", ProtocolLegacy},
+		{`{"sys":{"version":"1.1.1"}}`, ProtocolLegacy},
+		{`{"sys":{"protocol":2}}`, ProtocolStream},
+		{`{"sys":{"protocol":99}}`, ProtocolVersion},
+		{`{"sys":{"protocol":-1}}`, ProtocolLegacy},
+		{`not json`, ProtocolLegacy},
+	}
+	for _, c := range cases {
+		if protocol := negotiateProtocol([]byte(c.data)); protocol != c.protocol {
+			t.Fatalf("handshake %q: expected protocol %d, got %d", c.data, c.protocol, protocol)
+		}
+	}
+}
+
+func TestSupportedProtocols(t *testing.T) {
+	if versions := supportedProtocols(0); !reflect.DeepEqual(versions, []int{ProtocolLegacy, ProtocolStream, ProtocolReliable}) {
+		t.Fatalf("unexpected versions %v", versions)
+	}
+	if versions := supportedProtocols(ProtocolReliable); !reflect.DeepEqual(versions, []int{ProtocolReliable}) {
+		t.Fatalf("unexpected versions %v", versions)
+	}
+}
+
+func TestLegacyProtocolFeatures(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+
+	a := newAgent(server, nil, nil)
+	a.setProtocol(ProtocolLegacy)
+	if err := a.StreamResponseMid(1, nil); err != session.ErrStreamUnsupported {
+		t.Fatal("expected stream responses unsupported by the legacy protocol")
+	}
+	if err := a.PushReliable("test", nil); err != session.ErrReliableUnsupported {
+		t.Fatal("expected reliable pushes unsupported by the legacy protocol")
+	}
+	a.cipher = nopCipher{}
+	if err := a.RotateKey(); err != session.ErrKeyRotationUnsupported {
+		t.Fatal("expected key rotation unsupported by the legacy protocol")
+	}
+}
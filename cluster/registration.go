@@ -0,0 +1,86 @@
+package cluster
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/lonng/nano/component"
+	"github.com/lonng/nano/internal/log"
+)
+
+// RegistrationMode decides how the startup handles the components failed to register
+type RegistrationMode int
+
+const (
+	// RegistrationStrict aborts the startup once a component failed to register
+	RegistrationStrict RegistrationMode = iota
+	// RegistrationLenient skips the components failed to register and the ones depending
+	// on them, the node starts up with the rest, e.g: the optional plugin components
+	RegistrationLenient
+)
+
+// RegistrationFailure is a component failed to register in the lenient mode
+type RegistrationFailure struct {
+	Component string
+	Err       error
+}
+
+// RegistrationError is returned by Startup in the lenient mode if some components failed
+// to register, the node has started up with the rest of components when it is returned
+type RegistrationError struct {
+	Failures []RegistrationFailure
+}
+
+func (e *RegistrationError) Error() string {
+	failures := make([]string, 0, len(e.Failures))
+	for _, f := range e.Failures {
+		failures = append(failures, fmt.Sprintf("%s: %v", f.Component, f.Err))
+	}
+	return fmt.Sprintf("%d components failed to register: %s", len(e.Failures), strings.Join(failures, "; "))
+}
+
+// registerComponents registers the components to the local handler, and returns the ones
+// registered. The first failure is returned in the strict mode, and the failures are
+// collected in a RegistrationError in the lenient mode
+func (n *Node) registerComponents(components []component.CompWithOptions) ([]component.CompWithOptions, *RegistrationError, error) {
+	if n.RegistrationMode != RegistrationLenient {
+		for _, c := range components {
+			if err := n.handler.register(c.Comp, c.Opts); err != nil {
+				return nil, nil, err
+			}
+		}
+		return components, nil, nil
+	}
+
+	var (
+		registered []component.CompWithOptions
+		regErr     *RegistrationError
+		failed     = map[string]bool{}
+	)
+	// the components are sorted by the dependencies, so the failed dependencies of a
+	// component are known before it
+	for _, c := range components {
+		name := c.Name()
+		var err error
+		for _, dep := range c.DependsOn() {
+			if failed[dep] {
+				err = fmt.Errorf("depends on the failed component %s", dep)
+				break
+			}
+		}
+		if err == nil {
+			err = n.handler.register(c.Comp, c.Opts)
+		}
+		if err != nil {
+			log.Println(fmt.Sprintf("Component %s failed to register, skipped: %v", name, err))
+			failed[name] = true
+			if regErr == nil {
+				regErr = &RegistrationError{}
+			}
+			regErr.Failures = append(regErr.Failures, RegistrationFailure{Component: name, Err: err})
+			continue
+		}
+		registered = append(registered, c)
+	}
+	return registered, regErr, nil
+}
@@ -0,0 +1,70 @@
+package cluster
+
+import (
+	"sync"
+	"time"
+
+	"github.com/lonng/nano/internal/env"
+	"github.com/lonng/nano/session"
+)
+
+// defaultKeyGraceWindow is the time the replaced key is accepted if not configured
+const defaultKeyGraceWindow = 10 * time.Second
+
+// PayloadCipher encrypts the payloads exchanged with a client connection by the key of
+// the connection, the key is rotated by a rekey packet without reconnecting. The key
+// material is supplied by the application, nano only negotiates and switches the keys
+type PayloadCipher interface {
+	// NewKey returns a new key of the session and the material sent to client, from
+	// which client derives the same key, e.g: the key wrapped by a secret shared with
+	// client, or the id of a pre-shared key. It is called in the handshake and in the
+	// write goroutine of the connection for each rotation, which should not block
+	NewKey(s *session.Session) (key, material []byte, err error)
+
+	// Encrypt encrypts the payload sent to client by the key
+	Encrypt(key, payload []byte) ([]byte, error)
+
+	// Decrypt decrypts the payload received from client by the key, it must fail on the
+	// payloads encrypted by another key, e.g: by an authenticated encryption, since both
+	// keys are tried during the grace window of a rotation
+	Decrypt(key, payload []byte) ([]byte, error)
+}
+
+// connKeys holds the keys of a connection, the replaced key is still accepted until
+// the client acknowledged the rotation or the grace window elapsed
+type connKeys struct {
+	mu       sync.Mutex
+	current  []byte
+	previous []byte
+	expire   time.Time // the time previous key is dropped
+}
+
+// rotate replaces the current key, it returns the replaced one
+func (k *connKeys) rotate(key []byte, grace time.Duration) []byte {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	previous := k.current
+	k.current = key
+	k.previous = previous
+	k.expire = env.Clock.Now().Add(grace)
+	return previous
+}
+
+// acknowledged drops the replaced key after client switched to the current one
+func (k *connKeys) acknowledged() {
+	k.mu.Lock()
+	k.previous = nil
+	k.mu.Unlock()
+}
+
+// keys returns the current key and the replaced key if it is still accepted
+func (k *connKeys) keys() (current, previous []byte) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if k.previous != nil && env.Clock.Now().After(k.expire) {
+		k.previous = nil
+	}
+	return k.current, k.previous
+}
@@ -0,0 +1,137 @@
+package cluster
+
+import (
+	"encoding/binary"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/lonng/nano/internal/env"
+)
+
+// defaultReliableWindow is the unacknowledged reliable pushes retained per connection
+// if not configured
+const defaultReliableWindow = 256
+
+// errInvalidAck represents a malformed ack packet
+var errInvalidAck = errors.New("invalid ack packet")
+
+type (
+	// reliableWindow retains the reliable pushes of a connection until client acknowledged
+	// them, the pushes not acknowledged within a heartbeat interval are retransmitted
+	reliableWindow struct {
+		mu       sync.Mutex
+		seq      uint64 // sequence number of the last push
+		limit    int
+		retained map[uint64]*retainedPush
+	}
+
+	retainedPush struct {
+		msg    pendingMessage
+		sentAt time.Time
+	}
+)
+
+func newReliableWindow(limit int) *reliableWindow {
+	if limit <= 0 {
+		limit = defaultReliableWindow
+	}
+	return &reliableWindow{limit: limit, retained: map[uint64]*retainedPush{}}
+}
+
+// retain assigns the next sequence number to the push and retains it, false will be
+// returned if the unacknowledged pushes fill the window
+func (w *reliableWindow) retain(m pendingMessage) (pendingMessage, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if len(w.retained) >= w.limit {
+		return m, false
+	}
+	w.seq++
+	m.seq = w.seq
+	w.retained[m.seq] = &retainedPush{msg: m, sentAt: env.Clock.Now()}
+	return m, true
+}
+
+// ack releases the pushes up to the cumulative sequence number and the pushes in the
+// ranges, and returns the number of released pushes
+func (w *reliableWindow) ack(cumulative uint64, ranges [][2]uint64) int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	released := 0
+	for seq := range w.retained {
+		acked := seq <= cumulative
+		for _, r := range ranges {
+			if acked {
+				break
+			}
+			acked = seq >= r[0] && seq <= r[1]
+		}
+		if acked {
+			delete(w.retained, seq)
+			released++
+		}
+	}
+	return released
+}
+
+// drop releases the push which cannot be sent
+func (w *reliableWindow) drop(seq uint64) {
+	w.mu.Lock()
+	delete(w.retained, seq)
+	w.mu.Unlock()
+}
+
+// overdue returns the pushes sent before the deadline in sequence order, which will be
+// retransmitted at now
+func (w *reliableWindow) overdue(deadline, now time.Time) []pendingMessage {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var pushes []pendingMessage
+	for _, p := range w.retained {
+		if p.sentAt.Before(deadline) {
+			p.sentAt = now
+			pushes = append(pushes, p.msg)
+		}
+	}
+	sort.Slice(pushes, func(i, j int) bool { return pushes[i].seq < pushes[j].seq })
+	return pushes
+}
+
+// unacked returns the number of the pushes not acknowledged
+func (w *reliableWindow) unacked() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return len(w.retained)
+}
+
+// decodeAck decodes the body of an ack packet, which is composed of the cumulative
+// sequence number followed by the pairs of the first and last sequence numbers of the
+// ranges acknowledged beyond it, all encoded in uvarint
+func decodeAck(data []byte) (uint64, [][2]uint64, error) {
+	cumulative, n := binary.Uvarint(data)
+	if n <= 0 {
+		return 0, nil, errInvalidAck
+	}
+	data = data[n:]
+
+	var ranges [][2]uint64
+	for len(data) > 0 {
+		first, n := binary.Uvarint(data)
+		if n <= 0 {
+			return 0, nil, errInvalidAck
+		}
+		data = data[n:]
+		last, n := binary.Uvarint(data)
+		if n <= 0 || last < first {
+			return 0, nil, errInvalidAck
+		}
+		data = data[n:]
+		ranges = append(ranges, [2]uint64{first, last})
+	}
+	return cumulative, ranges, nil
+}
@@ -0,0 +1,115 @@
+package cluster
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/lonng/nano/internal/codec"
+	"github.com/lonng/nano/internal/message"
+	"github.com/lonng/nano/internal/packet"
+)
+
+// encodeAck encodes the body of an ack packet
+func encodeAck(cumulative uint64, ranges ...[2]uint64) []byte {
+	buf := make([]byte, 0, binary.MaxVarintLen64*(1+2*len(ranges)))
+	var tmp [binary.MaxVarintLen64]byte
+	buf = append(buf, tmp[:binary.PutUvarint(tmp[:], cumulative)]...)
+	for _, r := range ranges {
+		buf = append(buf, tmp[:binary.PutUvarint(tmp[:], r[0])]...)
+		buf = append(buf, tmp[:binary.PutUvarint(tmp[:], r[1])]...)
+	}
+	return buf
+}
+
+func TestDecodeAck(t *testing.T) {
+	cumulative, ranges, err := decodeAck(encodeAck(300, [2]uint64{302, 305}, [2]uint64{400, 400}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cumulative != 300 || len(ranges) != 2 || ranges[0] != [2]uint64{302, 305} || ranges[1] != [2]uint64{400, 400} {
+		t.Fatalf("unexpected ack %d %v", cumulative, ranges)
+	}
+
+	for _, data := range [][]byte{nil, encodeAck(1)[:0], append(encodeAck(1), 0x05), encodeAck(1, [2]uint64{5, 3})} {
+		if _, _, err := decodeAck(data); err != errInvalidAck {
+			t.Fatalf("expect invalid ack %v, got %v", data, err)
+		}
+	}
+}
+
+func TestReliableWindow(t *testing.T) {
+	w := newReliableWindow(4)
+	for i := 1; i <= 4; i++ {
+		m, ok := w.retain(pendingMessage{route: "tick"})
+		if !ok || m.seq != uint64(i) {
+			t.Fatalf("expect sequence number %d, got %d (%v)", i, m.seq, ok)
+		}
+	}
+	if _, ok := w.retain(pendingMessage{route: "tick"}); ok {
+		t.Fatal("window should be full")
+	}
+
+	// the pushes 1, 2 and 4 are acknowledged in batch
+	if released := w.ack(2, [][2]uint64{{4, 9}}); released != 3 {
+		t.Fatalf("expect 3 pushes released, got %d", released)
+	}
+	if w.unacked() != 1 {
+		t.Fatalf("expect 1 push unacknowledged, got %d", w.unacked())
+	}
+
+	now := time.Now()
+	overdue := w.overdue(now.Add(time.Second), now)
+	if len(overdue) != 1 || overdue[0].seq != 3 {
+		t.Fatalf("expect push 3 overdue, got %v", overdue)
+	}
+	if overdue = w.overdue(now, now); len(overdue) != 0 {
+		t.Fatalf("retransmitted push should not be overdue again, got %v", overdue)
+	}
+
+	m, ok := w.retain(pendingMessage{route: "tick"})
+	if !ok || m.seq != 5 {
+		t.Fatalf("expect sequence number 5, got %d (%v)", m.seq, ok)
+	}
+}
+
+func TestAgentPushReliable(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+
+	h := NewHandler(nil, nil)
+	a := newAgent(server, nil, nil)
+	a.setStatus(statusWorking)
+	go a.write()
+	defer a.Close()
+
+	decoder := codec.NewDecoder()
+	for i := 1; i <= 3; i++ {
+		if err := a.PushReliable("tick", []byte("price")); err != nil {
+			t.Fatal(err)
+		}
+		msg := readMessage(t, client, decoder)
+		if msg.Type != message.Push || msg.Seq != uint64(i) {
+			t.Fatalf("expect reliable push %d, got %+v", i, msg)
+		}
+	}
+	if n := a.session.Unacked(); n != 3 {
+		t.Fatalf("expect 3 pushes unacknowledged, got %d", n)
+	}
+
+	ack, err := codec.Encode(packet.Ack, encodeAck(1, [2]uint64{3, 3}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	packets, err := codec.NewDecoder().Decode(ack)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := h.processPacket(a, packets[0]); err != nil {
+		t.Fatal(err)
+	}
+	if n := a.session.Unacked(); n != 1 {
+		t.Fatalf("expect 1 push unacknowledged, got %d", n)
+	}
+}
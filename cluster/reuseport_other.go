@@ -0,0 +1,10 @@
+// +build !linux,!darwin,!dragonfly,!freebsd,!netbsd,!openbsd
+
+package cluster
+
+import "errors"
+
+// setReusePort is unsupported on the platforms without SO_REUSEPORT
+func setReusePort(_ uintptr) error {
+	return errors.New("reuse port unsupported on this platform")
+}
@@ -0,0 +1,11 @@
+// +build linux darwin dragonfly freebsd netbsd openbsd
+
+package cluster
+
+import "golang.org/x/sys/unix"
+
+// setReusePort enables SO_REUSEPORT on the socket, which allows the listeners to bind
+// the same address
+func setReusePort(fd uintptr) error {
+	return unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEPORT, 1)
+}
@@ -0,0 +1,97 @@
+package cluster
+
+import (
+	"hash/fnv"
+	"sort"
+	"strconv"
+
+	"github.com/lonng/nano/cluster/clusterpb"
+	"github.com/lonng/nano/session"
+)
+
+// defaultVirtualNodes is the virtual nodes per member of the hash ring, which keeps the
+// keys spread within a few percent of even for the usual cluster sizes
+const defaultVirtualNodes = 160
+
+// ShardKeyFunc extracts the shard key of a message forwarded to the remote members,
+// e.g: the room id carried in the payload. The messages of the same shard key are
+// routed to the same member of the service, an empty key routes the message by the
+// session as usual
+type ShardKeyFunc func(s *session.Session, route string, payload []byte) string
+
+// hashRing is the consistent hash ring of the members of a service. Each member is
+// placed on the ring as many virtual nodes, and a key is owned by the member of the
+// first virtual node clockwise from the hash of the key. Adding or removing a member
+// only moves the keys of its virtual nodes, and every gate builds the same ring from
+// the same members without sharing any state.
+//
+// A ring is immutable, the handler replaces the ring of a service as a whole when the
+// members change, so the lookups always see a consistent ring without locking it
+type hashRing struct {
+	points  []uint64
+	members []*clusterpb.MemberInfo // the owner of each point
+}
+
+type ringPoint struct {
+	hash   uint64
+	member *clusterpb.MemberInfo
+}
+
+func newHashRing(members []*clusterpb.MemberInfo, virtualNodes int) *hashRing {
+	if virtualNodes <= 0 {
+		virtualNodes = defaultVirtualNodes
+	}
+	points := make([]ringPoint, 0, len(members)*virtualNodes)
+	for _, m := range members {
+		for i := 0; i < virtualNodes; i++ {
+			points = append(points, ringPoint{hash: hashKey(m.ServiceAddr + "#" + strconv.Itoa(i)), member: m})
+		}
+	}
+	// ties are broken by address, which keeps the ring independent of the member order
+	sort.Slice(points, func(i, j int) bool {
+		if points[i].hash != points[j].hash {
+			return points[i].hash < points[j].hash
+		}
+		return points[i].member.ServiceAddr < points[j].member.ServiceAddr
+	})
+
+	r := &hashRing{
+		points:  make([]uint64, len(points)),
+		members: make([]*clusterpb.MemberInfo, len(points)),
+	}
+	for i, p := range points {
+		r.points[i] = p.hash
+		r.members[i] = p.member
+	}
+	return r
+}
+
+// owner returns the member owning the key, nil if the ring is empty
+func (r *hashRing) owner(key string) *clusterpb.MemberInfo {
+	if r == nil || len(r.points) == 0 {
+		return nil
+	}
+	h := hashKey(key)
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= h })
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.members[i]
+}
+
+func hashKey(key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	return mix(h.Sum64())
+}
+
+// mix is the finalizer of splitmix64, the addresses of members usually differ in the
+// last bytes only, which fnv alone does not spread to the high bits
+func mix(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
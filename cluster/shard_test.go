@@ -0,0 +1,120 @@
+package cluster
+
+import (
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/lonng/nano/cluster/clusterpb"
+)
+
+func ringMembers(n int) []*clusterpb.MemberInfo {
+	var members []*clusterpb.MemberInfo
+	for i := 0; i < n; i++ {
+		members = append(members, &clusterpb.MemberInfo{ServiceAddr: fmt.Sprintf("127.0.0.1:%d", 5000+i)})
+	}
+	return members
+}
+
+func TestHashRingUniformity(t *testing.T) {
+	const keys = 100000
+	members := ringMembers(8)
+	ring := newHashRing(members, 0)
+
+	counts := map[string]int{}
+	for i := 0; i < keys; i++ {
+		counts[ring.owner(fmt.Sprintf("room-%d", i)).ServiceAddr]++
+	}
+	if len(counts) != len(members) {
+		t.Fatalf("keys are owned by %d of %d members", len(counts), len(members))
+	}
+	expected := float64(keys) / float64(len(members))
+	for addr, c := range counts {
+		if dev := math.Abs(float64(c)-expected) / expected; dev > 0.2 {
+			t.Fatalf("member %s owns %d keys, %.1f%% off the even share", addr, c, dev*100)
+		}
+	}
+}
+
+func TestHashRingMembershipChange(t *testing.T) {
+	const keys = 10000
+	members := ringMembers(4)
+	ring := newHashRing(members, 0)
+
+	before := map[string]string{}
+	for i := 0; i < keys; i++ {
+		key := fmt.Sprintf("room-%d", i)
+		before[key] = ring.owner(key).ServiceAddr
+	}
+
+	// the order of members must not matter
+	reversed := make([]*clusterpb.MemberInfo, len(members))
+	for i, m := range members {
+		reversed[len(members)-1-i] = m
+	}
+	ring = newHashRing(reversed, 0)
+	for key, addr := range before {
+		if got := ring.owner(key).ServiceAddr; got != addr {
+			t.Fatalf("key %s moved from %s to %s after reordering", key, addr, got)
+		}
+	}
+
+	// adding a member only moves keys to the new member
+	added := &clusterpb.MemberInfo{ServiceAddr: "127.0.0.1:5004"}
+	ring = newHashRing(append(members, added), 0)
+	moved := 0
+	for key, addr := range before {
+		got := ring.owner(key).ServiceAddr
+		if got == addr {
+			continue
+		}
+		if got != added.ServiceAddr {
+			t.Fatalf("key %s moved from %s to %s", key, addr, got)
+		}
+		moved++
+	}
+	if moved == 0 || moved > keys*3/10 {
+		t.Fatalf("moved %d of %d keys after adding a member", moved, keys)
+	}
+
+	// removing a member only moves the keys of the removed member
+	removed := members[1]
+	ring = newHashRing([]*clusterpb.MemberInfo{members[0], members[2], members[3]}, 0)
+	for key, addr := range before {
+		got := ring.owner(key).ServiceAddr
+		if addr != removed.ServiceAddr && got != addr {
+			t.Fatalf("key %s moved from %s to %s", key, addr, got)
+		}
+		if got == removed.ServiceAddr {
+			t.Fatalf("key %s is owned by the removed member", key)
+		}
+	}
+}
+
+func TestShardOwner(t *testing.T) {
+	h := NewHandler(nil, nil)
+	if _, found := h.ShardOwner("Room", "room-1"); found {
+		t.Fatal("expect no owner without members")
+	}
+
+	members := ringMembers(3)
+	for _, m := range members {
+		m.Services = []string{"Room"}
+		h.addRemoteService(m)
+	}
+	owner, found := h.ShardOwner("Room", "room-1")
+	if !found {
+		t.Fatal("expect an owner")
+	}
+
+	h.delMember(owner)
+	next, found := h.ShardOwner("Room", "room-1")
+	if !found || next == owner {
+		t.Fatalf("expect the key to move off the removed member, got %s", next)
+	}
+
+	h.syncRemoteService(members)
+	if addr, _ := h.ShardOwner("Room", "room-1"); addr != owner {
+		t.Fatalf("expect the key back on %s after sync, got %s", owner, addr)
+	}
+}
@@ -0,0 +1,71 @@
+package cluster
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/lonng/nano/cluster/clusterpb"
+	"github.com/lonng/nano/internal/env"
+	"github.com/lonng/nano/internal/log"
+)
+
+// defaultRegistrySnapshotInterval is the interval of the registry snapshots if not configured
+const defaultRegistrySnapshotInterval = 10 * time.Second
+
+// snapshotRegistry snapshots the registered members to RegistrySnapshotPath periodically,
+// and takes the last one at shutdown
+func (n *Node) snapshotRegistry() {
+	interval := n.RegistrySnapshotInterval
+	if interval <= 0 {
+		interval = defaultRegistrySnapshotInterval
+	}
+	ticker := env.Clock.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C():
+			if err := writeRegistrySnapshot(n.RegistrySnapshotPath, n.cluster.exportRegistry()); err != nil {
+				log.Println("Write registry snapshot failed", n.RegistrySnapshotPath, err)
+			}
+		case <-n.chDie:
+			if err := writeRegistrySnapshot(n.RegistrySnapshotPath, n.cluster.exportRegistry()); err != nil {
+				log.Println("Write registry snapshot failed", n.RegistrySnapshotPath, err)
+			}
+			return
+		}
+	}
+}
+
+// writeRegistrySnapshot writes the members to a temporary file and renames it to the
+// path, so a crash during writing never leaves a partial snapshot
+func writeRegistrySnapshot(path string, members []*clusterpb.MemberInfo) error {
+	data, err := json.Marshal(members)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// readRegistrySnapshot reads the members from the snapshot, no member will be returned
+// if the snapshot does not exist
+func readRegistrySnapshot(path string) ([]*clusterpb.MemberInfo, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var members []*clusterpb.MemberInfo
+	if err := json.Unmarshal(data, &members); err != nil {
+		return nil, err
+	}
+	return members, nil
+}
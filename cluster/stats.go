@@ -0,0 +1,46 @@
+package cluster
+
+import (
+	"runtime"
+
+	"github.com/lonng/nano/metrics"
+)
+
+// NodeStats is a snapshot of the statistics of current node
+type NodeStats struct {
+	Connections int64            // client connections
+	MessagesIn  int64            // data messages received from clients
+	MessagesOut int64            // data messages sent to clients
+	Goroutines  int              // goroutines of the process
+	Members     int              // known members of the cluster
+	Routes      map[string]int64 // messages handled by local services by route
+}
+
+// Stats returns the statistics of current node computed from the internal metrics,
+// which is cheap enough to be called frequently, e.g: by an in-game debug overlay
+func (n *Node) Stats() NodeStats {
+	stats := NodeStats{
+		Connections: metrics.Default.Gauge(metricConnections, "member", n.ServiceAddr).Value(),
+		MessagesIn:  metrics.Default.Counter(metricMessagesIn, "member", n.ServiceAddr).Value(),
+		MessagesOut: metrics.Default.Counter(metricMessagesOut, "member", n.ServiceAddr).Value(),
+		Goroutines:  runtime.NumGoroutine(),
+		Routes:      map[string]int64{},
+	}
+
+	if n.cluster != nil {
+		n.cluster.mu.RLock()
+		stats.Members = len(n.cluster.members)
+		n.cluster.mu.RUnlock()
+	}
+
+	for _, sample := range metrics.Default.Snapshot() {
+		if sample.Name != metricRouteDispatch {
+			continue
+		}
+		if sample.Labels["mode"] != "local" || sample.Labels["member"] != n.ServiceAddr {
+			continue
+		}
+		stats.Routes[sample.Labels["route"]] += sample.Value
+	}
+	return stats
+}
@@ -0,0 +1,108 @@
+package cluster
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/lonng/nano/cluster/clusterpb"
+	"github.com/lonng/nano/component"
+	"github.com/lonng/nano/internal/env"
+	"github.com/lonng/nano/internal/log"
+)
+
+// clusterShutdownPollInterval is the interval that the master checks whether the drained
+// members have left the cluster
+const clusterShutdownPollInterval = 50 * time.Millisecond
+
+// ClusterShutdown tears down the whole cluster in order: the master refuses the new client
+// connections and drains the members, which refuse the new client connections and shut
+// down, then the master shuts down once all members unregistered, or ctx is done. The
+// members registered meanwhile are drained as well. The shutdown reason and deadline of
+// ctx are passed to the members, ctx.Err() is returned if the members did not leave in time
+func (n *Node) ClusterShutdown(ctx context.Context) error {
+	if !n.IsMaster {
+		return ErrNotMaster
+	}
+	atomic.StoreInt32(&n.draining, 1)
+	log.Println("Cluster is shutting down, draining the members")
+
+	drained := map[string]bool{}
+	ticker := env.Clock.NewTicker(clusterShutdownPollInterval)
+	defer ticker.Stop()
+
+	var err error
+WAIT:
+	for {
+		var remaining int
+		for _, addr := range n.cluster.remoteAddrs() {
+			if addr == n.ServiceAddr {
+				continue
+			}
+			remaining++
+			if !drained[addr] {
+				drained[addr] = true
+				go n.drainMember(ctx, addr)
+			}
+		}
+		if remaining == 0 {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			err = ctx.Err()
+			log.Println("Cluster shutdown timeout, members are still registered", remaining)
+			break WAIT
+		case <-ticker.C():
+		}
+	}
+
+	n.ShutdownContext(ctx)
+	return err
+}
+
+// drainMember requests the member to drain and shut down with the reason and the remaining
+// time of ctx
+func (n *Node) drainMember(ctx context.Context, addr string) {
+	request := &clusterpb.DrainRequest{
+		Reason: string(component.ShutdownReasonFrom(ctx)),
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		request.Timeout = int64(time.Until(deadline) / time.Millisecond)
+	}
+	pool, err := n.rpcClient.getConnPool(addr)
+	if err != nil {
+		log.Println("Retrieve member address error", addr, err)
+		return
+	}
+	if _, err := clusterpb.NewMemberClient(pool.Get()).Drain(ctx, request); err != nil {
+		log.Println("Drain member failed", addr, err)
+	}
+}
+
+// Drain implements the MemberServer interface, current node refuses the new client
+// connections and shuts down in background, which unregisters it from the master
+func (n *Node) Drain(_ context.Context, req *clusterpb.DrainRequest) (*clusterpb.DrainResponse, error) {
+	atomic.StoreInt32(&n.draining, 1)
+	log.Println("Node is drained by the master, reason", req.Reason)
+
+	ctx := context.Background()
+	if req.Reason != "" {
+		ctx = component.WithShutdownReason(ctx, component.ShutdownReason(req.Reason))
+	}
+	go func() {
+		if req.Timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, time.Duration(req.Timeout)*time.Millisecond)
+			defer cancel()
+		}
+		n.ShutdownContext(ctx)
+	}()
+	return &clusterpb.DrainResponse{}, nil
+}
+
+// Done returns a channel which is closed once current node starts to shut down, e.g:
+// drained by the master in a cluster shutdown
+func (n *Node) Done() <-chan struct{} {
+	return n.chDie
+}
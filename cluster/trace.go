@@ -0,0 +1,95 @@
+package cluster
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"strings"
+
+	"github.com/lonng/nano/internal/log"
+	"github.com/lonng/nano/internal/message"
+	"github.com/lonng/nano/session"
+)
+
+// redactedValue replaces the values of the sensitive fields in the traces
+const redactedValue = "[REDACTED]"
+
+// messageTracer logs the messages exchanged with clients in the readable form for
+// debugging the protocol, it is nil unless the tracing enabled
+type messageTracer struct {
+	rate   float64         // sample rate in (0, 1]
+	redact map[string]bool // lower-cased names of the sensitive fields
+}
+
+func newMessageTracer(rate float64, redact []string) *messageTracer {
+	if rate <= 0 || rate > 1 {
+		rate = 1
+	}
+	t := &messageTracer{rate: rate, redact: map[string]bool{}}
+	for _, field := range redact {
+		t.redact[strings.ToLower(field)] = true
+	}
+	return t
+}
+
+// trace logs the message if it is sampled, the direction is "in" or "out"
+func (t *messageTracer) trace(direction string, s *session.Session, typ message.Type, route string, mid uint64, v interface{}) {
+	if t == nil || (t.rate < 1 && rand.Float64() >= t.rate) {
+		return
+	}
+	var uid int64
+	if s != nil {
+		uid = s.UID()
+	}
+	log.Println(fmt.Sprintf("Trace %s %s %s(id: %d), UID=%d, Data=%s", direction, typ, route, mid, uid, t.format(v)))
+}
+
+// format returns the payload in JSON with the sensitive fields redacted, the payloads
+// are converted in the way of JSON serializer regardless of the application serializer,
+// the serialized payloads which are not JSON are represented by their length only
+func (t *messageTracer) format(v interface{}) string {
+	var data []byte
+	switch p := v.(type) {
+	case []byte:
+		if !json.Valid(p) {
+			return fmt.Sprintf("<%d bytes>", len(p))
+		}
+		data = p
+	default:
+		var err error
+		if data, err = json.Marshal(v); err != nil {
+			return fmt.Sprintf("<%T: %v>", v, err)
+		}
+	}
+
+	var doc interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return fmt.Sprintf("<%d bytes>", len(data))
+	}
+	if len(t.redact) > 0 {
+		doc = t.redactValue(doc)
+	}
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return fmt.Sprintf("<%d bytes>", len(data))
+	}
+	return string(data)
+}
+
+func (t *messageTracer) redactValue(v interface{}) interface{} {
+	switch x := v.(type) {
+	case map[string]interface{}:
+		for k, val := range x {
+			if t.redact[strings.ToLower(k)] {
+				x[k] = redactedValue
+			} else {
+				x[k] = t.redactValue(val)
+			}
+		}
+	case []interface{}:
+		for i, val := range x {
+			x[i] = t.redactValue(val)
+		}
+	}
+	return v
+}
@@ -0,0 +1,44 @@
+package cluster
+
+import (
+	"testing"
+)
+
+func TestMessageTracerFormat(t *testing.T) {
+	tracer := newMessageTracer(0, []string{"Token", "password"})
+	if tracer.rate != 1 {
+		t.Fatalf("expect all messages traced, got rate %v", tracer.rate)
+	}
+
+	type login struct {
+		Name     string              `json:"name"`
+		Token    string              `json:"token"`
+		Profiles []map[string]string `json:"profiles"`
+	}
+	cases := []struct {
+		payload interface{}
+		expect  string
+	}{
+		{
+			payload: &login{Name: "nano", Token: "secret", Profiles: []map[string]string{{"password": "secret", "nick": "n"}}},
+			expect:  `{"name":"nano","profiles":[{"nick":"n","password":"[REDACTED]"}],"token":"[REDACTED]"}`,
+		},
+		{
+			payload: []byte(`{"TOKEN":"secret","room":1}`),
+			expect:  `{"TOKEN":"[REDACTED]","room":1}`,
+		},
+		{
+			payload: []byte{0x0a, 0x04, 0x6e, 0x61, 0x6e, 0x6f},
+			expect:  "<6 bytes>",
+		},
+	}
+	for _, c := range cases {
+		if got := tracer.format(c.payload); got != c.expect {
+			t.Fatalf("expect %s, got %s", c.expect, got)
+		}
+	}
+
+	// the tracing of nil tracer is a no-op
+	var disabled *messageTracer
+	disabled.trace("in", nil, 0, "Room.Join", 1, nil)
+}
@@ -0,0 +1,133 @@
+package cluster
+
+import (
+	"bytes"
+	"fmt"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/lonng/nano/internal/env"
+	"github.com/lonng/nano/internal/log"
+	"github.com/lonng/nano/metrics"
+	"github.com/lonng/nano/scheduler"
+)
+
+// metricDispatcherStalls counts the handlers which blocked the dispatcher longer than the
+// stall threshold, labeled by route
+const metricDispatcherStalls = "nano_dispatcher_stalls_total"
+
+// stallWatchdog watches the handlers running on the shared dispatcher, the handler running
+// longer than the threshold stalls all the sessions, which is reported with the stack of
+// the dispatcher once per handler. It is nil unless the stall threshold configured
+type stallWatchdog struct {
+	threshold time.Duration
+
+	mu       sync.Mutex
+	gid      string    // goroutine id of the dispatcher
+	route    string    // route of the running handler, empty if idle
+	startAt  time.Time // start time of the running handler
+	reported bool      // the running handler has been reported
+}
+
+func newStallWatchdog(threshold time.Duration) *stallWatchdog {
+	return &stallWatchdog{threshold: threshold}
+}
+
+// wrap records the running of the handler of route on the dispatcher
+func (w *stallWatchdog) wrap(route string, task scheduler.Task) scheduler.Task {
+	if w == nil {
+		return task
+	}
+	return func() {
+		w.begin(route)
+		defer w.end()
+		task()
+	}
+}
+
+func (w *stallWatchdog) begin(route string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.gid == "" {
+		w.gid = goroutineID()
+	}
+	w.route, w.startAt, w.reported = route, env.Clock.Now(), false
+}
+
+func (w *stallWatchdog) end() {
+	w.mu.Lock()
+	w.route = ""
+	w.mu.Unlock()
+}
+
+// watch checks the running handler periodically until die closed
+func (w *stallWatchdog) watch(die <-chan struct{}) {
+	interval := w.threshold / 4
+	if interval < 10*time.Millisecond {
+		interval = 10 * time.Millisecond
+	}
+	ticker := env.Clock.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C():
+			w.check(now)
+		case <-die:
+			return
+		}
+	}
+}
+
+// check reports the handler running longer than the threshold
+func (w *stallWatchdog) check(now time.Time) {
+	w.mu.Lock()
+	route, elapsed, gid := w.route, now.Sub(w.startAt), w.gid
+	stalled := route != "" && !w.reported && elapsed > w.threshold
+	if stalled {
+		w.reported = true
+	}
+	w.mu.Unlock()
+	if !stalled {
+		return
+	}
+
+	metrics.Default.Counter(metricDispatcherStalls, "route", route).Inc()
+	log.Println(fmt.Sprintf("Dispatcher is blocked by handler %s for %s, the sessions are stalled\n%s",
+		route, elapsed, goroutineStack(gid)))
+}
+
+// goroutineID returns the id of the current goroutine, which is parsed from the
+// header of its stack, e.g: "goroutine 18 [running]:"
+func goroutineID() string {
+	buf := make([]byte, 64)
+	buf = buf[:runtime.Stack(buf, false)]
+	fields := bytes.Fields(buf)
+	if len(fields) < 2 {
+		return ""
+	}
+	return string(fields[1])
+}
+
+// goroutineStack returns the stack of the goroutine by id, or the stacks of all goroutines
+// if the goroutine is not found
+func goroutineStack(gid string) []byte {
+	buf := make([]byte, 64<<10)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+	header := []byte("goroutine " + gid + " [")
+	for _, stack := range bytes.Split(buf, []byte("\n\n")) {
+		if gid != "" && bytes.HasPrefix(stack, header) {
+			return stack
+		}
+	}
+	return buf
+}
@@ -0,0 +1,64 @@
+package cluster
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/lonng/nano/metrics"
+)
+
+func blockingHandler(release chan struct{}) {
+	<-release
+}
+
+func TestStallWatchdog(t *testing.T) {
+	w := newStallWatchdog(50 * time.Millisecond)
+	stalls := metrics.Default.Counter(metricDispatcherStalls, "route", "Watchdog.Block")
+	before := stalls.Value()
+
+	release, done := make(chan struct{}), make(chan struct{})
+	go func() {
+		w.wrap("Watchdog.Block", func() { blockingHandler(release) })()
+		close(done)
+	}()
+	for {
+		w.mu.Lock()
+		running := w.route != ""
+		w.mu.Unlock()
+		if running {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	w.check(time.Now())
+	if v := stalls.Value() - before; v != 0 {
+		t.Fatalf("expect no stall before the threshold, got %d", v)
+	}
+
+	// the stalled handler is reported once
+	w.check(time.Now().Add(time.Second))
+	w.check(time.Now().Add(2 * time.Second))
+	if v := stalls.Value() - before; v != 1 {
+		t.Fatalf("expect 1 stall, got %d", v)
+	}
+	if stack := goroutineStack(w.gid); !bytes.Contains(stack, []byte("blockingHandler")) {
+		t.Fatalf("expect the stack of the blocked goroutine, got %s", stack)
+	}
+
+	close(release)
+	<-done
+	w.check(time.Now().Add(time.Second))
+	if v := stalls.Value() - before; v != 1 {
+		t.Fatalf("expect no stall after the handler returned, got %d", v)
+	}
+
+	// nil watchdog leaves the task unchanged
+	var disabled *stallWatchdog
+	var called bool
+	disabled.wrap("Watchdog.Block", func() { called = true })()
+	if !called {
+		t.Fatal("expect the task called")
+	}
+}
@@ -26,8 +26,35 @@ import (
 	"time"
 
 	"github.com/gorilla/websocket"
+	"github.com/lonng/nano/session"
 )
 
+// The WebSocket close codes of the close reasons specific to nano, which are in the
+// range 4000-4999 reserved for the applications
+const (
+	WSCloseKicked  = 4001 // kicked or closed by server
+	WSCloseTimeout = 4002 // client has not sent a heartbeat in time
+)
+
+// wsCloseTimeout bounds the write of the close frame to the client
+const wsCloseTimeout = time.Second
+
+// wsCloseCode returns the WebSocket close code and text of the close reason
+func wsCloseCode(reason session.CloseReason) (int, string) {
+	switch reason {
+	case session.ServerShutdown:
+		return websocket.CloseGoingAway, "server shutdown"
+	case session.Kicked:
+		return WSCloseKicked, "kicked"
+	case session.Timeout:
+		return WSCloseTimeout, "heartbeat timeout"
+	case session.ReadError:
+		return websocket.CloseProtocolError, "invalid data"
+	default:
+		return websocket.CloseNormalClosure, ""
+	}
+}
+
 // wsConn is an adapter to t.Conn, which implements all t.Conn
 // interface base on *websocket.Conn
 type wsConn struct {
@@ -87,6 +114,14 @@ func (c *wsConn) Close() error {
 	return c.conn.Close()
 }
 
+// closeWithReason sends the close frame of the reason to the client before closing the
+// connection, the error of the close frame is ignored, e.g: the client has gone
+func (c *wsConn) closeWithReason(reason session.CloseReason) error {
+	code, text := wsCloseCode(reason)
+	c.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, text), time.Now().Add(wsCloseTimeout))
+	return c.conn.Close()
+}
+
 // LocalAddr returns the local network address.
 func (c *wsConn) LocalAddr() net.Addr {
 	return c.conn.LocalAddr()
@@ -0,0 +1,48 @@
+package cluster
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/lonng/nano/session"
+)
+
+func TestWSCloseWithReason(t *testing.T) {
+	tests := []struct {
+		reason session.CloseReason
+		code   int
+		text   string
+	}{
+		{session.Kicked, WSCloseKicked, "kicked"},
+		{session.Timeout, WSCloseTimeout, "heartbeat timeout"},
+		{session.ServerShutdown, websocket.CloseGoingAway, "server shutdown"},
+		{session.ReadError, websocket.CloseProtocolError, "invalid data"},
+		{session.ClientClosed, websocket.CloseNormalClosure, ""},
+	}
+
+	for _, tt := range tests {
+		upgrader := websocket.Upgrader{}
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			conn, err := upgrader.Upgrade(w, r, nil)
+			if err != nil {
+				return
+			}
+			(&wsConn{conn: conn}).closeWithReason(tt.reason)
+		}))
+
+		conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _, err = conn.ReadMessage()
+		e, ok := err.(*websocket.CloseError)
+		if !ok || e.Code != tt.code || e.Text != tt.text {
+			t.Fatalf("unexpected close of reason %s: %v", tt.reason, err)
+		}
+		conn.Close()
+		server.Close()
+	}
+}
@@ -0,0 +1,80 @@
+// Package codec defines the PacketCodec interface, which frames the packets of client
+// connections, to adapt nano to the clients speaking a non-standard framing
+package codec
+
+import (
+	"io"
+
+	"github.com/lonng/nano/internal/codec"
+	"github.com/lonng/nano/internal/packet"
+)
+
+type (
+	// Packet is the alias of `packet.Packet`
+	Packet = packet.Packet
+
+	// Type is the alias of `packet.Type`
+	Type = packet.Type
+
+	// Framing is the alias of the length encoding of the built-in codec
+	Framing = codec.Framing
+)
+
+// Packet types
+const (
+	Handshake    Type = packet.Handshake
+	HandshakeAck Type = packet.HandshakeAck
+	Heartbeat    Type = packet.Heartbeat
+	Data         Type = packet.Data
+	Kick         Type = packet.Kick
+	Rekey        Type = packet.Rekey
+	Ack          Type = packet.Ack
+)
+
+// Length encodings of the built-in codec
+const (
+	FramingFixed24 = codec.FramingFixed24
+	FramingFixed32 = codec.FramingFixed32
+	FramingVarint  = codec.FramingVarint
+)
+
+// Errors of the built-in codec
+var (
+	ErrWrongPacketType  = packet.ErrWrongPacketType
+	ErrPacketSizeExceed = codec.ErrPacketSizeExcced
+	ErrInvalidLength    = codec.ErrInvalidLength
+)
+
+// PacketCodec encodes the packets sent to clients and decodes the packets received
+// from clients. Decode reads one packet from the connection each time, the reader is
+// buffered and shared by the successive calls, so Decode must not read ahead of the
+// packet. Decode returns io.EOF if the connection ends before the packet, and any error
+// closes the connection. The Length of the decoded packet must be the length of Data
+type PacketCodec interface {
+	Encode(p Packet) ([]byte, error)
+	Decode(r io.Reader) (Packet, error)
+}
+
+// builtin is the PacketCodec of the built-in framing
+type builtin struct {
+	framing Framing
+	maxSize int
+}
+
+// NewPacketCodec returns the built-in codec of the framing, the packets larger than
+// maxSize are rejected, 64KB is used if maxSize is not positive
+func NewPacketCodec(framing Framing, maxSize int) PacketCodec {
+	return &builtin{framing: framing, maxSize: maxSize}
+}
+
+func (c *builtin) Encode(p Packet) ([]byte, error) {
+	return c.framing.Encode(p.Type, p.Data)
+}
+
+func (c *builtin) Decode(r io.Reader) (Packet, error) {
+	p, err := c.framing.Read(r, c.maxSize)
+	if err != nil {
+		return Packet{}, err
+	}
+	return *p, nil
+}
@@ -0,0 +1,14 @@
+package codec_test
+
+import (
+	"testing"
+
+	"github.com/lonng/nano/codec"
+	"github.com/lonng/nano/codec/codectest"
+)
+
+func TestBuiltin(t *testing.T) {
+	for _, framing := range []codec.Framing{codec.FramingFixed24, codec.FramingFixed32, codec.FramingVarint} {
+		codectest.TestPacketCodec(t, codec.NewPacketCodec(framing, 0))
+	}
+}
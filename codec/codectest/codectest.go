@@ -0,0 +1,129 @@
+// Package codectest implements the conformance tests of codec.PacketCodec, which the
+// custom codecs should pass before plugging into nano
+package codectest
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"testing"
+	"testing/iotest"
+
+	"github.com/lonng/nano/codec"
+)
+
+// sizes are the payload sizes of the tests, which fit in the smallest framing
+var sizes = []int{0, 1, 127, 128, 255, 256, 4096}
+
+// TestPacketCodec runs the conformance tests against c, e.g:
+//
+//	func TestMyCodec(t *testing.T) {
+//		codectest.TestPacketCodec(t, &MyCodec{})
+//	}
+func TestPacketCodec(t *testing.T, c codec.PacketCodec) {
+	t.Run("RoundTrip", func(t *testing.T) { testRoundTrip(t, c) })
+	t.Run("Stream", func(t *testing.T) { testStream(t, c) })
+	t.Run("PartialRead", func(t *testing.T) { testPartialRead(t, c) })
+	t.Run("EOF", func(t *testing.T) { testEOF(t, c) })
+	t.Run("Truncated", func(t *testing.T) { testTruncated(t, c) })
+}
+
+func packets() []codec.Packet {
+	var ps []codec.Packet
+	types := []codec.Type{codec.Handshake, codec.HandshakeAck, codec.Heartbeat, codec.Data, codec.Kick, codec.Rekey, codec.Ack}
+	for _, typ := range types {
+		for _, size := range sizes {
+			data := make([]byte, size)
+			for i := range data {
+				data[i] = byte(i)
+			}
+			ps = append(ps, codec.Packet{Type: typ, Length: size, Data: data})
+		}
+	}
+	return ps
+}
+
+func check(t *testing.T, expect, got codec.Packet) {
+	t.Helper()
+	if got.Type != expect.Type || got.Length != len(got.Data) || !bytes.Equal(got.Data, expect.Data) {
+		t.Fatalf("expect packet type %d with %d bytes, got type %d, length %d with %d bytes",
+			expect.Type, len(expect.Data), got.Type, got.Length, len(got.Data))
+	}
+}
+
+func encode(t *testing.T, c codec.PacketCodec, ps []codec.Packet) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	for _, p := range ps {
+		data, err := c.Encode(p)
+		if err != nil {
+			t.Fatalf("encode packet type %d with %d bytes: %v", p.Type, len(p.Data), err)
+		}
+		buf.Write(data)
+	}
+	return buf.Bytes()
+}
+
+// testRoundTrip decodes every packet from its own reader
+func testRoundTrip(t *testing.T, c codec.PacketCodec) {
+	for _, p := range packets() {
+		got, err := c.Decode(bufio.NewReader(bytes.NewReader(encode(t, c, []codec.Packet{p}))))
+		if err != nil {
+			t.Fatalf("decode packet type %d with %d bytes: %v", p.Type, len(p.Data), err)
+		}
+		check(t, p, got)
+	}
+}
+
+// testStream decodes the successive packets from a shared reader, which fails if
+// Decode reads ahead of the packet
+func testStream(t *testing.T, c codec.PacketCodec) {
+	ps := packets()
+	r := bufio.NewReader(bytes.NewReader(encode(t, c, ps)))
+	for _, p := range ps {
+		got, err := c.Decode(r)
+		if err != nil {
+			t.Fatalf("decode packet type %d with %d bytes: %v", p.Type, len(p.Data), err)
+		}
+		check(t, p, got)
+	}
+}
+
+// testPartialRead decodes the packets from a reader returning one byte each time, like
+// a connection receiving the packets in pieces
+func testPartialRead(t *testing.T, c codec.PacketCodec) {
+	ps := packets()
+	r := iotest.OneByteReader(bytes.NewReader(encode(t, c, ps)))
+	for _, p := range ps {
+		got, err := c.Decode(r)
+		if err != nil {
+			t.Fatalf("decode packet type %d with %d bytes: %v", p.Type, len(p.Data), err)
+		}
+		check(t, p, got)
+	}
+}
+
+// testEOF expects io.EOF after the last packet
+func testEOF(t *testing.T, c codec.PacketCodec) {
+	p := codec.Packet{Type: codec.Data, Length: 5, Data: []byte("hello")}
+	r := bufio.NewReader(bytes.NewReader(encode(t, c, []codec.Packet{p})))
+	if _, err := c.Decode(r); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Decode(r); err != io.EOF {
+		t.Fatalf("expect %v after the last packet, got %v", io.EOF, err)
+	}
+}
+
+// testTruncated expects an error other than io.EOF if the connection ends in the
+// middle of a packet
+func testTruncated(t *testing.T, c codec.PacketCodec) {
+	p := codec.Packet{Type: codec.Data, Length: 256, Data: make([]byte, 256)}
+	data := encode(t, c, []codec.Packet{p})
+	for _, n := range []int{1, len(data) / 2, len(data) - 1} {
+		_, err := c.Decode(bufio.NewReader(bytes.NewReader(data[:n])))
+		if err == nil || err == io.EOF {
+			t.Fatalf("expect error decoding %d of %d bytes, got %v", n, len(data), err)
+		}
+	}
+}
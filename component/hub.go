@@ -20,11 +20,37 @@
 
 package component
 
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
 type CompWithOptions struct {
 	Comp Component
 	Opts []Option
 }
 
+// Name returns the name of component
+func (c CompWithOptions) Name() string {
+	return NewService(c.Comp, c.Opts).Name
+}
+
+// DependsOn returns the names of the components which should be initialized before it
+func (c CompWithOptions) DependsOn() []string {
+	return NewService(c.Comp, c.Opts).Options.dependsOn
+}
+
+// InitTimeout returns the init timeout of component, the timeout d of node will be
+// returned if the component does not override it
+func (c CompWithOptions) InitTimeout(d time.Duration) time.Duration {
+	s := NewService(c.Comp, c.Opts)
+	if s.Options.hasInitTimeout {
+		return s.Options.initTimeout
+	}
+	return d
+}
+
 type Components struct {
 	comps []CompWithOptions
 }
@@ -38,3 +64,59 @@ func (cs *Components) Register(c Component, options ...Option) {
 func (cs *Components) List() []CompWithOptions {
 	return cs.comps
 }
+
+// Sorted returns all components ordered by their declared dependencies, a component
+// always follows the components it depends on, and the registration order is kept
+// for the components without dependency relation
+func (cs *Components) Sorted() ([]CompWithOptions, error) {
+	names := make([]string, len(cs.comps))
+	deps := make([][]string, len(cs.comps))
+	index := make(map[string]int, len(cs.comps))
+	for i, c := range cs.comps {
+		s := NewService(c.Comp, c.Opts)
+		names[i] = s.Name
+		deps[i] = s.Options.dependsOn
+		index[s.Name] = i
+	}
+
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	states := make([]int, len(cs.comps))
+	sorted := make([]CompWithOptions, 0, len(cs.comps))
+
+	var path []string
+	var visit func(i int) error
+	visit = func(i int) error {
+		switch states[i] {
+		case visited:
+			return nil
+		case visiting:
+			return fmt.Errorf("component: dependency cycle %s -> %s", strings.Join(path, " -> "), names[i])
+		}
+		states[i] = visiting
+		path = append(path, names[i])
+		for _, dep := range deps[i] {
+			j, found := index[dep]
+			if !found {
+				return fmt.Errorf("component: %s depends on unknown component %s", names[i], dep)
+			}
+			if err := visit(j); err != nil {
+				return err
+			}
+		}
+		path = path[:len(path)-1]
+		states[i] = visited
+		sorted = append(sorted, cs.comps[i])
+		return nil
+	}
+
+	for i := range cs.comps {
+		if err := visit(i); err != nil {
+			return nil, err
+		}
+	}
+	return sorted, nil
+}
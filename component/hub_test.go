@@ -0,0 +1,74 @@
+package component
+
+import (
+	"strings"
+	"testing"
+)
+
+type (
+	CacheComponent struct{ Base }
+	LoginComponent struct{ Base }
+	RoomComponent  struct{ Base }
+)
+
+func names(comps []CompWithOptions) []string {
+	var result []string
+	for _, c := range comps {
+		result = append(result, NewService(c.Comp, c.Opts).Name)
+	}
+	return result
+}
+
+func TestComponents_Sorted(t *testing.T) {
+	cs := &Components{}
+	cs.Register(&RoomComponent{}, WithDependsOn("LoginComponent", "Cache"))
+	cs.Register(&LoginComponent{}, WithDependsOn("Cache"))
+	cs.Register(&CacheComponent{}, WithName("Cache"))
+
+	sorted, err := cs.Sorted()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(names(sorted), ","); got != "Cache,LoginComponent,RoomComponent" {
+		t.Fatalf("unexpected order: %s", got)
+	}
+}
+
+func TestComponents_SortedKeepRegisterOrder(t *testing.T) {
+	cs := &Components{}
+	cs.Register(&RoomComponent{})
+	cs.Register(&LoginComponent{})
+	cs.Register(&CacheComponent{})
+
+	sorted, err := cs.Sorted()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(names(sorted), ","); got != "RoomComponent,LoginComponent,CacheComponent" {
+		t.Fatalf("unexpected order: %s", got)
+	}
+}
+
+func TestComponents_SortedCycle(t *testing.T) {
+	cs := &Components{}
+	cs.Register(&RoomComponent{}, WithDependsOn("LoginComponent"))
+	cs.Register(&LoginComponent{}, WithDependsOn("CacheComponent"))
+	cs.Register(&CacheComponent{}, WithDependsOn("RoomComponent"))
+
+	_, err := cs.Sorted()
+	if err == nil {
+		t.Fatal("expect cycle error")
+	}
+	if !strings.Contains(err.Error(), "RoomComponent -> LoginComponent -> CacheComponent -> RoomComponent") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestComponents_SortedUnknown(t *testing.T) {
+	cs := &Components{}
+	cs.Register(&RoomComponent{}, WithDependsOn("NotExists"))
+
+	if _, err := cs.Sorted(); err == nil {
+		t.Fatal("expect unknown dependency error")
+	}
+}
@@ -21,7 +21,11 @@
 package component
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"reflect"
+	"strings"
 	"unicode"
 	"unicode/utf8"
 
@@ -30,6 +34,7 @@ import (
 
 var (
 	typeOfError   = reflect.TypeOf((*error)(nil)).Elem()
+	typeOfContext = reflect.TypeOf((*context.Context)(nil)).Elem()
 	typeOfBytes   = reflect.TypeOf(([]byte)(nil))
 	typeOfSession = reflect.TypeOf(session.New(nil))
 )
@@ -50,28 +55,68 @@ func isExportedOrBuiltinType(t reflect.Type) bool {
 
 // isHandlerMethod decide a method is suitable handler method
 func isHandlerMethod(method reflect.Method) bool {
+	return checkHandlerMethod(method) == nil
+}
+
+// checkHandlerMethod returns the reason why the method is not a handler, nil if it is
+func checkHandlerMethod(method reflect.Method) error {
 	mt := method.Type
 	// Method must be exported.
 	if method.PkgPath != "" {
-		return false
+		return errors.New("method is not exported")
 	}
 
-	// Method needs three ins: receiver, *Session, []byte or pointer.
-	if mt.NumIn() != 3 {
-		return false
+	// Method needs three ins: receiver, *Session, []byte or pointer, a context.Context
+	// preceding the *Session is optional.
+	offset := 0
+	switch mt.NumIn() {
+	case 3:
+	case 4:
+		if mt.In(1) != typeOfContext {
+			return fmt.Errorf("the argument preceding *session.Session must be context.Context, got %s", mt.In(1))
+		}
+		offset = 1
+	default:
+		return fmt.Errorf("want arguments ([context.Context,] *session.Session, []byte or pointer), got %d arguments", mt.NumIn()-1)
 	}
 
 	// Method needs one outs: error
-	if mt.NumOut() != 1 {
-		return false
+	if mt.NumOut() != 1 || mt.Out(0) != typeOfError {
+		return fmt.Errorf("want a single result of type error, got %s", results(mt))
 	}
 
-	if t1 := mt.In(1); t1.Kind() != reflect.Ptr || t1 != typeOfSession {
-		return false
+	if t1 := mt.In(1 + offset); t1.Kind() != reflect.Ptr || t1 != typeOfSession {
+		return fmt.Errorf("want *session.Session argument, got %s", t1)
+	}
+
+	if t2 := mt.In(2 + offset); t2.Kind() != reflect.Ptr && t2 != typeOfBytes {
+		return fmt.Errorf("the payload argument must be []byte or a pointer, got %s", t2)
 	}
+	return nil
+}
 
-	if (mt.In(2).Kind() != reflect.Ptr && mt.In(2) != typeOfBytes) || mt.Out(0) != typeOfError {
+// looksLikeHandler reports whether the exported method takes a session argument, which
+// is intended to be a handler, the rejected signature of which should be reported
+func looksLikeHandler(method reflect.Method) bool {
+	if method.PkgPath != "" {
 		return false
 	}
-	return true
+	mt := method.Type
+	for i := 1; i < mt.NumIn(); i++ {
+		if t := mt.In(i); t == typeOfSession || t == typeOfSession.Elem() {
+			return true
+		}
+	}
+	return false
+}
+
+func results(mt reflect.Type) string {
+	if mt.NumOut() == 0 {
+		return "no result"
+	}
+	out := make([]string, mt.NumOut())
+	for i := range out {
+		out[i] = mt.Out(i).String()
+	}
+	return "(" + strings.Join(out, ", ") + ")"
 }
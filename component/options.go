@@ -20,11 +20,25 @@
 
 package component
 
+import "time"
+
 type (
 	options struct {
 		name      string              // component name
 		nameFunc  func(string) string // rename handler name
 		schedName string              // schedName name
+		dependsOn []string            // names of components initialized before this one
+		workers   int                 // size of the dedicated worker pool
+
+		maxConcurrency   int // handlers of a route running concurrently, zero is unlimited
+		concurrencyQueue int // handlers of a route waiting for the limit
+
+		cacheTTL time.Duration // ttl of the cached responses, zero disables the cache
+
+		metricsNamespace string // component label of the route metrics, empty is the name
+
+		initTimeout    time.Duration // overrides the init timeout of node
+		hasInitTimeout bool
 	}
 
 	// Option used to customize handler
@@ -52,3 +66,69 @@ func WithSchedulerName(name string) Option {
 		opt.schedName = name
 	}
 }
+
+// WithDependsOn declares the components which should be initialized before
+// the current component, the names are the component names
+func WithDependsOn(names ...string) Option {
+	return func(opt *options) {
+		opt.dependsOn = append(opt.dependsOn, names...)
+	}
+}
+
+// WithInitTimeout overrides the init timeout of node for the component, zero disables
+// the timeout for the component which legitimately takes long to initialize
+func WithInitTimeout(d time.Duration) Option {
+	return func(opt *options) {
+		opt.initTimeout = d
+		opt.hasInitTimeout = true
+	}
+}
+
+// WithWorkerPool runs the handlers of component on a dedicated pool of size goroutines
+// instead of the shared dispatcher, which suits the components doing blocking work,
+// e.g: accessing database or external API.
+//
+// The handlers run concurrently in the pool, so the messages of a session are no longer
+// handled in order, and the handlers must not share state with the other components
+// without synchronization. Keep the components relying on ordering on the dispatcher.
+func WithWorkerPool(size int) Option {
+	return func(opt *options) {
+		opt.workers = size
+	}
+}
+
+// WithMaxConcurrency limits the handlers of each route of component running concurrently
+// across all the sessions to n, which protects the shared downstream resources, e.g: a
+// matchmaking pool or a payment gateway. The handlers beyond the limit wait in a queue of
+// the route bounded by queue, and the messages overflowing the queue are rejected, the
+// requests are responded with the 503 error.
+//
+// The limit only takes effect if the handlers run concurrently, e.g: by WithWorkerPool
+func WithMaxConcurrency(n, queue int) Option {
+	return func(opt *options) {
+		opt.maxConcurrency = n
+		opt.concurrencyQueue = queue
+	}
+}
+
+// WithCache caches the responses of each route of component for ttl, the same request
+// of the same session, or the sessions of the same uid, is responded from the cache
+// without dispatching the handler. The responses are cached after serialized, so only
+// the idempotent read routes, e.g: leaderboard or profile, should be cached, and the
+// stale responses can be dropped by Node.InvalidateCache.
+//
+// Only the requests are cached, the notifies, the error and streamed responses are not
+func WithCache(ttl time.Duration) Option {
+	return func(opt *options) {
+		opt.cacheTTL = ttl
+	}
+}
+
+// WithMetricsNamespace labels the metrics of the routes of component by the namespace
+// instead of the component name, e.g: the components of a subsystem share a namespace
+// "combat", so that the dashboards drill down by subsystem
+func WithMetricsNamespace(namespace string) Option {
+	return func(opt *options) {
+		opt.metricsNamespace = namespace
+	}
+}
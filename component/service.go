@@ -23,6 +23,7 @@ package component
 import (
 	"errors"
 	"reflect"
+	"time"
 )
 
 type (
@@ -33,6 +34,7 @@ type (
 		Method   reflect.Method // method stub
 		Type     reflect.Type   // low-level type of method
 		IsRawArg bool           // whether the data need to serialize
+		Context  bool           // whether the method receives the request-scoped context
 	}
 
 	// Service implements a specific service, some of it's methods will be
@@ -42,8 +44,15 @@ type (
 		Type      reflect.Type        // type of the receiver
 		Receiver  reflect.Value       // receiver of methods for the service
 		Handlers  map[string]*Handler // registered methods
+		Rejected  map[string]error    // methods taking a session but rejected by signature
 		SchedName string              // name of scheduler variable in session data
-		Options   options             // options
+		Workers   int                 // size of the dedicated worker pool, zero runs on dispatcher
+
+		MaxConcurrency   int           // handlers of a route running concurrently, zero is unlimited
+		ConcurrencyQueue int           // handlers of a route waiting for the concurrency limit
+		CacheTTL         time.Duration // ttl of the cached responses of each route, zero is uncached
+		MetricsNamespace string        // component label of the route metrics, empty is the name
+		Options          options       // options
 	}
 )
 
@@ -64,6 +73,11 @@ func NewService(comp Component, opts []Option) *Service {
 		s.Name = reflect.Indirect(s.Receiver).Type().Name()
 	}
 	s.SchedName = s.Options.schedName
+	s.Workers = s.Options.workers
+	s.MaxConcurrency = s.Options.maxConcurrency
+	s.ConcurrencyQueue = s.Options.concurrencyQueue
+	s.CacheTTL = s.Options.cacheTTL
+	s.MetricsNamespace = s.Options.metricsNamespace
 
 	return s
 }
@@ -76,26 +90,44 @@ func (s *Service) suitableHandlerMethods(typ reflect.Type) map[string]*Handler {
 		mt := method.Type
 		mn := method.Name
 		if isHandlerMethod(method) {
+			arg := mt.In(mt.NumIn() - 1)
 			raw := false
-			if mt.In(2) == typeOfBytes {
+			if arg == typeOfBytes {
 				raw = true
 			}
 			// rewrite handler name
 			if s.Options.nameFunc != nil {
 				mn = s.Options.nameFunc(mn)
 			}
-			methods[mn] = &Handler{Method: method, Type: mt.In(2), IsRawArg: raw}
+			methods[mn] = &Handler{Method: method, Type: arg, IsRawArg: raw, Context: mt.NumIn() == 4}
 		}
 	}
 	return methods
 }
 
+// rejectedHandlerMethods returns the methods of typ which take a session argument but
+// are rejected by signature, e.g: the payload passed by value, keyed by the method name
+func rejectedHandlerMethods(typ reflect.Type) map[string]error {
+	rejected := make(map[string]error)
+	for m := 0; m < typ.NumMethod(); m++ {
+		method := typ.Method(m)
+		if !looksLikeHandler(method) {
+			continue
+		}
+		if err := checkHandlerMethod(method); err != nil {
+			rejected[method.Name] = err
+		}
+	}
+	return rejected
+}
+
 // ExtractHandler extract the set of methods from the
 // receiver value which satisfy the following conditions:
 // - exported method of exported type
 // - two arguments, both of exported type
 // - the first argument is *session.Session
 // - the second argument is []byte or a pointer
+// - an optional context.Context argument preceding the *session.Session
 func (s *Service) ExtractHandler() error {
 	typeName := reflect.Indirect(s.Receiver).Type().Name()
 	if typeName == "" {
@@ -107,6 +139,7 @@ func (s *Service) ExtractHandler() error {
 
 	// Install the methods
 	s.Handlers = s.suitableHandlerMethods(s.Type)
+	s.Rejected = rejectedHandlerMethods(s.Type)
 
 	if len(s.Handlers) == 0 {
 		str := ""
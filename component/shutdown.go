@@ -0,0 +1,58 @@
+package component
+
+import "context"
+
+// ShutdownReason describes why the node is shutting down
+type ShutdownReason string
+
+// Reasons of the shutdown
+const (
+	ShutdownGraceful ShutdownReason = "graceful" // requested by application, e.g: nano.Shutdown
+	ShutdownSignal   ShutdownReason = "signal"   // the process received a termination signal
+	ShutdownError    ShutdownReason = "error"    // the node cannot keep running
+	ShutdownUpgrade  ShutdownReason = "upgrade"  // the listeners are handed to a new process
+)
+
+// ContextShutdowner is implemented by the components which need the reason and the
+// deadline of the shutdown, e.g: to flush the critical state within the grace window.
+// The context hooks are called instead of BeforeShutdown/Shutdown if implemented
+type ContextShutdowner interface {
+	BeforeShutdownContext(ctx context.Context)
+	ShutdownContext(ctx context.Context)
+}
+
+type reasonKey struct{}
+
+// WithShutdownReason returns a copy of ctx carrying the shutdown reason
+func WithShutdownReason(ctx context.Context, reason ShutdownReason) context.Context {
+	return context.WithValue(ctx, reasonKey{}, reason)
+}
+
+// ShutdownReasonFrom returns the shutdown reason carried by ctx, graceful will be
+// returned if ctx carries none
+func ShutdownReasonFrom(ctx context.Context) ShutdownReason {
+	if reason, ok := ctx.Value(reasonKey{}).(ShutdownReason); ok {
+		return reason
+	}
+	return ShutdownGraceful
+}
+
+// BeforeShutdown calls the BeforeShutdownContext hook of c if implemented, otherwise
+// the BeforeShutdown hook
+func BeforeShutdown(ctx context.Context, c Component) {
+	if s, ok := c.(ContextShutdowner); ok {
+		s.BeforeShutdownContext(ctx)
+		return
+	}
+	c.BeforeShutdown()
+}
+
+// Shutdown calls the ShutdownContext hook of c if implemented, otherwise the
+// Shutdown hook
+func Shutdown(ctx context.Context, c Component) {
+	if s, ok := c.(ContextShutdowner); ok {
+		s.ShutdownContext(ctx)
+		return
+	}
+	c.Shutdown()
+}
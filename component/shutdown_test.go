@@ -0,0 +1,57 @@
+package component
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+type legacyComponent struct {
+	Base
+	calls []string
+}
+
+func (c *legacyComponent) BeforeShutdown() { c.calls = append(c.calls, "BeforeShutdown") }
+func (c *legacyComponent) Shutdown()       { c.calls = append(c.calls, "Shutdown") }
+
+type contextComponent struct {
+	legacyComponent
+	reasons []ShutdownReason
+}
+
+func (c *contextComponent) BeforeShutdownContext(ctx context.Context) {
+	c.reasons = append(c.reasons, ShutdownReasonFrom(ctx))
+}
+
+func (c *contextComponent) ShutdownContext(ctx context.Context) {
+	if _, ok := ctx.Deadline(); !ok {
+		panic("expect deadline")
+	}
+	c.reasons = append(c.reasons, ShutdownReasonFrom(ctx))
+}
+
+func TestShutdownHooks(t *testing.T) {
+	ctx, cancel := context.WithTimeout(WithShutdownReason(context.Background(), ShutdownSignal), time.Second)
+	defer cancel()
+
+	legacy := &legacyComponent{}
+	BeforeShutdown(ctx, legacy)
+	Shutdown(ctx, legacy)
+	if len(legacy.calls) != 2 || legacy.calls[0] != "BeforeShutdown" || legacy.calls[1] != "Shutdown" {
+		t.Fatalf("unexpected legacy hooks: %v", legacy.calls)
+	}
+
+	c := &contextComponent{}
+	BeforeShutdown(ctx, c)
+	Shutdown(ctx, c)
+	if len(c.calls) != 0 {
+		t.Fatalf("legacy hooks should not be called: %v", c.calls)
+	}
+	if len(c.reasons) != 2 || c.reasons[0] != ShutdownSignal || c.reasons[1] != ShutdownSignal {
+		t.Fatalf("unexpected reasons: %v", c.reasons)
+	}
+
+	if reason := ShutdownReasonFrom(context.Background()); reason != ShutdownGraceful {
+		t.Fatalf("expect default reason graceful, got %s", reason)
+	}
+}
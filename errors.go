@@ -28,4 +28,8 @@ var (
 	ErrClosedGroup        = errors.New("group closed")
 	ErrMemberNotFound     = errors.New("member not found in the group")
 	ErrSessionDuplication = errors.New("session has existed in the current group")
+	ErrTooManyGroups      = errors.New("session has joined too many groups")
+	ErrNotRunning         = errors.New("nano is not running")
+	ErrRunning            = errors.New("nano is already running")
+	ErrInvalidFraction    = errors.New("sample fraction must be in the range [0, 1]")
 )
@@ -22,12 +22,17 @@ package nano
 
 import (
 	"fmt"
+	"math"
+	"math/rand"
+	"sort"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/lonng/nano/internal/env"
 	"github.com/lonng/nano/internal/log"
 	"github.com/lonng/nano/internal/message"
+	"github.com/lonng/nano/internal/runtime"
 	"github.com/lonng/nano/session"
 )
 
@@ -47,6 +52,9 @@ type Group struct {
 	status   int32                      // channel current status
 	name     string                     // channel name
 	sessions map[int64]*session.Session // session id map to session instance
+
+	muSample sync.Mutex
+	sample   *rand.Rand // random source of SampleMulticast, created on first use
 }
 
 // NewGroup returns a new group instance
@@ -115,6 +123,66 @@ func (c *Group) Multicast(route string, v interface{}, filter SessionFilter) err
 	return nil
 }
 
+// SetSampleSeed seeds the random source of SampleMulticast, the same members will be
+// sampled by the same sequence of calls for the same seed and members, e.g: in tests
+func (c *Group) SetSampleSeed(seed int64) {
+	c.muSample.Lock()
+	c.sample = rand.New(rand.NewSource(seed))
+	c.muSample.Unlock()
+}
+
+// SampleMulticast pushes the message to a random subset of the members, whose size is the
+// fraction of the members rounded to the nearest, e.g: 0.05 sends a survey prompt to 5%
+// of a room. The members are sampled uniformly by the random source seeded by
+// SetSampleSeed, or by the current time if not seeded
+func (c *Group) SampleMulticast(route string, v interface{}, fraction float64) error {
+	if c.isClosed() {
+		return ErrClosedGroup
+	}
+	if fraction < 0 || fraction > 1 || math.IsNaN(fraction) {
+		return ErrInvalidFraction
+	}
+
+	data, err := message.Serialize(v)
+	if err != nil {
+		return err
+	}
+
+	if env.Debug {
+		log.Println(fmt.Sprintf("SampleMulticast %s, Fraction=%v, Data=%+v", route, fraction, v))
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	// the members are ordered by session id, which makes the sampling reproducible
+	members := make([]*session.Session, 0, len(c.sessions))
+	for _, s := range c.sessions {
+		members = append(members, s)
+	}
+	sort.Slice(members, func(i, j int) bool { return members[i].ID() < members[j].ID() })
+
+	// partial Fisher-Yates shuffle, the first n members are the sample
+	n := int(math.Round(fraction * float64(len(members))))
+	c.muSample.Lock()
+	if c.sample == nil {
+		c.sample = rand.New(rand.NewSource(time.Now().UnixNano()))
+	}
+	for i := 0; i < n; i++ {
+		j := i + c.sample.Intn(len(members)-i)
+		members[i], members[j] = members[j], members[i]
+	}
+	c.muSample.Unlock()
+
+	for _, s := range members[:n] {
+		if err = s.Push(route, data); err != nil {
+			log.Println(err.Error())
+		}
+	}
+
+	return nil
+}
+
 // Broadcast push  the message(s) to  all members
 func (c *Group) Broadcast(route string, v interface{}) error {
 	if c.isClosed() {
@@ -130,6 +198,10 @@ func (c *Group) Broadcast(route string, v interface{}) error {
 		log.Println(fmt.Sprintf("Broadcast %s, Data=%+v", route, v))
 	}
 
+	if node := runtime.CurrentNode; node != nil {
+		node.TeeBroadcast(route, data)
+	}
+
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 
@@ -167,10 +239,41 @@ func (c *Group) Add(session *session.Session) error {
 		return ErrSessionDuplication
 	}
 
+	if !session.TryAddMembership(c, env.MaxGroupsPerSession) {
+		return ErrTooManyGroups
+	}
 	c.sessions[id] = session
 	return nil
 }
 
+// Rejoin implements the session.Membership interface, it replaces the session of the
+// same uid with the reconnected session, which is called by session.Reattach to keep
+// the group memberships across the reconnection within the linger window
+func (c *Group) Rejoin(s *session.Session) error {
+	if c.isClosed() {
+		return ErrClosedGroup
+	}
+
+	if env.Debug {
+		log.Println(fmt.Sprintf("Rejoin session to group %s, ID=%d, UID=%d", c.name, s.ID(), s.UID()))
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// the reconnected session may have joined the other groups before reattached
+	if !s.TryAddMembership(c, env.MaxGroupsPerSession) {
+		return ErrTooManyGroups
+	}
+	for id, member := range c.sessions {
+		if member.UID() == s.UID() {
+			delete(c.sessions, id)
+		}
+	}
+	c.sessions[s.ID()] = s
+	return nil
+}
+
 // Leave remove specified UID related session from group
 func (c *Group) Leave(s *session.Session) error {
 	if c.isClosed() {
@@ -185,6 +288,7 @@ func (c *Group) Leave(s *session.Session) error {
 	defer c.mu.Unlock()
 
 	delete(c.sessions, s.ID())
+	s.RemoveMembership(c)
 	return nil
 }
 
@@ -197,6 +301,9 @@ func (c *Group) LeaveAll() error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
+	for _, s := range c.sessions {
+		s.RemoveMembership(c)
+	}
 	c.sessions = make(map[int64]*session.Session)
 	return nil
 }
@@ -225,6 +332,11 @@ func (c *Group) Close() error {
 	atomic.StoreInt32(&c.status, groupStatusClosed)
 
 	// release all reference
+	c.mu.Lock()
+	for _, s := range c.sessions {
+		s.RemoveMembership(c)
+	}
 	c.sessions = make(map[int64]*session.Session)
+	c.mu.Unlock()
 	return nil
 }
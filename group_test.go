@@ -3,7 +3,10 @@ package nano
 import (
 	"math/rand"
 	"testing"
+	"time"
 
+	"github.com/lonng/nano/internal/env"
+	"github.com/lonng/nano/mock"
 	"github.com/lonng/nano/session"
 )
 
@@ -40,3 +43,143 @@ func TestChannel_Add(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func TestGroup_Rejoin(t *testing.T) {
+	session.Linger.SetWindow(time.Second)
+	defer session.Linger.SetWindow(0)
+
+	room := NewGroup("room")
+	party := NewGroup("party")
+	s := session.New(nil)
+	s.Bind(100)
+	room.Add(s)
+	party.Add(s)
+	party.Leave(s)
+
+	// the application leaves the groups on close, the memberships linger
+	session.Lifetime.Close(s)
+	room.Leave(s)
+	if room.Count() != 0 {
+		t.Fatal("session should leave the room")
+	}
+
+	n := session.New(nil)
+	n.Bind(100)
+	if !n.Reattach() {
+		t.Fatal("expect lingering state")
+	}
+	if member, err := room.Member(100); err != nil || member != n {
+		t.Fatalf("reconnected session should rejoin the room: %v", err)
+	}
+	if party.Contains(100) {
+		t.Fatal("session has left the party before closed")
+	}
+	if ms := n.Memberships(); len(ms) != 1 || ms[0] != room {
+		t.Fatalf("memberships %v", ms)
+	}
+}
+
+func TestGroup_RejoinMaxGroupsPerSession(t *testing.T) {
+	session.Linger.SetWindow(time.Second)
+	defer session.Linger.SetWindow(0)
+	env.MaxGroupsPerSession = 1
+	defer func() { env.MaxGroupsPerSession = 0 }()
+
+	room := NewGroup("room")
+	lobby := NewGroup("lobby")
+	s := session.New(nil)
+	s.Bind(100)
+	room.Add(s)
+	session.Lifetime.Close(s)
+	room.Leave(s)
+
+	// the reconnected session joins the lobby before reattached
+	n := session.New(nil)
+	n.Bind(100)
+	if err := lobby.Add(n); err != nil {
+		t.Fatal(err)
+	}
+	if !n.Reattach() {
+		t.Fatal("expect lingering state")
+	}
+	if room.Contains(100) {
+		t.Fatal("session should not rejoin the room beyond the limit")
+	}
+	if ms := n.Memberships(); len(ms) != 1 || ms[0] != lobby {
+		t.Fatalf("memberships %v", ms)
+	}
+}
+
+func TestGroup_MaxGroupsPerSession(t *testing.T) {
+	env.MaxGroupsPerSession = 2
+	defer func() { env.MaxGroupsPerSession = 0 }()
+
+	s := session.New(nil)
+	groups := []*Group{NewGroup("room"), NewGroup("party"), NewGroup("guild")}
+	for _, g := range groups[:2] {
+		if err := g.Add(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := groups[2].Add(s); err != ErrTooManyGroups {
+		t.Fatalf("expect ErrTooManyGroups, got %v", err)
+	}
+	if groups[2].Count() != 0 {
+		t.Fatal("session should not join the group exceeding the limit")
+	}
+
+	// the session can join another group after it left one
+	groups[0].Leave(s)
+	if err := groups[2].Add(s); err != nil {
+		t.Fatal(err)
+	}
+	if ms := s.Memberships(); len(ms) != 2 {
+		t.Fatalf("memberships %v", ms)
+	}
+}
+
+func TestGroup_SampleMulticast(t *testing.T) {
+	// sample returns the uids of the members sampled from a group of 100 members, the
+	// sessions are created in the order of uid, so their ids are in the same order
+	sample := func(seed int64) map[int64]bool {
+		g := NewGroup("sample")
+		g.SetSampleSeed(seed)
+		entities := map[int64]*mock.NetworkEntity{}
+		for uid := int64(1); uid <= 100; uid++ {
+			entity := mock.NewNetworkEntity()
+			s := session.New(entity)
+			s.Bind(uid)
+			entities[uid] = entity
+			if err := g.Add(s); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := g.SampleMulticast("survey", []byte("prompt"), 0.05); err != nil {
+			t.Fatal(err)
+		}
+		sampled := map[int64]bool{}
+		for uid, entity := range entities {
+			if entity.FindResponseByRoute("survey") != nil {
+				sampled[uid] = true
+			}
+		}
+		return sampled
+	}
+
+	first := sample(42)
+	if len(first) != 5 {
+		t.Fatalf("expect 5 members sampled, got %d", len(first))
+	}
+	// the same seed samples the same members
+	second := sample(42)
+	for uid := range first {
+		if !second[uid] {
+			t.Fatalf("expect the same members sampled, got %v and %v", first, second)
+		}
+	}
+
+	g := NewGroup("invalid")
+	if err := g.SampleMulticast("survey", []byte("prompt"), 1.5); err != ErrInvalidFraction {
+		t.Fatalf("expect ErrInvalidFraction, got %v", err)
+	}
+}
@@ -21,6 +21,7 @@
 package nano
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"os/signal"
@@ -73,6 +74,7 @@ func Listen(addr string, opts ...Option) {
 
 	opt := cluster.Options{
 		Components: &component.Components{},
+		TCPNoDelay: true,
 	}
 	for _, option := range opts {
 		option(&opt)
@@ -94,10 +96,11 @@ func Listen(addr string, opts ...Option) {
 		ServiceAddr: addr,
 	}
 	err := node.Startup()
-	if err != nil {
+	if _, ok := err.(*cluster.RegistrationError); ok {
+		log.Println("Node started up partially:", err)
+	} else if err != nil {
 		log.Fatalf("Node startup failed: %v", err)
 	}
-	runtime.CurrentNode = node
 
 	if node.ClientAddr != "" {
 		log.Println(fmt.Sprintf("Startup *Nano gate server* %s, client address: %v, service address: %s",
@@ -107,26 +110,94 @@ func Listen(addr string, opts ...Option) {
 			app.name, node.ServiceAddr))
 	}
 
+	serve(node, syscall.SIGINT, syscall.SIGQUIT, syscall.SIGKILL, syscall.SIGTERM)
+	atomic.StoreInt32(&running, 0)
+}
+
+// Run starts up the node and serves until one of the signals received, SIGINT and SIGTERM
+// if none given, the node shut down by the master or Shutdown called. The node is shut
+// down gracefully: the new client connections are refused first, then the components
+// are stopped in reverse order within the node's ShutdownTimeout if set.
+//
+// Run returns once the components have shut down, with nil if the node shut down cleanly,
+// the startup error if the node failed to start up (a *cluster.RegistrationError of the
+// lenient mode is logged only), or context.DeadlineExceeded if the shutdown did not finish
+// within ShutdownTimeout, the components still stopping are abandoned then, which is
+// expected to exit with a non-zero code, e.g:
+//
+//	if err := nano.Run(node); err != nil {
+//		log.Println(err)
+//		os.Exit(1)
+//	}
+func Run(node *cluster.Node, signals ...os.Signal) error {
+	if atomic.AddInt32(&running, 1) != 1 {
+		return ErrRunning
+	}
+	defer atomic.StoreInt32(&running, 0)
+
+	if err := node.Startup(); err != nil {
+		if _, ok := err.(*cluster.RegistrationError); !ok {
+			return err
+		}
+		log.Println("Node started up partially:", err)
+	}
+	if len(signals) == 0 {
+		signals = []os.Signal{syscall.SIGINT, syscall.SIGTERM}
+	}
+	if err := serve(node, signals...); err != nil {
+		log.Println("Nano server shutdown timeout", node.ShutdownTimeout)
+		return err
+	}
+	return nil
+}
+
+// serve serves the started node until one of the signals received, the node shut down by
+// the master or Shutdown called, then shuts it down gracefully within its ShutdownTimeout.
+// It returns once the node shut down, with the error of the shutdown context, which is
+// context.DeadlineExceeded if the shutdown did not finish in time
+func serve(node *cluster.Node, signals ...os.Signal) error {
+	runtime.CurrentNode = node
 	go scheduler.Sched()
-	sg := make(chan os.Signal)
-	signal.Notify(sg, syscall.SIGINT, syscall.SIGQUIT, syscall.SIGKILL, syscall.SIGTERM)
+	sg := make(chan os.Signal, 1)
+	signal.Notify(sg, signals...)
+	defer signal.Stop(sg)
 
+	reason := component.ShutdownGraceful
 	select {
 	case <-env.Die:
 		log.Println("The app will shutdown in a few seconds")
+	case <-node.Done():
+		log.Println("The node is shut down by the master")
 	case s := <-sg:
 		log.Println("Nano server got signal", s)
+		reason = component.ShutdownSignal
 	}
 
 	log.Println("Nano server is stopping...")
 
-	node.Shutdown()
+	ctx := component.WithShutdownReason(context.Background(), reason)
+	if node.ShutdownTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, node.ShutdownTimeout)
+		defer cancel()
+	}
+	node.ShutdownContext(ctx)
 	runtime.CurrentNode = nil
 	scheduler.Close()
-	atomic.StoreInt32(&running, 0)
+	return ctx.Err()
 }
 
 // Shutdown send a signal to let 'nano' shutdown itself.
 func Shutdown() {
 	close(env.Die)
 }
+
+// Upgrade hands the listeners to a new process of the executable, and shuts down once the
+// sessions drained, e.g: on SIGUSR2 after the binary replaced. It requires WithHotRestart
+func Upgrade() error {
+	node := runtime.CurrentNode
+	if node == nil {
+		return ErrNotRunning
+	}
+	return node.Upgrade()
+}
@@ -0,0 +1,78 @@
+// +build !windows
+
+package nano
+
+import (
+	"context"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/lonng/nano/cluster"
+	"github.com/lonng/nano/component"
+	"github.com/lonng/nano/session"
+)
+
+type SlowShutdownComponent struct {
+	component.Base
+	reason chan component.ShutdownReason
+}
+
+func (c *SlowShutdownComponent) Ping(s *session.Session, _ []byte) error {
+	return s.Response([]byte("pong"))
+}
+
+func (c *SlowShutdownComponent) BeforeShutdownContext(ctx context.Context) {}
+
+func (c *SlowShutdownComponent) ShutdownContext(ctx context.Context) {
+	c.reason <- component.ShutdownReasonFrom(ctx)
+	time.Sleep(time.Second)
+}
+
+func TestRun(t *testing.T) {
+	comp := &SlowShutdownComponent{reason: make(chan component.ShutdownReason, 1)}
+	comps := &component.Components{}
+	comps.Register(comp)
+	node := &cluster.Node{
+		Options: cluster.Options{
+			ClientAddr:      "127.0.0.1:0",
+			Components:      comps,
+			ShutdownTimeout: 50 * time.Millisecond,
+		},
+		ServiceAddr: "127.0.0.1:0",
+	}
+
+	result := make(chan error, 1)
+	go func() { result <- Run(node, syscall.SIGUSR1) }()
+	for deadline := time.Now().Add(5 * time.Second); Upgrade() == ErrNotRunning; {
+		select {
+		case err := <-result:
+			t.Fatalf("node startup failed: %v", err)
+		case <-time.After(10 * time.Millisecond):
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("node is not started up")
+		}
+	}
+	if err := Run(node); err != ErrRunning {
+		t.Fatalf("run twice should fail, got %v", err)
+	}
+
+	if err := syscall.Kill(os.Getpid(), syscall.SIGUSR1); err != nil {
+		t.Fatal(err)
+	}
+	if reason := <-comp.reason; reason != component.ShutdownSignal {
+		t.Fatalf("unexpected shutdown reason %v", reason)
+	}
+
+	// run abandons the slow component once the shutdown exceeded the timeout
+	select {
+	case err := <-result:
+		if err != context.DeadlineExceeded {
+			t.Fatalf("slow shutdown should exceed the deadline, got %v", err)
+		}
+	case <-time.After(time.Second / 2):
+		t.Fatal("run is blocked by the slow component")
+	}
+}
@@ -0,0 +1,63 @@
+// Package clock abstracts the time source of the timers and timeouts, the real clock
+// is used in production and the manual clock drives the time-dependent code in tests
+// deterministically instead of sleeping
+package clock
+
+import "time"
+
+type (
+	// Clock tells the current time and creates the timer channels
+	Clock interface {
+		// Now returns the current time
+		Now() time.Time
+		// After returns a channel which receives the current time after d elapsed
+		After(d time.Duration) <-chan time.Time
+		// NewTicker returns a ticker which ticks every d, d must be greater than zero
+		NewTicker(d time.Duration) Ticker
+		// NewTimer returns a timer which fires once after d elapsed, which can be stopped
+		// unlike After
+		NewTimer(d time.Duration) Timer
+		// AfterFunc calls f in its own goroutine after d elapsed, the C of returned timer
+		// is not used
+		AfterFunc(d time.Duration, f func()) Timer
+	}
+
+	// Ticker delivers the ticks of a clock at intervals
+	Ticker interface {
+		// C returns the channel on which the ticks are delivered
+		C() <-chan time.Time
+		// Stop turns off the ticker, no more ticks will be delivered
+		Stop()
+	}
+
+	// Timer delivers the time of a clock once
+	Timer interface {
+		// C returns the channel on which the time is delivered
+		C() <-chan time.Time
+		// Stop prevents the timer from firing
+		Stop()
+	}
+)
+
+// Real is the clock backed by the wall clock of the system
+var Real Clock = realClock{}
+
+type (
+	realClock  struct{}
+	realTicker struct{ *time.Ticker }
+	realTimer  struct{ *time.Timer }
+)
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+func (realClock) NewTicker(d time.Duration) Ticker       { return realTicker{time.NewTicker(d)} }
+func (realClock) NewTimer(d time.Duration) Timer         { return realTimer{time.NewTimer(d)} }
+
+func (realClock) AfterFunc(d time.Duration, f func()) Timer {
+	return realTimer{time.AfterFunc(d, f)}
+}
+
+func (t realTicker) C() <-chan time.Time { return t.Ticker.C }
+
+func (t realTimer) C() <-chan time.Time { return t.Timer.C }
+func (t realTimer) Stop()               { t.Timer.Stop() }
@@ -0,0 +1,149 @@
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+type (
+	// Manual is a clock which only moves when advanced, the timers and tickers fire
+	// synchronously in Advance once their deadlines are reached, and the functions of
+	// AfterFunc are called in their own goroutines
+	Manual struct {
+		mu      sync.Mutex
+		now     time.Time
+		waiters []*waiter
+	}
+
+	waiter struct {
+		deadline time.Time
+		interval time.Duration // zero if fires once
+		ch       chan time.Time
+		fn       func() // called instead of sending to ch if not nil
+		stopped  bool
+	}
+
+	// manualTicker is the ticker or timer of a manual clock
+	manualTicker struct {
+		clock *Manual
+		w     *waiter
+	}
+)
+
+// NewManual returns a manual clock starting at now
+func NewManual(now time.Time) *Manual {
+	return &Manual{now: now}
+}
+
+// Now implements the Clock interface
+func (m *Manual) Now() time.Time {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.now
+}
+
+// After implements the Clock interface
+func (m *Manual) After(d time.Duration) <-chan time.Time {
+	return m.add(d, 0).ch
+}
+
+// NewTicker implements the Clock interface
+func (m *Manual) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("non-positive interval for NewTicker")
+	}
+	return &manualTicker{clock: m, w: m.add(d, d)}
+}
+
+// NewTimer implements the Clock interface
+func (m *Manual) NewTimer(d time.Duration) Timer {
+	return &manualTicker{clock: m, w: m.add(d, 0)}
+}
+
+// AfterFunc implements the Clock interface
+func (m *Manual) AfterFunc(d time.Duration, f func()) Timer {
+	return &manualTicker{clock: m, w: m.addFunc(d, f)}
+}
+
+func (m *Manual) add(d, interval time.Duration) *waiter {
+	return m.addWaiter(&waiter{interval: interval, ch: make(chan time.Time, 1)}, d)
+}
+
+func (m *Manual) addFunc(d time.Duration, f func()) *waiter {
+	return m.addWaiter(&waiter{fn: f}, d)
+}
+
+func (m *Manual) addWaiter(w *waiter, d time.Duration) *waiter {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	w.deadline = m.now.Add(d)
+	if d <= 0 && w.interval == 0 {
+		w.fire(m.now)
+		return w
+	}
+	m.waiters = append(m.waiters, w)
+	return w
+}
+
+// Advance moves the clock forward by d, and fires the timers and tickers whose deadlines
+// are reached. Like time.Ticker, the ticks are dropped for the slow receivers
+func (m *Manual) Advance(d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.now = m.now.Add(d)
+	waiters := m.waiters[:0]
+	for _, w := range m.waiters {
+		if w.stopped {
+			continue
+		}
+		for !w.deadline.After(m.now) {
+			w.fire(m.now)
+			if w.interval == 0 {
+				w.stopped = true
+				break
+			}
+			w.deadline = w.deadline.Add(w.interval)
+		}
+		if !w.stopped {
+			waiters = append(waiters, w)
+		}
+	}
+	m.waiters = waiters
+}
+
+// Waiters returns the number of pending timers and tickers, which lets the tests wait
+// until the code under test is blocked on the clock before advancing it
+func (m *Manual) Waiters() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var n int
+	for _, w := range m.waiters {
+		if !w.stopped {
+			n++
+		}
+	}
+	return n
+}
+
+// fire sends the time to the channel, or calls the function in its own goroutine
+func (w *waiter) fire(now time.Time) {
+	if w.fn != nil {
+		go w.fn()
+		return
+	}
+	select {
+	case w.ch <- now:
+	default:
+	}
+}
+
+func (t *manualTicker) C() <-chan time.Time { return t.w.ch }
+
+func (t *manualTicker) Stop() {
+	t.clock.mu.Lock()
+	t.w.stopped = true
+	t.clock.mu.Unlock()
+}
@@ -0,0 +1,119 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+func TestManual(t *testing.T) {
+	start := time.Unix(1000, 0)
+	m := NewManual(start)
+
+	after := m.After(time.Second)
+	ticker := m.NewTicker(300 * time.Millisecond)
+	if n := m.Waiters(); n != 2 {
+		t.Fatalf("expect 2 waiters, got %d", n)
+	}
+
+	m.Advance(500 * time.Millisecond)
+	if now := m.Now(); !now.Equal(start.Add(500 * time.Millisecond)) {
+		t.Fatalf("unexpected now %v", now)
+	}
+	select {
+	case <-after:
+		t.Fatal("timer fired before the deadline")
+	default:
+	}
+	select {
+	case <-ticker.C():
+	default:
+		t.Fatal("expect a tick")
+	}
+
+	// the ticks are dropped for the slow receivers
+	m.Advance(time.Second)
+	select {
+	case now := <-after:
+		if !now.Equal(start.Add(1500 * time.Millisecond)) {
+			t.Fatalf("unexpected fire time %v", now)
+		}
+	default:
+		t.Fatal("expect the timer fired")
+	}
+	<-ticker.C()
+	select {
+	case <-ticker.C():
+		t.Fatal("expect the ticks dropped")
+	default:
+	}
+	if n := m.Waiters(); n != 1 {
+		t.Fatalf("expect the ticker waiting only, got %d", n)
+	}
+
+	ticker.Stop()
+	m.Advance(time.Second)
+	select {
+	case <-ticker.C():
+		t.Fatal("expect no tick after stopped")
+	default:
+	}
+	if n := m.Waiters(); n != 0 {
+		t.Fatalf("expect no waiters, got %d", n)
+	}
+
+	select {
+	case <-m.After(0):
+	default:
+		t.Fatal("expect the non-positive timer fired immediately")
+	}
+}
+
+func TestManualTimer(t *testing.T) {
+	m := NewManual(time.Unix(1000, 0))
+
+	stopped := m.NewTimer(time.Second)
+	timer := m.NewTimer(time.Second)
+	stopped.Stop()
+	if n := m.Waiters(); n != 1 {
+		t.Fatalf("expect the stopped timer removed, got %d waiters", n)
+	}
+
+	m.Advance(time.Second)
+	select {
+	case <-timer.C():
+	default:
+		t.Fatal("expect the timer fired")
+	}
+	select {
+	case <-stopped.C():
+		t.Fatal("expect no fire after stopped")
+	default:
+	}
+	if n := m.Waiters(); n != 0 {
+		t.Fatalf("expect no waiters, got %d", n)
+	}
+}
+
+func TestManualAfterFunc(t *testing.T) {
+	m := NewManual(time.Unix(1000, 0))
+
+	called := make(chan struct{}, 2)
+	stopped := m.AfterFunc(time.Second, func() { called <- struct{}{} })
+	m.AfterFunc(time.Second, func() { called <- struct{}{} })
+	stopped.Stop()
+
+	m.Advance(time.Second)
+	select {
+	case <-called:
+	case <-time.After(time.Second):
+		t.Fatal("expect the function called")
+	}
+	select {
+	case <-called:
+		t.Fatal("expect the stopped function not called")
+	case <-time.After(10 * time.Millisecond):
+	}
+	if n := m.Waiters(); n != 0 {
+		t.Fatalf("expect no waiters, got %d", n)
+	}
+}
@@ -22,7 +22,10 @@ package codec
 
 import (
 	"bytes"
+	"encoding/binary"
 	"errors"
+	"io"
+	"math"
 
 	"github.com/lonng/nano/internal/packet"
 )
@@ -36,34 +39,76 @@ const (
 // ErrPacketSizeExcced is the error used for encode/decode.
 var ErrPacketSizeExcced = errors.New("codec: packet size exceed")
 
+// ErrInvalidLength is returned by decoder if the varint length is malformed.
+var ErrInvalidLength = errors.New("codec: invalid packet length")
+
+// Framing represents the encoding of the packet length, which must be the same on both
+// ends of the connection
+type Framing byte
+
+// Length encodings of the packet framing
+const (
+	// FramingFixed24 encodes the length in 3 bytes big endian, which is compatible with
+	// the pomelo clients, the length is limited to 16MB
+	FramingFixed24 Framing = iota
+	// FramingFixed32 encodes the length in 4 bytes big endian
+	FramingFixed32
+	// FramingVarint encodes the length as unsigned varint in 1~5 bytes
+	FramingVarint
+)
+
 // A Decoder reads and decodes network data slice
 type Decoder struct {
-	buf  *bytes.Buffer
-	size int  // last packet length
-	typ  byte // last packet type
+	buf     *bytes.Buffer
+	size    int  // last packet length
+	typ     byte // last packet type
+	framing Framing
+	maxSize int
 }
 
 // NewDecoder returns a new decoder that used for decode network bytes slice.
 func NewDecoder() *Decoder {
+	return NewFramingDecoder(FramingFixed24, MaxPacketSize)
+}
+
+// NewFramingDecoder returns a new decoder of the framing, the packets larger than
+// maxSize are rejected, MaxPacketSize is used if maxSize is not positive
+func NewFramingDecoder(framing Framing, maxSize int) *Decoder {
+	if maxSize <= 0 {
+		maxSize = MaxPacketSize
+	}
 	return &Decoder{
-		buf:  bytes.NewBuffer(nil),
-		size: -1,
+		buf:     bytes.NewBuffer(nil),
+		size:    -1,
+		framing: framing,
+		maxSize: maxSize,
 	}
 }
 
-func (c *Decoder) forward() error {
-	header := c.buf.Next(HeadLength)
-	c.typ = header[0]
-	if c.typ < packet.Handshake || c.typ > packet.Kick {
-		return packet.ErrWrongPacketType
+// forward reads the header of next packet, false will be returned if the header
+// has not been received completely
+func (c *Decoder) forward() (bool, error) {
+	header := c.buf.Bytes()
+	if len(header) < 1 {
+		return false, nil
+	}
+	typ := header[0]
+	if typ < packet.Handshake || typ > packet.Ack {
+		return false, packet.ErrWrongPacketType
+	}
+	size, n, err := c.framing.readLength(header[1:])
+	if err != nil || n == 0 {
+		return false, err
 	}
-	c.size = bytesToInt(header[1:])
 
 	// packet length limitation
-	if c.size > MaxPacketSize {
-		return ErrPacketSizeExcced
+	if size < 0 || size > c.maxSize {
+		return false, ErrPacketSizeExcced
 	}
-	return nil
+	c.buf.Next(1 + n)
+	c.typ = typ
+	c.size = size
+	return true, nil
 }
 
 // Decode decode the network bytes slice to packet.Packet(s)
@@ -71,37 +116,24 @@ func (c *Decoder) forward() error {
 func (c *Decoder) Decode(data []byte) ([]*packet.Packet, error) {
 	c.buf.Write(data)
 
-	var (
-		packets []*packet.Packet
-		err     error
-	)
-	// check length
-	if c.buf.Len() < HeadLength {
-		return nil, err
-	}
-
-	// first time
-	if c.size < 0 {
-		if err = c.forward(); err != nil {
-			return nil, err
+	var packets []*packet.Packet
+	for {
+		if c.size < 0 {
+			ok, err := c.forward()
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				break
+			}
 		}
-	}
-
-	for c.size <= c.buf.Len() {
-		p := &packet.Packet{Type: packet.Type(c.typ), Length: c.size, Data: c.buf.Next(c.size)}
-		packets = append(packets, p)
-
-		// more packet
-		if c.buf.Len() < HeadLength {
-			c.size = -1
+		if c.size > c.buf.Len() {
 			break
 		}
 
-		if err = c.forward(); err != nil {
-			return nil, err
-
-		}
-
+		p := &packet.Packet{Type: packet.Type(c.typ), Length: c.size, Data: c.buf.Next(c.size)}
+		packets = append(packets, p)
+		c.size = -1
 	}
 
 	return packets, nil
@@ -114,20 +146,130 @@ func (c *Decoder) Decode(data []byte) ([]*packet.Packet, error) {
 // --------|------------------------|--------
 // 1 byte packet type, 3 bytes packet data length(big end), and data segment
 func Encode(typ packet.Type, data []byte) ([]byte, error) {
-	if typ < packet.Handshake || typ > packet.Kick {
+	return FramingFixed24.Encode(typ, data)
+}
+
+// Encode encodes the packet like the package level Encode, the length of data is
+// encoded by the framing
+func (f Framing) Encode(typ packet.Type, data []byte) ([]byte, error) {
+	if typ < packet.Handshake || typ > packet.Ack {
 		return nil, packet.ErrWrongPacketType
 	}
 
-	p := &packet.Packet{Type: typ, Length: len(data)}
-	buf := make([]byte, p.Length+HeadLength)
-	buf[0] = byte(p.Type)
+	var header [1 + binary.MaxVarintLen32]byte
+	header[0] = byte(typ)
+	n, err := f.putLength(header[1:], len(data))
+	if err != nil {
+		return nil, err
+	}
 
-	copy(buf[1:HeadLength], intToBytes(p.Length))
-	copy(buf[HeadLength:], data)
+	buf := make([]byte, 1+n+len(data))
+	copy(buf, header[:1+n])
+	copy(buf[1+n:], data)
 
 	return buf, nil
 }
 
+// Read reads a packet from r, the packets larger than maxSize are rejected, io.EOF is
+// returned only if r ends before the packet
+func (f Framing) Read(r io.Reader, maxSize int) (*packet.Packet, error) {
+	if maxSize <= 0 {
+		maxSize = MaxPacketSize
+	}
+
+	var header [1 + binary.MaxVarintLen32]byte
+	if _, err := io.ReadFull(r, header[:1]); err != nil {
+		return nil, err
+	}
+	typ := header[0]
+	if typ < packet.Handshake || typ > packet.Ack {
+		return nil, packet.ErrWrongPacketType
+	}
+
+	// read the length byte by byte, which is incomplete until readLength consumes it
+	var (
+		size, n int
+		err     error
+	)
+	for i := 1; n == 0; i++ {
+		if i == len(header) {
+			return nil, ErrInvalidLength
+		}
+		if _, err = io.ReadFull(r, header[i:i+1]); err != nil {
+			return nil, unexpected(err)
+		}
+		if size, n, err = f.readLength(header[1 : i+1]); err != nil {
+			return nil, err
+		}
+	}
+	if size < 0 || size > maxSize {
+		return nil, ErrPacketSizeExcced
+	}
+
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, unexpected(err)
+	}
+	return &packet.Packet{Type: packet.Type(typ), Length: size, Data: data}, nil
+}
+
+// unexpected converts io.EOF in the middle of a packet to io.ErrUnexpectedEOF
+func unexpected(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// putLength encodes the length to b, returns the number of bytes written
+func (f Framing) putLength(b []byte, length int) (int, error) {
+	switch f {
+	case FramingFixed32:
+		if uint64(length) > math.MaxUint32 {
+			return 0, ErrPacketSizeExcced
+		}
+		binary.BigEndian.PutUint32(b, uint32(length))
+		return 4, nil
+	case FramingVarint:
+		if uint64(length) > math.MaxUint32 {
+			return 0, ErrPacketSizeExcced
+		}
+		return binary.PutUvarint(b, uint64(length)), nil
+	default:
+		if length > 1<<24-1 {
+			return 0, ErrPacketSizeExcced
+		}
+		copy(b, intToBytes(length))
+		return HeadLength - 1, nil
+	}
+}
+
+// readLength decodes the length from b, returns the number of bytes read, which is
+// zero if b is incomplete
+func (f Framing) readLength(b []byte) (int, int, error) {
+	switch f {
+	case FramingFixed32:
+		if len(b) < 4 {
+			return 0, 0, nil
+		}
+		return int(binary.BigEndian.Uint32(b)), 4, nil
+	case FramingVarint:
+		v, n := binary.Uvarint(b)
+		if n == 0 && len(b) < binary.MaxVarintLen32 {
+			return 0, 0, nil
+		}
+		if n <= 0 || n > binary.MaxVarintLen32 || v > math.MaxUint32 {
+			return 0, 0, ErrInvalidLength
+		}
+		return int(v), n, nil
+	default:
+		if len(b) < HeadLength-1 {
+			return 0, 0, nil
+		}
+		return bytesToInt(b[:HeadLength-1]), HeadLength - 1, nil
+	}
+}
+
 // Decode packet data length byte to int(Big end)
 func bytesToInt(b []byte) int {
 	result := 0
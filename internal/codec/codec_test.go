@@ -50,8 +50,8 @@ func TestPack(t *testing.T) {
 		t.Error("should err")
 	}
 
-	_ = &Packet{Type: Type(6), Data: data, Length: len(data)}
-	if _, err = Encode(Type(6), data); err == nil {
+	_ = &Packet{Type: Type(8), Data: data, Length: len(data)}
+	if _, err = Encode(Type(8), data); err == nil {
 		t.Error("should err")
 	}
 
@@ -74,6 +74,54 @@ func TestPack(t *testing.T) {
 	}
 }
 
+func TestFraming(t *testing.T) {
+	large := make([]byte, 70000)
+	for _, f := range []Framing{FramingFixed24, FramingFixed32, FramingVarint} {
+		for _, data := range [][]byte{nil, []byte("hello world"), large} {
+			p, err := f.Encode(Data, data)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// feed byte by byte to decode the incomplete headers
+			d := NewFramingDecoder(f, len(large))
+			var packets []*Packet
+			for i := range p {
+				ps, err := d.Decode(p[i : i+1])
+				if err != nil {
+					t.Fatal(err)
+				}
+				packets = append(packets, ps...)
+			}
+			if len(packets) != 1 || packets[0].Type != Data || len(packets[0].Data) != len(data) {
+				t.Fatalf("framing %d: unexpected packets %v", f, packets)
+			}
+		}
+	}
+
+	p, err := FramingVarint.Encode(Data, []byte("hello world"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p) != 1+1+11 {
+		t.Fatalf("varint header should be 2 bytes: %v", p)
+	}
+
+	// the decoder limits the packet size
+	p, err = FramingFixed32.Encode(Data, large)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewFramingDecoder(FramingFixed32, 0).Decode(p); err != ErrPacketSizeExcced {
+		t.Fatalf("expect %v, got %v", ErrPacketSizeExcced, err)
+	}
+
+	// malformed varint length
+	if _, err := NewFramingDecoder(FramingVarint, 0).Decode([]byte{byte(Data), 0xff, 0xff, 0xff, 0xff, 0xff}); err != ErrInvalidLength {
+		t.Fatalf("expect %v, got %v", ErrInvalidLength, err)
+	}
+}
+
 func BenchmarkDecoder_Decode(b *testing.B) {
 	data := []byte("hello world")
 	pp1, err := Encode(Handshake, data)
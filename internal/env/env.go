@@ -26,6 +26,7 @@ import (
 	"net/http"
 	"time"
 
+	"github.com/lonng/nano/internal/clock"
 	"github.com/lonng/nano/serialize"
 	"github.com/lonng/nano/serialize/protobuf"
 	"google.golang.org/grpc"
@@ -40,9 +41,38 @@ var (
 	WSPath             string                   // WebSocket path(eg: ws://127.0.0.1/WSPath)
 	HandshakeValidator func([]byte) error       // When you need to verify the custom data of the handshake request
 
+	// RequestTimeout indicates the duration that a server initiated request
+	// waits for the client response, default is 5 seconds
+	RequestTimeout = 5 * time.Second
+
+	// DedupWindow indicates the duration that the response of a request will be
+	// cached for the duplicate requests with the same message id, zero disables
+	// the request deduplication
+	DedupWindow time.Duration
+
+	// MaxGroupsPerSession indicates the maximum number of groups which a session can
+	// join, zero means unlimited
+	MaxGroupsPerSession int
+
+	// DispatcherQueueSize indicates the capacity of the task queue of the dispatcher,
+	// pushing to a full queue blocks until the dispatcher catches up
+	DispatcherQueueSize = 1 << 8
+
+	// CompressThreshold indicates the minimum payload size that will be compressed
+	// on the connections which negotiated compression, zero disables compression
+	CompressThreshold int
+
+	// CompressionFilter decides whether to accept the compression offered by client
+	// with the handshake data, nil accepts all offers
+	CompressionFilter func([]byte) bool
+
 	// timerPrecision indicates the precision of timer, default is time.Second
 	TimerPrecision = time.Second
 
+	// Clock is the time source of the timers, the heartbeats and the timeouts, which
+	// can be replaced by a manual clock in tests to drive them deterministically
+	Clock = clock.Real
+
 	// globalTicker represents global ticker that all cron job will be executed
 	// in globalTicker.
 	GlobalTicker *time.Ticker
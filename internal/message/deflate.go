@@ -0,0 +1,48 @@
+package message
+
+import (
+	"bytes"
+	"compress/flate"
+	"io/ioutil"
+)
+
+// Deflate compresses the payload with deflate
+func Deflate(data []byte) ([]byte, error) {
+	return DeflateDict(data, nil)
+}
+
+// DeflateDict compresses the payload with deflate and the preset dictionary, which must
+// be inflated with the same dictionary, nil dictionary is the same as Deflate
+func DeflateDict(data, dict []byte) ([]byte, error) {
+	// only the best compression matches the small payloads against the dictionary,
+	// which costs little for the payloads the dictionary is meant for
+	level := flate.DefaultCompression
+	if len(dict) > 0 {
+		level = flate.BestCompression
+	}
+	var buf bytes.Buffer
+	w, err := flate.NewWriterDict(&buf, level, dict)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Inflate decompresses the payload which is compressed by deflate
+func Inflate(data []byte) ([]byte, error) {
+	return InflateDict(data, nil)
+}
+
+// InflateDict decompresses the payload which is compressed by deflate with the preset
+// dictionary
+func InflateDict(data, dict []byte) ([]byte, error) {
+	r := flate.NewReaderDict(bytes.NewReader(data), dict)
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
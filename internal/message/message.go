@@ -21,10 +21,13 @@
 package message
 
 import (
+	"context"
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/lonng/nano/internal/log"
 )
@@ -42,11 +45,21 @@ const (
 
 const (
 	msgRouteCompressMask = 0x01
+	msgDataCompressMask  = 0x10
+	msgErrorMask         = 0x20
+	msgTimeoutMask       = 0x40
+	msgMoreMask          = 0x80 // response only
+	msgSeqMask           = 0x80 // push only
+	msgHeaderMask        = 0x80 // request and notify, 0x40 for response and push
 	msgTypeMask          = 0x07
 	msgRouteLengthMask   = 0xFF
 	msgHeadLength        = 0x02
 )
 
+// MaxHeaderSize is the maximum encoded size of the header of a message, the key and the
+// value are counted with their length prefix
+const MaxHeaderSize = 1024
+
 var types = map[Type]string{
 	Request:  "Request",
 	Notify:   "Notify",
@@ -69,6 +82,7 @@ var (
 	ErrInvalidMessage    = errors.New("invalid message")
 	ErrRouteInfoNotFound = errors.New("route info not found in dictionary")
 	ErrWrongMessage      = errors.New("wrong message")
+	ErrHeaderTooLarge    = errors.New("message header too large")
 )
 
 // Message represents a unmarshaled message or a message which to be marshaled
@@ -77,7 +91,27 @@ type Message struct {
 	ID         uint64 // unique id, zero while notify mode
 	Route      string // route for locating service
 	Data       []byte // payload
+	Err        bool   // is an error message
+	Deflated   bool   // is payload compressed by deflate
 	compressed bool   // is message compressed
+
+	// Timeout is the time budget of a request attached by client, the request is useless
+	// after it elapsed, zero means no timeout. It is encoded in milliseconds
+	Timeout time.Duration
+
+	// More indicates more responses of the same request follow, the response without
+	// it ends the stream of responses
+	More bool
+
+	// Seq is the sequence number of a reliable push which is acknowledged by client,
+	// zero means the push is not reliable
+	Seq uint64
+
+	// Header is the metadata of the message out of the payload, e.g: the locale or the
+	// version of client, which is limited to MaxHeaderSize encoded
+	Header map[string]string
+
+	ctx context.Context // request-scoped context populated by the inbound pipeline
 }
 
 // New returns a new message instance
@@ -85,6 +119,29 @@ func New() *Message {
 	return &Message{}
 }
 
+// Context returns the request-scoped context of the message, which carries the values
+// set by the inbound pipeline to the handler declaring a context.Context argument. The
+// context lives for the handling of one message, and it is read by the handler only
+// after the pipeline returned, so it needs no synchronization. context.Background() is
+// returned if the pipeline set nothing
+func (m *Message) Context() context.Context {
+	if m.ctx == nil {
+		return context.Background()
+	}
+	return m.ctx
+}
+
+// SetContext replaces the request-scoped context, e.g: the context carrying a trace span
+func (m *Message) SetContext(ctx context.Context) {
+	m.ctx = ctx
+}
+
+// WithValue stores the request-scoped value of key, e.g: the authenticated user object
+// or a database transaction, the key should be an unexported type like context.WithValue
+func (m *Message) WithValue(key, val interface{}) {
+	m.ctx = context.WithValue(m.Context(), key, val)
+}
+
 // String, implementation of fmt.Stringer interface
 func (m *Message) String() string {
 	return fmt.Sprintf("%s %s (%dbytes)", types[m.Type], m.Route, len(m.Data))
@@ -104,18 +161,79 @@ func invalidType(t Type) bool {
 
 }
 
+// headerMask returns the flag bit of the header, which shares the bit of the timeout
+// with the response and push as the bit of the more and sequence flags is taken
+func headerMask(t Type) byte {
+	if t == Request || t == Notify {
+		return msgHeaderMask
+	}
+	return msgTimeoutMask
+}
+
+// EncodeHeader marshals the header to the count of the entries followed by the length
+// prefixed keys and values, the keys are sorted to keep the encoding deterministic
+func EncodeHeader(h map[string]string) ([]byte, error) {
+	keys := make([]string, 0, len(h))
+	for k := range h {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var tmp [binary.MaxVarintLen64]byte
+	buf := append([]byte(nil), tmp[:binary.PutUvarint(tmp[:], uint64(len(keys)))]...)
+	for _, k := range keys {
+		for _, s := range []string{k, h[k]} {
+			buf = append(buf, tmp[:binary.PutUvarint(tmp[:], uint64(len(s)))]...)
+			buf = append(buf, s...)
+		}
+	}
+	if len(buf) > MaxHeaderSize {
+		return nil, ErrHeaderTooLarge
+	}
+	return buf, nil
+}
+
+// DecodeHeader unmarshals the header encoded by EncodeHeader, the number of bytes read
+// is returned as the header may be followed by the other fields
+func DecodeHeader(data []byte) (map[string]string, int, error) {
+	count, offset := binary.Uvarint(data)
+	if offset <= 0 || count > MaxHeaderSize {
+		return nil, 0, ErrWrongMessage
+	}
+	h := make(map[string]string, count)
+	for i := uint64(0); i < count; i++ {
+		var kv [2]string
+		for j := range kv {
+			l, n := binary.Uvarint(data[offset:])
+			if n <= 0 || l > uint64(len(data)-offset-n) {
+				return nil, 0, ErrWrongMessage
+			}
+			offset += n
+			kv[j] = string(data[offset : offset+int(l)])
+			offset += int(l)
+		}
+		h[kv[0]] = kv[1]
+	}
+	if offset > MaxHeaderSize {
+		return nil, 0, ErrHeaderTooLarge
+	}
+	return h, offset, nil
+}
+
 // Encode marshals message to binary format. Different message types is corresponding to
 // different message header, message types is identified by 2-4 bit of flag field. The
 // relationship between message types and message header is presented as follows:
 // ------------------------------------------
 // |   type   |  flag  |       other        |
 // |----------|--------|--------------------|
-// | request  |----000-|<message id>|<route>|
+// | request  |----000-|<message id>|<route>| (<timeout> after message id if 0x40 is set)
 // | notify   |----001-|<route>             |
-// | response |----010-|<message id>        |
-// | push     |----011-|<route>             |
+// | response |----010-|<message id>        | (more responses follow if 0x80 is set)
+// | push     |----011-|<route>             | (<sequence> before route if 0x80 is set)
 // ------------------------------------------
-// The figure above indicates that the bit does not affect the type of message.
+// The figure above indicates that the bit does not affect the type of message. The
+// <header> precedes the route (or the payload of response) if 0x80 of request/notify
+// or 0x40 of response/push is set.
 // See ref: https://github.com/lonnng/nano/blob/master/docs/communication_protocol.md
 func Encode(m *Message) ([]byte, error) {
 	if invalidType(m.Type) {
@@ -129,6 +247,31 @@ func Encode(m *Message) ([]byte, error) {
 	if compressed {
 		flag |= msgRouteCompressMask
 	}
+	if m.Err {
+		flag |= msgErrorMask
+	}
+	if m.Deflated {
+		flag |= msgDataCompressMask
+	}
+	timeout := m.Type == Request && m.Timeout > 0
+	if timeout {
+		flag |= msgTimeoutMask
+	}
+	if m.Type == Response && m.More {
+		flag |= msgMoreMask
+	}
+	sequenced := m.Type == Push && m.Seq > 0
+	if sequenced {
+		flag |= msgSeqMask
+	}
+	var header []byte
+	if len(m.Header) > 0 {
+		var err error
+		if header, err = EncodeHeader(m.Header); err != nil {
+			return nil, err
+		}
+		flag |= headerMask(m.Type)
+	}
 	buf = append(buf, flag)
 
 	if m.Type == Request || m.Type == Response {
@@ -146,6 +289,20 @@ func Encode(m *Message) ([]byte, error) {
 		}
 	}
 
+	if timeout {
+		// rounded up to milliseconds, which never turns a timeout into no timeout
+		ms := uint64((m.Timeout + time.Millisecond - 1) / time.Millisecond)
+		var tmp [binary.MaxVarintLen64]byte
+		buf = append(buf, tmp[:binary.PutUvarint(tmp[:], ms)]...)
+	}
+
+	if sequenced {
+		var tmp [binary.MaxVarintLen64]byte
+		buf = append(buf, tmp[:binary.PutUvarint(tmp[:], m.Seq)]...)
+	}
+
+	buf = append(buf, header...)
+
 	if routable(m.Type) {
 		if compressed {
 			buf = append(buf, byte((code>>8)&0xFF))
@@ -170,10 +327,13 @@ func Decode(data []byte) (*Message, error) {
 	flag := data[0]
 	offset := 1
 	m.Type = Type((flag >> 1) & msgTypeMask)
+	m.Err = flag&msgErrorMask == msgErrorMask
+	m.Deflated = flag&msgDataCompressMask == msgDataCompressMask
 
 	if invalidType(m.Type) {
 		return nil, ErrWrongMessageType
 	}
+	m.More = m.Type == Response && flag&msgMoreMask == msgMoreMask
 
 	if m.Type == Request || m.Type == Response {
 		id := uint64(0)
@@ -189,6 +349,36 @@ func Decode(data []byte) (*Message, error) {
 			}
 		}
 		m.ID = id
+
+		if m.Type == Request && flag&msgTimeoutMask == msgTimeoutMask && offset < len(data) {
+			ms, n := binary.Uvarint(data[offset:])
+			if n <= 0 {
+				return nil, ErrWrongMessage
+			}
+			m.Timeout = time.Duration(ms) * time.Millisecond
+			offset += n
+		}
+	}
+
+	if m.Type == Push && flag&msgSeqMask == msgSeqMask {
+		seq, n := binary.Uvarint(data[offset:])
+		if n <= 0 {
+			return nil, ErrWrongMessage
+		}
+		m.Seq = seq
+		offset += n
+	}
+
+	if flag&headerMask(m.Type) != 0 {
+		if offset >= len(data) {
+			return nil, ErrWrongMessage
+		}
+		h, n, err := DecodeHeader(data[offset:])
+		if err != nil {
+			return nil, err
+		}
+		m.Header = h
+		offset += n
 	}
 
 	if offset >= len(data) {
@@ -1,8 +1,11 @@
 package message
 
 import (
+	"bytes"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestEncode(t *testing.T) {
@@ -162,3 +165,208 @@ func TestEncode(t *testing.T) {
 		t.Error("not equal")
 	}
 }
+
+func TestEncodeError(t *testing.T) {
+	m := &Message{
+		Type: Response,
+		ID:   100,
+		Data: []byte(`{"code":500}`),
+		Err:  true,
+	}
+	em, err := m.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dm, err := Decode(em)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(m, dm) {
+		t.Error("not equal")
+	}
+}
+
+func TestEncodeDeflated(t *testing.T) {
+	raw := []byte(strings.Repeat("nano", 100))
+	data, err := Deflate(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) >= len(raw) {
+		t.Fatalf("expect compressed, got %d bytes", len(data))
+	}
+	m := &Message{
+		Type:     Push,
+		Route:    "test.deflate",
+		Data:     data,
+		Deflated: true,
+	}
+	em, err := m.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dm, err := Decode(em)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(m, dm) {
+		t.Error("not equal")
+	}
+	inflated, err := Inflate(dm.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(inflated, raw) {
+		t.Error("inflated payload not equal")
+	}
+}
+
+func TestEncodeTimeout(t *testing.T) {
+	m := &Message{
+		Type:    Request,
+		ID:      300,
+		Route:   "test.timeout",
+		Data:    []byte("hello"),
+		Timeout: 2 * time.Second,
+	}
+	em, err := m.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if em[0]&msgTimeoutMask == 0 {
+		t.Fatalf("expect timeout flag, got %#x", em[0])
+	}
+	dm, err := Decode(em)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(m, dm) {
+		t.Errorf("expect %+v, got %+v", m, dm)
+	}
+
+	// rounded up to milliseconds
+	m.Timeout = time.Microsecond
+	if em, err = m.Encode(); err != nil {
+		t.Fatal(err)
+	}
+	if dm, err = Decode(em); err != nil || dm.Timeout != time.Millisecond {
+		t.Fatalf("expect 1ms timeout, got %v (%v)", dm, err)
+	}
+
+	// only requests carry the timeout
+	m = &Message{Type: Notify, Route: "test.timeout", Data: []byte("hello"), Timeout: time.Second}
+	if em, err = m.Encode(); err != nil {
+		t.Fatal(err)
+	}
+	if dm, err = Decode(em); err != nil || dm.Timeout != 0 {
+		t.Fatalf("expect no timeout, got %v (%v)", dm, err)
+	}
+}
+
+func TestEncodeMore(t *testing.T) {
+	m := &Message{
+		Type: Response,
+		ID:   300,
+		Data: []byte("tick"),
+		More: true,
+	}
+	em, err := m.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if em[0]&msgMoreMask == 0 {
+		t.Fatalf("expect more flag, got %#x", em[0])
+	}
+	dm, err := Decode(em)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(m, dm) {
+		t.Errorf("expect %+v, got %+v", m, dm)
+	}
+
+	// only responses carry the flag
+	m = &Message{Type: Push, Route: "test.more", Data: []byte("tick"), More: true}
+	if em, err = m.Encode(); err != nil {
+		t.Fatal(err)
+	}
+	if dm, err = Decode(em); err != nil || dm.More {
+		t.Fatalf("expect no more flag, got %v (%v)", dm, err)
+	}
+}
+
+func TestEncodeSeq(t *testing.T) {
+	m := &Message{
+		Type:  Push,
+		Route: "test.seq",
+		Data:  []byte("tick"),
+		Seq:   300,
+	}
+	em, err := m.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if em[0]&msgSeqMask == 0 {
+		t.Fatalf("expect sequence flag, got %#x", em[0])
+	}
+	dm, err := Decode(em)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(m, dm) {
+		t.Errorf("expect %+v, got %+v", m, dm)
+	}
+
+	// only pushes carry the sequence number
+	m = &Message{Type: Notify, Route: "test.seq", Data: []byte("tick"), Seq: 300}
+	if em, err = m.Encode(); err != nil {
+		t.Fatal(err)
+	}
+	if dm, err = Decode(em); err != nil || dm.Seq != 0 {
+		t.Fatalf("expect no sequence number, got %v (%v)", dm, err)
+	}
+}
+
+func TestEncodeHeader(t *testing.T) {
+	header := map[string]string{"locale": "en-US", "Version": "1.2.0"}
+	cases := []*Message{
+		{Type: Request, ID: 300, Route: "test.header", Data: []byte("hello"), Header: header, Timeout: time.Second},
+		{Type: Notify, Route: "test.header", Data: []byte("hello"), Header: header},
+		{Type: Response, ID: 300, Data: []byte("hello"), Header: header, More: true},
+		{Type: Push, Route: "test.header", Data: []byte("hello"), Header: header, Seq: 300},
+	}
+	for _, m := range cases {
+		em, err := m.Encode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if em[0]&headerMask(m.Type) == 0 {
+			t.Fatalf("expect header flag of %s, got %#x", m.Type, em[0])
+		}
+		dm, err := Decode(em)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(m, dm) {
+			t.Errorf("expect %+v, got %+v", m, dm)
+		}
+	}
+
+	// the header is limited in size
+	m := &Message{Type: Notify, Route: "test.header", Data: []byte("hello"), Header: map[string]string{
+		"large": strings.Repeat("x", MaxHeaderSize),
+	}}
+	if _, err := m.Encode(); err != ErrHeaderTooLarge {
+		t.Fatalf("expect %v, got %v", ErrHeaderTooLarge, err)
+	}
+
+	// the truncated header is rejected
+	m.Header = header
+	em, err := m.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Decode(em[:len(em)-len("test.header")-len("hello")-3]); err == nil {
+		t.Fatal("expect error of truncated header")
+	}
+}
@@ -20,13 +20,31 @@
 
 package message
 
-import "github.com/lonng/nano/internal/env"
+import (
+	"fmt"
 
-func Serialize(v interface{}) ([]byte, error) {
+	"github.com/lonng/nano/internal/env"
+	"github.com/lonng/nano/serialize"
+)
+
+// Serialize marshals v with the application serializer, a []byte will be returned
+// directly. A panic in serializer is recovered and returned as an error.
+func Serialize(v interface{}) (data []byte, err error) {
+	return SerializeWith(env.Serializer, v)
+}
+
+// SerializeWith marshals v with the serializer, e.g: the serializer negotiated by
+// the connection, a []byte will be returned directly
+func SerializeWith(serializer serialize.Serializer, v interface{}) (data []byte, err error) {
 	if data, ok := v.([]byte); ok {
 		return data, nil
 	}
-	data, err := env.Serializer.Marshal(v)
+	defer func() {
+		if e := recover(); e != nil {
+			data, err = nil, fmt.Errorf("serialize %T panic: %v", v, e)
+		}
+	}()
+	data, err = serializer.Marshal(v)
 	if err != nil {
 		return nil, err
 	}
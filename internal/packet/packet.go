@@ -44,6 +44,12 @@ const (
 
 	// Kick represents a kick off packet
 	Kick = 0x05 // disconnect message from server
+
+	// Rekey represents a key rotation: new key(server) <====> ack(client)
+	Rekey = 0x06
+
+	// Ack represents an acknowledgement of the reliable pushes from client
+	Ack = 0x07
 )
 
 // ErrWrongPacketType represents a wrong packet type.
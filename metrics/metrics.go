@@ -0,0 +1,190 @@
+package metrics
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// The metrics labeled by the route are labeled by the component owning the route
+// automatically, which groups the metrics of a subsystem, e.g: combat, chat or inventory.
+// The component is the route prefix, unless it is mapped to a namespace by SetNamespace
+const (
+	LabelRoute     = "route"
+	LabelComponent = "component"
+)
+
+type (
+	// Counter is a metric which value only increases
+	Counter struct {
+		value int64
+	}
+
+	// Gauge is a metric which value can go up and down
+	Gauge struct {
+		value int64
+	}
+
+	// Sample represents the value of a metric at the moment of the snapshot
+	Sample struct {
+		Name   string
+		Labels map[string]string
+		Value  int64
+	}
+
+	metric struct {
+		name   string
+		labels map[string]string
+		value  interface{ Value() int64 }
+	}
+
+	// Registry holds all metrics by name and labels
+	Registry struct {
+		mu      sync.RWMutex
+		metrics map[string]*metric // metric key map to metric
+
+		muNamespaces sync.RWMutex
+		namespaces   map[string]string // component name map to namespace
+	}
+)
+
+// Default is the registry which nano reports internal metrics to
+var Default = NewRegistry()
+
+// Inc increases the counter by 1
+func (c *Counter) Inc() {
+	atomic.AddInt64(&c.value, 1)
+}
+
+// Add increases the counter by n, n must not be negative
+func (c *Counter) Add(n int64) {
+	atomic.AddInt64(&c.value, n)
+}
+
+// Value returns the current value of the counter
+func (c *Counter) Value() int64 {
+	return atomic.LoadInt64(&c.value)
+}
+
+// Set sets the gauge to v
+func (g *Gauge) Set(v int64) {
+	atomic.StoreInt64(&g.value, v)
+}
+
+// Add adds n to the gauge, n can be negative
+func (g *Gauge) Add(n int64) {
+	atomic.AddInt64(&g.value, n)
+}
+
+// Value returns the current value of the gauge
+func (g *Gauge) Value() int64 {
+	return atomic.LoadInt64(&g.value)
+}
+
+// NewRegistry returns an empty registry
+func NewRegistry() *Registry {
+	return &Registry{metrics: map[string]*metric{}, namespaces: map[string]string{}}
+}
+
+// Counter returns the counter identified by name and labels, the labels are
+// key/value pairs, e.g: Counter("nano_requests_total", "route", "room.join")
+func (r *Registry) Counter(name string, labels ...string) *Counter {
+	m := r.lookup(name, labels, func() interface{ Value() int64 } { return &Counter{} })
+	return m.value.(*Counter)
+}
+
+// Gauge returns the gauge identified by name and labels, the labels are
+// key/value pairs, e.g: Gauge("nano_sessions", "node", "127.0.0.1:3250")
+func (r *Registry) Gauge(name string, labels ...string) *Gauge {
+	m := r.lookup(name, labels, func() interface{ Value() int64 } { return &Gauge{} })
+	return m.value.(*Gauge)
+}
+
+// Snapshot returns the current values of all metrics sorted by name
+func (r *Registry) Snapshot() []Sample {
+	r.mu.RLock()
+	keys := make([]string, 0, len(r.metrics))
+	for k := range r.metrics {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	samples := make([]Sample, 0, len(keys))
+	for _, k := range keys {
+		m := r.metrics[k]
+		samples = append(samples, Sample{Name: m.name, Labels: m.labels, Value: m.value.Value()})
+	}
+	r.mu.RUnlock()
+	return samples
+}
+
+// SetNamespace labels the metrics of the routes of component by the namespace instead
+// of the component name, e.g: the components of a subsystem share a namespace. It should
+// be called before the metrics of the routes are reported
+func (r *Registry) SetNamespace(component, namespace string) {
+	r.muNamespaces.Lock()
+	r.namespaces[component] = namespace
+	r.muNamespaces.Unlock()
+}
+
+// withComponent appends the component label to the labels of a route metric, the labels
+// which have a component label or no route label are returned as is
+func (r *Registry) withComponent(labels []string) []string {
+	var route string
+	for i := 0; i+1 < len(labels); i += 2 {
+		switch labels[i] {
+		case LabelComponent:
+			return labels
+		case LabelRoute:
+			route = labels[i+1]
+		}
+	}
+	index := strings.LastIndex(route, ".")
+	if index < 1 {
+		return labels
+	}
+
+	component := route[:index]
+	r.muNamespaces.RLock()
+	if ns, found := r.namespaces[component]; found {
+		component = ns
+	}
+	r.muNamespaces.RUnlock()
+	return append(labels[:len(labels):len(labels)], LabelComponent, component)
+}
+
+func (r *Registry) lookup(name string, labels []string, create func() interface{ Value() int64 }) *metric {
+	labels = r.withComponent(labels)
+	k := key(name, labels)
+	r.mu.RLock()
+	m, found := r.metrics[k]
+	r.mu.RUnlock()
+	if found {
+		return m
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if m, found := r.metrics[k]; found {
+		return m
+	}
+	m = &metric{name: name, labels: map[string]string{}, value: create()}
+	for i := 0; i+1 < len(labels); i += 2 {
+		m.labels[labels[i]] = labels[i+1]
+	}
+	r.metrics[k] = m
+	return m
+}
+
+// key returns the identity of a metric, e.g: name{k1="v1",k2="v2"}
+func key(name string, labels []string) string {
+	if len(labels) < 2 {
+		return name
+	}
+	pairs := make([]string, 0, len(labels)/2)
+	for i := 0; i+1 < len(labels); i += 2 {
+		pairs = append(pairs, labels[i]+"=\""+labels[i+1]+"\"")
+	}
+	sort.Strings(pairs)
+	return name + "{" + strings.Join(pairs, ",") + "}"
+}
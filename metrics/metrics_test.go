@@ -0,0 +1,61 @@
+package metrics
+
+import (
+	"testing"
+)
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry()
+	r.Counter("requests", "route", "room.join", "mode", "local").Inc()
+	r.Counter("requests", "mode", "local", "route", "room.join").Add(2)
+	r.Counter("requests", "route", "room.leave", "mode", "remote").Inc()
+	r.Gauge("sessions").Set(10)
+	r.Gauge("sessions").Add(-3)
+
+	if v := r.Counter("requests", "route", "room.join", "mode", "local").Value(); v != 3 {
+		t.Fatalf("expect 3, got %d", v)
+	}
+
+	samples := r.Snapshot()
+	if len(samples) != 3 {
+		t.Fatalf("expect 3 samples, got %d", len(samples))
+	}
+	if s := samples[2]; s.Name != "sessions" || s.Value != 7 {
+		t.Fatalf("unexpected sample: %+v", s)
+	}
+	if s := samples[1]; s.Labels["route"] != "room.leave" || s.Labels["mode"] != "remote" || s.Value != 1 {
+		t.Fatalf("unexpected sample: %+v", s)
+	}
+}
+
+func TestRegistryComponentLabel(t *testing.T) {
+	r := NewRegistry()
+	r.SetNamespace("Guild", "social")
+	r.Counter("requests", "route", "Room.Join").Inc()
+	r.Counter("requests", "route", "Room.Leave").Inc()
+	r.Counter("requests", "route", "Guild.Join").Inc()
+	r.Counter("requests", "route", "Chat.Say", "component", "chat").Inc()
+	r.Counter("requests", "route", "invalid").Inc()
+
+	// the metrics are found by the labels without the component
+	if v := r.Counter("requests", "route", "Guild.Join").Value(); v != 1 {
+		t.Fatalf("expect 1, got %d", v)
+	}
+
+	components := map[string]string{}
+	for _, s := range r.Snapshot() {
+		components[s.Labels["route"]] = s.Labels["component"]
+	}
+	expected := map[string]string{
+		"Room.Join":  "Room",
+		"Room.Leave": "Room",
+		"Guild.Join": "social",
+		"Chat.Say":   "chat",
+		"invalid":    "",
+	}
+	for route, component := range expected {
+		if components[route] != component {
+			t.Fatalf("expect route %s labeled by component %q, got %q", route, component, components[route])
+		}
+	}
+}
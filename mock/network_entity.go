@@ -46,6 +46,7 @@ type NetworkEntity struct {
 	responses []interface{}
 	msgmap    map[uint64]interface{}
 	rpcCall   []message
+	requests  []message
 }
 
 // NewNetworkEntity returns an mock network entity
@@ -61,6 +62,12 @@ func (n *NetworkEntity) RPC(route string, v interface{}) error {
 	return nil
 }
 
+// Request records the server initiated request, see session.Session.Request
+func (n *NetworkEntity) Request(route string, v interface{}) ([]byte, error) {
+	n.requests = append(n.requests, message{route: route, data: v})
+	return nil, nil
+}
+
 // Push implements the session.NetworkEntity interface
 func (n *NetworkEntity) Push(route string, v interface{}) error {
 	n.messages = append(n.messages, message{route: route, data: v})
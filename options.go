@@ -1,17 +1,24 @@
 package nano
 
 import (
+	"context"
+	"net"
 	"net/http"
+	"syscall"
 	"time"
 
 	"github.com/lonng/nano/cluster"
+	pcodec "github.com/lonng/nano/codec"
 	"github.com/lonng/nano/component"
+	"github.com/lonng/nano/internal/codec"
 	"github.com/lonng/nano/internal/env"
 	"github.com/lonng/nano/internal/log"
 	"github.com/lonng/nano/internal/message"
 	"github.com/lonng/nano/pipeline"
 	"github.com/lonng/nano/serialize"
+	"github.com/lonng/nano/session"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
 )
 
 type Option func(*cluster.Options)
@@ -42,13 +49,556 @@ func WithClientAddr(addr string) Option {
 	}
 }
 
-// WithMaster sets the option to indicate whether the current node is master node
+// WithMaster sets the option to indicate whether the current node is master node. The
+// master also runs as a member in the same process: the routes of its components are
+// registered to the cluster like the other members, so a single binary can run the
+// whole cluster with the master alone, see docs/master_handoff.md
 func WithMaster() Option {
 	return func(opt *cluster.Options) {
 		opt.IsMaster = true
 	}
 }
 
+// WithTCPNoDelay controls whether the Nagle's algorithm is disabled on the accepted
+// client connections, it is disabled by default to reduce the latency of small messages
+func WithTCPNoDelay(noDelay bool) Option {
+	return func(opt *cluster.Options) {
+		opt.TCPNoDelay = noDelay
+	}
+}
+
+// WithTCPKeepAlive sets the keep-alive period of the accepted client connections, which
+// detects the dead peers, a negative duration disables the keep-alive
+func WithTCPKeepAlive(d time.Duration) Option {
+	return func(opt *cluster.Options) {
+		opt.TCPKeepAlive = d
+	}
+}
+
+// WithListenBacklog sets the backlog of the client listener, which absorbs the bursts of
+// connects before they are accepted. The backlog is capped by the system limit, e.g:
+// net.core.somaxconn on Linux and kern.ipc.somaxconn on BSD and macOS, which should be
+// raised as well. It is unsupported on Windows and only logged there
+func WithListenBacklog(backlog int) Option {
+	return func(opt *cluster.Options) {
+		opt.ListenBacklog = backlog
+	}
+}
+
+// WithListenControl sets the function which is called with the raw socket of the client
+// listener before it binds, which sets the socket options, e.g: SO_REUSEPORT
+func WithListenControl(fn func(network, address string, c syscall.RawConn) error) Option {
+	return func(opt *cluster.Options) {
+		opt.ListenControl = fn
+	}
+}
+
+// WithReusePort runs an accept loop per GOMAXPROCS on the client address, each of which
+// owns a listener bound with SO_REUSEPORT, the kernel balances the connects among them.
+// It is supported by the TCP listener on Linux, BSD and macOS, and the startup fails on
+// the other platforms or with the WebSocket listener
+func WithReusePort() Option {
+	return func(opt *cluster.Options) {
+		opt.ReusePort = true
+	}
+}
+
+// WithSocketBuffer sets the sizes of the OS receive and send buffers of the accepted TCP
+// client connections, which benefits the high-throughput workloads. A non-positive size
+// keeps the system default, and the OS may adjust or cap the sizes
+func WithSocketBuffer(read, write int) Option {
+	return func(opt *cluster.Options) {
+		opt.ReadBufferSize = read
+		opt.WriteBufferSize = write
+	}
+}
+
+// WithInitTimeout sets the duration that startup waits for the Init/AfterInit of each
+// component, startup fails with an error naming the stuck component after the timeout
+func WithInitTimeout(d time.Duration) Option {
+	return func(opt *cluster.Options) {
+		opt.InitTimeout = d
+	}
+}
+
+// WithReadRateLimit limits the bandwidth of each client connection to bytesPerSec, the
+// bursts up to burst bytes are allowed, zero means one second of the rate. The connection
+// exceeding it is not read until the rate recovered, which pushes back on the client, or
+// it is kicked if kick, e.g: the client streaming huge payloads to saturate the decoding
+func WithReadRateLimit(bytesPerSec, burst int, kick bool) Option {
+	return func(opt *cluster.Options) {
+		opt.MaxReadBytesPerSec = bytesPerSec
+		opt.ReadBurstBytes = burst
+		opt.ReadRateKick = kick
+	}
+}
+
+// WithSystemPacketLimit closes the connections which send more than limit handshake
+// and heartbeat packets in the window, e.g: WithSystemPacketLimit(10, time.Minute).
+// The system packets do not go through the route dispatch, so they are limited apart
+// from the application messages
+func WithSystemPacketLimit(limit int, window time.Duration) Option {
+	return func(opt *cluster.Options) {
+		opt.SystemPacketLimit = limit
+		opt.SystemPacketWindow = window
+	}
+}
+
+// WithMessageTrace logs the messages exchanged with clients in the readable form, e.g:
+//
+//	Trace in Request Room.Join(id: 3), UID=1001, Data={"room":"lobby","token":"[REDACTED]"}
+//
+// The payloads are logged in JSON with the values of the redact fields (matched case
+// insensitively at any depth) replaced, the serialized payloads which are not JSON
+// are logged by length only. A sample rate in (0, 1) traces the messages randomly,
+// otherwise all of them are traced. It is intended for development, which costs an
+// extra serialization per message
+func WithMessageTrace(sampleRate float64, redact ...string) Option {
+	return func(opt *cluster.Options) {
+		opt.TraceMessages = true
+		opt.TraceSampleRate = sampleRate
+		opt.TraceRedact = redact
+	}
+}
+
+// WithPayloadCipher encrypts the payloads exchanged with clients by the key of each
+// connection, the key material is supplied by the cipher. The initial key material is
+// sent in the handshake response, and the key is rotated every interval if positive,
+// or on demand by session.Session.RotateKey. The replaced key is still accepted in the
+// grace window after a rotation, unless client acknowledged the rotation, see the
+// Rekey Package in docs/communication_protocol.md
+func WithPayloadCipher(cipher cluster.PayloadCipher, interval, grace time.Duration) Option {
+	return func(opt *cluster.Options) {
+		opt.PayloadCipher = cipher
+		opt.KeyRotationInterval = interval
+		opt.KeyGraceWindow = grace
+	}
+}
+
+// WithPauseBuffer caps the messages buffered for a paused session, the messages beyond
+// it are dropped and the requests are responded an error. It is 64 by default, and a
+// negative buffer drops all of the messages received while paused
+func WithPauseBuffer(n int) Option {
+	return func(opt *cluster.Options) {
+		opt.PauseBuffer = n
+	}
+}
+
+// WithSessionQueueLimit caps the messages of a client connection which are dispatched to
+// the handlers but not handled yet, which protects the other sessions from a session
+// flooding messages. The connection reaching the limit stops being read until one of
+// its messages handled, which pushes back on the client, or it is kicked if kick is true
+func WithSessionQueueLimit(limit int, kick bool) Option {
+	return func(opt *cluster.Options) {
+		opt.SessionQueueLimit = limit
+		opt.SessionQueueKick = kick
+	}
+}
+
+// WithCloseFlushTimeout flushes the messages queued before a client connection closed
+// within the timeout, which delivers the final messages, e.g: the kick reason
+func WithCloseFlushTimeout(timeout time.Duration) Option {
+	return func(opt *cluster.Options) {
+		opt.CloseFlushTimeout = timeout
+	}
+}
+
+// WithSlowConsumer kicks the sessions whose send queue holds at least threshold messages
+// for the window with the reason "slow connection", instead of dropping the messages pushed
+// to a full queue silently. The threshold is capped by the capacity of the send queue, and
+// zero means a full queue
+func WithSlowConsumer(threshold int, window time.Duration) Option {
+	return func(opt *cluster.Options) {
+		opt.SlowConsumerThreshold = threshold
+		opt.SlowConsumerWindow = window
+	}
+}
+
+// Length encodings of the client packets, see WithFraming
+const (
+	FramingFixed24 = codec.FramingFixed24 // 3 bytes big endian, the default, compatible with pomelo clients
+	FramingFixed32 = codec.FramingFixed32 // 4 bytes big endian
+	FramingVarint  = codec.FramingVarint  // unsigned varint in 1~5 bytes
+)
+
+// WithFraming sets the length encoding of the client packets and the max size of the
+// packets received from clients, e.g: WithFraming(nano.FramingFixed32, 4<<20). The
+// framing is not negotiated, the clients of the deployment must use the same framing
+func WithFraming(framing codec.Framing, maxPacketSize int) Option {
+	return func(opt *cluster.Options) {
+		opt.Framing = framing
+		opt.MaxPacketSize = maxPacketSize
+	}
+}
+
+// WithPacketCodec frames the client packets with the custom codec instead of the built-in
+// codec, e.g: to serve the legacy clients speaking a non-standard framing. The custom
+// codecs should pass the conformance tests of package codec/codectest
+func WithPacketCodec(c pcodec.PacketCodec) Option {
+	return func(opt *cluster.Options) {
+		opt.PacketCodec = c
+	}
+}
+
+// WithAuthenticator authenticates the clients with the handshake data before creating
+// the sessions, the uid returned by fn will be bound to the session, and the clients
+// failed to authenticate are rejected before reaching any route. The authentication
+// is canceled after timeout if positive
+func WithAuthenticator(fn cluster.Authenticator, timeout time.Duration) Option {
+	return func(opt *cluster.Options) {
+		opt.Authenticator = fn
+		opt.AuthTimeout = timeout
+	}
+}
+
+// WithLocalizer localizes the messages generated by server, e.g: the kick reasons and the
+// error responses, into the locale declared by the client in sys.locale of the handshake
+func WithLocalizer(fn cluster.Localizer) Option {
+	return func(opt *cluster.Options) {
+		opt.Localizer = fn
+	}
+}
+
+// WithShardKey routes the messages to the remote members by the shard key extracted by
+// fn, e.g: the room or match id in the payload, so all messages of a shard land on the
+// same member of the service. The members are selected by consistent hashing, adding or
+// removing a member only moves the shards of that member. The messages with an empty
+// key are routed by the session as usual
+func WithShardKey(fn cluster.ShardKeyFunc) Option {
+	return func(opt *cluster.Options) {
+		opt.ShardKey = fn
+	}
+}
+
+// WithMemberLabels advertises the labels in the member info of current node, e.g: the
+// region or zone, which are available to the balancer of the other members
+func WithMemberLabels(labels map[string]string) Option {
+	return func(opt *cluster.Options) {
+		opt.MemberLabels = labels
+	}
+}
+
+// WithBalancer sets the balancer which selects the member of a service for the session
+// which has not bound to one, e.g: the member in the region of the player by the member
+// labels. The selected member is bound to the session
+func WithBalancer(balancer cluster.Balancer) Option {
+	return func(opt *cluster.Options) {
+		opt.Balancer = balancer
+	}
+}
+
+// WithShardVirtualNodes sets the virtual nodes per member of the consistent hash ring
+// used by the shard key routing, more virtual nodes spread the keys more evenly at
+// the cost of memory and slower membership changes, 160 by default
+func WithShardVirtualNodes(n int) Option {
+	return func(opt *cluster.Options) {
+		opt.ShardVirtualNodes = n
+	}
+}
+
+// WithStrictHandlerRegistration fails the startup if a component method taking a session
+// argument has an unsupported handler signature, e.g: the payload is passed by value,
+// instead of logging and skipping it
+func WithStrictHandlerRegistration() Option {
+	return func(opt *cluster.Options) {
+		opt.StrictHandlerRegistration = true
+	}
+}
+
+// WithRegistrationMode sets how the startup handles the components failed to register,
+// cluster.RegistrationLenient skips them and serves with the rest, the failures are logged
+func WithRegistrationMode(mode cluster.RegistrationMode) Option {
+	return func(opt *cluster.Options) {
+		opt.RegistrationMode = mode
+	}
+}
+
+// WithSingleSessionPerUID limits an uid to one active connection in the cluster, the
+// older session of an uid is kicked with the reason (e.g: "logged in elsewhere") when
+// a new session binds the uid, the reason is sent to client in the kick packet
+func WithSingleSessionPerUID(reason string) Option {
+	return func(opt *cluster.Options) {
+		opt.SingleSessionPerUID = true
+		opt.KickReason = reason
+	}
+}
+
+// WithRawMode skips the handshake and heartbeat for the simple clients (e.g: IoT devices),
+// which send data packets right after connecting. Dead connections cannot be detected by
+// heartbeat timeout in raw mode, enable TCP keep-alive with WithTCPKeepAlive instead
+func WithRawMode() Option {
+	return func(opt *cluster.Options) {
+		opt.RawMode = true
+	}
+}
+
+// WithPayloadTransform sets the transforms of the payloads between server and clients,
+// e.g: encryption or obfuscation. The outbound transform is applied after serialization
+// and compression, and the inbound transform is applied before decompression and
+// deserialization, the session will be closed if the inbound transform fails
+func WithPayloadTransform(outbound func([]byte) []byte, inbound func([]byte) ([]byte, error)) Option {
+	return func(opt *cluster.Options) {
+		opt.OutboundTransform = outbound
+		opt.InboundTransform = inbound
+	}
+}
+
+// WithRouteACL restricts the route to the sessions satisfying the allow predicate, e.g:
+// the sessions whose role attribute is set to admin after authentication. The route can
+// be a full route or a service wildcard like "Admin.*", a request rejected by the
+// predicate receives an error response with code 403
+func WithRouteACL(route string, allow func(*session.Session) bool) Option {
+	return func(opt *cluster.Options) {
+		if opt.RouteACL == nil {
+			opt.RouteACL = map[string]func(*session.Session) bool{}
+		}
+		opt.RouteACL[route] = allow
+	}
+}
+
+// WithIdempotentRoutes marks the routes as idempotent, which are full routes or service
+// wildcards like "Room.*". A message of an idempotent route is retried on another member
+// of the service if the selected member became unavailable, the requests of the other
+// routes receive an error response with code 503 instead
+func WithIdempotentRoutes(routes ...string) Option {
+	return func(opt *cluster.Options) {
+		if opt.IdempotentRoutes == nil {
+			opt.IdempotentRoutes = map[string]bool{}
+		}
+		for _, route := range routes {
+			opt.IdempotentRoutes[route] = true
+		}
+	}
+}
+
+// WithAdmin starts an admin server on the addr which serves the management operations
+// of current node (members, kick, drain, reload), every request must carry the token
+// in the Authorization header as a bearer token
+func WithAdmin(addr, token string) Option {
+	return func(opt *cluster.Options) {
+		opt.AdminAddr = addr
+		opt.AdminToken = token
+	}
+}
+
+// WithAdminReload sets the function which will be called by the reload operation of
+// the admin server, e.g: reload the configurations of application
+func WithAdminReload(fn func() error) Option {
+	return func(opt *cluster.Options) {
+		opt.AdminReload = fn
+	}
+}
+
+// WithRPCClientCache bounds the cached connections to other members, the least recently
+// used targets except the master are closed if the number of targets exceeds maxTargets,
+// and the targets which have not been used for idleTimeout are closed, zero disables
+// each limit. The evicted connections are closed after the calls in flight finished
+func WithRPCClientCache(maxTargets int, idleTimeout time.Duration) Option {
+	return func(opt *cluster.Options) {
+		opt.RPCClientMaxTargets = maxTargets
+		opt.RPCClientIdleTimeout = idleTimeout
+	}
+}
+
+// WithRPCDialBackoff sets the jittered exponential backoff of re-dialing a member which
+// failed to dial, the delay starts from base and is doubled for each consecutive failure
+// up to max, which keeps a degraded cluster from a dial storm. The dial attempts and
+// successes are counted by the metrics nano_rpc_dial_total and nano_rpc_dial_success_total
+func WithRPCDialBackoff(base, max time.Duration) Option {
+	return func(opt *cluster.Options) {
+		opt.RPCDialBackoffBase = base
+		opt.RPCDialBackoffMax = max
+	}
+}
+
+// WithGRPCDialer sets the dialer of the connections to the other members, e.g: dials
+// through an Envoy sidecar, a SOCKS proxy or a bufconn listener of tests
+func WithGRPCDialer(dialer func(ctx context.Context, addr string) (net.Conn, error)) Option {
+	return func(opt *cluster.Options) {
+		opt.GRPCDialer = dialer
+	}
+}
+
+// WithMaxGoroutines refuses the new client connections while the goroutines of the process
+// exceed limit, the most common goroutine stacks are logged once the limit hit
+func WithMaxGoroutines(limit int) Option {
+	return func(opt *cluster.Options) {
+		opt.MaxGoroutines = limit
+	}
+}
+
+// WithDispatcherStallThreshold logs the route and the stack of the handler which blocks
+// the shared dispatcher longer than threshold
+func WithDispatcherStallThreshold(threshold time.Duration) Option {
+	return func(opt *cluster.Options) {
+		opt.DispatcherStallThreshold = threshold
+	}
+}
+
+// WithMaxInflightPerMember caps the forwarded messages in flight to each member to limit,
+// the forwards beyond the cap wait in a queue of the member bounded by queue, and the
+// ones overflowing the queue fail fast, so a slow member sheds the load
+func WithMaxInflightPerMember(limit, queue int) Option {
+	return func(opt *cluster.Options) {
+		opt.MaxInflightPerMember = limit
+		opt.InflightQueuePerMember = queue
+	}
+}
+
+// WithHotRestart lets nano.Upgrade hand the listeners to a new process for a zero downtime
+// binary upgrade, the old process shuts down once its sessions closed or drainTimeout
+// elapsed, zero waits forever
+func WithHotRestart(drainTimeout time.Duration) Option {
+	return func(opt *cluster.Options) {
+		opt.EnableHotRestart = true
+		opt.HotRestartDrainTimeout = drainTimeout
+	}
+}
+
+// WithCircuitBreaker guards the forwards to each member by a circuit breaker, which trips
+// open once the failed forwards reached the rate of at least minRequests forwards in the
+// window, and probes the member after openTimeout. The zero values use the defaults
+func WithCircuitBreaker(failureRate float64, minRequests int, window, openTimeout time.Duration) Option {
+	return func(opt *cluster.Options) {
+		opt.BreakerFailureRate = failureRate
+		opt.BreakerMinRequests = minRequests
+		opt.BreakerWindow = window
+		opt.BreakerOpenTimeout = openTimeout
+	}
+}
+
+// WithRPCResolver sets the scheme of the gRPC resolver which resolves the addresses of
+// the other members, e.g: "dns" for the dual-stack members advertising a hostname
+func WithRPCResolver(scheme string) Option {
+	return func(opt *cluster.Options) {
+		opt.RPCResolver = scheme
+	}
+}
+
+// WithMemberStateChange sets the function which will be called when the state of the
+// connection to a member changed, e.g: from Ready to TransientFailure. The readiness of
+// members is reported by the metric nano_member_ready as well
+func WithMemberStateChange(fn func(addr string, from, to connectivity.State)) Option {
+	return func(opt *cluster.Options) {
+		opt.OnMemberStateChange = fn
+	}
+}
+
+// WithShutdownTimeout sets the grace window of shutdown, the components implementing
+// component.ContextShutdowner receive it as the deadline of the shutdown context
+func WithShutdownTimeout(d time.Duration) Option {
+	return func(opt *cluster.Options) {
+		opt.ShutdownTimeout = d
+	}
+}
+
+// WithGateway makes current node a gateway which holds the client sessions and forwards
+// the messages to the backend members, it may register no components, and is never
+// routed to by the other members as it advertises no services
+func WithGateway() Option {
+	return func(opt *cluster.Options) {
+		opt.IsGateway = true
+	}
+}
+
+// WithObserver makes current node a read-only observer member, which registers with the
+// master but is excluded from the request routing, fn receives the copies of the notify
+// and broadcast traffic teed by the gates, e.g: feed an analytics or anti-cheat pipeline
+func WithObserver(fn func(*cluster.Observation)) Option {
+	return func(opt *cluster.Options) {
+		opt.Observe = fn
+	}
+}
+
+// WithTeeRoutes sets the notify and broadcast routes whose copies are sent to the observer
+// members, a route can be a full route or a service wildcard like "Chat.*". The copies
+// are sent asynchronously and best-effort, which never affects the normal processing
+func WithTeeRoutes(routes ...string) Option {
+	return func(opt *cluster.Options) {
+		opt.TeeRoutes = append(opt.TeeRoutes, routes...)
+	}
+}
+
+// WithSyncMembersInterval sets the interval that a member reconciles its member list
+// and remote services against the master, which makes the routing table eventually
+// consistent even if a member notification was lost
+func WithSyncMembersInterval(d time.Duration) Option {
+	return func(opt *cluster.Options) {
+		opt.SyncMembersInterval = d
+	}
+}
+
+// WithNewMemberDebounce sets the window in which the master coalesces the registered
+// members and announces them to the existing members in a single batch, which avoids
+// the announcement storm when a lot of members start up at the same time
+func WithNewMemberDebounce(d time.Duration) Option {
+	return func(opt *cluster.Options) {
+		opt.NewMemberDebounce = d
+	}
+}
+
+// WithRegistrySnapshot makes the master snapshot the registered members to the file at
+// path every interval, zero means the default (10s). A restarted master reloads the
+// members from the snapshot and verifies them, so it starts with the last-known members
+// instead of waiting for them to register again, which suits the small deployments
+// without an external store
+func WithRegistrySnapshot(path string, interval time.Duration) Option {
+	return func(opt *cluster.Options) {
+		opt.RegistrySnapshotPath = path
+		opt.RegistrySnapshotInterval = interval
+	}
+}
+
+// WithCodecErrorHook sets the function called with the phase (cluster.CodecPhasePacket,
+// etc.) if a packet, message or payload of a client connection failed to decode or
+// encode, the connection is closed once limit decode failures occurred, zero means the
+// default (3). A corrupt packet always closes the connection as the framing is lost
+func WithCodecErrorHook(fn func(s *session.Session, err error, phase string), limit int) Option {
+	return func(opt *cluster.Options) {
+		opt.OnCodecError = fn
+		opt.CodecErrorLimit = limit
+	}
+}
+
+// WithReliableWindow caps the reliable pushes of a session which are not acknowledged
+// by client, session.PushReliable returns an error if exceeded
+func WithReliableWindow(n int) Option {
+	return func(opt *cluster.Options) {
+		opt.ReliableWindow = n
+	}
+}
+
+// WithMinProtocolVersion rejects the clients whose wire protocol version declared by
+// sys.protocol in the handshake is below the version, the clients declaring no version
+// are regarded as cluster.ProtocolLegacy. The rejected clients receive a handshake
+// response with code 501 and the supported versions
+func WithMinProtocolVersion(version int) Option {
+	return func(opt *cluster.Options) {
+		opt.MinProtocolVersion = version
+	}
+}
+
+// WithRequiredServices delays serving the clients until all services are provided by
+// current node or the discovered members, which avoids the failed requests of a gate
+// started before the backend members. It waits for the timeout at most and then serves
+// the clients anyway, zero timeout waits forever
+func WithRequiredServices(timeout time.Duration, services ...string) Option {
+	return func(opt *cluster.Options) {
+		opt.RequiredServices = services
+		opt.ReadinessTimeout = timeout
+	}
+}
+
+// WithMemberAddrResolver sets the function which derives the address registered to the
+// cluster, e.g: from an environment variable or a metadata service, the service address
+// is still used to listen on. It is useful in containerized environments where the
+// node binds to 0.0.0.0 but the other members must dial the pod IP
+func WithMemberAddrResolver(fn func() (string, error)) Option {
+	return func(opt *cluster.Options) {
+		opt.ResolveMemberAddr = fn
+	}
+}
+
 // WithGrpcOptions sets the grpc dial options
 func WithGrpcOptions(opts ...grpc.DialOption) Option {
 	return func(_ *cluster.Options) {
@@ -70,6 +620,80 @@ func WithHeartbeatInterval(d time.Duration) Option {
 	}
 }
 
+// WithRequestTimeout sets the duration that a server initiated request (session.Request)
+// waits for the client response
+func WithRequestTimeout(d time.Duration) Option {
+	return func(_ *cluster.Options) {
+		env.RequestTimeout = d
+	}
+}
+
+// WithDispatcherQueueSize sets the capacity of the task queue of the dispatcher, which
+// must be set before the dispatcher starts. Pushing a message to the full queue blocks
+// the network goroutine until the dispatcher catches up instead of dropping it, which
+// is counted by the metric nano_dispatcher_queue_blocked_total, and the queue depth is
+// reported by the metric nano_dispatcher_queue_depth
+func WithDispatcherQueueSize(size int) Option {
+	return func(_ *cluster.Options) {
+		env.DispatcherQueueSize = size
+	}
+}
+
+// WithMaxGroupsPerSession limits the number of groups which a session can join, Group.Add
+// returns ErrTooManyGroups if the session has joined n groups, zero means unlimited
+func WithMaxGroupsPerSession(n int) Option {
+	return func(_ *cluster.Options) {
+		env.MaxGroupsPerSession = n
+	}
+}
+
+// WithDedupWindow enables the request deduplication, the client may resend a request
+// with the same message id(sequence number) after a retry, the response of the first
+// request will be replied within the window instead of processing it again, which
+// makes the critical operations idempotent over unreliable connections. The requests
+// are deduplicated per session, and the responses are retained in memory for the window
+func WithDedupWindow(d time.Duration) Option {
+	return func(_ *cluster.Options) {
+		env.DedupWindow = d
+	}
+}
+
+// WithCompression enables the payload compression for the clients which offer deflate
+// in the handshake, the payloads smaller than threshold are sent without compression
+func WithCompression(threshold int) Option {
+	return func(_ *cluster.Options) {
+		env.CompressThreshold = threshold
+	}
+}
+
+// WithCompressionFilter sets the function to decide whether to accept the compression
+// offered by client with the handshake data, e.g: decline the compression for the
+// clients whose payloads are already compressed
+func WithCompressionFilter(fn func([]byte) bool) Option {
+	return func(_ *cluster.Options) {
+		env.CompressionFilter = fn
+	}
+}
+
+// WithValidator sets the function which validates the decoded messages before they are
+// dispatched to the handlers, e.g: checks the required fields and the ranges. The invalid
+// requests are responded with an error message whose code is 400
+func WithValidator(fn func(route string, msg interface{}) error) Option {
+	return func(opt *cluster.Options) {
+		opt.Validate = fn
+	}
+}
+
+// WithCompressionDictionary sets the preset dictionary of the payload compression, which
+// improves the ratio of the small and repetitive payloads, e.g: the state updates of the
+// real-time games. The dictionary is used by the connections whose client declares the
+// same one in handshake (sys.compressDict), and requires WithCompression
+func WithCompressionDictionary(dict []byte) Option {
+	return func(opt *cluster.Options) {
+		opt.CompressionDictionary = dict
+	}
+}
+
 // WithCheckOriginFunc sets the function that check `Origin` in http headers
 func WithCheckOriginFunc(fn func(*http.Request) bool) Option {
 	return func(opt *cluster.Options) {
@@ -117,6 +741,19 @@ func WithSerializer(serializer serialize.Serializer) Option {
 	}
 }
 
+// WithNegotiableSerializer registers a serializer which can be negotiated by clients in
+// the handshake with the name, e.g: the web clients offer "json" while the mobile clients
+// use the application serializer. The argument types of all handlers must be compatible
+// with every negotiable serializer, which is checked at startup
+func WithNegotiableSerializer(name string, serializer serialize.Serializer) Option {
+	return func(opt *cluster.Options) {
+		if opt.Serializers == nil {
+			opt.Serializers = map[string]serialize.Serializer{}
+		}
+		opt.Serializers[name] = serializer
+	}
+}
+
 // WithLabel sets the current node label in cluster
 func WithLabel(label string) Option {
 	return func(opt *cluster.Options) {
@@ -131,6 +768,24 @@ func WithIsWebsocket(enableWs bool) Option {
 	}
 }
 
+// WithWSResponseHeader sets the function to customize the response headers of the
+// websocket upgrade, e.g: echo the Sec-WebSocket-Protocol for subprotocol negotiation
+func WithWSResponseHeader(fn func(*http.Request) http.Header) Option {
+	return func(opt *cluster.Options) {
+		opt.WSResponseHeader = fn
+	}
+}
+
+// WithWSAuthenticate sets the function to authenticate the websocket upgrade request,
+// e.g: by the token in the query parameters, which lets the browsers authenticate at
+// connect time. The upgrade is refused with 401 if it returns an error, otherwise the
+// returned uid is bound to the session
+func WithWSAuthenticate(fn func(*http.Request) (int64, error)) Option {
+	return func(opt *cluster.Options) {
+		opt.WSAuthenticate = fn
+	}
+}
+
 // WithTSLConfig sets the `key` and `certificate` of TSL
 func WithTSLConfig(certificate, key string) Option {
 	return func(opt *cluster.Options) {
@@ -139,6 +794,17 @@ func WithTSLConfig(certificate, key string) Option {
 	}
 }
 
+// WithClientCertAuth verifies the client certificates on the websocket TLS connections
+// with the certificate authorities in the PEM file caFile, the clients without a verified
+// certificate are rejected if require is true. The verified certificate is available by
+// session.ClientCertificate
+func WithClientCertAuth(caFile string, require bool) Option {
+	return func(opt *cluster.Options) {
+		opt.ClientCAFile = caFile
+		opt.RequireClientCert = require
+	}
+}
+
 // WithLogger overrides the default logger
 func WithLogger(l log.Logger) Option {
 	return func(opt *cluster.Options) {
@@ -152,3 +818,16 @@ func WithHandshakeValidator(fn func([]byte) error) Option {
 		env.HandshakeValidator = fn
 	}
 }
+
+// WithSessionLinger sets the duration that the state of a closed session which has
+// bound an uid will be retained, a new session binding the same uid can take it back
+// by session.Reattach before it is released, the groups joined by the closed session
+// are rejoined by the new session as well. All retained state is kept in memory,
+// so a long linger window with a large number of reconnecting players will hold a
+// significant amount of memory. The state lingers in the store of the node, so the
+// nodes started in one process have their own windows.
+func WithSessionLinger(d time.Duration) Option {
+	return func(opt *cluster.Options) {
+		opt.SessionLinger = d
+	}
+}
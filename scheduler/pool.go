@@ -0,0 +1,40 @@
+package scheduler
+
+import "sync"
+
+// WorkerPool schedules tasks to a bounded group of goroutines, the tasks run
+// concurrently and may complete in any order
+type WorkerPool struct {
+	tasks chan Task
+	wg    sync.WaitGroup
+	once  sync.Once
+}
+
+// NewWorkerPool starts a pool of size goroutines
+func NewWorkerPool(size int) *WorkerPool {
+	p := &WorkerPool{tasks: make(chan Task, size*messageQueueBacklog)}
+	p.wg.Add(size)
+	for i := 0; i < size; i++ {
+		go func() {
+			defer p.wg.Done()
+			for task := range p.tasks {
+				try(task)
+			}
+		}()
+	}
+	return p
+}
+
+// Schedule implements the LocalScheduler interface, it blocks if the queue of
+// the pool is full
+func (p *WorkerPool) Schedule(task Task) {
+	p.tasks <- task
+}
+
+// Close stops the pool after the queued tasks completed
+func (p *WorkerPool) Close() {
+	p.once.Do(func() {
+		close(p.tasks)
+	})
+	p.wg.Wait()
+}
@@ -0,0 +1,41 @@
+package scheduler
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWorkerPool(t *testing.T) {
+	const size = 4
+	pool := NewWorkerPool(size)
+
+	var running, done int32
+	release := make(chan struct{})
+	for i := 0; i < size; i++ {
+		pool.Schedule(func() {
+			atomic.AddInt32(&running, 1)
+			<-release
+			atomic.AddInt32(&done, 1)
+		})
+	}
+
+	// the blocking tasks run concurrently
+	deadline := time.Now().Add(time.Second)
+	for atomic.LoadInt32(&running) != size {
+		if time.Now().After(deadline) {
+			t.Fatalf("expect %d running tasks, got %d", size, atomic.LoadInt32(&running))
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// a panic task does not stop the worker
+	pool.Schedule(func() { panic("task panic") })
+	pool.Schedule(func() { atomic.AddInt32(&done, 1) })
+
+	close(release)
+	pool.Close()
+	if n := atomic.LoadInt32(&done); n != size+1 {
+		t.Fatalf("expect %d done tasks after close, got %d", size+1, n)
+	}
+}
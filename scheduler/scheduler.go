@@ -23,11 +23,12 @@ package scheduler
 import (
 	"fmt"
 	"runtime/debug"
+	"sync"
 	"sync/atomic"
-	"time"
 
 	"github.com/lonng/nano/internal/env"
 	"github.com/lonng/nano/internal/log"
+	"github.com/lonng/nano/metrics"
 )
 
 const (
@@ -44,14 +45,30 @@ type Task func()
 
 type Hook func()
 
+// Metrics of the dispatcher queue
+const (
+	metricQueueDepth   = "nano_dispatcher_queue_depth"
+	metricQueueBlocked = "nano_dispatcher_queue_blocked_total"
+)
+
 var (
-	chDie   = make(chan struct{})
-	chExit  = make(chan struct{})
-	chTasks = make(chan Task, 1<<8)
-	started int32
-	closed  int32
+	chDie    = make(chan struct{})
+	chExit   = make(chan struct{})
+	chTasks  chan Task
+	initOnce sync.Once
+	started  int32
+	closed   int32
 )
 
+// tasks returns the task queue of the dispatcher, which is created on first use
+// with the configured size
+func tasks() chan Task {
+	initOnce.Do(func() {
+		chTasks = make(chan Task, env.DispatcherQueueSize)
+	})
+	return chTasks
+}
+
 func try(f func()) {
 	defer func() {
 		if err := recover(); err != nil {
@@ -66,7 +83,9 @@ func Sched() {
 		return
 	}
 
-	ticker := time.NewTicker(env.TimerPrecision)
+	queue := tasks()
+	depth := metrics.Default.Gauge(metricQueueDepth)
+	ticker := env.Clock.NewTicker(env.TimerPrecision)
 	defer func() {
 		ticker.Stop()
 		close(chExit)
@@ -74,10 +93,11 @@ func Sched() {
 
 	for {
 		select {
-		case <-ticker.C:
+		case <-ticker.C():
 			cron()
+			depth.Set(int64(len(queue)))
 
-		case f := <-chTasks:
+		case f := <-queue:
 			try(f)
 
 		case <-chDie:
@@ -95,6 +115,15 @@ func Close() {
 	log.Println("Scheduler stopped")
 }
 
+// PushTask pushes the task to the dispatcher, it blocks if the queue is full rather
+// than dropping the task, and the blocked pushes are counted by the metric
+// nano_dispatcher_queue_blocked_total
 func PushTask(task Task) {
-	chTasks <- task
+	queue := tasks()
+	select {
+	case queue <- task:
+	default:
+		metrics.Default.Counter(metricQueueBlocked).Inc()
+		queue <- task
+	}
 }
@@ -0,0 +1,43 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lonng/nano/internal/env"
+	"github.com/lonng/nano/metrics"
+)
+
+func TestPushTaskBlocked(t *testing.T) {
+	size := env.DispatcherQueueSize
+	env.DispatcherQueueSize = 2
+	defer func() { env.DispatcherQueueSize = size }()
+
+	done := make(chan int, 3)
+	for i := 0; i < 2; i++ {
+		i := i
+		PushTask(func() { done <- i })
+	}
+	if cap(tasks()) != 2 {
+		t.Fatalf("expect queue size 2, got %d", cap(tasks()))
+	}
+
+	// the queue is full before the dispatcher starts
+	blocked := metrics.Default.Counter(metricQueueBlocked)
+	go PushTask(func() { done <- 2 })
+	deadline := time.Now().Add(time.Second)
+	for blocked.Value() != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("expect the push blocked")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	go Sched()
+	defer Close()
+	for i := 0; i < 3; i++ {
+		if n := <-done; n != i {
+			t.Fatalf("expect task %d, got %d", i, n)
+		}
+	}
+}
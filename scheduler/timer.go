@@ -28,6 +28,8 @@ import (
 	"sync"
 	"sync/atomic"
 	"time"
+
+	"github.com/lonng/nano/internal/env"
 )
 
 const (
@@ -114,7 +116,7 @@ func cron() {
 		return
 	}
 
-	now := time.Now()
+	now := env.Clock.Now()
 	unn := now.UnixNano()
 	for id, t := range timerManager.timers {
 		if t.counter == infinite || t.counter > 0 {
@@ -181,7 +183,7 @@ func NewCountTimer(interval time.Duration, count int, fn TimerFunc) *Timer {
 	t := &Timer{
 		id:       atomic.AddInt64(&timerManager.incrementID, 1),
 		fn:       fn,
-		createAt: time.Now().UnixNano(),
+		createAt: env.Clock.Now().UnixNano(),
 		interval: interval,
 		elapse:   int64(interval), // first execution will be after interval
 		counter:  count,
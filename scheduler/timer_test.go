@@ -24,6 +24,9 @@ import (
 	"sync/atomic"
 	"testing"
 	"time"
+
+	"github.com/lonng/nano/internal/clock"
+	"github.com/lonng/nano/internal/env"
 )
 
 func TestNewTimer(t *testing.T) {
@@ -102,3 +105,34 @@ func TestNewAfterTimer(t *testing.T) {
 		t.Fatalf("closingTimer: %d", len(timerManager.closingTimer))
 	}
 }
+
+func TestTimerManualClock(t *testing.T) {
+	defer func(c clock.Clock) { env.Clock = c }(env.Clock)
+	manual := clock.NewManual(time.Unix(1000, 0))
+	env.Clock = manual
+
+	var counter int64
+	timer := NewCountTimer(time.Minute, 2, func() {
+		atomic.AddInt64(&counter, 1)
+	})
+	defer timer.Stop()
+
+	// the timer fires by the clock only, no matter how long the test runs
+	cron()
+	manual.Advance(59 * time.Second)
+	cron()
+	if v := atomic.LoadInt64(&counter); v != 0 {
+		t.Fatalf("expect not fired before the interval, got %d", v)
+	}
+	manual.Advance(time.Second)
+	cron()
+	if v := atomic.LoadInt64(&counter); v != 1 {
+		t.Fatalf("expect 1, got %d", v)
+	}
+	manual.Advance(time.Hour)
+	cron()
+	cron()
+	if v := atomic.LoadInt64(&counter); v != 2 {
+		t.Fatalf("expect the count timer stopped after 2, got %d", v)
+	}
+}
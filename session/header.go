@@ -0,0 +1,18 @@
+package session
+
+import "context"
+
+type headerKey struct{}
+
+// WithHeader returns a copy of ctx carrying the header of the message being handled
+func WithHeader(ctx context.Context, header map[string]string) context.Context {
+	return context.WithValue(ctx, headerKey{}, header)
+}
+
+// HeaderFrom returns the header of the message carried by ctx, e.g: the locale or the
+// version of client, which is available to the handlers declaring a context argument.
+// nil will be returned if the message has no header
+func HeaderFrom(ctx context.Context) map[string]string {
+	header, _ := ctx.Value(headerKey{}).(map[string]string)
+	return header
+}
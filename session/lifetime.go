@@ -6,10 +6,29 @@ type (
 	// session low-level connection broken.
 	LifetimeHandler func(*Session)
 
+	// RebindHandler represents a callback that will be called when a session
+	// rebound from the old uid to the current one
+	RebindHandler func(s *Session, oldUID int64)
+
 	lifetime struct {
 		// callbacks that emitted on session closed
 		onClosed []LifetimeHandler
+		// callbacks that emitted on session rebound
+		onRebind []RebindHandler
 	}
+
+	// CloseReason describes why the session was closed, which can be retrieved by
+	// Session.CloseReason in the LifetimeHandler
+	CloseReason string
+)
+
+// Reasons of the session close
+const (
+	ClientClosed   CloseReason = "client_closed"   // client closed the connection
+	ReadError      CloseReason = "read_error"      // the connection failed or sent the invalid data
+	Timeout        CloseReason = "timeout"         // client has not sent a heartbeat in time
+	Kicked         CloseReason = "kicked"          // kicked or closed by server
+	ServerShutdown CloseReason = "server_shutdown" // the node is shutting down
 )
 
 var Lifetime = &lifetime{}
@@ -20,7 +39,32 @@ func (lt *lifetime) OnClosed(h LifetimeHandler) {
 	lt.onClosed = append(lt.onClosed, h)
 }
 
+// OnRebind set the Callback which will be called when session rebound an uid, e.g:
+// moves the state of the old uid to the new one
+func (lt *lifetime) OnRebind(h RebindHandler) {
+	lt.onRebind = append(lt.onRebind, h)
+}
+
+func (lt *lifetime) rebind(s *Session, oldUID int64) {
+	for _, h := range lt.onRebind {
+		h(s, oldUID)
+	}
+}
+
+// Close closes the session for an unknown reason and calls the callbacks
 func (lt *lifetime) Close(s *Session) {
+	lt.CloseWithReason(s, "")
+}
+
+// CloseWithReason closes the session for the reason and calls the callbacks, the reason
+// of the first close is retained
+func (lt *lifetime) CloseWithReason(s *Session, reason CloseReason) {
+	s.closeOnce.Do(func() {
+		s.closeReason = reason
+		close(s.done)
+	})
+	s.lingerStore().retain(s)
+
 	if len(lt.onClosed) < 1 {
 		return
 	}
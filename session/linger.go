@@ -0,0 +1,125 @@
+package session
+
+import (
+	"sync"
+	"time"
+
+	"github.com/lonng/nano/internal/clock"
+	"github.com/lonng/nano/internal/env"
+)
+
+type (
+	lingerEntry struct {
+		data        map[string]interface{}
+		memberships []Membership
+		timer       clock.Timer
+	}
+
+	// LingerStore retains the state of closed sessions which has bound an uid, the retained
+	// state can be reattached to a new session binding the same uid before the linger
+	// window elapsed. The store is disabled while the window is zero.
+	LingerStore struct {
+		mu      sync.Mutex
+		window  time.Duration
+		entries map[int64]*lingerEntry // uid map to retained state
+	}
+)
+
+// LingerProvider is an optional interface of NetworkEntity, the closed sessions of the
+// entity which implements it linger in the returned store instead of Linger, e.g. the
+// store of the node serving the entity. Linger is used if the returned store is nil
+type LingerProvider interface {
+	LingerStore() *LingerStore
+}
+
+// Linger is the default linger store of the sessions whose entity provides no store,
+// e.g. the sessions created without a node
+var Linger = NewLingerStore(0)
+
+// NewLingerStore returns a linger store which retains the state of a closed session
+// for the window
+func NewLingerStore(window time.Duration) *LingerStore {
+	return &LingerStore{window: window, entries: map[int64]*lingerEntry{}}
+}
+
+// SetWindow sets the duration that the state of a closed session will be retained
+func (ls *LingerStore) SetWindow(d time.Duration) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	ls.window = d
+}
+
+// Window returns the duration that the state of a closed session will be retained
+func (ls *LingerStore) Window() time.Duration {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	return ls.window
+}
+
+// Count returns the number of lingering session states
+func (ls *LingerStore) Count() int {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	return len(ls.entries)
+}
+
+func (ls *LingerStore) retain(s *Session) {
+	uid := s.UID()
+	if uid < 1 {
+		return
+	}
+
+	// copy the state, the close callbacks may clear the original one or leave groups
+	s.RLock()
+	data := make(map[string]interface{}, len(s.data))
+	for k, v := range s.data {
+		data[k] = v
+	}
+	s.RUnlock()
+	memberships := s.Memberships()
+
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	if ls.window <= 0 {
+		return
+	}
+	if e, found := ls.entries[uid]; found {
+		e.timer.Stop()
+	}
+	e := &lingerEntry{data: data, memberships: memberships}
+	e.timer = env.Clock.AfterFunc(ls.window, func() {
+		ls.mu.Lock()
+		if ls.entries[uid] == e {
+			delete(ls.entries, uid)
+		}
+		ls.mu.Unlock()
+	})
+	ls.entries[uid] = e
+}
+
+func (ls *LingerStore) take(uid int64) (map[string]interface{}, []Membership, bool) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	e, found := ls.entries[uid]
+	if !found {
+		return nil, nil, false
+	}
+	e.timer.Stop()
+	delete(ls.entries, uid)
+	return e.data, e.memberships, true
+}
+
+// lingerStore returns the store which the state of session lingers in
+func (s *Session) lingerStore() *LingerStore {
+	if p, ok := s.entity.(LingerProvider); ok {
+		if ls := p.LingerStore(); ls != nil {
+			return ls
+		}
+	}
+	return Linger
+}
@@ -21,6 +21,7 @@
 package session
 
 import (
+	"crypto/x509"
 	"errors"
 	"net"
 	"sync"
@@ -41,9 +42,62 @@ type NetworkEntity interface {
 	RemoteAddr() net.Addr
 }
 
+// Membership represents a group (e.g: room, party) which a session has joined, the
+// memberships of a closed session linger with its state and are rejoined by Reattach
+type Membership interface {
+	// Rejoin replaces the session of the same uid in the group with the new session
+	Rejoin(s *Session) error
+}
+
+// BindObserver is an optional interface of NetworkEntity, the entity which
+// implements it will be notified after the session bound an uid
+type BindObserver interface {
+	OnBind(uid int64)
+}
+
+// RebindObserver is an optional interface of NetworkEntity, the entity which implements
+// it will be notified after the session rebound from the previous uid, instead of
+// BindObserver
+type RebindObserver interface {
+	OnRebind(oldUID, newUID int64)
+}
+
+// Priority is the priority of an outbound message
+type Priority int
+
+// Priorities of the outbound messages
+const (
+	PriorityNormal Priority = iota // queued in order
+	PriorityHigh                   // sent ahead of the queued normal messages, e.g: kick or error
+)
+
+// CompressionStats represents the payload compression statistics of a connection,
+// only the payloads which were compressed are counted
+type CompressionStats struct {
+	RawBytes        int64 // bytes before compression
+	CompressedBytes int64 // bytes after compression
+}
+
+// Ratio returns the ratio of compressed bytes to raw bytes, zero if nothing compressed
+func (c CompressionStats) Ratio() float64 {
+	if c.RawBytes == 0 {
+		return 0
+	}
+	return float64(c.CompressedBytes) / float64(c.RawBytes)
+}
+
 var (
 	//ErrIllegalUID represents a invalid uid
 	ErrIllegalUID = errors.New("illegal uid")
+	// ErrKeyRotationUnsupported represents the network entity has no payload encryption
+	ErrKeyRotationUnsupported = errors.New("key rotation unsupported")
+	// ErrStreamUnsupported represents the network entity cannot stream responses
+	ErrStreamUnsupported = errors.New("stream response unsupported")
+	// ErrReliableUnsupported represents the network entity cannot push reliably, e.g:
+	// the client speaks a protocol version without acknowledgement
+	ErrReliableUnsupported = errors.New("reliable push unsupported")
+	// ErrRequestUnsupported represents the network entity cannot send requests to client
+	ErrRequestUnsupported = errors.New("server request unsupported")
 )
 
 // Session represents a client session which could storage temp data during low-level
@@ -58,6 +112,13 @@ type Session struct {
 	entity       NetworkEntity          // low-level network entity
 	data         map[string]interface{} // session data store
 	router       *Router
+	done         chan struct{} // closed when the session closed
+	closeOnce    sync.Once
+	closeReason  CloseReason          // set before done closed
+	transferID   uint64               // id of the last transfer
+	transfers    map[uint64]*Transfer // transfers in progress
+	memberships  map[Membership]struct{}
+	locale       string // locale of the client, e.g: "en-US"
 }
 
 // New returns a new session instance
@@ -69,6 +130,7 @@ func New(entity NetworkEntity) *Session {
 		data:     make(map[string]interface{}),
 		lastTime: time.Now().Unix(),
 		router:   newRouter(),
+		done:     make(chan struct{}),
 	}
 }
 
@@ -87,22 +149,87 @@ func (s *Session) RPC(route string, v interface{}) error {
 	return s.entity.RPC(route, v)
 }
 
-// Push message to client
+// Push message to client, it is safe to be called from any goroutine, e.g: a background
+// timer. The messages are queued by the send queue of the connection, and the messages
+// pushed by the same goroutine are delivered in order
 func (s *Session) Push(route string, v interface{}) error {
 	return s.entity.Push(route, v)
 }
 
-// Response message to client
+// PushPriority pushes message to client with the priority, the high priority messages
+// jump ahead of the routine messages queued for the client. The network entities which
+// do not support priority push the message in order
+func (s *Session) PushPriority(route string, v interface{}, prio Priority) error {
+	if p, ok := s.entity.(interface {
+		PushPriority(route string, v interface{}, prio Priority) error
+	}); ok {
+		return p.PushPriority(route, v, prio)
+	}
+	return s.Push(route, v)
+}
+
+// Request sends a request to client and blocks until the client responds or the
+// request timeout elapsed, the raw response data will be returned. It should not
+// be called in the handler goroutine which would be blocked during the request.
+func (s *Session) Request(route string, v interface{}) ([]byte, error) {
+	if r, ok := s.entity.(interface {
+		Request(route string, v interface{}) ([]byte, error)
+	}); ok {
+		return r.Request(route, v)
+	}
+	return nil, ErrRequestUnsupported
+}
+
+// Response message to client, it responds the request which the session is handling.
+// Like Push it is safe to be called from any goroutine, but the handling request may
+// have changed when a background goroutine responds, capture LastMid in the handler
+// and use ResponseMID instead
 func (s *Session) Response(v interface{}) error {
 	return s.entity.Response(v)
 }
 
 // ResponseMID responses message to client, mid is
-// request message ID
+// request message ID, it is safe to be called from any goroutine
 func (s *Session) ResponseMID(mid uint64, v interface{}) error {
 	return s.entity.ResponseMid(mid, v)
 }
 
+// StreamResponseMID sends a response of the request with more responses to follow, e.g:
+// the ticks of a subscribed price. The handler holds the message id by LastMid, sends
+// the updates by StreamResponseMID over time, and ends the stream by ResponseMID, the
+// client correlates all of them by the message id. It is safe to be called from any
+// goroutine
+func (s *Session) StreamResponseMID(mid uint64, v interface{}) error {
+	if r, ok := s.entity.(interface {
+		StreamResponseMid(mid uint64, v interface{}) error
+	}); ok {
+		return r.StreamResponseMid(mid, v)
+	}
+	return ErrStreamUnsupported
+}
+
+// PushReliable pushes the message with a sequence number, which is retransmitted until
+// client acknowledged it. The client acknowledges the pushes in batch by an ack packet
+// carrying the cumulative sequence number and ranges beyond it, and drops the duplicate
+// retransmissions by the sequence number. It is safe to be called from any goroutine
+func (s *Session) PushReliable(route string, v interface{}) error {
+	if r, ok := s.entity.(interface {
+		PushReliable(route string, v interface{}) error
+	}); ok {
+		return r.PushReliable(route, v)
+	}
+	return ErrReliableUnsupported
+}
+
+// Unacked returns the number of the reliable pushes which are not acknowledged by client,
+// zero will be returned if the network entity cannot push reliably
+func (s *Session) Unacked() int {
+	if r, ok := s.entity.(interface{ Unacked() int }); ok {
+		return r.Unacked()
+	}
+	return 0
+}
+
 // ID returns the session id
 func (s *Session) ID() int64 {
 	return s.id
@@ -125,6 +252,219 @@ func (s *Session) Bind(uid int64) error {
 	}
 
 	atomic.StoreInt64(&s.uid, uid)
+	if o, ok := s.entity.(BindObserver); ok {
+		o.OnBind(uid)
+	}
+	return nil
+}
+
+// Rebind binds another uid to the session which has bound an uid, e.g: the client
+// switched the account or the guest registered. The binding of the previous uid is
+// cleaned up by the network entity, and the new uid is bound by the single session
+// policy, e.g: the other sessions of the new uid are kicked if SingleSessionPerUID.
+// The rebind handlers of Lifetime are called after rebound. It is equivalent to Bind
+// if the session has not bound an uid
+func (s *Session) Rebind(uid int64) error {
+	if uid < 1 {
+		return ErrIllegalUID
+	}
+
+	old := atomic.SwapInt64(&s.uid, uid)
+	if old == uid {
+		return nil
+	}
+	if old == 0 {
+		if o, ok := s.entity.(BindObserver); ok {
+			o.OnBind(uid)
+		}
+		return nil
+	}
+	if o, ok := s.entity.(RebindObserver); ok {
+		o.OnRebind(old, uid)
+	} else if o, ok := s.entity.(BindObserver); ok {
+		o.OnBind(uid)
+	}
+	Lifetime.rebind(s, old)
+	return nil
+}
+
+// Reattach restores the state retained from a closed session which has bound the
+// same uid, it should be called after Bind and returns false if no state lingers
+// for the uid, see LingerStore.
+func (s *Session) Reattach() bool {
+	data, memberships, found := s.lingerStore().take(s.UID())
+	if !found {
+		return false
+	}
+	s.Restore(data)
+	for _, m := range memberships {
+		if err := m.Rejoin(s); err != nil {
+			continue
+		}
+		s.AddMembership(m)
+	}
+	return true
+}
+
+// AddMembership records the group which the session has joined, which is called by
+// the group implementations
+func (s *Session) AddMembership(m Membership) {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.memberships == nil {
+		s.memberships = map[Membership]struct{}{}
+	}
+	s.memberships[m] = struct{}{}
+}
+
+// TryAddMembership records the group which the session has joined unless the session
+// has joined max groups, zero max means unlimited. It returns false if the limit exceeded
+func (s *Session) TryAddMembership(m Membership, max int) bool {
+	s.Lock()
+	defer s.Unlock()
+
+	if _, found := s.memberships[m]; !found && max > 0 && len(s.memberships) >= max {
+		return false
+	}
+	if s.memberships == nil {
+		s.memberships = map[Membership]struct{}{}
+	}
+	s.memberships[m] = struct{}{}
+	return true
+}
+
+// RemoveMembership removes the group which the session has left
+func (s *Session) RemoveMembership(m Membership) {
+	s.Lock()
+	defer s.Unlock()
+
+	delete(s.memberships, m)
+}
+
+// Memberships returns the groups which the session has joined
+func (s *Session) Memberships() []Membership {
+	s.RLock()
+	defer s.RUnlock()
+
+	memberships := make([]Membership, 0, len(s.memberships))
+	for m := range s.memberships {
+		memberships = append(memberships, m)
+	}
+	return memberships
+}
+
+// CompressionStats returns the payload compression statistics of the connection,
+// zero value will be returned if the network entity does not compress payloads
+func (s *Session) CompressionStats() CompressionStats {
+	if c, ok := s.entity.(interface{ CompressionStats() CompressionStats }); ok {
+		return c.CompressionStats()
+	}
+	return CompressionStats{}
+}
+
+// Serializer returns the name of the serializer negotiated by the client connection in
+// the handshake, e.g: "json". Empty will be returned if the application serializer is
+// used, or the network entity does not negotiate, e.g: the session on a backend member
+func (s *Session) Serializer() string {
+	if n, ok := s.entity.(interface{ Serializer() string }); ok {
+		return n.Serializer()
+	}
+	return ""
+}
+
+// Compression returns the payload compression negotiated by the client connection in the
+// handshake, e.g: "deflate" to decide whether to pre-compress an asset. Empty will be
+// returned if the payloads are not compressed, or the network entity does not negotiate
+func (s *Session) Compression() string {
+	if n, ok := s.entity.(interface{ Compression() string }); ok {
+		return n.Compression()
+	}
+	return ""
+}
+
+// BytesIn returns the cumulative bytes received from the client connection, including
+// the framing of packets, e.g: enforce the bandwidth quota of players in a pipeline.
+// Zero will be returned if the network entity does not count the bytes
+func (s *Session) BytesIn() int64 {
+	if c, ok := s.entity.(interface{ BytesIn() int64 }); ok {
+		return c.BytesIn()
+	}
+	return 0
+}
+
+// BytesOut returns the cumulative bytes sent to the client connection, including the
+// framing of packets. Zero will be returned if the network entity does not count the bytes
+func (s *Session) BytesOut() int64 {
+	if c, ok := s.entity.(interface{ BytesOut() int64 }); ok {
+		return c.BytesOut()
+	}
+	return 0
+}
+
+// ClientCertificate returns the verified certificate of client if the client connects
+// with a certificate over TLS, e.g: bind the uid from the common name of the subject.
+// nil will be returned if the client has no verified certificate
+func (s *Session) ClientCertificate() *x509.Certificate {
+	if c, ok := s.entity.(interface{ ClientCertificate() *x509.Certificate }); ok {
+		return c.ClientCertificate()
+	}
+	return nil
+}
+
+// QueueDepth returns the messages of current session which are dispatched to the
+// handlers but not handled yet, zero if the network entity does not count them
+func (s *Session) QueueDepth() int {
+	if q, ok := s.entity.(interface{ QueueDepth() int }); ok {
+		return q.QueueDepth()
+	}
+	return 0
+}
+
+// Pause stops dispatching the messages of current session until Resume, e.g: while a
+// loading screen is shown or a multi-step transaction completes. The messages received
+// in the meantime are buffered up to a cap and dispatched in order after resumed, the
+// ones beyond the cap are dropped. Only the client connections of the gate can be
+// paused, it is a no-op for the other network entities
+func (s *Session) Pause() {
+	if p, ok := s.entity.(interface{ Pause() }); ok {
+		p.Pause()
+	}
+}
+
+// Resume dispatches the messages buffered while paused and the new ones again
+func (s *Session) Resume() {
+	if p, ok := s.entity.(interface{ Resume() }); ok {
+		p.Resume()
+	}
+}
+
+// Paused reports whether current session is paused
+func (s *Session) Paused() bool {
+	if p, ok := s.entity.(interface{ Paused() bool }); ok {
+		return p.Paused()
+	}
+	return false
+}
+
+// RotateKey rotates the key of the payload encryption of current session without
+// reconnecting, the messages sent after it are encrypted by the new key. An error
+// is returned if the network entity does not support the payload encryption
+func (s *Session) RotateKey() error {
+	if r, ok := s.entity.(interface{ RotateKey() error }); ok {
+		return r.RotateKey()
+	}
+	return ErrKeyRotationUnsupported
+}
+
+// Kick sends a kick packet with the reason to client ahead of the queued messages and
+// closes the session, e.g: "logged in elsewhere". The network entities which do not
+// support kick close the session directly
+func (s *Session) Kick(reason string) error {
+	if k, ok := s.entity.(interface{ Kick(reason string) error }); ok {
+		return k.Kick(reason)
+	}
+	s.Close()
 	return nil
 }
 
@@ -134,6 +474,24 @@ func (s *Session) Close() {
 	s.entity.Close()
 }
 
+// Done returns a channel that is closed when the session closed, e.g: the client
+// disconnected. The long-running tasks started by handlers can select on it to
+// abort the work for the gone client.
+func (s *Session) Done() <-chan struct{} {
+	return s.done
+}
+
+// CloseReason returns why the session was closed, e.g: ClientClosed for the client
+// closed the connection, empty if the session is not closed or the reason is unknown
+func (s *Session) CloseReason() CloseReason {
+	select {
+	case <-s.done:
+		return s.closeReason
+	default:
+		return ""
+	}
+}
+
 // RemoteAddr returns the remote network address.
 func (s *Session) RemoteAddr() net.Addr {
 	return s.entity.RemoteAddr()
@@ -147,6 +505,22 @@ func (s *Session) Remove(key string) {
 	delete(s.data, key)
 }
 
+// SetLocale sets the locale of the client, which localizes the messages generated by
+// server, e.g: the kick reasons and the error responses. It is set from the handshake
+// by default, and can be changed after the client authenticated
+func (s *Session) SetLocale(locale string) {
+	s.Lock()
+	s.locale = locale
+	s.Unlock()
+}
+
+// Locale returns the locale of the client, empty if unknown
+func (s *Session) Locale() string {
+	s.RLock()
+	defer s.RUnlock()
+	return s.locale
+}
+
 // Set associates value with the key in session storage
 func (s *Session) Set(key string, value interface{}) {
 	s.Lock()
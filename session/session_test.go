@@ -1,6 +1,9 @@
 package session
 
-import "testing"
+import (
+	"testing"
+	"time"
+)
 
 func TestNewSession(t *testing.T) {
 	s := New(nil)
@@ -20,6 +23,50 @@ func TestSession_Bind(t *testing.T) {
 	}
 }
 
+func TestSession_Rebind(t *testing.T) {
+	defer func(handlers []RebindHandler) { Lifetime.onRebind = handlers }(Lifetime.onRebind)
+	var rebound []int64
+	Lifetime.OnRebind(func(s *Session, oldUID int64) {
+		rebound = append(rebound, oldUID, s.UID())
+	})
+
+	s := New(nil)
+	if err := s.Rebind(0); err != ErrIllegalUID {
+		t.Fatalf("expect ErrIllegalUID, got %v", err)
+	}
+	// rebinding the unbound session or the same uid fires no rebind event
+	for _, uid := range []int64{100, 100, 200} {
+		if err := s.Rebind(uid); err != nil {
+			t.Fatal(err)
+		}
+		if s.UID() != uid {
+			t.Fatalf("expect uid %d, got %d", uid, s.UID())
+		}
+	}
+	if len(rebound) != 2 || rebound[0] != 100 || rebound[1] != 200 {
+		t.Fatalf("expect rebound from 100 to 200, got %v", rebound)
+	}
+}
+
+func TestSession_Locale(t *testing.T) {
+	s := New(nil)
+	if s.Locale() != "" {
+		t.Fatalf("expect empty locale, got %s", s.Locale())
+	}
+	s.SetLocale("zh-CN")
+	if s.Locale() != "zh-CN" {
+		t.Fatalf("expect zh-CN, got %s", s.Locale())
+	}
+}
+
+func TestSession_Negotiation(t *testing.T) {
+	// the network entity without negotiation
+	s := New(nil)
+	if s.Serializer() != "" || s.Compression() != "" {
+		t.Fatalf("expect no negotiation, got %q, %q", s.Serializer(), s.Compression())
+	}
+}
+
 func TestSession_HasKey(t *testing.T) {
 	s := New(nil)
 	key := "hello"
@@ -173,3 +220,147 @@ func TestSession_Restore(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func TestSession_Reattach(t *testing.T) {
+	Linger.SetWindow(time.Second)
+	defer Linger.SetWindow(0)
+
+	s := New(nil)
+	s.Bind(10)
+	s.Set("room", 1001)
+	Lifetime.Close(s)
+	s.Clear()
+
+	n := New(nil)
+	n.Bind(10)
+	if !n.Reattach() {
+		t.Fatal("expect lingering state")
+	}
+	if n.Int("room") != 1001 {
+		t.Fail()
+	}
+	if n.Reattach() {
+		t.Fatal("lingering state should be taken only once")
+	}
+}
+
+func TestSession_ReattachExpired(t *testing.T) {
+	Linger.SetWindow(10 * time.Millisecond)
+	defer Linger.SetWindow(0)
+
+	s := New(nil)
+	s.Bind(11)
+	s.Set("room", 1001)
+	Lifetime.Close(s)
+
+	time.Sleep(50 * time.Millisecond)
+	if Linger.Count() != 0 {
+		t.Fatal("lingering state should be released")
+	}
+	n := New(nil)
+	n.Bind(11)
+	if n.Reattach() {
+		t.Fail()
+	}
+}
+
+func TestSession_Done(t *testing.T) {
+	s := New(nil)
+	select {
+	case <-s.Done():
+		t.Fatal("session has not closed")
+	default:
+	}
+
+	Lifetime.Close(s)
+	select {
+	case <-s.Done():
+	case <-time.After(time.Second):
+		t.Fatal("done channel not closed")
+	}
+
+	// close twice should not panic
+	Lifetime.Close(s)
+}
+
+type rejoinRecorder struct {
+	rejoined []*Session
+}
+
+func (r *rejoinRecorder) Rejoin(s *Session) error {
+	r.rejoined = append(r.rejoined, s)
+	return nil
+}
+
+func TestSession_ReattachMemberships(t *testing.T) {
+	Linger.SetWindow(time.Second)
+	defer Linger.SetWindow(0)
+
+	room := &rejoinRecorder{}
+	s := New(nil)
+	s.Bind(12)
+	s.AddMembership(room)
+	Lifetime.Close(s)
+	s.RemoveMembership(room)
+
+	n := New(nil)
+	n.Bind(12)
+	if !n.Reattach() {
+		t.Fatal("expect lingering state")
+	}
+	if len(room.rejoined) != 1 || room.rejoined[0] != n {
+		t.Fatalf("rejoined %v", room.rejoined)
+	}
+	if ms := n.Memberships(); len(ms) != 1 || ms[0] != room {
+		t.Fatalf("memberships %v", ms)
+	}
+}
+
+func TestSession_CloseReason(t *testing.T) {
+	s := New(nil)
+	if s.CloseReason() != "" {
+		t.Fatal("session is not closed")
+	}
+	Lifetime.CloseWithReason(s, ClientClosed)
+	Lifetime.CloseWithReason(s, Kicked)
+	if s.CloseReason() != ClientClosed {
+		t.Fatalf("expect the reason of the first close, got %s", s.CloseReason())
+	}
+}
+
+type lingerEntity struct {
+	NetworkEntity
+	store *LingerStore
+}
+
+func (e *lingerEntity) LingerStore() *LingerStore {
+	return e.store
+}
+
+func TestSession_LingerProvider(t *testing.T) {
+	entity := &lingerEntity{store: NewLingerStore(time.Second)}
+	s := New(entity)
+	s.Bind(13)
+	s.Set("room", 1001)
+	Lifetime.Close(s)
+	if entity.store.Count() != 1 || Linger.Count() != 0 {
+		t.Fatal("state should linger in the store of entity")
+	}
+
+	// the default store is disabled, the state of entity is not visible to it
+	if n := New(nil); n.Bind(13) == nil && n.Reattach() {
+		t.Fatal("state should not be reattached from the default store")
+	}
+	n := New(entity)
+	n.Bind(13)
+	if !n.Reattach() || n.Int("room") != 1001 {
+		t.Fatal("expect lingering state in the store of entity")
+	}
+}
+
+func TestSession_RequestUnsupported(t *testing.T) {
+	s := New(&lingerEntity{})
+	if _, err := s.Request("test", nil); err != ErrRequestUnsupported {
+		t.Fatalf("expect request unsupported, got %v", err)
+	}
+}
@@ -0,0 +1,194 @@
+package session
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultTransferChunkSize is the size of transfer chunks if not specified
+const DefaultTransferChunkSize = 16 * 1024
+
+// TransferHeaderSize is the size of the header which precedes the data of every chunk,
+// the header is composed of the transfer id(8 bytes), the sequence number of the chunk
+// (4 bytes) and the flag(1 byte) in big endian
+const TransferHeaderSize = 13
+
+// Flags of the transfer chunks
+const (
+	TransferFlagLast     byte = 1 << iota // the last chunk of the transfer
+	TransferFlagCanceled                  // the transfer was canceled or failed, the chunk carries no data
+)
+
+// ErrTransferCanceled is returned by Transfer.Err if the transfer was canceled
+var ErrTransferCanceled = errors.New("transfer canceled")
+
+// TransferOptions contains the options of a transfer
+type TransferOptions struct {
+	ChunkSize int                     // data size of each chunk, DefaultTransferChunkSize if zero
+	Progress  func(sent, total int64) // called after each chunk has been queued
+}
+
+// Transfer represents a blob being streamed to the client as ordered chunks
+type Transfer struct {
+	id    uint64
+	total int64
+	sent  int64
+
+	cancel     chan struct{}
+	cancelOnce sync.Once
+	done       chan struct{}
+	err        error
+}
+
+// ID returns the session scoped id of the transfer
+func (t *Transfer) ID() uint64 {
+	return t.id
+}
+
+// Sent returns the data bytes which have been queued to the client
+func (t *Transfer) Sent() int64 {
+	return atomic.LoadInt64(&t.sent)
+}
+
+// Done returns a channel which is closed when the transfer finished or canceled
+func (t *Transfer) Done() <-chan struct{} {
+	return t.done
+}
+
+// Err returns the error of the transfer after Done is closed, nil if all chunks
+// have been queued to the client
+func (t *Transfer) Err() error {
+	<-t.done
+	return t.err
+}
+
+// Cancel stops the transfer, the client will receive a chunk with the canceled flag
+func (t *Transfer) Cancel() {
+	t.cancelOnce.Do(func() {
+		close(t.cancel)
+	})
+}
+
+// Transfer streams the blob read from r to the client as ordered chunks pushed to the
+// route, size is the total size reported to the progress callback. The chunks are sent
+// in a separate goroutine, which waits for the send queue of the connection instead of
+// failing when it is full, so a large blob never floods the connection. The client can
+// cancel the transfer by a message to a handler which calls CancelTransfer with the id.
+// The format of chunks is described in docs/communication_protocol.md
+func (s *Session) Transfer(route string, r io.Reader, size int64, opts TransferOptions) *Transfer {
+	t := &Transfer{
+		id:     atomic.AddUint64(&s.transferID, 1),
+		total:  size,
+		cancel: make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+
+	s.Lock()
+	if s.transfers == nil {
+		s.transfers = map[uint64]*Transfer{}
+	}
+	s.transfers[t.id] = t
+	s.Unlock()
+
+	go func() {
+		defer func() {
+			s.Lock()
+			delete(s.transfers, t.id)
+			s.Unlock()
+			close(t.done)
+		}()
+		t.err = s.transfer(t, route, r, opts)
+	}()
+	return t
+}
+
+// CancelTransfer cancels the transfer of current session, false will be returned if
+// the transfer does not exist or has finished
+func (s *Session) CancelTransfer(id uint64) bool {
+	s.RLock()
+	t, found := s.transfers[id]
+	s.RUnlock()
+	if found {
+		t.Cancel()
+	}
+	return found
+}
+
+func (s *Session) transfer(t *Transfer, route string, r io.Reader, opts TransferOptions) error {
+	chunkSize := opts.ChunkSize
+	if chunkSize <= 0 {
+		chunkSize = DefaultTransferChunkSize
+	}
+
+	var seq uint32
+	buf := make([]byte, chunkSize)
+	for {
+		if t.canceled() {
+			s.pushChunk(t, route, seq, TransferFlagCanceled, nil)
+			return ErrTransferCanceled
+		}
+
+		n, err := io.ReadFull(r, buf)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			s.pushChunk(t, route, seq, TransferFlagCanceled, nil)
+			return err
+		}
+
+		var flag byte
+		if err != nil {
+			flag = TransferFlagLast
+		}
+		if e := s.pushChunk(t, route, seq, flag, buf[:n]); e != nil {
+			return e
+		}
+		seq++
+
+		sent := atomic.AddInt64(&t.sent, int64(n))
+		if opts.Progress != nil {
+			opts.Progress(sent, t.total)
+		}
+		if flag == TransferFlagLast {
+			return nil
+		}
+	}
+}
+
+// pushChunk pushes a chunk to the client, which waits for the send queue if the
+// network entity supports it
+func (s *Session) pushChunk(t *Transfer, route string, seq uint32, flag byte, data []byte) error {
+	chunk := make([]byte, TransferHeaderSize+len(data))
+	binary.BigEndian.PutUint64(chunk, t.id)
+	binary.BigEndian.PutUint32(chunk[8:], seq)
+	chunk[12] = flag
+	copy(chunk[TransferHeaderSize:], data)
+
+	p, ok := s.entity.(interface {
+		PushWait(cancel <-chan struct{}, route string, v interface{}) error
+	})
+	if !ok {
+		return s.entity.Push(route, chunk)
+	}
+
+	// the canceled chunk is the last one, which must not be aborted by the cancellation
+	if flag&TransferFlagCanceled != 0 {
+		return p.PushWait(nil, route, chunk)
+	}
+	err := p.PushWait(t.cancel, route, chunk)
+	if err != nil && t.canceled() {
+		s.pushChunk(t, route, seq, TransferFlagCanceled, nil)
+		return ErrTransferCanceled
+	}
+	return err
+}
+
+func (t *Transfer) canceled() bool {
+	select {
+	case <-t.cancel:
+		return true
+	default:
+		return false
+	}
+}
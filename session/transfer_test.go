@@ -0,0 +1,102 @@
+package session
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+type chunkEntity struct {
+	chunks chan []byte
+}
+
+func (e *chunkEntity) Push(route string, v interface{}) error              { return errors.New("not supported") }
+func (e *chunkEntity) RPC(route string, v interface{}) error               { return nil }
+func (e *chunkEntity) Request(route string, v interface{}) ([]byte, error) { return nil, nil }
+func (e *chunkEntity) LastMid() uint64                                     { return 0 }
+func (e *chunkEntity) Response(v interface{}) error                        { return nil }
+func (e *chunkEntity) ResponseMid(mid uint64, v interface{}) error         { return nil }
+func (e *chunkEntity) Close() error                                        { return nil }
+func (e *chunkEntity) RemoteAddr() net.Addr                                { return nil }
+
+func (e *chunkEntity) PushWait(cancel <-chan struct{}, route string, v interface{}) error {
+	select {
+	case e.chunks <- v.([]byte):
+		return nil
+	case <-cancel:
+		return errors.New("canceled")
+	}
+}
+
+func parseChunk(t *testing.T, chunk []byte) (uint64, uint32, byte, []byte) {
+	if len(chunk) < TransferHeaderSize {
+		t.Fatalf("chunk size %d", len(chunk))
+	}
+	return binary.BigEndian.Uint64(chunk), binary.BigEndian.Uint32(chunk[8:]), chunk[12], chunk[TransferHeaderSize:]
+}
+
+func TestSession_Transfer(t *testing.T) {
+	entity := &chunkEntity{chunks: make(chan []byte, 16)}
+	s := New(entity)
+
+	blob := bytes.Repeat([]byte("nano"), 10)
+	var progress []int64
+	tr := s.Transfer("replay", bytes.NewReader(blob), int64(len(blob)), TransferOptions{
+		ChunkSize: 16,
+		Progress:  func(sent, total int64) { progress = append(progress, sent) },
+	})
+	if err := tr.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	var received []byte
+	for i := 0; i < 3; i++ {
+		id, seq, flag, data := parseChunk(t, <-entity.chunks)
+		if id != tr.ID() || seq != uint32(i) {
+			t.Fatalf("chunk %d: id %d, seq %d", i, id, seq)
+		}
+		if last := i == 2; (flag == TransferFlagLast) != last {
+			t.Fatalf("chunk %d: flag %d", i, flag)
+		}
+		received = append(received, data...)
+	}
+	if !bytes.Equal(received, blob) {
+		t.Fatalf("received %q", received)
+	}
+	if len(progress) != 3 || progress[2] != int64(len(blob)) {
+		t.Fatalf("progress %v", progress)
+	}
+	if s.CancelTransfer(tr.ID()) {
+		t.Fatal("cancel a finished transfer")
+	}
+}
+
+func TestSession_CancelTransfer(t *testing.T) {
+	// the send queue holds a chunk, the transfer waits for it
+	entity := &chunkEntity{chunks: make(chan []byte, 1)}
+	s := New(entity)
+
+	blob := bytes.Repeat([]byte("nano"), 10)
+	tr := s.Transfer("replay", bytes.NewReader(blob), int64(len(blob)), TransferOptions{ChunkSize: 4})
+	time.Sleep(50 * time.Millisecond)
+	if !s.CancelTransfer(tr.ID()) {
+		t.Fatal("transfer not found")
+	}
+
+	// drain the queue, the last chunk is the canceled one
+	var flag byte
+	for flag != TransferFlagCanceled {
+		select {
+		case chunk := <-entity.chunks:
+			_, _, flag, _ = parseChunk(t, chunk)
+		case <-time.After(time.Second):
+			t.Fatal("canceled chunk not received")
+		}
+	}
+	if err := tr.Err(); err != ErrTransferCanceled {
+		t.Fatalf("expect %v, got %v", ErrTransferCanceled, err)
+	}
+}